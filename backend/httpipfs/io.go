@@ -142,3 +142,14 @@ func (nd *Node) Add(r io.Reader) (h.Hash, error) {
 
 	return h.FromB58String(hs)
 }
+
+// HashOf returns the hash that Add() would return for the contents of `r`,
+// without adding them to IPFS.
+func (nd *Node) HashOf(r io.Reader) (h.Hash, error) {
+	hs, err := nd.sh.Add(r, shell.OnlyHash(true))
+	if err != nil {
+		return nil, err
+	}
+
+	return h.FromB58String(hs)
+}
@@ -31,6 +31,10 @@ type FsBackend interface {
 	// which it can be accessed on later.
 	Add(r io.Reader) (h.Hash, error)
 
+	// HashOf should read all data in `r` and return the hash that Add()
+	// would return for it, but without storing anything.
+	HashOf(r io.Reader) (h.Hash, error)
+
 	// Pin gives the object at `hash` a "pin".
 	// (i.e. it marks the file to be stored indefinitely in local storage)
 	// When pinning an explicit pin with an implicit pin, the explicit pin
@@ -88,6 +92,16 @@ func (mb *MemFsBackend) Add(r io.Reader) (h.Hash, error) {
 	return hash, nil
 }
 
+// HashOf implements FsBackend.HashOf by hashing the data like Add does.
+func (mb *MemFsBackend) HashOf(r io.Reader) (h.Hash, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.SumWithBackendHash(data), nil
+}
+
 // Pin implements FsBackend.Pin by storing a marker in memory.
 func (mb *MemFsBackend) Pin(hash h.Hash) error {
 	mb.pins[hash.B58String()] = true
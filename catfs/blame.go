@@ -0,0 +1,275 @@
+package catfs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pmezard/go-difflib/difflib"
+	ie "github.com/sahib/brig/catfs/errors"
+	n "github.com/sahib/brig/catfs/nodes"
+	"github.com/sahib/brig/catfs/vcs"
+	h "github.com/sahib/brig/util/hashlib"
+)
+
+const (
+	// Files bigger than this are not annotated line by line.
+	blameMaxTextSize = 8 * 1024 * 1024
+
+	// Only this many bytes are checked when guessing if a file is binary.
+	blameBinarySniffSize = 8000
+)
+
+// BlameVersion is a version of a file that changed its content.
+type BlameVersion struct {
+	// Commit is the commit that introduced this version.
+	Commit *Commit
+	// Author is the user that made the commit.
+	Author string
+	// MergedWith is the remote this version came from, if it was synced.
+	MergedWith string
+	// Path is the path of the file in this version.
+	Path string
+	// Size is the size of this version in bytes.
+	Size uint64
+	// ContentHash is the hash of the content of this version.
+	ContentHash h.Hash
+}
+
+// BlameLine is a single line of a text file.
+type BlameLine struct {
+	// Text is the content of the line without the newline.
+	Text string
+	// Version is the index of the version in Blame.Versions
+	// that last changed this line.
+	Version int
+}
+
+// Blame tells which version introduced which content of a file.
+type Blame struct {
+	// Versions are all versions of the file, the newest first.
+	Versions []BlameVersion
+	// IsBinary is true if the file is not annotated line by line,
+	// either because it is binary or because it is too big.
+	IsBinary bool
+	// Lines are the lines of the newest version.
+	// It is empty if IsBinary is true.
+	Lines []BlameLine
+}
+
+type blameVersion struct {
+	BlameVersion
+	key         []byte
+	backendHash h.Hash
+}
+
+// blameVersions returns all versions of the file at `path` in which
+// the content was changed, the newest first.
+func (fs *FS) blameVersions(path string) ([]blameVersion, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	nd, err := fs.lkr.LookupModNode(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := nd.(*n.File); !ok {
+		return nil, ie.ErrBadNode
+	}
+
+	status, err := fs.lkr.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	owner, err := fs.lkr.Owner()
+	if err != nil {
+		return nil, err
+	}
+
+	hist, err := vcs.History(fs.lkr, nd, status, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	hashToRef, err := fs.buildCommitHashToRefTable()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []blameVersion{}
+	for _, change := range hist {
+		file, ok := change.Curr.(*n.File)
+		if !ok {
+			// Removed in this commit; nothing to blame.
+			continue
+		}
+
+		author := change.Head.Author()
+		if author == n.AuthorOfStage {
+			// Uncommitted changes are always our own.
+			author = owner
+		}
+
+		mergedWith, _ := change.Head.MergeMarker()
+		version := blameVersion{
+			BlameVersion: BlameVersion{
+				Commit:      commitToExternal(change.Head, hashToRef),
+				Author:      author,
+				MergedWith:  mergedWith,
+				Path:        file.Path(),
+				Size:        file.Size(),
+				ContentHash: file.ContentHash().Clone(),
+			},
+			key:         append([]byte{}, file.Key()...),
+			backendHash: file.BackendHash().Clone(),
+		}
+
+		// The history goes back in time; a run of versions with the
+		// same content was introduced by the oldest one of them.
+		if last := len(versions) - 1; last >= 0 && versions[last].ContentHash.Equal(version.ContentHash) {
+			versions[last] = version
+			continue
+		}
+
+		versions = append(versions, version)
+	}
+
+	return versions, nil
+}
+
+func (fs *FS) blameLines(version blameVersion) ([]string, error) {
+	stream, err := fs.catHash(version.backendHash, version.key, version.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	defer stream.Close()
+
+	data, err := ioutil.ReadAll(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return []string{}, nil
+	}
+
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), nil
+}
+
+func isBinary(r io.Reader) (bool, error) {
+	buf := make([]byte, blameBinarySniffSize)
+	size, err := io.ReadFull(r, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+
+	buf = buf[:size]
+	if bytes.IndexByte(buf, 0) >= 0 {
+		return true, nil
+	}
+
+	// The sniffed part might end in the middle of a rune:
+	for cut := 0; cut < utf8.UTFMax && len(buf) > 0; cut++ {
+		if utf8.Valid(buf) {
+			return false, nil
+		}
+
+		if size < blameBinarySniffSize {
+			break
+		}
+
+		buf = buf[:len(buf)-1]
+	}
+
+	return true, nil
+}
+
+// Blame attributes each line of the text file at `path` to the version
+// that last changed it. For binary files only the versions are returned.
+func (fs *FS) Blame(path string) (*Blame, error) {
+	versions, err := fs.blameVersions(path)
+	if err != nil {
+		return nil, err
+	}
+
+	blame := &Blame{
+		Versions: []BlameVersion{},
+		Lines:    []BlameLine{},
+	}
+
+	for _, version := range versions {
+		blame.Versions = append(blame.Versions, version.BlameVersion)
+	}
+
+	if len(versions) == 0 {
+		return blame, nil
+	}
+
+	newest := versions[0]
+	if newest.Size > blameMaxTextSize {
+		blame.IsBinary = true
+		return blame, nil
+	}
+
+	stream, err := fs.catHash(newest.backendHash, newest.key, newest.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	blame.IsBinary, err = isBinary(stream)
+	stream.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if blame.IsBinary {
+		return blame, nil
+	}
+
+	// Go from the oldest version to the newest and carry over
+	// the attribution of all lines that stayed the same.
+	var prevLines []string
+	var prevOrigin []int
+	for idx := len(versions) - 1; idx >= 0; idx-- {
+		if versions[idx].Size > blameMaxTextSize {
+			// Treat it like a complete rewrite.
+			prevLines, prevOrigin = nil, nil
+			continue
+		}
+
+		lines, err := fs.blameLines(versions[idx])
+		if err != nil {
+			return nil, err
+		}
+
+		origin := make([]int, len(lines))
+		for lineIdx := range origin {
+			origin[lineIdx] = idx
+		}
+
+		matcher := difflib.NewMatcherWithJunk(prevLines, lines, false, nil)
+		for _, op := range matcher.GetOpCodes() {
+			if op.Tag != 'e' {
+				continue
+			}
+
+			copy(origin[op.J1:op.J2], prevOrigin[op.I1:op.I2])
+		}
+
+		prevLines, prevOrigin = lines, origin
+	}
+
+	for lineIdx, line := range prevLines {
+		blame.Lines = append(blame.Lines, BlameLine{
+			Text:    line,
+			Version: prevOrigin[lineIdx],
+		})
+	}
+
+	return blame, nil
+}
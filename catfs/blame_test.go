@@ -0,0 +1,92 @@
+package catfs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlameText(t *testing.T) {
+	t.Parallel()
+
+	withDummyFS(t, func(fs *FS) {
+		require.Nil(t, fs.Stage("/x", bytes.NewReader([]byte("a\nb\nc\n"))))
+		require.Nil(t, fs.MakeCommit("1"))
+		require.Nil(t, fs.Stage("/x", bytes.NewReader([]byte("a\nB\nc\nd\n"))))
+		require.Nil(t, fs.MakeCommit("2"))
+		require.Nil(t, fs.Move("/x", "/y"))
+		require.Nil(t, fs.MakeCommit("move"))
+		require.Nil(t, fs.Stage("/y", bytes.NewReader([]byte("a\nB\nc\nd\ne"))))
+
+		blame, err := fs.Blame("/y")
+		require.Nil(t, err)
+		require.False(t, blame.IsBinary)
+
+		// The move did not change the content:
+		require.Len(t, blame.Versions, 3)
+		require.Equal(t, []string{"curr"}, blame.Versions[0].Commit.Tags)
+		require.Equal(t, "2", blame.Versions[1].Commit.Msg)
+		require.Equal(t, "1", blame.Versions[2].Commit.Msg)
+		require.Equal(t, "/x", blame.Versions[2].Path)
+
+		for _, version := range blame.Versions {
+			require.Equal(t, "alice", version.Author)
+			require.Equal(t, "", version.MergedWith)
+		}
+
+		require.Equal(t, []BlameLine{
+			{Text: "a", Version: 2},
+			{Text: "B", Version: 1},
+			{Text: "c", Version: 2},
+			{Text: "d", Version: 1},
+			{Text: "e", Version: 0},
+		}, blame.Lines)
+	})
+}
+
+func TestBlameBinary(t *testing.T) {
+	t.Parallel()
+
+	withDummyFS(t, func(fs *FS) {
+		require.Nil(t, fs.Stage("/x", bytes.NewReader([]byte{0, 1, 2})))
+		require.Nil(t, fs.MakeCommit("1"))
+		require.Nil(t, fs.Stage("/x", bytes.NewReader([]byte{0, 1, 3, 4})))
+		require.Nil(t, fs.MakeCommit("2"))
+
+		blame, err := fs.Blame("/x")
+		require.Nil(t, err)
+		require.True(t, blame.IsBinary)
+		require.Empty(t, blame.Lines)
+		require.Len(t, blame.Versions, 2)
+		require.Equal(t, uint64(4), blame.Versions[0].Size)
+		require.Equal(t, uint64(3), blame.Versions[1].Size)
+
+		_, err = fs.Blame("/")
+		require.NotNil(t, err)
+	})
+}
+
+func TestBlameMerged(t *testing.T) {
+	t.Parallel()
+
+	withDummyFS(t, func(fsa *FS) {
+		require.Nil(t, fsa.Stage("/x", bytes.NewReader([]byte("a\n"))))
+		require.Nil(t, fsa.MakeCommit("a"))
+
+		withDummyFS(t, func(fsb *FS) {
+			require.Nil(t, fsb.Sync(fsa))
+			require.Nil(t, fsb.Stage("/x", bytes.NewReader([]byte("a\nb\n"))))
+			require.Nil(t, fsb.MakeCommit("b"))
+			require.Nil(t, fsa.Sync(fsb))
+
+			// The content is only in the backend of fsb:
+			versions, err := fsa.blameVersions("/x")
+			require.Nil(t, err)
+			require.Len(t, versions, 2)
+			require.Equal(t, "alice", versions[0].MergedWith)
+			require.Equal(t, "", versions[1].MergedWith)
+			require.Equal(t, "a", versions[1].Commit.Msg)
+		})
+	})
+}
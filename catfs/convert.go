@@ -0,0 +1,248 @@
+package catfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	e "github.com/pkg/errors"
+	c "github.com/sahib/brig/catfs/core"
+	"github.com/sahib/brig/catfs/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// A conversion of the metadata at dbPath to another key/value store
+// uses two directories in a separate work directory:
+//
+//	convert-<to>:   the new store while it is being filled.
+//	<from>-backup:  the old store after the conversion.
+//
+// The work directory must be on the same filesystem as dbPath,
+// so both can be swapped by renaming them.
+const (
+	convertPrefix = "convert-"
+	backupSuffix  = "-backup"
+)
+
+// openDatabase opens the metadata database at `dbPath` with the
+// implementation called `backend`. If the data there was written by
+// another implementation, it has to be converted with PrepareDatabase first.
+func openDatabase(dbPath, backend string) (db.Database, error) {
+	stored, err := db.DetectBackend(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored != "" && stored != backend {
+		return nil, fmt.Errorf(
+			"metadata at %s is stored in %s and needs to be converted to %s first",
+			dbPath, stored, backend,
+		)
+	}
+
+	return db.Open(backend, dbPath)
+}
+
+// PrepareDatabase makes sure that the metadata at `dbPath` can be opened
+// with the implementation called `backend`. A conversion that was interrupted
+// before is finished or rolled back. If the data was written by another
+// implementation, it is converted now and the old data is kept as backup
+// in `workDir`.
+func PrepareDatabase(dbPath, workDir, backend string) error {
+	if err := recoverDatabase(dbPath, workDir); err != nil {
+		return e.Wrapf(err, "recover metadata conversion")
+	}
+
+	stored, err := db.DetectBackend(dbPath)
+	if err != nil {
+		return err
+	}
+
+	if stored == "" || stored == backend {
+		return nil
+	}
+
+	src, err := db.Open(stored, dbPath)
+	if err != nil {
+		return err
+	}
+
+	if err := convertDatabase(src, dbPath, workDir, stored, backend); err != nil {
+		return e.Wrapf(err, "convert metadata from %s to %s", stored, backend)
+	}
+
+	return nil
+}
+
+// convertDatabase copies `src`, which is opened at `dbPath`, to a new store
+// of the implementation `to` and swaps it in. `src` is closed afterwards,
+// even if the conversion failed.
+func convertDatabase(src db.Database, dbPath, workDir, from, to string) error {
+	tmpPath := filepath.Join(workDir, convertPrefix+to)
+	backupPath := filepath.Join(workDir, from+backupSuffix)
+	log.Infof("converting metadata at %s from %s to %s", dbPath, from, to)
+
+	copyErr := copyDatabase(src, tmpPath, to)
+	if err := src.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	if copyErr != nil {
+		os.RemoveAll(tmpPath)
+		return copyErr
+	}
+
+	// An older backup is replaced. If we crash while it is deleted,
+	// the data at dbPath is still there and the conversion is rolled back.
+	if err := os.RemoveAll(backupPath); err != nil {
+		return err
+	}
+
+	if err := os.Rename(dbPath, backupPath); err != nil {
+		return err
+	}
+
+	// If we crash here, recoverDatabase() finishes the swap.
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		return err
+	}
+
+	log.Infof("old metadata was moved to %s", backupPath)
+	return nil
+}
+
+// copyDatabase exports everything in `src` to a new store of the
+// implementation `to` in the directory `path`.
+func copyDatabase(src db.Database, path, to string) error {
+	// Might be a left-over of an interrupted conversion:
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return err
+	}
+
+	dst, err := db.Open(to, path)
+	if err != nil {
+		return err
+	}
+
+	copyErr := db.Convert(dst, src)
+	if err := dst.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	return copyErr
+}
+
+// recoverDatabase cleans up after a conversion that was interrupted.
+// As long as there is data at `dbPath`, the new store might be incomplete
+// and is deleted. Otherwise the old store was already moved to its backup
+// location and the new store only needs to be moved to `dbPath`.
+func recoverDatabase(dbPath, workDir string) error {
+	infos, err := ioutil.ReadDir(workDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	tmpPaths := []string{}
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), convertPrefix) {
+			tmpPaths = append(tmpPaths, filepath.Join(workDir, info.Name()))
+		}
+	}
+
+	if len(tmpPaths) == 0 {
+		return nil
+	}
+
+	stored, err := db.DetectBackend(dbPath)
+	if err != nil {
+		return err
+	}
+
+	if stored != "" {
+		for _, tmpPath := range tmpPaths {
+			log.Warningf("removing incomplete metadata conversion at %s", tmpPath)
+			if err := os.RemoveAll(tmpPath); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if len(tmpPaths) > 1 {
+		return fmt.Errorf("more than one converted store to choose from: %v", tmpPaths)
+	}
+
+	log.Warningf("finishing interrupted metadata conversion of %s", dbPath)
+
+	// dbPath might exist as empty directory:
+	if err := os.RemoveAll(dbPath); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPaths[0], dbPath)
+}
+
+// ConvertDatabase converts the metadata of the filesystem to the key/value
+// store implementation called `to`. The old data is kept as backup in
+// `workDir`, which must be on the same filesystem as the metadata.
+// The previously used implementation is returned.
+func (fs *FS) ConvertDatabase(to, workDir string) (string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	from, err := db.DetectBackend(fs.dbPath)
+	if err != nil {
+		return "", err
+	}
+
+	if from == to {
+		return from, nil
+	}
+
+	if err := os.MkdirAll(workDir, 0700); err != nil {
+		return "", err
+	}
+
+	convErr := convertDatabase(fs.kv, fs.dbPath, workDir, from, to)
+
+	// The old store was closed in any case.
+	// Continue with whatever is at dbPath now:
+	if err := recoverDatabase(fs.dbPath, workDir); err != nil {
+		return "", err
+	}
+
+	stored, err := db.DetectBackend(fs.dbPath)
+	if err != nil {
+		return "", err
+	}
+
+	kv, err := db.Open(stored, fs.dbPath)
+	if err != nil {
+		return "", err
+	}
+
+	fs.kv = kv
+	fs.lkr = c.NewLinker(kv)
+	fs.pinner, err = NewPinner(fs.lkr, fs.bk)
+	if err != nil {
+		return "", err
+	}
+
+	fs.gc = c.NewGarbageCollector(fs.lkr, kv, fs.handleGcEvent)
+	if convErr != nil {
+		return "", e.Wrapf(convErr, "convert metadata from %s to %s", from, to)
+	}
+
+	return from, nil
+}
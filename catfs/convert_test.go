@@ -0,0 +1,155 @@
+package catfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sahib/brig/catfs/db"
+	"github.com/sahib/brig/defaults"
+	"github.com/sahib/config"
+	"github.com/stretchr/testify/require"
+)
+
+func withConvertDirs(t *testing.T, fn func(dbPath, workDir string, cfg *config.Config)) {
+	dir, err := ioutil.TempDir("", "brig-convert")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg, err := config.Open(nil, defaults.Defaults, config.StrictnessPanic)
+	require.Nil(t, err)
+
+	dbPath := filepath.Join(dir, "metadata", "alice")
+	require.Nil(t, os.MkdirAll(dbPath, 0700))
+	fn(dbPath, filepath.Join(dir, "convert", "alice"), cfg)
+}
+
+func requireConvertedFS(t *testing.T, fs *FS, nCommits int) {
+	stream, err := fs.Cat("/x")
+	require.Nil(t, err)
+	data, err := ioutil.ReadAll(stream)
+	require.Nil(t, err)
+	require.Equal(t, []byte("hello"), data)
+	require.Nil(t, stream.Close())
+
+	// The history should have been converted too:
+	commits := 0
+	require.Nil(t, fs.Log("head", func(*Commit) error {
+		commits++
+		return nil
+	}))
+	require.Equal(t, nCommits, commits)
+}
+
+func requireBackend(t *testing.T, path, expected string) {
+	detected, err := db.DetectBackend(path)
+	require.Nil(t, err)
+	require.Equal(t, expected, detected)
+}
+
+func TestPrepareDatabase(t *testing.T) {
+	withConvertDirs(t, func(dbPath, workDir string, cfg *config.Config) {
+		backend := NewMemFsBackend()
+		fs, err := NewFilesystem(backend, dbPath, "alice", false, cfg.Section("fs"))
+		require.Nil(t, err)
+		require.Nil(t, fs.Stage("/x", bytes.NewReader([]byte("hello"))))
+		require.Nil(t, fs.MakeCommit("add x"))
+		require.Nil(t, fs.Close())
+
+		// Other stores can only be used after a conversion:
+		require.Nil(t, cfg.SetString("fs.db.backend", "bolt"))
+		_, err = NewFilesystem(backend, dbPath, "alice", false, cfg.Section("fs"))
+		require.NotNil(t, err)
+
+		require.Nil(t, PrepareDatabase(dbPath, workDir, "bolt"))
+		fs, err = NewFilesystem(backend, dbPath, "alice", false, cfg.Section("fs"))
+		require.Nil(t, err)
+		requireConvertedFS(t, fs, 1)
+		require.Nil(t, fs.Close())
+
+		requireBackend(t, dbPath, "bolt")
+		requireBackend(t, filepath.Join(workDir, "badger-backup"), "badger")
+
+		// Nothing left in the directory of dbPath but dbPath itself:
+		infos, err := ioutil.ReadDir(filepath.Dir(dbPath))
+		require.Nil(t, err)
+		require.Len(t, infos, 1)
+	})
+}
+
+func TestConvertDatabaseInPlace(t *testing.T) {
+	withConvertDirs(t, func(dbPath, workDir string, cfg *config.Config) {
+		backend := NewMemFsBackend()
+		fs, err := NewFilesystem(backend, dbPath, "alice", false, cfg.Section("fs"))
+		require.Nil(t, err)
+		require.Nil(t, fs.Stage("/x", bytes.NewReader([]byte("hello"))))
+		require.Nil(t, fs.MakeCommit("add x"))
+
+		from, err := fs.ConvertDatabase("bolt", workDir)
+		require.Nil(t, err)
+		require.Equal(t, "badger", from)
+		requireBackend(t, dbPath, "bolt")
+		requireConvertedFS(t, fs, 1)
+
+		// The filesystem should stay usable:
+		require.Nil(t, fs.Stage("/y", bytes.NewReader([]byte("world"))))
+		require.Nil(t, fs.MakeCommit("add y"))
+		requireConvertedFS(t, fs, 2)
+
+		// Failed conversions keep the current store:
+		_, err = fs.ConvertDatabase("leveldb", workDir)
+		require.NotNil(t, err)
+		requireBackend(t, dbPath, "bolt")
+		requireConvertedFS(t, fs, 2)
+		require.Nil(t, fs.Close())
+	})
+}
+
+func TestRecoverInterruptedConversion(t *testing.T) {
+	tcs := []struct {
+		name            string
+		oldStoreMoved   bool
+		expectedBackend string
+	}{
+		{name: "before-swap", oldStoreMoved: false, expectedBackend: "badger"},
+		{name: "during-swap", oldStoreMoved: true, expectedBackend: "bolt"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			withConvertDirs(t, func(dbPath, workDir string, cfg *config.Config) {
+				backend := NewMemFsBackend()
+				fs, err := NewFilesystem(backend, dbPath, "alice", false, cfg.Section("fs"))
+				require.Nil(t, err)
+				require.Nil(t, fs.Stage("/x", bytes.NewReader([]byte("hello"))))
+				require.Nil(t, fs.MakeCommit("add x"))
+				require.Nil(t, fs.Close())
+
+				// Simulate a crash at different points of convertDatabase():
+				src, err := db.Open("badger", dbPath)
+				require.Nil(t, err)
+				require.Nil(t, copyDatabase(src, filepath.Join(workDir, "convert-bolt"), "bolt"))
+				require.Nil(t, src.Close())
+
+				if tc.oldStoreMoved {
+					require.Nil(t, os.Rename(dbPath, filepath.Join(workDir, "badger-backup")))
+					require.Nil(t, os.MkdirAll(dbPath, 0700))
+				}
+
+				require.Nil(t, PrepareDatabase(dbPath, workDir, tc.expectedBackend))
+				requireBackend(t, dbPath, tc.expectedBackend)
+
+				_, err = os.Stat(filepath.Join(workDir, "convert-bolt"))
+				require.True(t, os.IsNotExist(err))
+
+				require.Nil(t, cfg.SetString("fs.db.backend", tc.expectedBackend))
+				fs, err = NewFilesystem(backend, dbPath, "alice", false, cfg.Section("fs"))
+				require.Nil(t, err)
+				requireConvertedFS(t, fs, 1)
+				require.Nil(t, fs.Close())
+			})
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sahib/brig/catfs/db"
+	ie "github.com/sahib/brig/catfs/errors"
+	n "github.com/sahib/brig/catfs/nodes"
+	h "github.com/sahib/brig/util/hashlib"
+)
+
+const (
+	// DefaultBranch is the branch every repository starts with.
+	DefaultBranch = "master"
+
+	// branchRefs is the sub-bucket of refs/ where branches are stored.
+	branchRefs = "branches"
+)
+
+var (
+	branchNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+)
+
+// normalizeBranchName lowercases `name` (like refs are) and checks that
+// it can be safely used as key in the key/value store.
+func normalizeBranchName(name string) (string, error) {
+	name = strings.ToLower(name)
+	if !branchNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid branch name `%s`: only letters, digits, `-` and `_` are allowed", name)
+	}
+
+	return name, nil
+}
+
+// CurrentBranch returns the name of the branch HEAD belongs to.
+func (lkr *Linker) CurrentBranch() (string, error) {
+	data, err := lkr.kv.Get("stage", "BRANCH")
+	if err != nil && err != db.ErrNoSuchKey {
+		return "", err
+	}
+
+	if len(data) == 0 {
+		return DefaultBranch, nil
+	}
+
+	return string(data), nil
+}
+
+// BranchTip returns the newest commit of the branch called `name`.
+// The tip of the current branch is always HEAD.
+func (lkr *Linker) BranchTip(name string) (*n.Commit, error) {
+	name, err := normalizeBranchName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	curr, err := lkr.CurrentBranch()
+	if err != nil {
+		return nil, err
+	}
+
+	if name == curr {
+		return lkr.Head()
+	}
+
+	data, err := lkr.kv.Get("refs", branchRefs, name)
+	if err == db.ErrNoSuchKey {
+		return nil, ie.ErrNoSuchBranch(name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := h.FromB58String(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	cmt, err := lkr.CommitByHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if cmt == nil {
+		return nil, fmt.Errorf("branch `%s` points to unknown commit `%s`", name, data)
+	}
+
+	return cmt, nil
+}
+
+// ListBranches returns the names of all branches in sorted order.
+// The current branch is always part of it.
+func (lkr *Linker) ListBranches() ([]string, error) {
+	curr, err := lkr.CurrentBranch()
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := lkr.kv.Keys("refs", branchRefs)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{curr}
+	for _, key := range keys {
+		if len(key) != 3 || key[1] != branchRefs || key[2] == curr {
+			continue
+		}
+
+		names = append(names, key[2])
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// MakeBranch creates a new branch called `name` that starts at `cmt`.
+// It is an error if the branch exists already.
+func (lkr *Linker) MakeBranch(name string, cmt *n.Commit) error {
+	name, err := normalizeBranchName(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := lkr.BranchTip(name); err == nil {
+		return ie.ErrExists
+	} else if !ie.IsErrNoSuchBranch(err) {
+		return err
+	}
+
+	return lkr.AtomicWithBatch(func(batch db.Batch) (bool, error) {
+		batch.Put([]byte(cmt.TreeHash().B58String()), "refs", branchRefs, name)
+		return false, nil
+	})
+}
+
+// RemoveBranch deletes the branch called `name`.
+// The commits of it are not deleted. The current branch can't be removed.
+func (lkr *Linker) RemoveBranch(name string) error {
+	name, err := normalizeBranchName(name)
+	if err != nil {
+		return err
+	}
+
+	curr, err := lkr.CurrentBranch()
+	if err != nil {
+		return err
+	}
+
+	if name == curr {
+		return fmt.Errorf("can't remove the current branch `%s`", name)
+	}
+
+	if _, err := lkr.kv.Get("refs", branchRefs, name); err == db.ErrNoSuchKey {
+		return ie.ErrNoSuchBranch(name)
+	}
+
+	return lkr.AtomicWithBatch(func(batch db.Batch) (bool, error) {
+		batch.Erase("refs", branchRefs, name)
+		return false, nil
+	})
+}
+
+// SwitchBranch makes `name` the current branch. HEAD and the staging
+// commit are set to the tip of it. If `force` is false, ErrStageNotEmpty
+// is returned if there are uncommitted changes, otherwise they get lost.
+func (lkr *Linker) SwitchBranch(name string, force bool) error {
+	name, err := normalizeBranchName(name)
+	if err != nil {
+		return err
+	}
+
+	curr, err := lkr.CurrentBranch()
+	if err != nil {
+		return err
+	}
+
+	if name == curr {
+		return nil
+	}
+
+	tip, err := lkr.BranchTip(name)
+	if err != nil {
+		return err
+	}
+
+	if !force {
+		haveStaged, err := lkr.HaveStagedChanges()
+		if err != nil {
+			return err
+		}
+
+		if haveStaged {
+			return ie.ErrStageNotEmpty
+		}
+	}
+
+	head, err := lkr.Head()
+	if err != nil {
+		return err
+	}
+
+	root, err := lkr.DirectoryByHash(tip.Root())
+	if err != nil {
+		return err
+	}
+
+	err = lkr.AtomicWithBatch(func(batch db.Batch) (bool, error) {
+		// Remember where the old branch stopped. The new one
+		// is tracked by HEAD from now on.
+		batch.Put([]byte(head.TreeHash().B58String()), "refs", branchRefs, curr)
+		batch.Erase("refs", branchRefs, name)
+		batch.Put([]byte(name), "stage", "BRANCH")
+
+		if err := lkr.SaveRef("HEAD", tip); err != nil {
+			return true, err
+		}
+
+		if err := lkr.rebuildIndex(batch, tip); err != nil {
+			return true, err
+		}
+
+		// The tree/ bucket maps paths of HEAD to their hashes:
+		if err := batch.Clear("tree"); err != nil {
+			return true, err
+		}
+
+		err := n.Walk(lkr, root, true, func(child n.Node) error {
+			childPath := child.Path()
+			if child.Type() == n.NodeTypeDirectory {
+				childPath = appendDot(childPath)
+			}
+
+			batch.Put([]byte(child.TreeHash().B58String()), "tree", childPath)
+			return nil
+		})
+
+		if err != nil {
+			return true, err
+		}
+
+		if err := lkr.clearStage(batch); err != nil {
+			return true, err
+		}
+
+		status, err := n.NewEmptyCommit(lkr.NextInode(), tip.Index()+1)
+		if err != nil {
+			return true, err
+		}
+
+		status.SetRoot(tip.Root())
+		return hintRollback(lkr.saveStatus(status))
+	})
+
+	// Nodes of the old branch might be still cached:
+	lkr.MemIndexClear()
+	return err
+}
+
+// rebuildIndex makes index/ describe the history of `head`.
+// Indices of other branches that are newer than `head` are removed.
+func (lkr *Linker) rebuildIndex(batch db.Batch, head *n.Commit) error {
+	keys, err := lkr.kv.Keys("index")
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		index, err := strconv.ParseInt(key[len(key)-1], 10, 64)
+		if err == nil && index > head.Index() {
+			batch.Erase(key...)
+		}
+	}
+
+	for cmt := head; cmt != nil; {
+		batch.Put(
+			[]byte(cmt.TreeHash().B58String()),
+			"index", strconv.FormatInt(cmt.Index(), 10),
+		)
+
+		parent, err := cmt.Parent(lkr)
+		if err != nil {
+			return err
+		}
+
+		if parent == nil {
+			break
+		}
+
+		parentCmt, ok := parent.(*n.Commit)
+		if !ok {
+			return ie.ErrBadNode
+		}
+
+		cmt = parentCmt
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"testing"
+
+	ie "github.com/sahib/brig/catfs/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBranchSwitch(t *testing.T) {
+	WithDummyLinker(t, func(lkr *Linker) {
+		_, base := MustTouchAndCommit(t, lkr, "/x", 1)
+
+		curr, err := lkr.CurrentBranch()
+		require.Nil(t, err)
+		require.Equal(t, DefaultBranch, curr)
+
+		require.Nil(t, lkr.MakeBranch("Dev", base))
+		require.Equal(t, ie.ErrExists, lkr.MakeBranch("dev", base))
+		require.Nil(t, lkr.SwitchBranch("dev", false))
+
+		_, devCmt := MustTouchAndCommit(t, lkr, "/y", 2)
+		require.Equal(t, base.Index()+1, devCmt.Index())
+
+		require.Nil(t, lkr.SwitchBranch(DefaultBranch, false))
+
+		head, err := lkr.Head()
+		require.Nil(t, err)
+		require.Equal(t, base.TreeHash(), head.TreeHash())
+
+		_, err = lkr.LookupNode("/y")
+		require.True(t, ie.IsNoSuchFileError(err))
+		nd, err := lkr.ResolveNode("/y")
+		require.Nil(t, err)
+		require.Nil(t, nd)
+
+		// Commit something different on master with the same index:
+		_, masterCmt := MustTouchAndCommit(t, lkr, "/z", 3)
+		require.Equal(t, devCmt.Index(), masterCmt.Index())
+
+		tip, err := lkr.BranchTip("dev")
+		require.Nil(t, err)
+		require.Equal(t, devCmt.TreeHash(), tip.TreeHash())
+
+		branches, err := lkr.ListBranches()
+		require.Nil(t, err)
+		require.Equal(t, []string{"dev", "master"}, branches)
+
+		// The index should always describe the current branch:
+		require.Nil(t, lkr.SwitchBranch("dev", false))
+		cmt, err := lkr.CommitByIndex(devCmt.Index())
+		require.Nil(t, err)
+		require.Equal(t, devCmt.TreeHash(), cmt.TreeHash())
+
+		_, err = lkr.LookupFile("/y")
+		require.Nil(t, err)
+		_, err = lkr.LookupNode("/z")
+		require.True(t, ie.IsNoSuchFileError(err))
+
+		require.Empty(t, mustFsck(t, lkr, false))
+	})
+}
+
+func TestBranchSwitchWithStagedChanges(t *testing.T) {
+	WithDummyLinker(t, func(lkr *Linker) {
+		_, base := MustTouchAndCommit(t, lkr, "/x", 1)
+		require.Nil(t, lkr.MakeBranch("dev", base))
+
+		MustTouch(t, lkr, "/staged", 2)
+		require.Equal(t, ie.ErrStageNotEmpty, lkr.SwitchBranch("dev", false))
+
+		curr, err := lkr.CurrentBranch()
+		require.Nil(t, err)
+		require.Equal(t, DefaultBranch, curr)
+
+		require.Nil(t, lkr.SwitchBranch("dev", true))
+		_, err = lkr.LookupNode("/staged")
+		require.True(t, ie.IsNoSuchFileError(err))
+
+		haveStaged, err := lkr.HaveStagedChanges()
+		require.Nil(t, err)
+		require.False(t, haveStaged)
+	})
+}
+
+func TestBranchErrors(t *testing.T) {
+	WithDummyLinker(t, func(lkr *Linker) {
+		head, err := lkr.Head()
+		require.Nil(t, err)
+
+		require.NotNil(t, lkr.MakeBranch("no/slashes", head))
+		require.NotNil(t, lkr.MakeBranch("no.dots", head))
+		require.NotNil(t, lkr.RemoveBranch(DefaultBranch))
+		require.True(t, ie.IsErrNoSuchBranch(lkr.RemoveBranch("nope")))
+		require.True(t, ie.IsErrNoSuchBranch(lkr.SwitchBranch("nope", false)))
+		require.NotNil(t, lkr.SaveRef(branchRefs, head))
+
+		require.Nil(t, lkr.MakeBranch("dev", head))
+		refs, err := lkr.ListRefs()
+		require.Nil(t, err)
+		require.NotContains(t, refs, branchRefs)
+
+		require.Nil(t, lkr.RemoveBranch("dev"))
+		branches, err := lkr.ListBranches()
+		require.Nil(t, err)
+		require.Equal(t, []string{DefaultBranch}, branches)
+	})
+}
@@ -0,0 +1,735 @@
+package core
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sahib/brig/catfs/db"
+	n "github.com/sahib/brig/catfs/nodes"
+	h "github.com/sahib/brig/util/hashlib"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// FsckDanglingRef is reported when a ref points to a node that does not exist.
+	FsckDanglingRef = "dangling-ref"
+	// FsckCorruptObject is reported when a stored node cannot be unmarshaled.
+	FsckCorruptObject = "corrupt-object"
+	// FsckMissingObject is reported when a node is referenced but not stored.
+	FsckMissingObject = "missing-object"
+	// FsckHashMismatch is reported when a node's hash differs from the
+	// recomputed hash or from the key it is stored under.
+	FsckHashMismatch = "hash-mismatch"
+	// FsckSizeMismatch is reported when a directory's size is not
+	// the sum of the sizes of its children.
+	FsckSizeMismatch = "size-mismatch"
+	// FsckBadIndex is reported when index/<n> does not point to commit n.
+	FsckBadIndex = "bad-index"
+	// FsckBadTreeEntry is reported when a tree/<path> entry does not
+	// match the node at this path in HEAD.
+	FsckBadTreeEntry = "bad-tree-entry"
+	// FsckDanglingMove is reported when a move mapping can not be resolved.
+	FsckDanglingMove = "dangling-move"
+	// FsckBadContent is reported when the content of a file could not be
+	// retrieved or decrypted. It is only reported if a content checker is set.
+	FsckBadContent = "bad-content"
+)
+
+// FsckProblem describes a single inconsistency found by Fsck.
+type FsckProblem struct {
+	// Kind is one of the Fsck* constants.
+	Kind string
+	// Path is the path of the affected node, if any.
+	Path string
+	// Commit is the commit where the problem was first seen, if any.
+	Commit h.Hash
+	// Message describes the problem in a human readable way.
+	Message string
+	// Repaired is true if the problem was fixed.
+	Repaired bool
+}
+
+func (fp FsckProblem) String() string {
+	where := fp.Path
+	if fp.Commit != nil {
+		where = fmt.Sprintf("%s@%s", where, fp.Commit.ShortB58())
+	}
+
+	return fmt.Sprintf("%s: %s: %s", fp.Kind, where, fp.Message)
+}
+
+// fsckContent is a file whose content still needs to be checked.
+type fsckContent struct {
+	file *n.File
+	cmt  *n.Commit
+}
+
+// Fsck checks the integrity of the metadata stored by a Linker.
+// Starting from all refs, every reachable commit and node is loaded and
+// its hash is compared with the recomputed one. Additionally, the index,
+// tree and move mapping buckets are checked to resolve properly.
+type Fsck struct {
+	lkr         *Linker
+	repair      bool
+	checkFile   func(file *n.File) error
+	problems    []FsckProblem
+	seenNodes   map[string]n.Node
+	seenContent map[string]struct{}
+	seenCommit  map[string]struct{}
+	content     []fsckContent
+}
+
+// NewFsck returns a new Fsck operating on `lkr`. If `repair` is true,
+// problems that can be fixed without losing data are fixed. `checkFile` is
+// called by CheckContent() once for every distinct file content and may be
+// nil. A returned error is reported as FsckBadContent problem.
+func NewFsck(lkr *Linker, repair bool, checkFile func(file *n.File) error) *Fsck {
+	return &Fsck{
+		lkr:       lkr,
+		repair:    repair,
+		checkFile: checkFile,
+	}
+}
+
+func (fk *Fsck) report(kind, nodePath string, cmt *n.Commit, repaired bool, format string, args ...interface{}) {
+	problem := FsckProblem{
+		Kind:     kind,
+		Path:     nodePath,
+		Message:  fmt.Sprintf(format, args...),
+		Repaired: repaired,
+	}
+
+	if cmt != nil {
+		problem.Commit = cmt.TreeHash().Clone()
+	}
+
+	log.Debugf("fsck: %s", problem)
+	fk.problems = append(fk.problems, problem)
+}
+
+// loadObject loads the node stored under `hash`. Unlike NodeByHash it does
+// not use the memory cache and reports corrupted objects as problem.
+// `key` is the key where the object was found and nil if it was not found.
+func (fk *Fsck) loadObject(hash h.Hash, nodePath string, cmt *n.Commit) (n.Node, []string, error) {
+	b58Hash := hash.B58String()
+	for _, key := range [][]string{
+		{"stage", "objects", b58Hash},
+		{"objects", b58Hash},
+	} {
+		data, err := fk.lkr.kv.Get(key...)
+		if err == db.ErrNoSuchKey {
+			continue
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		nd, err := n.UnmarshalNode(data)
+		if err != nil {
+			fk.report(FsckCorruptObject, nodePath, cmt, false, "%s: %v", b58Hash, err)
+			return nil, key, nil
+		}
+
+		if !nd.TreeHash().Equal(hash) {
+			fk.report(
+				FsckHashMismatch, nodePath, cmt, false,
+				"stored as %s but has hash %s", b58Hash, nd.TreeHash().B58String(),
+			)
+		}
+
+		return nd, key, nil
+	}
+
+	return nil, nil, nil
+}
+
+func (fk *Fsck) saveObject(key []string, nd n.Node) error {
+	data, err := n.MarshalNode(nd)
+	if err != nil {
+		return err
+	}
+
+	return fk.lkr.AtomicWithBatch(func(batch db.Batch) (bool, error) {
+		batch.Put(data, key...)
+		return false, nil
+	})
+}
+
+// isFreshRoot checks if `dir` is a root directory that was never modified.
+// NewEmptyDirectory() hashes those differently than rehash() would.
+func isFreshRoot(dir *n.Directory) bool {
+	return dir.IsRoot() && dir.NChildren() == 0 && dir.TreeHash().Equal(h.Sum([]byte("")))
+}
+
+func (fk *Fsck) checkDirectory(dir *n.Directory, key []string, cmt *n.Commit) error {
+	if computed := dir.ComputeTreeHash(); !computed.Equal(dir.TreeHash()) && !isFreshRoot(dir) {
+		fk.report(
+			FsckHashMismatch, dir.Path(), cmt, false,
+			"directory hash is %s, but should be %s",
+			dir.TreeHash().B58String(), computed.B58String(),
+		)
+	}
+
+	childHashes := dir.ChildHashes()
+	names := make([]string, 0, len(childHashes))
+	for name := range childHashes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	size, sizeKnown := uint64(0), true
+	for _, name := range names {
+		child, err := fk.checkNode(childHashes[name], path.Join(dir.Path(), name), cmt)
+		if err != nil {
+			return err
+		}
+
+		if child == nil {
+			sizeKnown = false
+			continue
+		}
+
+		if child.Type() != n.NodeTypeGhost {
+			size += child.Size()
+		}
+	}
+
+	if !sizeKnown || size == dir.Size() {
+		return nil
+	}
+
+	repaired := false
+	if fk.repair {
+		oldSize := dir.Size()
+		dir.SetSize(size)
+		if err := fk.saveObject(key, dir); err != nil {
+			return err
+		}
+
+		// Restore the old value, the directory might be cached
+		// and used by other checks.
+		dir.SetSize(oldSize)
+		repaired = true
+	}
+
+	fk.report(
+		FsckSizeMismatch, dir.Path(), cmt, repaired,
+		"directory size is %d, but children sum up to %d", dir.Size(), size,
+	)
+	return nil
+}
+
+// checkNode loads the node at `hash` and checks it and all of its children.
+// It returns nil if the node could not be loaded.
+func (fk *Fsck) checkNode(hash h.Hash, nodePath string, cmt *n.Commit) (n.Node, error) {
+	// Most nodes are shared between commits; check them only once.
+	b58Hash := hash.B58String()
+	if nd, ok := fk.seenNodes[b58Hash]; ok {
+		return nd, nil
+	}
+
+	nd, key, err := fk.loadObject(hash, nodePath, cmt)
+	if err != nil {
+		return nil, err
+	}
+
+	fk.seenNodes[b58Hash] = nd
+	if nd == nil {
+		if key == nil {
+			fk.report(FsckMissingObject, nodePath, cmt, false, "no object with hash %s", b58Hash)
+		}
+
+		return nil, nil
+	}
+
+	if nd.Path() != nodePath {
+		fk.report(FsckHashMismatch, nodePath, cmt, false, "node claims to be at %s", nd.Path())
+	}
+
+	switch nd.Type() {
+	case n.NodeTypeDirectory:
+		dir, ok := nd.(*n.Directory)
+		if !ok {
+			return nil, fmt.Errorf("fsck: bad directory node: %v", nd)
+		}
+
+		return nd, fk.checkDirectory(dir, key, cmt)
+	case n.NodeTypeFile:
+		file, ok := nd.(*n.File)
+		if !ok {
+			return nil, fmt.Errorf("fsck: bad file node: %v", nd)
+		}
+
+		if computed := file.ComputeTreeHash(); !computed.Equal(file.TreeHash()) {
+			fk.report(
+				FsckHashMismatch, nodePath, cmt, false,
+				"file hash is %s, but should be %s",
+				file.TreeHash().B58String(), computed.B58String(),
+			)
+		}
+
+		if fk.checkFile == nil {
+			return nd, nil
+		}
+
+		// Several files might share the same content:
+		contentKey := file.BackendHash().B58String()
+		if _, ok := fk.seenContent[contentKey]; ok {
+			return nd, nil
+		}
+
+		// Fetching the content might take long; it is done by CheckContent().
+		fk.seenContent[contentKey] = struct{}{}
+		fk.content = append(fk.content, fsckContent{file: file, cmt: cmt})
+	}
+
+	return nd, nil
+}
+
+func (fk *Fsck) checkCommit(cmt *n.Commit) error {
+	for cmt != nil {
+		b58Hash := cmt.TreeHash().B58String()
+		if _, ok := fk.seenCommit[b58Hash]; ok {
+			return nil
+		}
+
+		fk.seenCommit[b58Hash] = struct{}{}
+
+		if computed := cmt.ComputeTreeHash(); !computed.Equal(cmt.TreeHash()) {
+			fk.report(
+				FsckHashMismatch, "", cmt, false,
+				"commit hash should be %s", computed.B58String(),
+			)
+		}
+
+		root, err := fk.checkNode(cmt.Root(), "/", cmt)
+		if err != nil {
+			return err
+		}
+
+		if root != nil && root.Type() != n.NodeTypeDirectory {
+			fk.report(FsckHashMismatch, "/", cmt, false, "root is not a directory but a %v", root.Type())
+		}
+
+		parentHash := cmt.ParentHash()
+		if parentHash == nil {
+			return nil
+		}
+
+		parent, _, err := fk.loadObject(parentHash, "", cmt)
+		if err != nil {
+			return err
+		}
+
+		if parent == nil {
+			fk.report(FsckMissingObject, "", cmt, false, "parent commit %s is missing", parentHash.B58String())
+			return nil
+		}
+
+		parentCmt, ok := parent.(*n.Commit)
+		if !ok {
+			fk.report(FsckHashMismatch, "", cmt, false, "parent %s is not a commit", parentHash.B58String())
+			return nil
+		}
+
+		cmt = parentCmt
+	}
+
+	return nil
+}
+
+// checkRefs checks that every ref (including branches and stashes) resolves
+// and returns all commits that are pointed to by a ref.
+func (fk *Fsck) checkRefs(status *n.Commit) ([]*n.Commit, error) {
+	refs, err := fk.lkr.ListRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(refs)
+
+	keys := [][]string{}
+	for _, ref := range refs {
+		keys = append(keys, []string{"refs", ref})
+	}
+
+	for _, bucket := range []string{branchRefs, stashRefs} {
+		bucketKeys, err := fk.lkr.kv.Keys("refs", bucket)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range bucketKeys {
+			if len(key) == 3 && key[1] == bucket {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	cmts := []*n.Commit{status}
+	for _, key := range keys {
+		data, err := fk.lkr.kv.Get(key...)
+		if err != nil {
+			return nil, err
+		}
+
+		var nd n.Node
+		hash, err := h.FromB58String(string(data))
+		if err == nil {
+			if hash.Equal(status.TreeHash()) {
+				nd = status
+			} else if nd, _, err = fk.loadObject(hash, "", nil); err != nil {
+				return nil, err
+			}
+		}
+
+		if nd != nil {
+			if cmt, ok := nd.(*n.Commit); ok {
+				cmts = append(cmts, cmt)
+			}
+
+			continue
+		}
+
+		ref := strings.Join(key[1:], "/")
+		repaired := false
+		if fk.repair {
+			if len(key) == 2 {
+				repaired, err = fk.repairRef(ref)
+			} else {
+				repaired = true
+				err = fk.lkr.AtomicWithBatch(func(batch db.Batch) (bool, error) {
+					batch.Erase(key...)
+					return false, nil
+				})
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		fk.report(FsckDanglingRef, "", nil, repaired, "ref `%s` points to unknown node `%s`", ref, data)
+	}
+
+	return cmts, nil
+}
+
+// repairRef removes `ref`. Refs needed by the system are set to
+// sensible values instead; they are never removed. It returns false
+// if no sensible value could be found.
+func (fk *Fsck) repairRef(ref string) (bool, error) {
+	switch ref {
+	case "curr":
+		status, err := fk.lkr.Status()
+		if err != nil {
+			return false, err
+		}
+
+		return true, fk.lkr.SaveRef("curr", status)
+	case "head":
+		status, err := fk.lkr.Status()
+		if err != nil {
+			return false, err
+		}
+
+		// The newest commit is the parent of the status:
+		parentHash := status.ParentHash()
+		if parentHash == nil {
+			return false, nil
+		}
+
+		parent, err := fk.lkr.CommitByHash(parentHash)
+		if err != nil || parent == nil {
+			return false, nil
+		}
+
+		return true, fk.lkr.SaveRef("head", parent)
+	}
+
+	return true, fk.lkr.RemoveRef(ref)
+}
+
+// headChain returns all commits from HEAD to the first commit,
+// indexed by their commit index.
+func (fk *Fsck) headChain() (map[int64]*n.Commit, error) {
+	chain := make(map[int64]*n.Commit)
+
+	status, err := fk.lkr.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := status.ParentHash()
+	for hash != nil {
+		nd, _, err := fk.loadObject(hash, "", nil)
+		if err != nil {
+			return nil, err
+		}
+
+		cmt, ok := nd.(*n.Commit)
+		if !ok {
+			break
+		}
+
+		if _, ok := chain[cmt.Index()]; ok {
+			break
+		}
+
+		chain[cmt.Index()] = cmt
+		hash = cmt.ParentHash()
+	}
+
+	return chain, nil
+}
+
+func (fk *Fsck) checkIndex() error {
+	chain, err := fk.headChain()
+	if err != nil {
+		return err
+	}
+
+	keys, err := fk.lkr.kv.Keys("index")
+	if err != nil {
+		return err
+	}
+
+	needsRebuild := false
+	indexed := make(map[int64]bool)
+	for _, key := range keys {
+		index, err := strconv.ParseInt(key[len(key)-1], 10, 64)
+		if err != nil {
+			fk.report(FsckBadIndex, "", nil, fk.repair, "bad index key %s", strings.Join(key, "/"))
+			needsRebuild = true
+			continue
+		}
+
+		indexed[index] = true
+
+		data, err := fk.lkr.kv.Get(key...)
+		if err != nil {
+			return err
+		}
+
+		cmt, ok := chain[index]
+		if !ok {
+			// Not part of the head chain; only complain if it can't be resolved.
+			hash, err := h.FromB58String(string(data))
+			if err == nil {
+				if nd, _, err := fk.loadObject(hash, "", nil); err != nil {
+					return err
+				} else if _, ok := nd.(*n.Commit); ok {
+					continue
+				}
+			}
+
+			fk.report(FsckBadIndex, "", nil, fk.repair, "index %d points to unknown commit `%s`", index, data)
+			needsRebuild = true
+			continue
+		}
+
+		if cmt.TreeHash().B58String() != string(data) {
+			fk.report(FsckBadIndex, "", cmt, fk.repair, "index %d points to `%s`", index, data)
+			needsRebuild = true
+		}
+	}
+
+	for index, cmt := range chain {
+		if !indexed[index] {
+			fk.report(FsckBadIndex, "", cmt, fk.repair, "commit with index %d is not indexed", index)
+			needsRebuild = true
+		}
+	}
+
+	if !fk.repair || !needsRebuild {
+		return nil
+	}
+
+	return fk.lkr.AtomicWithBatch(func(batch db.Batch) (bool, error) {
+		if err := batch.Clear("index"); err != nil {
+			return hintRollback(err)
+		}
+
+		for index, cmt := range chain {
+			batch.Put(
+				[]byte(cmt.TreeHash().B58String()),
+				"index", strconv.FormatInt(index, 10),
+			)
+		}
+
+		return false, nil
+	})
+}
+
+// collectTreeFixes compares the tree/ entries of `nd` and its children
+// with their actual hashes. Broken nodes were already reported and
+// are skipped, so that no tree entry is pointed to them.
+func (fk *Fsck) collectTreeFixes(hash h.Hash, head *n.Commit, fixes map[string]string) error {
+	nd, err := fk.lkr.loadNode(hash)
+	if err != nil || nd == nil || !nd.TreeHash().Equal(hash) {
+		return nil
+	}
+
+	nodePath := nd.Path()
+	if nd.Type() == n.NodeTypeDirectory {
+		nodePath = appendDot(nodePath)
+	}
+
+	b58Hash := hash.B58String()
+	data, err := fk.lkr.kv.Get("tree", nodePath)
+	if err != nil && err != db.ErrNoSuchKey {
+		return err
+	}
+
+	if string(data) != b58Hash {
+		fk.report(
+			FsckBadTreeEntry, nd.Path(), head, fk.repair,
+			"tree entry is `%s` instead of %s", data, b58Hash,
+		)
+		fixes[nodePath] = b58Hash
+	}
+
+	dir, ok := nd.(*n.Directory)
+	if !ok {
+		return nil
+	}
+
+	for _, childHash := range dir.ChildHashes() {
+		if err := fk.collectTreeFixes(childHash, head, fixes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (fk *Fsck) checkTree() error {
+	head, err := fk.lkr.Head()
+	if err != nil {
+		// Probably no commit yet; nothing to check then.
+		return nil
+	}
+
+	fixes := make(map[string]string)
+	if err := fk.collectTreeFixes(head.Root(), head, fixes); err != nil {
+		return err
+	}
+
+	if !fk.repair || len(fixes) == 0 {
+		return nil
+	}
+
+	return fk.lkr.AtomicWithBatch(func(batch db.Batch) (bool, error) {
+		for nodePath, b58Hash := range fixes {
+			batch.Put([]byte(b58Hash), "tree", nodePath)
+		}
+
+		return false, nil
+	})
+}
+
+func (fk *Fsck) checkMoves() error {
+	// Staged moves refer to inodes which might be gone legitimately,
+	// so only the committed move mapping is checked.
+	keys, err := fk.lkr.kv.Keys("moves")
+	if err != nil {
+		return err
+	}
+
+	dangling := [][]string{}
+	for _, key := range keys {
+		data, err := fk.lkr.kv.Get(key...)
+		if err != nil {
+			return err
+		}
+
+		nd, _, err := fk.lkr.parseMoveMappingLine(string(data))
+		if err == nil && nd != nil {
+			continue
+		}
+
+		msg := fmt.Sprintf("`%s` does not resolve", data)
+		if err != nil {
+			msg = err.Error()
+		}
+
+		fk.report(FsckDanglingMove, "", nil, fk.repair, "%s: %s", strings.Join(key, "/"), msg)
+		dangling = append(dangling, key)
+	}
+
+	if !fk.repair || len(dangling) == 0 {
+		return nil
+	}
+
+	return fk.lkr.AtomicWithBatch(func(batch db.Batch) (bool, error) {
+		for _, key := range dangling {
+			batch.Erase(key...)
+		}
+
+		return false, nil
+	})
+}
+
+// Run checks the linker and returns all found problems.
+// An error is only returned if the check itself could not be done.
+func (fk *Fsck) Run() ([]FsckProblem, error) {
+	fk.problems = []FsckProblem{}
+	fk.seenNodes = make(map[string]n.Node)
+	fk.seenContent = make(map[string]struct{})
+	fk.seenCommit = make(map[string]struct{})
+	fk.content = nil
+
+	status, err := fk.lkr.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	cmts, err := fk.checkRefs(status)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cmt := range cmts {
+		if err := fk.checkCommit(cmt); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, check := range []func() error{
+		fk.checkIndex,
+		fk.checkTree,
+		fk.checkMoves,
+	} {
+		if err := check(); err != nil {
+			return nil, err
+		}
+	}
+
+	if fk.repair {
+		// Cached nodes might be outdated now.
+		fk.lkr.MemIndexClear()
+	}
+
+	return fk.problems, nil
+}
+
+// CheckContent calls the `checkFile` function passed to NewFsck() for every
+// distinct file content that Run() came across and returns the problems it
+// found. Unlike Run() it does not access the linker, so the caller does not
+// need to keep it locked while the content is fetched.
+func (fk *Fsck) CheckContent() []FsckProblem {
+	start := len(fk.problems)
+	for _, content := range fk.content {
+		file := content.file
+		if err := fk.checkFile(file); err != nil {
+			fk.report(FsckBadContent, file.Path(), content.cmt, false, "%s: %v", file.BackendHash().B58String(), err)
+		}
+	}
+
+	return fk.problems[start:]
+}
@@ -0,0 +1,205 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	n "github.com/sahib/brig/catfs/nodes"
+	h "github.com/sahib/brig/util/hashlib"
+	"github.com/stretchr/testify/require"
+)
+
+func setupFsckLinker(t *testing.T, lkr *Linker) {
+	MustMkdir(t, lkr, "/sub/dir")
+	MustTouchAndCommit(t, lkr, "/sub/dir/x", 1)
+	MustTouchAndCommit(t, lkr, "/sub/y", 2)
+
+	x, err := lkr.LookupModNode("/sub/dir/x")
+	require.Nil(t, err)
+	MustMove(t, lkr, x, "/z")
+	MustCommit(t, lkr, "move")
+
+	y, err := lkr.LookupModNode("/sub/y")
+	require.Nil(t, err)
+	MustRemove(t, lkr, y)
+	MustCommit(t, lkr, "remove")
+
+	// Leave something in the staging area too:
+	MustTouch(t, lkr, "/staged", 3)
+}
+
+func mustFsck(t *testing.T, lkr *Linker, repair bool) []FsckProblem {
+	problems, err := NewFsck(lkr, repair, nil).Run()
+	require.Nil(t, err)
+	return problems
+}
+
+func problemKinds(problems []FsckProblem) []string {
+	kinds := []string{}
+	for _, problem := range problems {
+		kinds = append(kinds, problem.Kind)
+	}
+
+	return kinds
+}
+
+func TestFsckClean(t *testing.T) {
+	WithDummyLinker(t, func(lkr *Linker) {
+		setupFsckLinker(t, lkr)
+		require.Empty(t, mustFsck(t, lkr, false))
+	})
+}
+
+func TestFsckDanglingRef(t *testing.T) {
+	WithDummyLinker(t, func(lkr *Linker) {
+		setupFsckLinker(t, lkr)
+
+		batch := lkr.kv.Batch()
+		batch.Put([]byte(h.TestDummy(t, 42).B58String()), "refs", "broken")
+		require.Nil(t, batch.Flush())
+
+		problems := mustFsck(t, lkr, false)
+		require.Len(t, problems, 1)
+		require.Equal(t, FsckDanglingRef, problems[0].Kind)
+		require.False(t, problems[0].Repaired)
+
+		problems = mustFsck(t, lkr, true)
+		require.Len(t, problems, 1)
+		require.True(t, problems[0].Repaired)
+
+		refs, err := lkr.ListRefs()
+		require.Nil(t, err)
+		require.NotContains(t, refs, "broken")
+		require.Empty(t, mustFsck(t, lkr, false))
+	})
+}
+
+func TestFsckDanglingHeadIsKept(t *testing.T) {
+	WithDummyLinker(t, func(lkr *Linker) {
+		setupFsckLinker(t, lkr)
+		head, err := lkr.Head()
+		require.Nil(t, err)
+
+		// Break head and make sure it can not be repaired from the status:
+		batch := lkr.kv.Batch()
+		batch.Put([]byte(h.TestDummy(t, 42).B58String()), "refs", "head")
+		batch.Erase("objects", head.TreeHash().B58String())
+		require.Nil(t, batch.Flush())
+		lkr.MemIndexClear()
+
+		found := false
+		for _, problem := range mustFsck(t, lkr, true) {
+			if problem.Kind == FsckDanglingRef {
+				require.False(t, problem.Repaired)
+				found = true
+			}
+		}
+
+		require.True(t, found)
+
+		data, err := lkr.kv.Get("refs", "head")
+		require.Nil(t, err)
+		require.Equal(t, h.TestDummy(t, 42).B58String(), string(data))
+	})
+}
+
+func TestFsckBadObjects(t *testing.T) {
+	WithDummyLinker(t, func(lkr *Linker) {
+		setupFsckLinker(t, lkr)
+		head, err := lkr.Head()
+		require.Nil(t, err)
+
+		root, err := lkr.DirectoryByHash(head.Root())
+		require.Nil(t, err)
+
+		// Break the size of the root directory:
+		root.SetSize(root.Size() + 100)
+		data, err := n.MarshalNode(root)
+		require.Nil(t, err)
+
+		// Let a file be stored under a different hash:
+		file, err := lkr.LookupFile("/z")
+		require.Nil(t, err)
+		file.SetContent(lkr, h.TestDummy(t, 23))
+		fileData, err := n.MarshalNode(file)
+		require.Nil(t, err)
+
+		subDir, err := lkr.LookupDirectory("/sub")
+		require.Nil(t, err)
+
+		batch := lkr.kv.Batch()
+		batch.Put(data, "objects", head.Root().B58String())
+		batch.Put(fileData, "objects", subDir.TreeHash().B58String())
+		require.Nil(t, batch.Flush())
+		lkr.MemIndexClear()
+
+		kinds := problemKinds(mustFsck(t, lkr, false))
+		require.Contains(t, kinds, FsckSizeMismatch)
+		require.Contains(t, kinds, FsckHashMismatch)
+
+		// Only the size can be repaired:
+		problems := mustFsck(t, lkr, true)
+		for _, problem := range problems {
+			require.Equal(t, problem.Kind == FsckSizeMismatch, problem.Repaired)
+		}
+
+		kinds = problemKinds(mustFsck(t, lkr, false))
+		require.NotContains(t, kinds, FsckSizeMismatch)
+		require.Contains(t, kinds, FsckHashMismatch)
+	})
+}
+
+func TestFsckIndexAndTree(t *testing.T) {
+	WithDummyLinker(t, func(lkr *Linker) {
+		setupFsckLinker(t, lkr)
+
+		batch := lkr.kv.Batch()
+		batch.Erase("index", "1")
+		batch.Put([]byte(h.TestDummy(t, 42).B58String()), "index", "2")
+		batch.Erase("tree", "/z")
+		batch.Put([]byte("garbage"), "moves", "overlay", "garbage")
+		require.Nil(t, batch.Flush())
+
+		kinds := problemKinds(mustFsck(t, lkr, true))
+		require.Contains(t, kinds, FsckBadIndex)
+		require.Contains(t, kinds, FsckBadTreeEntry)
+		require.Contains(t, kinds, FsckDanglingMove)
+
+		require.Empty(t, mustFsck(t, lkr, false))
+
+		cmt, err := lkr.CommitByIndex(2)
+		require.Nil(t, err)
+		require.Equal(t, "cmt 2", cmt.Message())
+
+		nd, err := lkr.ResolveNode("/z")
+		require.Nil(t, err)
+		require.NotNil(t, nd)
+	})
+}
+
+func TestFsckContent(t *testing.T) {
+	WithDummyLinker(t, func(lkr *Linker) {
+		setupFsckLinker(t, lkr)
+
+		seen := 0
+		fsck := NewFsck(lkr, false, func(file *n.File) error {
+			seen++
+			if file.Path() == "/z" {
+				return errors.New("no such block")
+			}
+
+			return nil
+		})
+
+		problems, err := fsck.Run()
+		require.Nil(t, err)
+		require.Empty(t, problems)
+		require.Equal(t, 0, seen)
+
+		problems = fsck.CheckContent()
+		require.Equal(t, 3, seen)
+		require.Len(t, problems, 1)
+		require.Equal(t, FsckBadContent, problems[0].Kind)
+		require.Equal(t, "/z", problems[0].Path)
+	})
+}
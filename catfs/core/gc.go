@@ -58,6 +58,11 @@ func (gc *GarbageCollector) mark(cmt *n.Commit, recursive bool) error {
 		return nil
 	}
 
+	// Branches share most of their history; no need to mark it twice.
+	if _, ok := gc.markMap[cmt.TreeHash().B58String()]; ok && recursive {
+		return nil
+	}
+
 	root, err := gc.lkr.DirectoryByHash(cmt.Root())
 	if err != nil {
 		return err
@@ -136,12 +141,20 @@ func (gc *GarbageCollector) sweep(prefix []string) (int, error) {
 	})
 }
 
-func (gc *GarbageCollector) findAllMoveLocations(head *n.Commit) ([][]string, error) {
-	locations := [][]string{
-		{"stage", "moves"},
-	}
+// findAllMoveLocations returns the move mappings of `head` and of all of its
+// parents. Locations in `seen` are skipped.
+func (gc *GarbageCollector) findAllMoveLocations(head *n.Commit, seen map[string]bool) ([][]string, error) {
+	locations := [][]string{}
+
+	for head != nil {
+		b58Hash := head.TreeHash().B58String()
+		if seen[b58Hash] {
+			break
+		}
+
+		seen[b58Hash] = true
+		locations = append(locations, []string{"moves", b58Hash})
 
-	for {
 		parent, err := head.Parent(gc.lkr)
 		if err != nil {
 			return nil, err
@@ -157,13 +170,55 @@ func (gc *GarbageCollector) findAllMoveLocations(head *n.Commit) ([][]string, er
 		}
 
 		head = parentCmt
-		location := []string{"moves", head.TreeHash().B58String()}
-		locations = append(locations, location)
 	}
 
 	return locations, nil
 }
 
+// refCommits returns the commits that refs point to, which
+// are not necessarily reachable from the staging commit:
+// the tips of other branches and the stashes.
+func (gc *GarbageCollector) refCommits() ([]*n.Commit, error) {
+	cmts := []*n.Commit{}
+	for _, bucket := range []string{branchRefs, stashRefs} {
+		keys, err := gc.kv.Keys("refs", bucket)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			if len(key) != 3 || key[1] != bucket {
+				continue
+			}
+
+			data, err := gc.kv.Get(key...)
+			if err != nil {
+				return nil, err
+			}
+
+			hash, err := h.FromB58String(string(data))
+			if err != nil {
+				return nil, err
+			}
+
+			cmt, err := gc.lkr.CommitByHash(hash)
+			if err != nil {
+				return nil, err
+			}
+
+			if cmt == nil {
+				// Nothing to keep alive; fsck will complain about it.
+				log.Warningf("gc: ref %v points to unknown commit %s", key, data)
+				continue
+			}
+
+			cmts = append(cmts, cmt)
+		}
+	}
+
+	return cmts, nil
+}
+
 // Run will trigger a GC run. If `allObjects` is false,
 // only the staging commit will be checked. Otherwise
 // all objects in the key value store.
@@ -174,10 +229,6 @@ func (gc *GarbageCollector) Run(allObjects bool) error {
 		return err
 	}
 
-	if err := gc.mark(head, allObjects); err != nil {
-		return err
-	}
-
 	// Staging might contain moved files that are not reachable anymore,
 	// but still are referenced by the move mapping.
 	// Keep them for now, they will die most likely on MakeCommit()
@@ -185,11 +236,33 @@ func (gc *GarbageCollector) Run(allObjects bool) error {
 		{"stage", "moves"},
 	}
 
+	roots := []*n.Commit{head}
 	if allObjects {
-		moveMapLocations, err = gc.findAllMoveLocations(head)
+		// Other branches and stashes are not reachable from the staging commit:
+		refCmts, err := gc.refCommits()
 		if err != nil {
 			return err
 		}
+
+		roots = append(roots, refCmts...)
+	}
+
+	seen := make(map[string]bool)
+	for _, root := range roots {
+		if err := gc.mark(root, allObjects); err != nil {
+			return err
+		}
+
+		if !allObjects {
+			continue
+		}
+
+		locations, err := gc.findAllMoveLocations(root, seen)
+		if err != nil {
+			return err
+		}
+
+		moveMapLocations = append(moveMapLocations, locations...)
 	}
 
 	for _, location := range moveMapLocations {
@@ -5,6 +5,7 @@ import (
 
 	"github.com/sahib/brig/catfs/db"
 	n "github.com/sahib/brig/catfs/nodes"
+	h "github.com/sahib/brig/util/hashlib"
 	"github.com/stretchr/testify/require"
 )
 
@@ -106,3 +107,56 @@ func TestGC(t *testing.T) {
 		t.Fatalf("Third gc run failed: %v", err)
 	}
 }
+
+func TestGCKeepsBranches(t *testing.T) {
+	WithDummyLinker(t, func(lkr *Linker) {
+		_, base := MustTouchAndCommit(t, lkr, "/x", 1)
+		require.Nil(t, lkr.MakeBranch("dev", base))
+		require.Nil(t, lkr.SwitchBranch("dev", false))
+
+		file, _ := MustTouchAndCommit(t, lkr, "/y", 2)
+		MustMove(t, lkr, file, "/z")
+		devCmt := MustCommit(t, lkr, "move on dev")
+
+		require.Nil(t, lkr.SwitchBranch(DefaultBranch, false))
+
+		gc := NewGarbageCollector(lkr, lkr.kv, nil)
+		require.Nil(t, gc.Run(true))
+		lkr.MemIndexClear()
+
+		tip, err := lkr.BranchTip("dev")
+		require.Nil(t, err)
+		require.Equal(t, devCmt.TreeHash(), tip.TreeHash())
+
+		nd, err := lkr.LookupNodeAt(tip, "/z")
+		require.Nil(t, err)
+		require.Equal(t, h.TestDummy(t, 2), nd.(*n.File).ContentHash())
+
+		ghost, err := lkr.LookupNodeAt(tip, "/y")
+		require.Nil(t, err)
+		moved, _, err := lkr.MoveEntryPoint(ghost)
+		require.Nil(t, err)
+		require.NotNil(t, moved)
+	})
+}
+
+func TestGCKeepsStashes(t *testing.T) {
+	WithDummyLinker(t, func(lkr *Linker) {
+		MustTouchAndCommit(t, lkr, "/x", 1)
+		MustTouch(t, lkr, "/stashed", 2)
+		stash, err := lkr.MakeStash(n.AuthorOfStage, "stash")
+		require.Nil(t, err)
+
+		gc := NewGarbageCollector(lkr, lkr.kv, nil)
+		require.Nil(t, gc.Run(true))
+		lkr.MemIndexClear()
+
+		loaded, err := lkr.Stash(0)
+		require.Nil(t, err)
+		require.Equal(t, stash.TreeHash(), loaded.TreeHash())
+
+		nd, err := lkr.LookupNodeAt(loaded, "/stashed")
+		require.Nil(t, err)
+		require.Equal(t, h.TestDummy(t, 2), nd.(*n.File).ContentHash())
+	})
+}
@@ -14,9 +14,12 @@ package core
 // stage/STATUS                          => COMMIT_METADATA
 // stage/moves/<INODE>                   => MOVE_INFO
 // stage/moves/overlay/<INODE>           => MOVE_INFO
+// stage/BRANCH                          => BRANCH_NAME
 //
 // stats/max-inode                       => UINT64
 // refs/<REFNAME>                        => NODE_HASH
+// refs/branches/<BRANCH_NAME>           => COMMIT_HASH
+// refs/stash/<SEQ>                      => COMMIT_HASH
 //
 // Defined by caller:
 //
@@ -30,6 +33,11 @@ package core
 // HEAD -> Points to the latest finished commit, or nil.
 // CURR -> Points to the staging commit.
 //
+// HEAD is always the tip of the current branch (stage/BRANCH).
+// The refs in refs/branches/ are only used for the other branches.
+// Stashes are commits that have HEAD (at stash time) as parent,
+// but are not part of index/ or tree/.
+//
 // In git terminology, this file implements the following commands:
 //
 // - git add:    StageNode(): Create and Update Nodes.
@@ -793,6 +801,10 @@ func (lkr *Linker) ResolveRef(refname string) (n.Node, error) {
 // resolvable.
 func (lkr *Linker) SaveRef(refname string, nd n.Node) error {
 	refname = strings.ToLower(refname)
+	if refname == branchRefs || refname == stashRefs {
+		return fmt.Errorf("`%s` is reserved and can't be used as ref name", refname)
+	}
+
 	return lkr.AtomicWithBatch(func(batch db.Batch) (bool, error) {
 		batch.Put([]byte(nd.TreeHash().B58String()), "refs", refname)
 		return false, nil
@@ -808,7 +820,9 @@ func (lkr *Linker) ListRefs() ([]string, error) {
 	}
 
 	for _, key := range keys {
-		if len(key) <= 1 {
+		// Branches and stashes are stored one level deeper
+		// and are listed by ListBranches and ListStashes.
+		if len(key) != 2 {
 			continue
 		}
 
@@ -0,0 +1,217 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/sahib/brig/catfs/db"
+	ie "github.com/sahib/brig/catfs/errors"
+	n "github.com/sahib/brig/catfs/nodes"
+	h "github.com/sahib/brig/util/hashlib"
+)
+
+const (
+	// stashRefs is the sub-bucket of refs/ where stashes are stored.
+	stashRefs = "stash"
+)
+
+// stashKeys returns the sequence numbers of all stashes, newest first.
+func (lkr *Linker) stashKeys() ([]int64, error) {
+	keys, err := lkr.kv.Keys("refs", stashRefs)
+	if err != nil {
+		return nil, err
+	}
+
+	seqs := []int64{}
+	for _, key := range keys {
+		if len(key) != 3 || key[1] != stashRefs {
+			continue
+		}
+
+		seq, err := strconv.ParseInt(key[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad stash key `%v`: %v", key, err)
+		}
+
+		seqs = append(seqs, seq)
+	}
+
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqs[i] > seqs[j]
+	})
+
+	return seqs, nil
+}
+
+func (lkr *Linker) stashKey(idx int) ([]string, error) {
+	seqs, err := lkr.stashKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	if idx < 0 || idx >= len(seqs) {
+		return nil, ie.ErrNoSuchStash(idx)
+	}
+
+	return []string{"refs", stashRefs, strconv.FormatInt(seqs[idx], 10)}, nil
+}
+
+// MakeStash saves all staged changes as a new stash with `message`
+// and resets the staging area to HEAD afterwards. The stash is a commit
+// whose parent is HEAD, but which is not part of the history.
+// ErrNoChange is returned if there is nothing to stash.
+func (lkr *Linker) MakeStash(author, message string) (*n.Commit, error) {
+	head, err := lkr.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := lkr.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	if status.Root().Equal(head.Root()) {
+		return nil, ie.ErrNoChange
+	}
+
+	rootDir, err := lkr.Root()
+	if err != nil {
+		return nil, err
+	}
+
+	seqs, err := lkr.stashKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	nextSeq := int64(0)
+	if len(seqs) > 0 {
+		nextSeq = seqs[0] + 1
+	}
+
+	err = lkr.AtomicWithBatch(func(batch db.Batch) (bool, error) {
+		// Like in MakeCommit, but the nodes are not part of tree/,
+		// since this only describes the state of HEAD.
+		exportedInodes := make(map[uint64]bool)
+		err := n.Walk(lkr, rootDir, true, func(child n.Node) error {
+			data, err := n.MarshalNode(child)
+			if err != nil {
+				return err
+			}
+
+			batch.Put(data, "objects", child.TreeHash().B58String())
+			exportedInodes[child.Inode()] = true
+			return nil
+		})
+
+		if err != nil {
+			return true, err
+		}
+
+		if err := status.SetParent(lkr, head); err != nil {
+			return true, err
+		}
+
+		if err := status.BoxCommit(author, message); err != nil {
+			return true, err
+		}
+
+		statusData, err := n.MarshalNode(status)
+		if err != nil {
+			return true, err
+		}
+
+		statusB58Hash := status.TreeHash().B58String()
+		batch.Put(statusData, "objects", statusB58Hash)
+		batch.Put([]byte(statusB58Hash), "refs", stashRefs, strconv.FormatInt(nextSeq, 10))
+
+		// Keep the staged moves, so they can be detected on pop:
+		if err := lkr.commitMoveMapping(status, exportedInodes); err != nil {
+			return true, err
+		}
+
+		if err := lkr.clearStage(batch); err != nil {
+			return true, err
+		}
+
+		newStatus, err := n.NewEmptyCommit(lkr.NextInode(), head.Index()+1)
+		if err != nil {
+			return true, err
+		}
+
+		newStatus.SetRoot(head.Root())
+		return hintRollback(lkr.saveStatus(newStatus))
+	})
+
+	// Nodes of the stashed state might be still cached:
+	lkr.MemIndexClear()
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// Stash returns the stash at `idx`, where 0 is the newest one.
+func (lkr *Linker) Stash(idx int) (*n.Commit, error) {
+	key, err := lkr.stashKey(idx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := lkr.kv.Get(key...)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := h.FromB58String(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	cmt, err := lkr.CommitByHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if cmt == nil {
+		return nil, fmt.Errorf("stash %d points to unknown commit `%s`", idx, data)
+	}
+
+	return cmt, nil
+}
+
+// ListStashes returns all stashes, newest first.
+func (lkr *Linker) ListStashes() ([]*n.Commit, error) {
+	seqs, err := lkr.stashKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	stashes := []*n.Commit{}
+	for idx := range seqs {
+		cmt, err := lkr.Stash(idx)
+		if err != nil {
+			return nil, err
+		}
+
+		stashes = append(stashes, cmt)
+	}
+
+	return stashes, nil
+}
+
+// DropStash removes the stash at `idx`, where 0 is the newest one.
+func (lkr *Linker) DropStash(idx int) error {
+	key, err := lkr.stashKey(idx)
+	if err != nil {
+		return err
+	}
+
+	return lkr.AtomicWithBatch(func(batch db.Batch) (bool, error) {
+		batch.Erase(key...)
+		return false, nil
+	})
+}
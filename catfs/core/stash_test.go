@@ -0,0 +1,71 @@
+package core
+
+import (
+	"testing"
+
+	ie "github.com/sahib/brig/catfs/errors"
+	n "github.com/sahib/brig/catfs/nodes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStash(t *testing.T) {
+	WithDummyLinker(t, func(lkr *Linker) {
+		_, head := MustTouchAndCommit(t, lkr, "/x", 1)
+
+		_, err := lkr.MakeStash(n.AuthorOfStage, "nothing")
+		require.Equal(t, ie.ErrNoChange, err)
+
+		MustTouch(t, lkr, "/first", 2)
+		first, err := lkr.MakeStash(n.AuthorOfStage, "first")
+		require.Nil(t, err)
+		require.Equal(t, "first", first.Message())
+
+		// The stage is clean again and HEAD did not move:
+		haveStaged, err := lkr.HaveStagedChanges()
+		require.Nil(t, err)
+		require.False(t, haveStaged)
+
+		currHead, err := lkr.Head()
+		require.Nil(t, err)
+		require.Equal(t, head.TreeHash(), currHead.TreeHash())
+
+		nd, err := lkr.ResolveNode("/first")
+		require.Nil(t, err)
+		require.Nil(t, nd)
+
+		MustTouch(t, lkr, "/second", 3)
+		_, err = lkr.MakeStash(n.AuthorOfStage, "second")
+		require.Nil(t, err)
+
+		stashes, err := lkr.ListStashes()
+		require.Nil(t, err)
+		require.Len(t, stashes, 2)
+		require.Equal(t, "second", stashes[0].Message())
+		require.Equal(t, "first", stashes[1].Message())
+
+		// Stashes have HEAD as parent:
+		parent, err := stashes[1].Parent(lkr)
+		require.Nil(t, err)
+		require.Equal(t, head.TreeHash(), parent.TreeHash())
+
+		stashedFile, err := lkr.LookupNodeAt(stashes[1], "/first")
+		require.Nil(t, err)
+		require.Equal(t, "/first", stashedFile.Path())
+
+		require.Empty(t, mustFsck(t, lkr, false))
+
+		require.Nil(t, lkr.DropStash(0))
+		stash, err := lkr.Stash(0)
+		require.Nil(t, err)
+		require.Equal(t, "first", stash.Message())
+
+		require.True(t, ie.IsErrNoSuchStash(lkr.DropStash(1)))
+		_, err = lkr.Stash(-1)
+		require.True(t, ie.IsErrNoSuchStash(err))
+
+		refs, err := lkr.ListRefs()
+		require.Nil(t, err)
+		require.NotContains(t, refs, stashRefs)
+		require.NotNil(t, lkr.SaveRef(stashRefs, head))
+	})
+}
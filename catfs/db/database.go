@@ -1,13 +1,22 @@
 package db
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
 	"errors"
+	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 )
 
 var (
 	// ErrNoSuchKey is returned when Get() was passed a non-existent key
 	ErrNoSuchKey = errors.New("This key does not exist")
+
+	// ErrBadDump is returned by Load when the input was not written by Dump.
+	ErrBadDump = errors.New("not a metadata dump")
 )
 
 // Batch is an API object used to model a transaction.
@@ -54,8 +63,8 @@ type Database interface {
 	// to the number Batch() was called.
 	Batch() Batch
 
-	// Export backups all database content to `w` in
-	// an implemenation specific format that can be read by Import.
+	// Export backups all database content to `w` in the format written
+	// by Dump. It can be read by Import of every implementation.
 	Export(w io.Writer) error
 
 	// Import reads a previously exported db dump by Export from `r`.
@@ -80,3 +89,160 @@ func CopyKey(db Database, src, dst []string) error {
 	batch.Put(data, dst...)
 	return batch.Flush()
 }
+
+const (
+	// BackendBadger is the name of the badger implementation for Open.
+	BackendBadger = "badger"
+	// BackendBolt is the name of the bolt implementation for Open.
+	BackendBolt = "bolt"
+)
+
+// Backends lists the names of all implementations that can be used with Open.
+var Backends = []string{BackendBadger, BackendBolt}
+
+// Open opens the database implementation called `backend` in the directory `path`.
+func Open(backend, path string) (Database, error) {
+	switch backend {
+	case BackendBadger:
+		return NewBadgerDatabase(path)
+	case BackendBolt:
+		return NewBoltDatabase(path)
+	default:
+		return nil, fmt.Errorf("unknown database backend: %s", backend)
+	}
+}
+
+// DetectBackend returns the name of the implementation that stored data
+// in the directory `path`. If there is no data, an empty string is returned.
+func DetectBackend(path string) (string, error) {
+	markers := []struct {
+		backend string
+		name    string
+	}{
+		{BackendBolt, BoltFileName},
+		{BackendBadger, "MANIFEST"},
+	}
+
+	for _, marker := range markers {
+		_, err := os.Stat(filepath.Join(path, marker.name))
+		if err == nil {
+			return marker.backend, nil
+		}
+
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	return "", nil
+}
+
+// dumpHeader is written before the first entry of a dump.
+// It lets Import tell a dump apart from older, implementation specific exports.
+var dumpHeader = []byte("brig-db-dump:1\n")
+
+// isDump checks if `br` starts with a dump written by Dump.
+// Nothing is consumed from `br`.
+func isDump(br *bufio.Reader) bool {
+	header, err := br.Peek(len(dumpHeader))
+	return err == nil && bytes.Equal(header, dumpHeader)
+}
+
+type dumpEntry struct {
+	Key   []string
+	Value []byte
+}
+
+type dumpEncoder struct {
+	enc *gob.Encoder
+}
+
+func newDumpEncoder(w io.Writer) *dumpEncoder {
+	return &dumpEncoder{enc: gob.NewEncoder(w)}
+}
+
+func (de *dumpEncoder) encode(key []string, val []byte) error {
+	return de.enc.Encode(dumpEntry{Key: key, Value: val})
+}
+
+// Dump writes all keys and values of `db` to `w`. The format does not
+// depend on the implementation, so it can be read by Load into any other
+// implementation. All implementations use it for Export.
+func Dump(db Database, w io.Writer) error {
+	keys, err := db.Keys()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(dumpHeader); err != nil {
+		return err
+	}
+
+	enc := newDumpEncoder(w)
+	for _, key := range keys {
+		val, err := db.Get(key...)
+		if err != nil {
+			return err
+		}
+
+		if err := enc.encode(key, val); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load reads a dump written by Dump from `r` and stores it in `db`.
+// Existing keys are overwritten if the dump also contains them.
+func Load(db Database, r io.Reader) error {
+	br := bufio.NewReader(r)
+	if !isDump(br) {
+		return ErrBadDump
+	}
+
+	if _, err := br.Discard(len(dumpHeader)); err != nil {
+		return err
+	}
+
+	dec := gob.NewDecoder(br)
+	batch := db.Batch()
+	for {
+		entry := dumpEntry{}
+		if err := dec.Decode(&entry); err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			batch.Rollback()
+			return err
+		}
+
+		batch.Put(entry.Value, entry.Key...)
+	}
+
+	return batch.Flush()
+}
+
+// Convert copies all keys and values of `src` to `dst`, which may be a
+// different implementation. This works since all of them share the
+// format of Export and Import.
+func Convert(dst, src Database) error {
+	pr, pw := io.Pipe()
+	exportErrCh := make(chan error, 1)
+	go func() {
+		err := src.Export(pw)
+		pw.CloseWithError(err)
+		exportErrCh <- err
+	}()
+
+	err := dst.Import(pr)
+
+	// Make sure Export() does not block forever if Import() failed early:
+	pr.CloseWithError(io.ErrClosedPipe)
+	if exportErr := <-exportErrCh; err == nil {
+		err = exportErr
+	}
+
+	return err
+}
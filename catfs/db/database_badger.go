@@ -1,6 +1,7 @@
 package db
 
 import (
+	"bufio"
 	"io"
 	"strings"
 	"sync"
@@ -133,19 +134,22 @@ func (db *BadgerDatabase) Keys(prefix ...string) ([][]string, error) {
 
 // Export is the badger implementation of Database.Export.
 func (db *BadgerDatabase) Export(w io.Writer) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
-	_, err := db.db.Backup(w, 0)
-	return err
+	return Dump(db, w)
 }
 
 // Import is the badger implementation of Database.Import.
+// Exports of older versions were written in badger's own backup format;
+// those are still accepted.
 func (db *BadgerDatabase) Import(r io.Reader) error {
+	br := bufio.NewReader(r)
+	if isDump(br) {
+		return Load(db, br)
+	}
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	return db.db.Load(r)
+	return db.db.Load(br)
 }
 
 // Glob is the badger implementation of Database.Glob
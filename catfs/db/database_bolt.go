@@ -0,0 +1,329 @@
+package db
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// BoltFileName is the name of the database file inside the directory
+	// that was passed to NewBoltDatabase.
+	BoltFileName = "bolt.db"
+)
+
+var (
+	boltBucket = []byte("brig")
+)
+
+// BoltDatabase is a database implementation based on bbolt.
+// All data is stored in a single file that does not need any compaction.
+type BoltDatabase struct {
+	mu         sync.Mutex
+	db         *bolt.DB
+	tx         *bolt.Tx
+	refCount   int
+	haveWrites bool
+}
+
+// NewBoltDatabase creates a new bolt database in the directory `path`.
+func NewBoltDatabase(path string) (*BoltDatabase, error) {
+	db, err := bolt.Open(filepath.Join(path, BoltFileName), 0600, &bolt.Options{
+		Timeout: 5 * time.Second,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltDatabase{db: db}, nil
+}
+
+func (db *BoltDatabase) view(fn func(bkt *bolt.Bucket) error) error {
+	// If we have an open transaction, retrieve the values from there.
+	// Bolt might deadlock when opening a second one in the same goroutine.
+	if db.tx != nil {
+		return fn(db.tx.Bucket(boltBucket))
+	}
+
+	return db.db.View(func(tx *bolt.Tx) error {
+		return fn(tx.Bucket(boltBucket))
+	})
+}
+
+// Get is the bolt implementation of Database.Get.
+func (db *BoltDatabase) Get(key ...string) ([]byte, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var data []byte
+	err := db.view(func(bkt *bolt.Bucket) error {
+		val := bkt.Get([]byte(strings.Join(key, ".")))
+		if val == nil {
+			return ErrNoSuchKey
+		}
+
+		// val is only valid during the transaction:
+		data = make([]byte, len(val))
+		copy(data, val)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Keys is the bolt implementation of Database.Keys.
+func (db *BoltDatabase) Keys(prefix ...string) ([][]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	keys := [][]string{}
+	seek := []byte(strings.Join(prefix, "."))
+	return keys, db.view(func(bkt *bolt.Bucket) error {
+		cursor := bkt.Cursor()
+		for key, _ := cursor.Seek(seek); key != nil && bytes.HasPrefix(key, seek); key, _ = cursor.Next() {
+			splitKey := strings.Split(string(key), ".")
+
+			hasPrefix := len(prefix) <= len(splitKey)
+			for i := 0; hasPrefix && i < len(prefix); i++ {
+				if prefix[i] != splitKey[i] {
+					hasPrefix = false
+				}
+			}
+
+			if hasPrefix {
+				keys = append(keys, splitKey)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Glob is the bolt implementation of Database.Glob
+func (db *BoltDatabase) Glob(prefix []string) ([][]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	fullPrefix := strings.Join(prefix, ".")
+
+	results := [][]string{}
+	err := db.view(func(bkt *bolt.Bucket) error {
+		cursor := bkt.Cursor()
+		for key, _ := cursor.Seek([]byte(fullPrefix)); key != nil; key, _ = cursor.Next() {
+			fullKey := string(key)
+			if !strings.HasPrefix(fullKey, fullPrefix) {
+				break
+			}
+
+			// Don't do recursive globbing:
+			leftOver := fullKey[len(fullPrefix):]
+			if !strings.Contains(leftOver, ".") {
+				results = append(results, strings.Split(fullKey, "."))
+			}
+		}
+
+		return nil
+	})
+
+	return results, err
+}
+
+// Export is the bolt implementation of Database.Export.
+func (db *BoltDatabase) Export(w io.Writer) error {
+	return Dump(db, w)
+}
+
+// Import is the bolt implementation of Database.Import.
+func (db *BoltDatabase) Import(r io.Reader) error {
+	return Load(db, r)
+}
+
+// Batch is the bolt implementation of Database.Batch
+func (db *BoltDatabase) Batch() Batch {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.tx == nil {
+		tx, err := db.db.Begin(true)
+		if err != nil {
+			// This only happens if the database was closed.
+			log.Errorf("bolt: failed to start transaction: %v", err)
+			return db
+		}
+
+		db.tx = tx
+	}
+
+	db.refCount++
+	return db
+}
+
+func (db *BoltDatabase) bucket() *bolt.Bucket {
+	if db.tx == nil {
+		return nil
+	}
+
+	return db.tx.Bucket(boltBucket)
+}
+
+// Put is the bolt implementation of Database.Put
+func (db *BoltDatabase) Put(val []byte, key ...string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	fullKey := strings.Join(key, ".")
+	bkt := db.bucket()
+	if bkt == nil {
+		log.Warningf("bolt: put of %s outside of a batch", fullKey)
+		return
+	}
+
+	db.haveWrites = true
+
+	// Bolt needs the value to be valid until the commit:
+	valCopy := make([]byte, len(val))
+	copy(valCopy, val)
+
+	if err := bkt.Put([]byte(fullKey), valCopy); err != nil {
+		log.Warningf("bolt: failed to set key %s: %v", fullKey, err)
+	}
+}
+
+// Clear is the bolt implementation of Database.Clear
+func (db *BoltDatabase) Clear(key ...string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	bkt := db.bucket()
+	if bkt == nil {
+		return bolt.ErrTxNotWritable
+	}
+
+	db.haveWrites = true
+
+	prefix := []byte(strings.Join(key, "."))
+	cursor := bkt.Cursor()
+	for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); {
+		if err := cursor.Delete(); err != nil {
+			return err
+		}
+
+		// Deleting moves the cursor, so seek again:
+		k, _ = cursor.Seek(prefix)
+	}
+
+	return nil
+}
+
+// Erase is the bolt implementation of Database.Erase
+func (db *BoltDatabase) Erase(key ...string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	fullKey := strings.Join(key, ".")
+	bkt := db.bucket()
+	if bkt == nil {
+		log.Warningf("bolt: erase of %s outside of a batch", fullKey)
+		return
+	}
+
+	db.haveWrites = true
+	if err := bkt.Delete([]byte(fullKey)); err != nil {
+		log.Warningf("bolt: failed to del key %s: %v", fullKey, err)
+	}
+}
+
+// Flush is the bolt implementation of Database.Flush
+func (db *BoltDatabase) Flush() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.refCount--
+	if db.refCount > 0 {
+		return nil
+	}
+
+	if db.refCount < 0 || db.tx == nil {
+		log.Errorf("negative batch ref count: %d", db.refCount)
+		db.refCount = 0
+		return nil
+	}
+
+	tx := db.tx
+	db.tx = nil
+	db.haveWrites = false
+	return tx.Commit()
+}
+
+// Rollback is the bolt implementation of Database.Rollback
+func (db *BoltDatabase) Rollback() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.refCount--
+	if db.refCount > 0 {
+		return
+	}
+
+	if db.refCount < 0 || db.tx == nil {
+		log.Errorf("negative batch ref count: %d", db.refCount)
+		db.refCount = 0
+		return
+	}
+
+	if err := db.tx.Rollback(); err != nil {
+		log.Warningf("bolt: rollback failed: %v", err)
+	}
+
+	db.tx = nil
+	db.haveWrites = false
+	db.refCount = 0
+}
+
+// HaveWrites is the bolt implementation of Database.HaveWrites
+func (db *BoltDatabase) HaveWrites() bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.haveWrites
+}
+
+// Close is the bolt implementation of Database.Close
+func (db *BoltDatabase) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// With an open transaction it would deadlock:
+	if db.tx != nil {
+		if err := db.tx.Rollback(); err != nil {
+			log.Warningf("bolt: rollback on close failed: %v", err)
+		}
+
+		db.tx = nil
+		db.haveWrites = false
+		db.refCount = 0
+	}
+
+	return db.db.Close()
+}
@@ -8,9 +8,6 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
-	"time"
-
-	"github.com/sahib/brig/util"
 )
 
 const (
@@ -19,7 +16,6 @@ const (
 
 // DiskDatabase is a database that simply uses the filesystem as storage.
 // Each bucket is one directory. Leaf keys are simple files.
-// The exported form of the database is simply a gzipped .tar of the directory.
 //
 // Note that this database backends was written for easy debugging.
 // It is currently by no means optimized for fast reads and writes and
@@ -368,15 +364,14 @@ func (db *DiskDatabase) Glob(prefix []string) ([][]string, error) {
 	return results, nil
 }
 
-// Export writes all key/values into a gzipped .tar that is written to `w`.
+// Export writes all keys and values to `w` (see Dump).
 func (db *DiskDatabase) Export(w io.Writer) error {
-	archiveName := fmt.Sprintf("brigmeta-%s.gz", time.Now().Format(time.RFC3339))
-	return util.Tar(db.basePath, archiveName, w)
+	return Dump(db, w)
 }
 
-// Import a gzipped tar from `r` into the current database.
+// Import reads a dump written by Export from `r`.
 func (db *DiskDatabase) Import(r io.Reader) error {
-	return util.Untar(r, db.basePath)
+	return Load(db, r)
 }
 
 // Close the database
@@ -1,7 +1,6 @@
 package db
 
 import (
-	"encoding/gob"
 	"io"
 	"path"
 	"sort"
@@ -163,15 +162,14 @@ func (mdb *MemoryDatabase) Glob(prefix []string) ([][]string, error) {
 	return result, nil
 }
 
-// Export encodes the internal memory map to a gob structure,
-// and writes it to `w`.
+// Export writes all keys and values to `w` (see Dump).
 func (mdb *MemoryDatabase) Export(w io.Writer) error {
-	return gob.NewEncoder(w).Encode(mdb.data)
+	return Dump(mdb, w)
 }
 
-// Import imports a previously exported dump and decodes the gob structure.
+// Import reads a dump written by Export from `r`.
 func (mdb *MemoryDatabase) Import(r io.Reader) error {
-	return gob.NewDecoder(r).Decode(&mdb.data)
+	return Load(mdb, r)
 }
 
 // Close the memory - a no op.
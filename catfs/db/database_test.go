@@ -39,6 +39,19 @@ func withBadgerDatabase(fn func(db *BadgerDatabase)) error {
 	return db.Close()
 }
 
+func withBoltDatabase(fn func(db *BoltDatabase)) error {
+	testDir, _ := ioutil.TempDir("", "brig-")
+	defer os.RemoveAll(testDir)
+
+	db, err := NewBoltDatabase(testDir)
+	if err != nil {
+		return err
+	}
+
+	fn(db)
+	return db.Close()
+}
+
 func withMemDatabase(fn func(db *MemoryDatabase)) error {
 	mdb := NewMemoryDatabase()
 	fn(mdb)
@@ -59,6 +72,10 @@ func withDbByName(name string, fn func(db Database)) error {
 		return withBadgerDatabase(func(db *BadgerDatabase) {
 			fn(db)
 		})
+	case "bolt":
+		return withBoltDatabase(func(db *BoltDatabase) {
+			fn(db)
+		})
 	default:
 		panic("bad db name: " + name)
 	}
@@ -87,6 +104,10 @@ func TestDatabase(t *testing.T) {
 		testDatabaseOneDb(t, "badger")
 		testDatabaseTwoDbs(t, "badger")
 	})
+	t.Run("bolt", func(t *testing.T) {
+		testDatabaseOneDb(t, "bolt")
+		testDatabaseTwoDbs(t, "bolt")
+	})
 }
 
 func TestGCRace(t *testing.T) {
@@ -418,13 +439,101 @@ func testExportImport(t *testing.T, db1, db2 Database) {
 	}
 }
 
+func TestConvert(t *testing.T) {
+	names := []string{"badger", "bolt", "memory"}
+	for _, srcName := range names {
+		for _, dstName := range names {
+			t.Run(srcName+"-to-"+dstName, func(t *testing.T) {
+				require.Nil(t, withDbByName(srcName, func(src Database) {
+					require.Nil(t, withDbByName(dstName, func(dst Database) {
+						batch := src.Batch()
+						for idx := 0; idx < 100; idx++ {
+							batch.Put([]byte{byte(idx)}, "a", "b", fmt.Sprintf("%d", idx))
+						}
+						require.Nil(t, batch.Flush())
+
+						require.Nil(t, Convert(dst, src))
+
+						keys, err := dst.Keys("a")
+						require.Nil(t, err)
+						require.Len(t, keys, 100)
+
+						for idx := 0; idx < 100; idx++ {
+							data, err := dst.Get("a", "b", fmt.Sprintf("%d", idx))
+							require.Nil(t, err)
+							require.Equal(t, []byte{byte(idx)}, data)
+						}
+					}))
+				}))
+			})
+		}
+	}
+}
+
+func TestExportImportAcrossBackends(t *testing.T) {
+	for _, srcName := range Backends {
+		for _, dstName := range Backends {
+			t.Run(srcName+"-to-"+dstName, func(t *testing.T) {
+				require.Nil(t, withDbByName(srcName, func(src Database) {
+					require.Nil(t, withDbByName(dstName, func(dst Database) {
+						testExportImport(t, src, dst)
+					}))
+				}))
+			})
+		}
+	}
+}
+
+func TestBadgerImportsLegacyBackup(t *testing.T) {
+	require.Nil(t, withBadgerDatabase(func(src *BadgerDatabase) {
+		batch := src.Batch()
+		batch.Put([]byte{1, 2, 3}, "a", "b")
+		require.Nil(t, batch.Flush())
+
+		// Exports of older versions used badger's own format:
+		buf := &bytes.Buffer{}
+		_, err := src.db.Backup(buf, 0)
+		require.Nil(t, err)
+
+		require.Nil(t, withBadgerDatabase(func(dst *BadgerDatabase) {
+			require.Nil(t, dst.Import(buf))
+
+			data, err := dst.Get("a", "b")
+			require.Nil(t, err)
+			require.Equal(t, []byte{1, 2, 3}, data)
+		}))
+	}))
+}
+
+func TestDetectBackend(t *testing.T) {
+	for _, name := range Backends {
+		t.Run(name, func(t *testing.T) {
+			testDir, err := ioutil.TempDir("", "brig-")
+			require.Nil(t, err)
+			defer os.RemoveAll(testDir)
+
+			detected, err := DetectBackend(testDir)
+			require.Nil(t, err)
+			require.Equal(t, "", detected)
+
+			db, err := Open(name, testDir)
+			require.Nil(t, err)
+			require.Nil(t, db.Close())
+
+			detected, err = DetectBackend(testDir)
+			require.Nil(t, err)
+			require.Equal(t, name, detected)
+		})
+	}
+}
+
 // Regression bug fix: too many key/values in a transaction
 // will cause badger to return ErrTxnTooBig, which should
 // be handled as retry. This code triggers this.
 func TestLargeBatch(t *testing.T) {
 	nKeys := 1000 * 10
 
-	for _, name := range []string{"badger", "memory", "disk"} {
+	for _, name := range []string{"badger", "bolt", "memory", "disk"} {
 		t.Run(name, func(t *testing.T) {
 			withDbByName(name, func(db Database) {
 				batch := db.Batch()
@@ -455,7 +564,7 @@ func BenchmarkDatabase(b *testing.B) {
 
 	for benchmarkName, benchmark := range benchmarks {
 		b.Run(benchmarkName, func(b *testing.B) {
-			for _, name := range []string{"badger", "memory", "disk"} {
+			for _, name := range []string{"badger", "bolt", "memory", "disk"} {
 				b.Run(name, func(b *testing.B) {
 					withDbByName(name, func(db Database) {
 						b.ResetTimer()
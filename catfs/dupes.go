@@ -0,0 +1,366 @@
+package catfs
+
+import (
+	"sort"
+
+	c "github.com/sahib/brig/catfs/core"
+	ie "github.com/sahib/brig/catfs/errors"
+	n "github.com/sahib/brig/catfs/nodes"
+	"github.com/sahib/brig/catfs/search"
+	h "github.com/sahib/brig/util/hashlib"
+)
+
+// DupeGroup is a set of files that have the same content.
+type DupeGroup struct {
+	// ContentHash is the hash of the content all files share.
+	ContentHash h.Hash
+	// Size is the size of the content.
+	Size uint64
+	// Files are the files with this content. The first one is the file
+	// that is kept when deduplicating.
+	Files []*SearchResult
+	// Blobs is the number of different backend blobs that store the
+	// content. Files staged at different times might be encrypted
+	// differently and therefore use different blobs.
+	Blobs int
+	// WastedBytes is how much more the files take than a single copy.
+	WastedBytes uint64
+	// CachedBytes is how much local storage is taken by
+	// the blobs that are not used by the kept file.
+	CachedBytes uint64
+}
+
+// DedupeMode says what Dedupe() does with the duplicates.
+type DedupeMode int
+
+const (
+	// DedupeLink makes all duplicates use the blob of the kept file.
+	DedupeLink DedupeMode = iota
+	// DedupeUnpin unpins the blobs that are not used by the kept file.
+	DedupeUnpin
+)
+
+// DedupeChange is a single file that was (or would be) changed by Dedupe().
+type DedupeChange struct {
+	// Path is the path of the duplicate.
+	Path string
+	// KeptPath is the path of the file whose blob is kept.
+	KeptPath string
+	// FreedBytes is how much local storage can be freed by the change.
+	FreedBytes uint64
+}
+
+// isBlobCached returns true if the blob of `info` is stored locally.
+func (fs *FS) isBlobCached(info *StatInfo) bool {
+	isCached, err := fs.bk.IsCached(info.BackendHash)
+	return err == nil && isCached
+}
+
+// sortDupes puts the file that should be kept first: Files whose content is
+// available locally are preferred, then pinned ones. Files of the current
+// state come before files that only exist in the history.
+func (fs *FS) sortDupes(files []*SearchResult) {
+	isCached := make(map[*SearchResult]bool)
+	for _, file := range files {
+		isCached[file] = fs.isBlobCached(file.StatInfo)
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if (a.Commit == nil) != (b.Commit == nil) {
+			return a.Commit == nil
+		}
+
+		if isCached[a] != isCached[b] {
+			return isCached[a]
+		}
+
+		if a.IsPinned != b.IsPinned {
+			return a.IsPinned
+		}
+
+		return a.Path < b.Path
+	})
+}
+
+// redundantBlobBytes returns how much local storage each blob that is not
+// used by `kept` takes. Every blob is only counted once.
+func (fs *FS) redundantBlobBytes(kept *SearchResult, files []*SearchResult) map[string]uint64 {
+	blobs := make(map[string]uint64)
+	for _, file := range files {
+		if file.BackendHash.Equal(kept.BackendHash) {
+			continue
+		}
+
+		blob := file.BackendHash.B58String()
+		if _, ok := blobs[blob]; ok {
+			continue
+		}
+
+		blobs[blob] = 0
+		if fs.isBlobCached(file.StatInfo) {
+			blobs[blob] = file.CachedSize
+		}
+	}
+
+	return blobs
+}
+
+// dupes is the implementation of Dupes(). fs.mu needs to be locked.
+func (fs *FS) dupes(root string, opts SearchOptions) ([]*DupeGroup, error) {
+	query, err := search.Parse("is:file")
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := fs.search(root, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]*DupeGroup)
+	seen := make(map[string]bool)
+	for _, file := range files {
+		// Empty files are all the same, but do not waste anything.
+		if file.Size == 0 {
+			continue
+		}
+
+		// In the history, the same version might be reachable several times.
+		contentHash := file.ContentHash.B58String()
+		seenKey := file.Path + "|" + contentHash
+		if seen[seenKey] {
+			continue
+		}
+
+		seen[seenKey] = true
+
+		group, ok := groups[contentHash]
+		if !ok {
+			group = &DupeGroup{
+				ContentHash: file.ContentHash,
+				Size:        file.Size,
+			}
+
+			groups[contentHash] = group
+		}
+
+		group.Files = append(group.Files, file)
+	}
+
+	result := []*DupeGroup{}
+	for _, group := range groups {
+		if len(group.Files) < 2 {
+			continue
+		}
+
+		fs.sortDupes(group.Files)
+
+		blobs := fs.redundantBlobBytes(group.Files[0], group.Files)
+		group.Blobs = len(blobs) + 1
+		group.WastedBytes = group.Size * uint64(len(group.Files)-1)
+		for _, cachedSize := range blobs {
+			group.CachedBytes += cachedSize
+		}
+
+		result = append(result, group)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].WastedBytes != result[j].WastedBytes {
+			return result[i].WastedBytes > result[j].WastedBytes
+		}
+
+		return result[i].ContentHash.B58String() < result[j].ContentHash.B58String()
+	})
+
+	return result, nil
+}
+
+// Dupes groups all files below `root` that have the same content.
+// Only groups with more than one file are returned, the
+// most wasteful first. With `opts.History` set, older versions
+// of the files are considered too.
+func (fs *FS) Dupes(root string, opts SearchOptions) ([]*DupeGroup, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.dupes(root, opts)
+}
+
+// blobUsers maps the blob of every file in the current state
+// to the paths of all files that use it.
+func (fs *FS) blobUsers() (map[string][]string, error) {
+	query, err := search.Parse("is:file")
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := fs.search("/", query, SearchOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(map[string][]string)
+	for _, file := range files {
+		blob := file.BackendHash.B58String()
+		users[blob] = append(users[blob], file.Path)
+	}
+
+	return users, nil
+}
+
+// isBlobUsedElsewhere returns true if one of `users` is not part of `group`.
+func isBlobUsedElsewhere(users []string, group *DupeGroup) bool {
+	for _, user := range users {
+		isInGroup := false
+		for _, file := range group.Files {
+			if file.Path == user {
+				isInGroup = true
+				break
+			}
+		}
+
+		if !isInGroup {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dedupeFile looks up the file of the current state at `path`.
+func (fs *FS) dedupeFile(path string) (*n.File, error) {
+	nd, err := fs.lkr.LookupModNode(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, ok := nd.(*n.File)
+	if !ok {
+		return nil, ie.ErrBadNode
+	}
+
+	return file, nil
+}
+
+// linkDupe makes `dupe` use the same blob as `kept`.
+// The pin state of `dupe` is carried over to the new blob.
+func (fs *FS) linkDupe(kept, dupe *n.File) error {
+	isPinned, isExplicit, err := fs.pinner.IsNodePinned(dupe)
+	if err != nil {
+		return err
+	}
+
+	oldDupe := dupe.Copy(dupe.Inode()).(*n.File)
+	newDupe, err := c.StageWithFullInfo(
+		fs.lkr,
+		dupe.Path(),
+		dupe.ContentHash(),
+		kept.BackendHash(),
+		dupe.Size(),
+		kept.CachedSize(),
+		kept.Key(),
+		dupe.ModTime(),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if isPinned {
+		if err := fs.pinner.PinNode(newDupe, isExplicit); err != nil {
+			return err
+		}
+	}
+
+	return fs.pinner.UnpinNode(oldDupe, true)
+}
+
+// Dedupe gets rid of the redundant blobs of the duplicates in the current
+// state below `root` (see Dupes()). With DedupeLink the duplicates are changed
+// to use the blob of the kept file, like a copy made with Copy() would. With
+// DedupeUnpin the duplicates are only unpinned, so the backend may remove
+// their blobs; the kept file is pinned if it was not. Blobs that are still
+// used by files outside of `root` are left alone. If `dryRun` is true,
+// nothing is changed, but the changes are returned anyway.
+func (fs *FS) Dedupe(root string, mode DedupeMode, dryRun bool) ([]DedupeChange, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.readOnly && !dryRun {
+		return nil, ErrReadOnly
+	}
+
+	groups, err := fs.dupes(root, SearchOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Unpinning a blob affects every file that uses it, not only the ones below root:
+	users, err := fs.blobUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []DedupeChange{}
+	for _, group := range groups {
+		kept := group.Files[0]
+		blobs := fs.redundantBlobBytes(kept, group.Files)
+
+		var keptFile *n.File
+		for _, dupe := range group.Files[1:] {
+			if dupe.BackendHash.Equal(kept.BackendHash) {
+				// Shares the blob already.
+				continue
+			}
+
+			blob := dupe.BackendHash.B58String()
+			if isBlobUsedElsewhere(users[blob], group) {
+				continue
+			}
+
+			// The blob is freed by the first file that stops using it.
+			changes = append(changes, DedupeChange{
+				Path:       dupe.Path,
+				KeptPath:   kept.Path,
+				FreedBytes: blobs[blob],
+			})
+
+			blobs[blob] = 0
+			if dryRun {
+				continue
+			}
+
+			if keptFile == nil {
+				if keptFile, err = fs.dedupeFile(kept.Path); err != nil {
+					return nil, err
+				}
+
+				if !kept.IsPinned {
+					if err := fs.pinner.PinNode(keptFile, false); err != nil {
+						return nil, err
+					}
+				}
+			}
+
+			dupeFile, err := fs.dedupeFile(dupe.Path)
+			if err != nil {
+				return nil, err
+			}
+
+			switch mode {
+			case DedupeLink:
+				err = fs.linkDupe(keptFile, dupeFile)
+			case DedupeUnpin:
+				err = fs.pinner.UnpinNode(dupeFile, true)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,173 @@
+package catfs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// stageDupes creates /a, /b and /c with the same content.
+// /a and /c share a blob, /b uses a different one.
+func stageDupes(t *testing.T, fs *FS) []byte {
+	data := []byte("Hello world")
+	require.Nil(t, fs.Stage("/a", bytes.NewReader(data)))
+	require.Nil(t, fs.Stage("/b", bytes.NewReader([]byte("something else"))))
+	require.Nil(t, fs.Stage("/b", bytes.NewReader(data)))
+	require.Nil(t, fs.Stage("/c", bytes.NewReader(data)))
+	require.Nil(t, fs.Stage("/d", bytes.NewReader([]byte("unique"))))
+	return data
+}
+
+func TestDupes(t *testing.T) {
+	withDummyFS(t, func(fs *FS) {
+		data := stageDupes(t, fs)
+
+		groups, err := fs.Dupes("/", SearchOptions{})
+		require.Nil(t, err)
+		require.Len(t, groups, 1)
+
+		group := groups[0]
+		require.Equal(t, uint64(len(data)), group.Size)
+		require.Len(t, group.Files, 3)
+		require.Equal(t, "/a", group.Files[0].Path)
+		require.Equal(t, 2, group.Blobs)
+		require.Equal(t, 2*uint64(len(data)), group.WastedBytes)
+		require.Equal(t, group.Files[1].CachedSize, group.CachedBytes)
+
+		// Older versions count with --history:
+		require.Nil(t, fs.MakeCommit("dupes"))
+		require.Nil(t, fs.Remove("/c"))
+		require.Nil(t, fs.MakeCommit("removed c"))
+
+		groups, err = fs.Dupes("/", SearchOptions{})
+		require.Nil(t, err)
+		require.Len(t, groups[0].Files, 2)
+
+		groups, err = fs.Dupes("/", SearchOptions{History: true})
+		require.Nil(t, err)
+		require.Len(t, groups[0].Files, 3)
+		require.Equal(t, "/c", groups[0].Files[2].Path)
+		require.NotNil(t, groups[0].Files[2].Commit)
+	})
+}
+
+func TestDedupeLink(t *testing.T) {
+	withDummyFS(t, func(fs *FS) {
+		data := stageDupes(t, fs)
+		require.Nil(t, fs.Pin("/b", "", true))
+
+		bInfo, err := fs.Stat("/b")
+		require.Nil(t, err)
+
+		changes, err := fs.Dedupe("/", DedupeLink, true)
+		require.Nil(t, err)
+		require.Equal(t, []DedupeChange{{
+			Path:       "/b",
+			KeptPath:   "/a",
+			FreedBytes: bInfo.CachedSize,
+		}}, changes)
+
+		// Dry run did not change anything:
+		groups, err := fs.Dupes("/", SearchOptions{})
+		require.Nil(t, err)
+		require.Equal(t, 2, groups[0].Blobs)
+
+		_, err = fs.Dedupe("/", DedupeLink, false)
+		require.Nil(t, err)
+
+		groups, err = fs.Dupes("/", SearchOptions{})
+		require.Nil(t, err)
+		require.Equal(t, 1, groups[0].Blobs)
+		require.Equal(t, uint64(0), groups[0].CachedBytes)
+
+		aInfo, err := fs.Stat("/a")
+		require.Nil(t, err)
+		newBInfo, err := fs.Stat("/b")
+		require.Nil(t, err)
+		require.Equal(t, aInfo.BackendHash, newBInfo.BackendHash)
+		require.Equal(t, bInfo.Inode, newBInfo.Inode)
+		require.True(t, newBInfo.IsExplicit)
+
+		// The content can still be read:
+		stream, err := fs.Cat("/b")
+		require.Nil(t, err)
+		buf := &bytes.Buffer{}
+		_, err = buf.ReadFrom(stream)
+		require.Nil(t, err)
+		require.Equal(t, data, buf.Bytes())
+		require.Nil(t, stream.Close())
+
+		isPinned, err := fs.bk.IsPinned(bInfo.BackendHash)
+		require.Nil(t, err)
+		require.False(t, isPinned)
+
+		// Nothing left to do:
+		changes, err = fs.Dedupe("/", DedupeLink, false)
+		require.Nil(t, err)
+		require.Len(t, changes, 0)
+	})
+}
+
+func TestDedupeUnpin(t *testing.T) {
+	withDummyFS(t, func(fs *FS) {
+		stageDupes(t, fs)
+
+		changes, err := fs.Dedupe("/", DedupeUnpin, false)
+		require.Nil(t, err)
+		require.Len(t, changes, 1)
+		require.Equal(t, "/b", changes[0].Path)
+
+		bInfo, err := fs.Stat("/b")
+		require.Nil(t, err)
+		require.False(t, bInfo.IsPinned)
+
+		aInfo, err := fs.Stat("/a")
+		require.Nil(t, err)
+		require.True(t, aInfo.IsPinned)
+	})
+}
+
+func TestDedupeKeepsBlobsUsedOutsideRoot(t *testing.T) {
+	withDummyFS(t, func(fs *FS) {
+		data := []byte("Hello world")
+		require.Nil(t, fs.Mkdir("/sub", true))
+		require.Nil(t, fs.Mkdir("/other", true))
+		require.Nil(t, fs.Stage("/sub/a", bytes.NewReader(data)))
+		require.Nil(t, fs.Stage("/sub/b", bytes.NewReader([]byte("something else"))))
+		require.Nil(t, fs.Stage("/sub/b", bytes.NewReader(data)))
+
+		// /other/b shares the blob of /sub/b:
+		require.Nil(t, fs.Copy("/sub/b", "/other/b"))
+		require.Nil(t, fs.Pin("/other/b", "", true))
+
+		bInfo, err := fs.Stat("/other/b")
+		require.Nil(t, err)
+
+		for _, mode := range []DedupeMode{DedupeUnpin, DedupeLink} {
+			changes, err := fs.Dedupe("/sub", mode, false)
+			require.Nil(t, err)
+			require.Len(t, changes, 0)
+
+			isPinned, err := fs.bk.IsPinned(bInfo.BackendHash)
+			require.Nil(t, err)
+			require.True(t, isPinned)
+
+			otherInfo, err := fs.Stat("/other/b")
+			require.Nil(t, err)
+			require.True(t, otherInfo.IsPinned)
+			require.True(t, otherInfo.IsExplicit)
+		}
+
+		// Without the copy outside of /sub, the blob is unpinned:
+		require.Nil(t, fs.Remove("/other/b"))
+		changes, err := fs.Dedupe("/sub", DedupeUnpin, false)
+		require.Nil(t, err)
+		require.Len(t, changes, 1)
+		require.Equal(t, "/sub/b", changes[0].Path)
+
+		isPinned, err := fs.bk.IsPinned(bInfo.BackendHash)
+		require.Nil(t, err)
+		require.False(t, isPinned)
+	})
+}
@@ -40,6 +40,36 @@ func IsErrNoSuchRef(err error) bool {
 
 /////////////////
 
+// ErrNoSuchBranch is returned when a branch name was used that does not exist.
+type ErrNoSuchBranch string
+
+func (e ErrNoSuchBranch) Error() string {
+	return fmt.Sprintf("No branch found named `%s`", string(e))
+}
+
+// IsErrNoSuchBranch checks if `err` is a no such branch error.
+func IsErrNoSuchBranch(err error) bool {
+	_, ok := err.(ErrNoSuchBranch)
+	return ok
+}
+
+/////////////////
+
+// ErrNoSuchStash is returned when a stash index was used that does not exist.
+type ErrNoSuchStash int
+
+func (e ErrNoSuchStash) Error() string {
+	return fmt.Sprintf("No stash found at index %d", int(e))
+}
+
+// IsErrNoSuchStash checks if `err` is a no such stash error.
+func IsErrNoSuchStash(err error) bool {
+	_, ok := err.(ErrNoSuchStash)
+	return ok
+}
+
+/////////////////
+
 // ErrNoSuchCommitIndex is returned when a bad commit was used
 type ErrNoSuchCommitIndex struct {
 	index int64
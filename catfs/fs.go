@@ -24,11 +24,13 @@ import (
 	"github.com/sahib/brig/catfs/db"
 	ie "github.com/sahib/brig/catfs/errors"
 	"github.com/sahib/brig/catfs/mio"
+	"github.com/sahib/brig/catfs/mio/blockcache"
 	"github.com/sahib/brig/catfs/mio/compress"
 	n "github.com/sahib/brig/catfs/nodes"
 	"github.com/sahib/brig/catfs/vcs"
 	"github.com/sahib/brig/util"
 	h "github.com/sahib/brig/util/hashlib"
+	"github.com/sahib/brig/util/ignore"
 )
 
 const (
@@ -46,9 +48,15 @@ const (
 type FS struct {
 	mu sync.Mutex
 
+	// set by Close(); background loops must not touch kv afterwards
+	isClosed bool
+
 	// underlying key/value store
 	kv db.Database
 
+	// path of the key/value store on disk
+	dbPath string
+
 	// linker (holds all nodes together)
 	lkr *c.Linker
 
@@ -64,6 +72,9 @@ type FS struct {
 	// channel to schedule repins and quit the loop
 	repinControl chan string
 
+	// channel to schedule search index updates and quit the loop
+	searchIndexControl chan bool
+
 	// Actual storage backend (e.g. ipfs or memory)
 	bk FsBackend
 
@@ -73,6 +84,9 @@ type FS struct {
 	// cache for the isPinned operation
 	pinner *Pinner
 
+	// cache for decrypted blocks of file content (may be nil)
+	blockCache *blockcache.Cache
+
 	// wether this fs is read only and cannot be changed.
 	// It can be change by applying patches though.
 	readOnly bool
@@ -166,6 +180,16 @@ type Commit struct {
 	Index int64
 }
 
+// Branch is a named line of development.
+type Branch struct {
+	// Name of the branch.
+	Name string
+	// Tip is the newest commit of the branch.
+	Tip *Commit
+	// IsCurrent is true for the branch HEAD belongs to.
+	IsCurrent bool
+}
+
 // Change describes a single change to a node between two versions
 type Change struct {
 	// Path is the node that was changed
@@ -195,6 +219,20 @@ type Change struct {
 	Next *Commit
 }
 
+// FsckProblem describes a single inconsistency found by Fsck().
+type FsckProblem struct {
+	// Kind is a short identifier like "hash-mismatch" or "dangling-ref".
+	Kind string
+	// Path is the affected path, if any.
+	Path string
+	// Commit is the commit where the problem was first seen, if any.
+	Commit h.Hash
+	// Message describes the problem in detail.
+	Message string
+	// Repaired is true if the problem was fixed.
+	Repaired bool
+}
+
 // ExplicitPin is a pair of path and commit id.
 type ExplicitPin struct {
 	Path   string
@@ -257,6 +295,31 @@ func lookupFileOrDir(lkr *c.Linker, path string) (n.ModNode, error) {
 	return modNd, nil
 }
 
+// lookupNodeAt resolves `path` in the commit referenced by `rev`.
+// If `rev` is empty, the current state (including staged changes) is used.
+// fs.mu needs to be locked.
+func (fs *FS) lookupNodeAt(rev, path string) (n.Node, error) {
+	if rev == "" {
+		return fs.lkr.LookupNode(path)
+	}
+
+	cmt, err := parseRev(fs.lkr, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	nd, err := fs.lkr.LookupNodeAt(cmt, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if nd == nil {
+		return nil, ie.NoSuchFile(path)
+	}
+
+	return nd, nil
+}
+
 func (fs *FS) handleGcEvent(nd n.Node) bool {
 	if nd.Type() != n.NodeTypeFile {
 		return true
@@ -303,18 +366,19 @@ func (fs *FS) doGcRun() {
 // NewFilesystem creates a new CATFS filesystem.
 // This filesystem stores all its data in a Merkle DAG and is fully versioned.
 func NewFilesystem(backend FsBackend, dbPath string, owner string, readOnly bool, fsCfg *config.Config) (*FS, error) {
-	kv, err := db.NewBadgerDatabase(dbPath)
+	kv, err := openDatabase(dbPath, fsCfg.String("db.backend"))
 	if err != nil {
 		return nil, err
 	}
 
-	lkr := c.NewLinker(kv)
-	if err := lkr.SetOwner(owner); err != nil {
+	// Bring older databases to the current format before using them:
+	if _, err := migrateDatabase(kv, migrations, dbPath, abiVersion, false); err != nil {
+		kv.Close()
 		return nil, err
 	}
 
-	// NOTE: This is the place to start migrations in the future.
-	if err := lkr.SetABIVersion(abiVersion); err != nil {
+	lkr := c.NewLinker(kv)
+	if err := lkr.SetOwner(owner); err != nil {
 		return nil, err
 	}
 
@@ -328,15 +392,17 @@ func NewFilesystem(backend FsBackend, dbPath string, owner string, readOnly bool
 	// (we just need to convert a few keys to the vcs.SyncOptions enum later).
 
 	fs := &FS{
-		kv:                kv,
-		lkr:               lkr,
-		bk:                backend,
-		cfg:               fsCfg,
-		readOnly:          readOnly,
-		gcControl:         make(chan bool, 1),
-		autoCommitControl: make(chan bool, 1),
-		repinControl:      make(chan string, 1),
-		pinner:            pinCache,
+		kv:                 kv,
+		dbPath:             dbPath,
+		lkr:                lkr,
+		bk:                 backend,
+		cfg:                fsCfg,
+		readOnly:           readOnly,
+		gcControl:          make(chan bool, 1),
+		autoCommitControl:  make(chan bool, 1),
+		repinControl:       make(chan string, 1),
+		searchIndexControl: make(chan bool, 1),
+		pinner:             pinCache,
 	}
 
 	// Start the garbage collection background task.
@@ -347,6 +413,10 @@ func NewFilesystem(backend FsBackend, dbPath string, owner string, readOnly bool
 	go fs.gcLoop()
 	go fs.autoCommitLoop()
 	go fs.repinLoop()
+	go fs.searchIndexLoop()
+
+	// Index whatever was committed while we were not running:
+	fs.scheduleSearchIndexUpdate()
 
 	return fs, nil
 }
@@ -443,9 +513,12 @@ func (fs *FS) Close() error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	fs.isClosed = true
+
 	go func() { fs.gcControl <- false }()
 	go func() { fs.autoCommitControl <- false }()
 	go func() { fs.repinControl <- "" }()
+	go func() { fs.searchIndexControl <- false }()
 
 	if err := fs.pinner.Close(); err != nil {
 		log.Warnf("Failed to close pin cache: %v", err)
@@ -554,10 +627,16 @@ func (fs *FS) Remove(path string) error {
 
 // Stat delivers detailed information about the node at `path`.
 func (fs *FS) Stat(path string) (*StatInfo, error) {
+	return fs.StatAt("", path)
+}
+
+// StatAt is like Stat but returns information about the node
+// at `path` in the commit referenced by `rev`.
+func (fs *FS) StatAt(rev, path string) (*StatInfo, error) {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	nd, err := fs.lkr.LookupNode(path)
+	nd, err := fs.lookupNodeAt(rev, path)
 	if err != nil {
 		return nil, err
 	}
@@ -630,6 +709,11 @@ func (fs *FS) Filter(root, query string) ([]*StatInfo, error) {
 // Nodes deeper than maxDepth will not be shown. If maxDepth is a
 // negative number, all nodes will be shown.
 func (fs *FS) List(root string, maxDepth int) ([]*StatInfo, error) {
+	return fs.ListAt("", root, maxDepth)
+}
+
+// ListAt is like List but lists the nodes in the commit referenced by `rev`.
+func (fs *FS) ListAt(rev, root string, maxDepth int) ([]*StatInfo, error) {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
@@ -638,7 +722,7 @@ func (fs *FS) List(root string, maxDepth int) ([]*StatInfo, error) {
 	//
 	// Fix whenever it proves to be a problem.
 	// I don't want to engineer something now until I know what's needed.
-	rootNd, err := fs.lkr.LookupNode(root)
+	rootNd, err := fs.lookupNodeAt(rev, root)
 	if err != nil {
 		return nil, err
 	}
@@ -940,6 +1024,7 @@ func (fs *FS) renewPins(oldFile, newFile *n.File) error {
 // Stage reads all data from `r` and stores as content of the node at `path`.
 // If `path` already exists, it will be updated.
 func (fs *FS) Stage(path string, r io.ReadSeeker) error {
+	start := time.Now()
 	fs.mu.Lock()
 
 	if fs.readOnly {
@@ -1034,6 +1119,8 @@ func (fs *FS) Stage(path string, r io.ReadSeeker) error {
 		return err
 	}
 
+	metricStageSeconds.Observe(time.Since(start).Seconds())
+	metricStageBytes.Add(float64(size))
 	return fs.pinner.PinNode(newFile, false)
 }
 
@@ -1156,17 +1243,25 @@ func (fs *FS) Tar(root string, w io.Writer, filter func(node *StatInfo) bool) er
 // Cat will open a file read-only and expose it's underlying data as stream.
 // If no such path is known or it was deleted, nil is returned as stream.
 func (fs *FS) Cat(path string) (mio.Stream, error) {
+	return fs.CatAt("", path)
+}
+
+// CatAt is like Cat but reads the file at `path` in the commit
+// referenced by `rev`.
+func (fs *FS) CatAt(rev, path string) (mio.Stream, error) {
+	start := time.Now()
 	fs.mu.Lock()
 
-	file, err := fs.lkr.LookupFile(path)
-	if err == ie.ErrBadNode {
+	nd, err := fs.lookupNodeAt(rev, path)
+	if err != nil {
 		fs.mu.Unlock()
-		return nil, ie.NoSuchFile(path)
+		return nil, err
 	}
 
-	if err != nil {
+	file, ok := nd.(*n.File)
+	if !ok {
 		fs.mu.Unlock()
-		return nil, err
+		return nil, ie.NoSuchFile(path)
 	}
 
 	// Copy all attributes, since accessing them beyond the lock might be racy.
@@ -1177,17 +1272,80 @@ func (fs *FS) Cat(path string) (mio.Stream, error) {
 
 	fs.mu.Unlock()
 
-	return fs.catHash(backendHash, key, size)
+	stream, err := fs.catHash(backendHash, key, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMeteredStream(stream, start), nil
 }
 
-// NOTE: This method can be called without locking fs.mu!
-func (fs *FS) catHash(backendHash h.Hash, key []byte, size uint64) (mio.Stream, error) {
+// IsIgnored checks if `repoPath` should not be staged, according to the
+// "ignore.patterns" config and the ignore files in its parent directories.
+func (fs *FS) IsIgnored(repoPath string, isDir bool) (bool, error) {
+	repoPath = path.Clean(prefixSlash(repoPath))
+	matcher := ignore.New(fs.cfg.Strings("ignore.patterns"))
+
+	dirs := []string{}
+	for dir := path.Dir(repoPath); dir != "/"; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+
+	dirs = append(dirs, "/")
+
+	// Outer directories first; rules of inner ones take precedence.
+	for idx := len(dirs) - 1; idx >= 0; idx-- {
+		stream, err := fs.Cat(path.Join(dirs[idx], ignore.FileName))
+		if ie.IsNoSuchFileError(err) {
+			continue
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		err = matcher.AddReader(dirs[idx], stream)
+		stream.Close()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return matcher.Match(repoPath, isDir), nil
+}
+
+// SetBlockCache makes the filesystem cache decrypted file content in `cache`.
+// The cache can be shared between several filesystems. It needs to be set
+// before the filesystem is used.
+func (fs *FS) SetBlockCache(cache *blockcache.Cache) {
+	fs.blockCache = cache
+}
+
+func (fs *FS) openHash(backendHash h.Hash, key []byte) (mio.Stream, error) {
 	rawStream, err := fs.bk.Cat(backendHash)
 	if err != nil {
 		return nil, err
 	}
 
-	stream, err := mio.NewOutStream(rawStream, key)
+	readAhead := int(fs.cfg.Int("block_cache.read_ahead"))
+	return mio.NewOutStreamWithReadAhead(rawStream, key, readAhead)
+}
+
+// NOTE: This method can be called without locking fs.mu!
+func (fs *FS) catHash(backendHash h.Hash, key []byte, size uint64) (mio.Stream, error) {
+	if fs.blockCache == nil {
+		return fs.catHashUncached(backendHash, key, size)
+	}
+
+	// The backend is only asked for blocks that are not cached.
+	return fs.blockCache.Stream(backendHash.B58String(), size, func() (mio.Stream, error) {
+		return fs.openHash(backendHash, key)
+	}), nil
+}
+
+// catHashUncached is like catHash, but always reads from the backend.
+func (fs *FS) catHashUncached(backendHash h.Hash, key []byte, size uint64) (mio.Stream, error) {
+	stream, err := fs.openHash(backendHash, key)
 	if err != nil {
 		return nil, err
 	}
@@ -1201,10 +1359,17 @@ func (fs *FS) catHash(backendHash h.Hash, key []byte, size uint64) (mio.Stream,
 // Open returns a file like object that can be used for modifying a file in memory.
 // If you want to have seekable read-only stream, use Cat(), it has less overhead.
 func (fs *FS) Open(path string) (*Handle, error) {
+	return fs.OpenAt("", path)
+}
+
+// OpenAt is like Open but opens the file at `path` in the commit referenced
+// by `rev`. Unless `rev` is empty, the returned handle is always read-only,
+// since old versions can not be modified.
+func (fs *FS) OpenAt(rev, path string) (*Handle, error) {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	nd, err := fs.lkr.LookupNode(path)
+	nd, err := fs.lookupNodeAt(rev, path)
 	if err != nil {
 		return nil, err
 	}
@@ -1214,7 +1379,7 @@ func (fs *FS) Open(path string) (*Handle, error) {
 		return nil, fmt.Errorf("Can only open files: %v", path)
 	}
 
-	return newHandle(fs, file, fs.readOnly), nil
+	return newHandle(fs, file, fs.readOnly || rev != ""), nil
 }
 
 ////////////////////
@@ -1233,7 +1398,12 @@ func (fs *FS) MakeCommit(msg string) error {
 		return err
 	}
 
-	return fs.lkr.MakeCommit(owner, msg)
+	if err := fs.lkr.MakeCommit(owner, msg); err != nil {
+		return err
+	}
+
+	fs.scheduleSearchIndexUpdate()
+	return nil
 }
 
 func (fs *FS) isMove(nd n.ModNode) (bool, error) {
@@ -1544,6 +1714,14 @@ func SyncOptReadOnlyFolders(folders []string) SyncOption {
 	}
 }
 
+// SyncOptBranch makes Sync() use the tip of the branch called `name`
+// of the remote instead of its current state.
+func SyncOptBranch(name string) SyncOption {
+	return func(cfg *vcs.SyncOptions) {
+		cfg.Branch = name
+	}
+}
+
 // SyncOptConflictgStrategyPerFolder allows you to set a specific conflict
 // resolution strategy for specific folders. The key of the map is the folder,
 // the key is the conflict strategy name.
@@ -1585,7 +1763,30 @@ func (fs *FS) Sync(remote *FS, options ...SyncOption) error {
 		option(syncCfg)
 	}
 
-	return vcs.Sync(remote.lkr, fs.lkr, syncCfg)
+	remoteOwner, err := remote.lkr.Owner()
+	if err != nil {
+		return err
+	}
+
+	// Count the conflicts for the metrics:
+	onConflict := syncCfg.OnConflict
+	syncCfg.OnConflict = func(src, dst n.ModNode) bool {
+		metricSyncConflicts.WithLabelValues(remoteOwner).Inc()
+		if onConflict != nil {
+			return onConflict(src, dst)
+		}
+
+		return true
+	}
+
+	start := time.Now()
+	if err := vcs.Sync(remote.lkr, fs.lkr, syncCfg); err != nil {
+		return err
+	}
+
+	fs.scheduleSearchIndexUpdate()
+	metricSyncSeconds.WithLabelValues(remoteOwner).Observe(time.Since(start).Seconds())
+	return nil
 }
 
 // MakeDiff will return a diff between `headRevOwn` and `headRevRemote`.
@@ -1660,6 +1861,36 @@ func (fs *FS) MakeDiff(remote *FS, headRevOwn, headRevRemote string) (*Diff, err
 	return fakeDiff, nil
 }
 
+// Tags returns all refs that point to a commit, mapped to the hash of it.
+// The staging commit ("curr") is not included, since it changes constantly.
+func (fs *FS) Tags() (map[string]h.Hash, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	names, err := fs.lkr.ListRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]h.Hash)
+	for _, name := range names {
+		if name == "curr" {
+			continue
+		}
+
+		nd, err := fs.lkr.ResolveRef(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if cmt, ok := nd.(*n.Commit); ok {
+			tags[name] = cmt.TreeHash().Clone()
+		}
+	}
+
+	return tags, nil
+}
+
 func (fs *FS) buildCommitHashToRefTable() (map[string][]string, error) {
 	names, err := fs.lkr.ListRefs()
 	if err != nil {
@@ -1806,6 +2037,330 @@ func (fs *FS) RemoveTag(name string) error {
 	return fs.lkr.RemoveRef(name)
 }
 
+// Branches returns all branches, sorted by their name.
+func (fs *FS) Branches() ([]Branch, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	curr, err := fs.lkr.CurrentBranch()
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := fs.lkr.ListBranches()
+	if err != nil {
+		return nil, err
+	}
+
+	hashToRef, err := fs.buildCommitHashToRefTable()
+	if err != nil {
+		return nil, err
+	}
+
+	branches := []Branch{}
+	for _, name := range names {
+		tip, err := fs.lkr.BranchTip(name)
+		if err != nil {
+			return nil, err
+		}
+
+		branches = append(branches, Branch{
+			Name:      name,
+			Tip:       commitToExternal(tip, hashToRef),
+			IsCurrent: name == curr,
+		})
+	}
+
+	return branches, nil
+}
+
+// CurrentBranch returns the name of the branch we're currently on.
+func (fs *FS) CurrentBranch() (string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.lkr.CurrentBranch()
+}
+
+// MakeBranch creates a new branch called `name` starting at `rev`.
+// If `rev` is empty, HEAD is used.
+func (fs *FS) MakeBranch(name, rev string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.readOnly {
+		return ErrReadOnly
+	}
+
+	if rev == "" {
+		rev = "head"
+	}
+
+	cmt, err := parseRev(fs.lkr, rev)
+	if err != nil {
+		return e.Wrap(err, "parse ref")
+	}
+
+	return fs.lkr.MakeBranch(name, cmt)
+}
+
+// RemoveBranch removes the branch called `name`.
+// The commits of it are kept and can be still reached by their hash.
+func (fs *FS) RemoveBranch(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.readOnly {
+		return ErrReadOnly
+	}
+
+	return fs.lkr.RemoveBranch(name)
+}
+
+// SwitchBranch makes `name` the current branch and checks out its tip.
+// If `force` is true a non-empty staging area will be overwritten.
+func (fs *FS) SwitchBranch(name string, force bool) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.readOnly {
+		return ErrReadOnly
+	}
+
+	return fs.lkr.SwitchBranch(name, force)
+}
+
+// Merge merges the branch called `name` into the current branch.
+// A merge commit is created if anything changed. The staging area
+// has to be empty, otherwise ErrStageNotEmpty is returned.
+func (fs *FS) Merge(name string, options ...SyncOption) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.readOnly {
+		return ErrReadOnly
+	}
+
+	curr, err := fs.lkr.CurrentBranch()
+	if err != nil {
+		return err
+	}
+
+	if strings.ToLower(name) == curr {
+		return fmt.Errorf("can't merge branch `%s` with itself", curr)
+	}
+
+	src, err := fs.lkr.BranchTip(name)
+	if err != nil {
+		return err
+	}
+
+	haveStaged, err := fs.lkr.HaveStagedChanges()
+	if err != nil {
+		return err
+	}
+
+	if haveStaged {
+		return ie.ErrStageNotEmpty
+	}
+
+	syncCfg, err := fs.buildSyncCfg()
+	if err != nil {
+		return err
+	}
+
+	for _, option := range options {
+		option(syncCfg)
+	}
+
+	return vcs.Merge(fs.lkr, src, "branch:"+strings.ToLower(name), syncCfg)
+}
+
+// Revert creates a new commit that undoes the changes made by the commit at
+// `rev`. Paths that were changed again since then are conflicts, which are
+// handled by the conflict strategy of `options`. The conflicting paths are
+// returned. The staging area needs to be empty.
+func (fs *FS) Revert(rev string, options ...SyncOption) ([]string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	cmt, err := parseRev(fs.lkr, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.pick(options, fmt.Sprintf("revert »%s«", cmt.Message()), func(strategy vcs.ConflictStrategy) ([]string, error) {
+		return vcs.Revert(fs.lkr, cmt, strategy)
+	})
+}
+
+// CherryPick creates a new commit with the changes that were made by the
+// commit at `rev` in `remote`. Paths where our state differs from the state
+// before this commit are conflicts, which are handled by the conflict
+// strategy of `options`. The conflicting paths are returned.
+// The staging area needs to be empty.
+func (fs *FS) CherryPick(remote *FS, rev string, options ...SyncOption) ([]string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	cmt, err := parseRev(remote.lkr, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteOwner, err := remote.lkr.Owner()
+	if err != nil {
+		return nil, err
+	}
+
+	msg := fmt.Sprintf("cherry-pick »%s« from %s", cmt.Message(), remoteOwner)
+	return fs.pick(options, msg, func(strategy vcs.ConflictStrategy) ([]string, error) {
+		return vcs.CherryPick(remote.lkr, fs.lkr, cmt, strategy)
+	})
+}
+
+func (fs *FS) pick(options []SyncOption, msg string, fn func(strategy vcs.ConflictStrategy) ([]string, error)) ([]string, error) {
+	haveStaged, err := fs.lkr.HaveStagedChanges()
+	if err != nil {
+		return nil, err
+	}
+
+	if haveStaged {
+		return nil, ie.ErrStageNotEmpty
+	}
+
+	syncCfg, err := fs.buildSyncCfg()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, option := range options {
+		option(syncCfg)
+	}
+
+	conflicts, err := fn(syncCfg.ConflictStrategy)
+	if err != nil {
+		return nil, err
+	}
+
+	owner, err := fs.lkr.Owner()
+	if err != nil {
+		return nil, err
+	}
+
+	// Nothing might have changed if all conflicts were ignored:
+	if err := fs.lkr.MakeCommit(owner, msg); err != nil && err != ie.ErrNoChange {
+		return nil, err
+	}
+
+	return conflicts, nil
+}
+
+// StashPush saves all staged changes as a new stash and resets the
+// staging area to HEAD. ErrNoChange is returned if there is nothing to stash.
+func (fs *FS) StashPush(msg string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.readOnly {
+		return ErrReadOnly
+	}
+
+	owner, err := fs.lkr.Owner()
+	if err != nil {
+		return err
+	}
+
+	if msg == "" {
+		branch, err := fs.lkr.CurrentBranch()
+		if err != nil {
+			return err
+		}
+
+		msg = fmt.Sprintf("stash on %s", branch)
+	}
+
+	_, err = fs.lkr.MakeStash(owner, msg)
+	return err
+}
+
+// Stashes returns all stashes, newest first.
+// The index in the list can be passed to StashPop() and StashDrop().
+func (fs *FS) Stashes() ([]Commit, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	cmts, err := fs.lkr.ListStashes()
+	if err != nil {
+		return nil, err
+	}
+
+	stashes := []Commit{}
+	for _, cmt := range cmts {
+		stashes = append(stashes, *commitToExternal(cmt, nil))
+	}
+
+	return stashes, nil
+}
+
+// StashPop applies the changes of the stash at `idx` to the staging area.
+// Paths that were changed in the meantime are conflicts, which are handled
+// by the conflict strategy of `options`. The conflicting paths are returned.
+// If there were no conflicts, the stash is dropped afterwards.
+func (fs *FS) StashPop(idx int, options ...SyncOption) ([]string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	stash, err := fs.lkr.Stash(idx)
+	if err != nil {
+		return nil, err
+	}
+
+	syncCfg, err := fs.buildSyncCfg()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, option := range options {
+		option(syncCfg)
+	}
+
+	conflicts, err := vcs.CherryPick(fs.lkr, fs.lkr, stash, syncCfg.ConflictStrategy)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(conflicts) > 0 {
+		return conflicts, nil
+	}
+
+	return nil, fs.lkr.DropStash(idx)
+}
+
+// StashDrop removes the stash at `idx` without applying it.
+func (fs *FS) StashDrop(idx int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.readOnly {
+		return ErrReadOnly
+	}
+
+	return fs.lkr.DropStash(idx)
+}
+
 // FilesByContent returns all stat info for the content hashes referenced in
 // `contents`.  The return value is a map with the content hash as key and a
 // StatInfo describing the exact file content.
@@ -1988,9 +2543,47 @@ func (fs *FS) ApplyPatch(data []byte) error {
 		return err
 	}
 
+	fs.scheduleSearchIndexUpdate()
 	return nil
 }
 
+// PatchBackendHashes returns the backend hashes of all files that are
+// added or modified by the patch in `data`. This is useful to find out
+// which content needs to be transferred along with a patch.
+func (fs *FS) PatchBackendHashes(data []byte) ([]h.Hash, error) {
+	msg, err := capnp.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	patch := &vcs.Patch{}
+	if err := patch.FromCapnp(msg); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	hashes := []h.Hash{}
+	for _, change := range patch.Changes {
+		if change.Mask&vcs.ChangeTypeRemove != 0 {
+			continue
+		}
+
+		if change.Curr.Type() != n.NodeTypeFile {
+			continue
+		}
+
+		backend := change.Curr.BackendHash()
+		if seen[backend.B58String()] {
+			continue
+		}
+
+		seen[backend.B58String()] = true
+		hashes = append(hashes, backend)
+	}
+
+	return hashes, nil
+}
+
 // LastPatchIndex will return the current version of this filesystem
 // regarding patch state.
 func (fs *FS) LastPatchIndex() (int64, error) {
@@ -2029,6 +2622,87 @@ func (fs *FS) CommitInfo(rev string) (*Commit, error) {
 	return commitToExternal(cmt, hashToRef), nil
 }
 
+// ChangedPaths returns all paths that were modified by the commit `rev`
+// compared to its parent. Moved nodes are reported at their old and at
+// their new location.
+func (fs *FS) ChangedPaths(rev string) (map[string]vcs.ChangeType, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	cmt, err := parseRev(fs.lkr, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	cmtChanges, err := vcs.CommitChanges(fs.lkr, cmt)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]vcs.ChangeType)
+	for _, change := range cmtChanges {
+		changes[change.Curr.Path()] |= change.Mask
+		if change.WasPreviouslyAt != "" {
+			changes[change.WasPreviouslyAt] |= vcs.ChangeTypeMove
+		}
+	}
+
+	return changes, nil
+}
+
+// Fsck checks the metadata of the filesystem for consistency and returns
+// all found problems. If `repair` is true, problems that can be fixed
+// without losing data are fixed. If `checkContent` is true, the content
+// of every file in the history is fetched from the backend and decrypted.
+func (fs *FS) Fsck(repair, checkContent bool) ([]FsckProblem, error) {
+	if repair && fs.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	var checkFile func(file *n.File) error
+	if checkContent {
+		checkFile = func(file *n.File) error {
+			// The backend content should be checked, not the cache.
+			stream, err := fs.catHashUncached(file.BackendHash(), file.Key(), file.Size())
+			if err != nil {
+				return err
+			}
+
+			defer stream.Close()
+
+			_, err = io.Copy(ioutil.Discard, stream)
+			return err
+		}
+	}
+
+	fsck := c.NewFsck(fs.lkr, repair, checkFile)
+
+	fs.mu.Lock()
+	problems, err := fsck.Run()
+	fs.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetching the content of every file might take a while,
+	// so do it without holding the lock.
+	problems = append(problems, fsck.CheckContent()...)
+
+	extProblems := []FsckProblem{}
+	for _, problem := range problems {
+		extProblems = append(extProblems, FsckProblem{
+			Kind:     problem.Kind,
+			Path:     problem.Path,
+			Commit:   problem.Commit,
+			Message:  problem.Message,
+			Repaired: problem.Repaired,
+		})
+	}
+
+	return extProblems, nil
+}
+
 // HaveStagedChanges returns true if there are changes that were not committed yet.
 func (fs *FS) HaveStagedChanges() (bool, error) {
 	fs.mu.Lock()
@@ -2039,10 +2713,16 @@ func (fs *FS) HaveStagedChanges() (bool, error) {
 
 // IsCached will return true when the file is cached locally.
 func (fs *FS) IsCached(path string) (bool, error) {
+	return fs.IsCachedAt("", path)
+}
+
+// IsCachedAt is like IsCached but checks the node at `path`
+// in the commit referenced by `rev`.
+func (fs *FS) IsCachedAt(rev, path string) (bool, error) {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	nd, err := fs.lkr.LookupNode(path)
+	nd, err := fs.lookupNodeAt(rev, path)
 	if err != nil {
 		return false, err
 	}
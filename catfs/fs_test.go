@@ -11,12 +11,17 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	c "github.com/sahib/brig/catfs/core"
 	ie "github.com/sahib/brig/catfs/errors"
 	"github.com/sahib/brig/catfs/mio"
+	"github.com/sahib/brig/catfs/mio/blockcache"
 	"github.com/sahib/brig/catfs/mio/chunkbuf"
 	"github.com/sahib/brig/catfs/mio/compress"
 	n "github.com/sahib/brig/catfs/nodes"
+	"github.com/sahib/brig/catfs/vcs"
 	"github.com/sahib/brig/defaults"
 	h "github.com/sahib/brig/util/hashlib"
 	"github.com/sahib/brig/util/testutil"
@@ -773,10 +778,16 @@ func TestPatch(t *testing.T) {
 			patch, err := srcFs.MakePatch("commit[0]", nil, "")
 			require.Nil(t, err)
 
-			require.Nil(t, dstFs.ApplyPatch(patch))
 			srcX, err := srcFs.Stat("/x")
 			require.Nil(t, err)
 
+			hashes, err := srcFs.PatchBackendHashes(patch)
+			require.Nil(t, err)
+			require.Len(t, hashes, 1)
+			require.Equal(t, srcX.BackendHash, hashes[0])
+
+			require.Nil(t, dstFs.ApplyPatch(patch))
+
 			srcIndex, err = srcFs.LastPatchIndex()
 			require.Nil(t, err)
 			require.Equal(t, int64(0), srcIndex)
@@ -941,3 +952,378 @@ func TestUndeleteDirectory(t *testing.T) {
 		}, paths)
 	})
 }
+
+func TestChangedPaths(t *testing.T) {
+	t.Parallel()
+
+	withDummyFS(t, func(fs *FS) {
+		require.Nil(t, fs.MakeCommit("init"))
+
+		require.Nil(t, fs.Touch("/x"))
+		require.Nil(t, fs.Mkdir("/dir", true))
+		require.Nil(t, fs.Touch("/dir/y"))
+		require.Nil(t, fs.MakeCommit("add"))
+
+		changes, err := fs.ChangedPaths("HEAD")
+		require.Nil(t, err)
+		require.Equal(t, vcs.ChangeTypeAdd, changes["/x"])
+		require.Equal(t, vcs.ChangeTypeAdd, changes["/dir/y"])
+
+		require.Nil(t, fs.Move("/x", "/z"))
+		require.Nil(t, fs.MakeCommit("move"))
+
+		changes, err = fs.ChangedPaths("HEAD")
+		require.Nil(t, err)
+		require.True(t, changes["/z"]&vcs.ChangeTypeMove != 0)
+		require.True(t, changes["/x"]&vcs.ChangeTypeMove != 0)
+		require.NotContains(t, changes, "/dir/y")
+	})
+}
+
+func histogramCount(t *testing.T, hist prometheus.Histogram) uint64 {
+	metric := &dto.Metric{}
+	require.Nil(t, hist.Write(metric))
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestStageAndCatMetrics(t *testing.T) {
+	withDummyFS(t, func(fs *FS) {
+		stagedBefore := promtestutil.ToFloat64(metricStageBytes)
+		stageCountBefore := histogramCount(t, metricStageSeconds)
+
+		data := testutil.CreateDummyBuf(1024)
+		require.Nil(t, fs.Stage("/x", bytes.NewReader(data)))
+
+		require.True(t, promtestutil.ToFloat64(metricStageBytes)-stagedBefore >= 1024)
+		require.True(t, histogramCount(t, metricStageSeconds) > stageCountBefore)
+
+		// Only bytes that were actually read are counted:
+		catBefore := promtestutil.ToFloat64(metricCatBytes)
+		catCountBefore := histogramCount(t, metricCatSeconds)
+
+		stream, err := fs.Cat("/x")
+		require.Nil(t, err)
+		require.Equal(t, catCountBefore, histogramCount(t, metricCatSeconds))
+		require.Nil(t, stream.Close())
+
+		require.Equal(t, catBefore, promtestutil.ToFloat64(metricCatBytes))
+		require.Equal(t, catCountBefore+1, histogramCount(t, metricCatSeconds))
+
+		stream, err = fs.Cat("/x")
+		require.Nil(t, err)
+		_, err = ioutil.ReadAll(stream)
+		require.Nil(t, err)
+		require.Nil(t, stream.Close())
+
+		require.Equal(t, catBefore+1024, promtestutil.ToFloat64(metricCatBytes))
+		require.Equal(t, catCountBefore+2, histogramCount(t, metricCatSeconds))
+	})
+}
+
+func TestFsck(t *testing.T) {
+	withDummyFS(t, func(fs *FS) {
+		require.Nil(t, fs.Stage("/x", bytes.NewReader(testutil.CreateDummyBuf(1024))))
+		require.Nil(t, fs.Stage("/dir/y", bytes.NewReader(testutil.CreateDummyBuf(2048))))
+		require.Nil(t, fs.MakeCommit("add"))
+		require.Nil(t, fs.Move("/x", "/dir/z"))
+		require.Nil(t, fs.Remove("/dir/y"))
+		require.Nil(t, fs.MakeCommit("move and remove"))
+
+		problems, err := fs.Fsck(false, true)
+		require.Nil(t, err)
+		require.Empty(t, problems)
+
+		info, err := fs.Stat("/dir/z")
+		require.Nil(t, err)
+
+		// Corrupt the content of /dir/z:
+		bk := fs.bk.(*MemFsBackend)
+		data := bk.data[info.BackendHash.B58String()]
+		data[len(data)/2] ^= 0xFF
+
+		problems, err = fs.Fsck(false, false)
+		require.Nil(t, err)
+		require.Empty(t, problems)
+
+		problems, err = fs.Fsck(false, true)
+		require.Nil(t, err)
+		require.Len(t, problems, 1)
+		require.Equal(t, "bad-content", problems[0].Kind)
+		require.Equal(t, "/dir/z", problems[0].Path)
+	})
+}
+
+func TestCatWithBlockCache(t *testing.T) {
+	withDummyFS(t, func(fs *FS) {
+		cacheDir, err := ioutil.TempDir("", "brig-fs-block-cache")
+		require.Nil(t, err)
+		defer os.RemoveAll(cacheDir)
+
+		cache, err := blockcache.Open(cacheDir, 1024*1024)
+		require.Nil(t, err)
+		fs.SetBlockCache(cache)
+
+		data := testutil.CreateDummyBuf(3 * blockcache.BlockSize)
+		require.Nil(t, fs.Stage("/x", bytes.NewReader(data)))
+
+		for round := 0; round < 2; round++ {
+			stream, err := fs.Cat("/x")
+			require.Nil(t, err)
+
+			result, err := ioutil.ReadAll(stream)
+			require.Nil(t, err)
+			require.Equal(t, data, result)
+			require.Nil(t, stream.Close())
+		}
+
+		stats := cache.Stats()
+		require.Equal(t, int64(3), stats.Hits)
+		require.Equal(t, int64(3), stats.Blocks)
+
+		// Handles read through the cache too:
+		fd, err := fs.Open("/x")
+		require.Nil(t, err)
+
+		_, err = fd.Seek(blockcache.BlockSize+10, io.SeekStart)
+		require.Nil(t, err)
+
+		buf := make([]byte, 100)
+		_, err = fd.Read(buf)
+		require.Nil(t, err)
+		require.Equal(t, data[blockcache.BlockSize+10:blockcache.BlockSize+110], buf)
+		require.Nil(t, fd.Close())
+		require.Equal(t, int64(4), cache.Stats().Hits)
+	})
+}
+
+func TestAtRev(t *testing.T) {
+	withDummyFS(t, func(fs *FS) {
+		require.Nil(t, fs.Stage("/dir/x", bytes.NewReader([]byte{1, 2, 3})))
+		require.Nil(t, fs.MakeCommit("first"))
+		require.Nil(t, fs.Tag("head", "v1"))
+		require.Nil(t, fs.Stage("/dir/x", bytes.NewReader([]byte{4, 5})))
+		require.Nil(t, fs.Stage("/dir/y", bytes.NewReader([]byte{6})))
+		require.Nil(t, fs.MakeCommit("second"))
+
+		info, err := fs.StatAt("v1", "/dir/x")
+		require.Nil(t, err)
+		require.Equal(t, uint64(3), info.Size)
+
+		_, err = fs.StatAt("v1", "/dir/y")
+		require.True(t, ie.IsNoSuchFileError(err))
+
+		infos, err := fs.ListAt("head^", "/dir", 1)
+		require.Nil(t, err)
+		require.Len(t, infos, 1)
+
+		infos, err = fs.ListAt("", "/dir", 1)
+		require.Nil(t, err)
+		require.Len(t, infos, 2)
+
+		fd, err := fs.OpenAt("v1", "/dir/x")
+		require.Nil(t, err)
+		data, err := ioutil.ReadAll(fd)
+		require.Nil(t, err)
+		require.Equal(t, []byte{1, 2, 3}, data)
+
+		_, err = fd.Write([]byte{7})
+		require.Equal(t, ErrReadOnly, err)
+		require.Nil(t, fd.Close())
+
+		stream, err := fs.CatAt("v1", "/dir/x")
+		require.Nil(t, err)
+		data, err = ioutil.ReadAll(stream)
+		require.Nil(t, err)
+		require.Equal(t, []byte{1, 2, 3}, data)
+		require.Nil(t, stream.Close())
+
+		_, err = fs.CatAt("v1", "/dir/y")
+		require.True(t, ie.IsNoSuchFileError(err))
+
+		_, err = fs.CatAt("v1", "/dir")
+		require.True(t, ie.IsNoSuchFileError(err))
+
+		tags, err := fs.Tags()
+		require.Nil(t, err)
+		require.Contains(t, tags, "v1")
+		require.Contains(t, tags, "head")
+		require.NotContains(t, tags, "curr")
+
+		head, err := fs.Head()
+		require.Nil(t, err)
+		require.Equal(t, head, tags["head"].B58String())
+	})
+}
+
+func TestBranches(t *testing.T) {
+	withDummyFS(t, func(fs *FS) {
+		require.Nil(t, fs.Stage("/x", bytes.NewReader([]byte{1})))
+		require.Nil(t, fs.MakeCommit("x"))
+		require.Nil(t, fs.MakeBranch("feature-x", ""))
+		require.Nil(t, fs.SwitchBranch("feature-x", false))
+
+		require.Nil(t, fs.Stage("/y", bytes.NewReader([]byte{2})))
+		require.Nil(t, fs.MakeCommit("y"))
+
+		// Branch names can be used as revision:
+		info, err := fs.StatAt("feature-x", "/y")
+		require.Nil(t, err)
+		require.Equal(t, "/y", info.Path)
+		_, err = fs.StatAt("feature-x^", "/y")
+		require.True(t, ie.IsNoSuchFileError(err))
+
+		require.Nil(t, fs.SwitchBranch("master", false))
+		require.Nil(t, fs.Stage("/z", bytes.NewReader([]byte{3})))
+		require.Equal(t, ie.ErrStageNotEmpty, fs.Merge("feature-x"))
+		require.Nil(t, fs.MakeCommit("z"))
+		require.NotNil(t, fs.Merge("master"))
+		require.Nil(t, fs.Merge("feature-x"))
+
+		for _, path := range []string{"/x", "/y", "/z"} {
+			_, err := fs.Stat(path)
+			require.Nil(t, err)
+		}
+
+		branches, err := fs.Branches()
+		require.Nil(t, err)
+		require.Len(t, branches, 2)
+		require.Equal(t, "feature-x", branches[0].Name)
+		require.Equal(t, "y", branches[0].Tip.Msg)
+		require.False(t, branches[0].IsCurrent)
+		require.Equal(t, "master", branches[1].Name)
+		require.True(t, branches[1].IsCurrent)
+
+		require.Nil(t, fs.RemoveBranch("feature-x"))
+		curr, err := fs.CurrentBranch()
+		require.Nil(t, err)
+		require.Equal(t, "master", curr)
+	})
+}
+
+func TestRevertAndCherryPick(t *testing.T) {
+	withDummyFS(t, func(srcFs *FS) {
+		withDummyFS(t, func(dstFs *FS) {
+			require.Nil(t, srcFs.Stage("/x", bytes.NewReader([]byte{1})))
+			require.Nil(t, srcFs.MakeCommit("add x"))
+			require.Nil(t, srcFs.Stage("/y", bytes.NewReader([]byte{2})))
+			require.Nil(t, srcFs.MakeCommit("add y"))
+			require.Nil(t, dstFs.MakeCommit("init"))
+
+			conflicts, err := dstFs.CherryPick(srcFs, "HEAD")
+			require.Nil(t, err)
+			require.Empty(t, conflicts)
+
+			_, err = dstFs.Stat("/y")
+			require.Nil(t, err)
+			_, err = dstFs.Stat("/x")
+			require.True(t, ie.IsNoSuchFileError(err))
+
+			head, err := dstFs.Head()
+			require.Nil(t, err)
+			cmt, err := dstFs.CommitInfo(head)
+			require.Nil(t, err)
+			require.Equal(t, "cherry-pick »add y« from alice", cmt.Msg)
+
+			require.Nil(t, srcFs.Touch("/staged"))
+			_, err = srcFs.Revert("HEAD")
+			require.Equal(t, ie.ErrStageNotEmpty, err)
+			require.Nil(t, srcFs.MakeCommit("add staged"))
+
+			conflicts, err = srcFs.Revert("HEAD^")
+			require.Nil(t, err)
+			require.Empty(t, conflicts)
+
+			_, err = srcFs.Stat("/y")
+			require.True(t, ie.IsNoSuchFileError(err))
+			for _, path := range []string{"/x", "/staged"} {
+				_, err := srcFs.Stat(path)
+				require.Nil(t, err)
+			}
+		})
+	})
+}
+
+func TestStash(t *testing.T) {
+	withDummyFS(t, func(fs *FS) {
+		require.Nil(t, fs.Stage("/x", bytes.NewReader([]byte{1})))
+		require.Nil(t, fs.MakeCommit("add x"))
+		require.Equal(t, ie.ErrNoChange, fs.StashPush(""))
+
+		require.Nil(t, fs.Stage("/x", bytes.NewReader([]byte{2})))
+		require.Nil(t, fs.Stage("/y", bytes.NewReader([]byte{3})))
+		require.Nil(t, fs.StashPush(""))
+
+		_, err := fs.Stat("/y")
+		require.True(t, ie.IsNoSuchFileError(err))
+
+		stashes, err := fs.Stashes()
+		require.Nil(t, err)
+		require.Len(t, stashes, 1)
+		require.Equal(t, "stash on master", stashes[0].Msg)
+
+		// Change /x in the meantime, so popping conflicts:
+		require.Nil(t, fs.Stage("/x", bytes.NewReader([]byte{4})))
+		conflicts, err := fs.StashPop(0)
+		require.Nil(t, err)
+		require.Equal(t, []string{"/x"}, conflicts)
+
+		// The stash is kept on conflicts:
+		stashes, err = fs.Stashes()
+		require.Nil(t, err)
+		require.Len(t, stashes, 1)
+
+		for _, path := range []string{"/y", "/x.conflict.0"} {
+			_, err := fs.Stat(path)
+			require.Nil(t, err)
+		}
+
+		require.Nil(t, fs.StashDrop(0))
+		require.True(t, ie.IsErrNoSuchStash(fs.StashDrop(0)))
+
+		// Without conflicts the stash is gone after popping:
+		require.Nil(t, fs.Stage("/z", bytes.NewReader([]byte{5})))
+		require.Nil(t, fs.StashPush("z"))
+		conflicts, err = fs.StashPop(0)
+		require.Nil(t, err)
+		require.Empty(t, conflicts)
+
+		_, err = fs.Stat("/z")
+		require.Nil(t, err)
+
+		stashes, err = fs.Stashes()
+		require.Nil(t, err)
+		require.Empty(t, stashes)
+	})
+}
+
+func TestIsIgnored(t *testing.T) {
+	t.Parallel()
+
+	withDummyFS(t, func(fs *FS) {
+		require.Nil(t, fs.Stage("/.brigignore", bytes.NewReader([]byte("*.log\n"))))
+		require.Nil(t, fs.Stage("/sub/.brigignore", bytes.NewReader([]byte("!keep.log\nbuild/\n"))))
+
+		tests := []struct {
+			path    string
+			isDir   bool
+			ignored bool
+		}{
+			{"/x", false, false},
+			{"/x.swp", false, false},
+			{"/.x.swp", false, true},
+			{"/x.log", false, true},
+			{"/sub/x.log", false, true},
+			{"/sub/keep.log", false, false},
+			{"/keep.log", false, true},
+			{"/sub/build", true, true},
+			{"/sub/build/a/b", false, true},
+			{"/build", true, false},
+		}
+
+		for _, test := range tests {
+			ignored, err := fs.IsIgnored(test.path, test.isDir)
+			require.Nil(t, err)
+			require.Equal(t, test.ignored, ignored, "path %s", test.path)
+		}
+	})
+}
@@ -45,13 +45,8 @@ func (hdl *Handle) initStreamIfNeeded() error {
 	}
 
 	// Initialize the stream lazily to avoid I/O on open()
-	rawStream, err := hdl.fs.bk.Cat(hdl.file.BackendHash())
-	if err != nil {
-		return err
-	}
-
-	// Stack the mio stack on top:
-	hdl.stream, err = mio.NewOutStream(rawStream, hdl.file.Key())
+	var err error
+	hdl.stream, err = hdl.fs.catHash(hdl.file.BackendHash(), hdl.file.Key(), hdl.file.Size())
 	if err != nil {
 		return err
 	}
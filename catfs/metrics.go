@@ -0,0 +1,88 @@
+package catfs
+
+import (
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sahib/brig/catfs/mio"
+)
+
+var (
+	metricStageSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "brig_fs_stage_duration_seconds",
+		Help: "Time it took to stage a single file.",
+	})
+
+	metricStageBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "brig_fs_stage_bytes_total",
+		Help: "Number of bytes staged.",
+	})
+
+	metricCatSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "brig_fs_cat_duration_seconds",
+		Help: "Time from opening a file for reading until its stream was closed.",
+	})
+
+	metricCatBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "brig_fs_cat_bytes_total",
+		Help: "Number of bytes read from files.",
+	})
+
+	metricRepinRuns = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "brig_repin_runs_total",
+		Help: "Number of finished repin runs.",
+	})
+
+	metricRepinFreedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "brig_repin_freed_bytes_total",
+		Help: "Number of bytes that were unpinned by repin runs.",
+	})
+
+	metricSyncSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "brig_sync_duration_seconds",
+		Help: "Time it took to sync with a remote.",
+	}, []string{"remote"})
+
+	metricSyncConflicts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "brig_sync_conflicts_total",
+		Help: "Number of conflicts that occurred while syncing with a remote.",
+	}, []string{"remote"})
+)
+
+// meteredStream records how long a stream was open and
+// how many bytes were read from it once it is closed.
+type meteredStream struct {
+	mio.Stream
+
+	start    time.Time
+	read     uint64
+	isClosed bool
+}
+
+func newMeteredStream(stream mio.Stream, start time.Time) *meteredStream {
+	return &meteredStream{Stream: stream, start: start}
+}
+
+func (ms *meteredStream) Read(buf []byte) (int, error) {
+	n, err := ms.Stream.Read(buf)
+	ms.read += uint64(n)
+	return n, err
+}
+
+func (ms *meteredStream) WriteTo(w io.Writer) (int64, error) {
+	n, err := ms.Stream.WriteTo(w)
+	ms.read += uint64(n)
+	return n, err
+}
+
+func (ms *meteredStream) Close() error {
+	if !ms.isClosed {
+		ms.isClosed = true
+		metricCatSeconds.Observe(time.Since(ms.start).Seconds())
+		metricCatBytes.Add(float64(ms.read))
+	}
+
+	return ms.Stream.Close()
+}
@@ -0,0 +1,206 @@
+// Package migrate brings the metadata database of a catfs filesystem
+// from an older ABI version to a newer one.
+//
+// Every change to the key layout of the database or to the encoding of
+// the stored nodes increases the ABI version. For each increase a Step is
+// registered that transforms a database of version n to version n+1.
+// Steps are run in order and in one batch, so either all of them are
+// applied or none. Steps should be idempotent, i.e. running a step on data
+// that was already transformed should not change it again.
+package migrate
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/sahib/brig/catfs/db"
+)
+
+var (
+	// versionKey is where the ABI version of a database is stored.
+	// This is the same key the linker uses in SetABIVersion().
+	versionKey = []string{"metadata", "version"}
+)
+
+// Step transforms a database from version From to version From+1.
+type Step struct {
+	// From is the version this step starts from.
+	From int
+
+	// Description shortly says what the step does.
+	Description string
+
+	// Run does the actual transformation. All modifications should be
+	// done via `batch`. Reads via `kv` will see the changes of previous
+	// steps.
+	Run func(kv db.Database, batch db.Batch) error
+}
+
+// To returns the version of the database after the step.
+func (s Step) To() int {
+	return s.From + 1
+}
+
+// Registry holds all known migration steps.
+type Registry struct {
+	steps map[int]Step
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		steps: make(map[int]Step),
+	}
+}
+
+// Register adds `step` to the registry. It panics if there is already a
+// step starting at the same version, since this is a programming error.
+func (r *Registry) Register(step Step) {
+	if step.Run == nil {
+		panic(fmt.Sprintf("migration step from version %d has no Run func", step.From))
+	}
+
+	if _, ok := r.steps[step.From]; ok {
+		panic(fmt.Sprintf("duplicate migration step from version %d", step.From))
+	}
+
+	r.steps[step.From] = step
+}
+
+// Plan returns the steps needed to go from version `from` to `to`.
+// It is an error if a step in between is missing or if `to` is
+// older than `from`.
+func (r *Registry) Plan(from, to int) ([]Step, error) {
+	if from > to {
+		return nil, fmt.Errorf(
+			"database has version %d, but only versions up to %d are supported",
+			from, to,
+		)
+	}
+
+	steps := []Step{}
+	for version := from; version < to; version++ {
+		step, ok := r.steps[version]
+		if !ok {
+			return nil, fmt.Errorf("no migration from version %d to %d", version, version+1)
+		}
+
+		steps = append(steps, step)
+	}
+
+	return steps, nil
+}
+
+// Migrate runs all steps needed to go from version `from` to `to` and
+// stores `to` as the new version. If `dryRun` is true, the steps are still
+// run, but all changes are rolled back afterwards. The steps that were
+// (or would have been) applied are returned.
+func (r *Registry) Migrate(kv db.Database, from, to int, dryRun bool) ([]Step, error) {
+	steps, err := r.Plan(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(steps) == 0 {
+		return steps, nil
+	}
+
+	batch := kv.Batch()
+	for _, step := range steps {
+		if err := step.Run(kv, batch); err != nil {
+			batch.Rollback()
+			return nil, fmt.Errorf("migration from version %d to %d failed: %v", step.From, step.To(), err)
+		}
+	}
+
+	if dryRun {
+		batch.Rollback()
+		return steps, nil
+	}
+
+	setVersion(batch, to)
+	if err := batch.Flush(); err != nil {
+		return nil, err
+	}
+
+	return steps, nil
+}
+
+// Version returns the version stored in `kv`.
+// If no version was stored yet, db.ErrNoSuchKey is returned.
+func Version(kv db.Database) (int, error) {
+	data, err := kv.Get(versionKey...)
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0, fmt.Errorf("invalid database version %q: %v", data, err)
+	}
+
+	return version, nil
+}
+
+func setVersion(batch db.Batch, version int) {
+	batch.Put([]byte(strconv.Itoa(version)), versionKey...)
+}
+
+// SetVersion stores `version` in `kv` without running any steps.
+// This is meant for new databases.
+func SetVersion(kv db.Database, version int) error {
+	batch := kv.Batch()
+	setVersion(batch, version)
+	return batch.Flush()
+}
+
+// RewriteValues calls `fn` for every key that starts with `prefix` and
+// replaces the value with what `fn` returns. If `fn` returns nil, the key
+// is left untouched. This is useful for steps that change the encoding
+// of nodes.
+func RewriteValues(kv db.Database, batch db.Batch, prefix []string, fn func(key []string, data []byte) ([]byte, error)) error {
+	keys, err := kv.Keys(prefix...)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		data, err := kv.Get(key...)
+		if err != nil {
+			return err
+		}
+
+		newData, err := fn(key, data)
+		if err != nil {
+			return fmt.Errorf("key %v: %v", key, err)
+		}
+
+		if newData != nil {
+			batch.Put(newData, key...)
+		}
+	}
+
+	return nil
+}
+
+// MoveKeys moves all keys starting with `src` below `dst`.
+// This is useful for steps that change the key layout.
+func MoveKeys(kv db.Database, batch db.Batch, src, dst []string) error {
+	keys, err := kv.Keys(src...)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		data, err := kv.Get(key...)
+		if err != nil {
+			return err
+		}
+
+		newKey := append(append([]string{}, dst...), key[len(src):]...)
+		batch.Erase(key...)
+		batch.Put(data, newKey...)
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package migrate
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/sahib/brig/catfs/db"
+	"github.com/stretchr/testify/require"
+)
+
+func withDatabase(t *testing.T, fn func(kv db.Database)) {
+	dir, err := ioutil.TempDir("", "brig-migrate")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	kv, err := db.NewBadgerDatabase(dir)
+	require.Nil(t, err)
+
+	fn(kv)
+	require.Nil(t, kv.Close())
+}
+
+func put(t *testing.T, kv db.Database, val string, key ...string) {
+	batch := kv.Batch()
+	batch.Put([]byte(val), key...)
+	require.Nil(t, batch.Flush())
+}
+
+func get(t *testing.T, kv db.Database, key ...string) string {
+	data, err := kv.Get(key...)
+	require.Nil(t, err)
+	return string(data)
+}
+
+func testRegistry() *Registry {
+	reg := NewRegistry()
+	reg.Register(Step{
+		From:        1,
+		Description: "upper case all nodes",
+		Run: func(kv db.Database, batch db.Batch) error {
+			return RewriteValues(kv, batch, []string{"objects"}, func(key []string, data []byte) ([]byte, error) {
+				if bytes.Equal(data, bytes.ToUpper(data)) {
+					return nil, nil
+				}
+
+				return bytes.ToUpper(data), nil
+			})
+		},
+	})
+	reg.Register(Step{
+		From:        2,
+		Description: "move nodes to nodes",
+		Run: func(kv db.Database, batch db.Batch) error {
+			return MoveKeys(kv, batch, []string{"objects"}, []string{"nodes"})
+		},
+	})
+	return reg
+}
+
+func TestMigrate(t *testing.T) {
+	withDatabase(t, func(kv db.Database) {
+		require.Nil(t, SetVersion(kv, 1))
+		put(t, kv, "a", "objects", "x")
+		put(t, kv, "b", "objects", "y")
+
+		reg := testRegistry()
+
+		// A dry run should not change anything:
+		steps, err := reg.Migrate(kv, 1, 3, true)
+		require.Nil(t, err)
+		require.Len(t, steps, 2)
+		require.Equal(t, "a", get(t, kv, "objects", "x"))
+
+		version, err := Version(kv)
+		require.Nil(t, err)
+		require.Equal(t, 1, version)
+
+		steps, err = reg.Migrate(kv, 1, 3, false)
+		require.Nil(t, err)
+		require.Len(t, steps, 2)
+		require.Equal(t, 2, steps[0].To())
+
+		require.Equal(t, "A", get(t, kv, "nodes", "x"))
+		require.Equal(t, "B", get(t, kv, "nodes", "y"))
+		_, err = kv.Get("objects", "x")
+		require.Equal(t, db.ErrNoSuchKey, err)
+
+		version, err = Version(kv)
+		require.Nil(t, err)
+		require.Equal(t, 3, version)
+
+		// Nothing to do anymore:
+		steps, err = reg.Migrate(kv, 3, 3, false)
+		require.Nil(t, err)
+		require.Len(t, steps, 0)
+	})
+}
+
+func TestMigrateFailure(t *testing.T) {
+	withDatabase(t, func(kv db.Database) {
+		require.Nil(t, SetVersion(kv, 1))
+		put(t, kv, "a", "objects", "x")
+
+		reg := testRegistry()
+		reg.Register(Step{
+			From: 3,
+			Run: func(kv db.Database, batch db.Batch) error {
+				return errors.New("broken")
+			},
+		})
+
+		_, err := reg.Migrate(kv, 1, 4, false)
+		require.NotNil(t, err)
+
+		// The previous steps should have been rolled back:
+		require.Equal(t, "a", get(t, kv, "objects", "x"))
+		version, err := Version(kv)
+		require.Nil(t, err)
+		require.Equal(t, 1, version)
+	})
+}
+
+func TestPlan(t *testing.T) {
+	reg := testRegistry()
+
+	steps, err := reg.Plan(2, 3)
+	require.Nil(t, err)
+	require.Len(t, steps, 1)
+	require.Equal(t, 2, steps[0].From)
+
+	// Missing step:
+	_, err = reg.Plan(1, 4)
+	require.NotNil(t, err)
+
+	// Database is newer than the program:
+	_, err = reg.Plan(3, 2)
+	require.NotNil(t, err)
+
+	require.Panics(t, func() {
+		reg.Register(Step{From: 1, Run: func(kv db.Database, batch db.Batch) error { return nil }})
+	})
+}
@@ -0,0 +1,143 @@
+package catfs
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sahib/brig/catfs/db"
+	"github.com/sahib/brig/catfs/migrate"
+	log "github.com/sirupsen/logrus"
+)
+
+// migrations has all steps that are needed to bring the database of an
+// older repository to abiVersion. When the key layout or the encoding of
+// nodes changes, increase abiVersion and register a step here.
+var migrations = migrate.NewRegistry()
+
+// MigrationStep describes a single step of a migration.
+type MigrationStep struct {
+	// From is the version before the step.
+	From int
+	// To is the version after the step.
+	To int
+	// Description says what the step does.
+	Description string
+}
+
+// MigrationResult describes what a migration did (or would have done).
+type MigrationResult struct {
+	// Version is the version of the database before the migration.
+	Version int
+	// Target is the version the database was migrated to.
+	Target int
+	// Steps are the steps that were applied.
+	Steps []MigrationStep
+	// SnapshotPath is where the database was exported to before the
+	// migration. It is empty if no snapshot was needed.
+	SnapshotPath string
+}
+
+// migrationSnapshotPath returns where the database at `dbPath` is exported
+// to before it is migrated away from `version`.
+func migrationSnapshotPath(dbPath string, version int) string {
+	return fmt.Sprintf("%s.pre-migration-v%d.export", dbPath, version)
+}
+
+// exportSnapshot exports `kv` to `path`. A snapshot of an earlier attempt
+// that failed is replaced; since failed migrations are rolled back, both
+// describe the same version. The old snapshot is only replaced once the
+// new one was written completely.
+func exportSnapshot(kv db.Database, path string) error {
+	tmpPath := path + ".tmp"
+	fd, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := kv.Export(fd); err != nil {
+		fd.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := fd.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// migrateDatabase brings `kv` to the version `target` using the steps in `reg`.
+// Before anything is changed, the database is exported to a file next to
+// `dbPath`, so it can be restored with Import() if something goes wrong.
+// Databases without any version are assumed to be new and are set to `target`.
+func migrateDatabase(kv db.Database, reg *migrate.Registry, dbPath string, target int, dryRun bool) (*MigrationResult, error) {
+	version, err := migrate.Version(kv)
+	if err == db.ErrNoSuchKey {
+		result := &MigrationResult{Version: target, Target: target}
+		if dryRun {
+			return result, nil
+		}
+
+		return result, migrate.SetVersion(kv, target)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MigrationResult{Version: version, Target: target}
+	steps, err := reg.Plan(version, target)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(steps) > 0 && !dryRun {
+		result.SnapshotPath = migrationSnapshotPath(dbPath, version)
+		if err := exportSnapshot(kv, result.SnapshotPath); err != nil {
+			return nil, fmt.Errorf("failed to export pre-migration snapshot: %v", err)
+		}
+
+		log.Infof(
+			"migrating metadata from version %d to %d (snapshot at %s)",
+			version, target, result.SnapshotPath,
+		)
+	}
+
+	steps, err = reg.Migrate(kv, version, target, dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, step := range steps {
+		result.Steps = append(result.Steps, MigrationStep{
+			From:        step.From,
+			To:          step.To(),
+			Description: step.Description,
+		})
+	}
+
+	return result, nil
+}
+
+// Migrate brings the metadata of the filesystem to the version this
+// program supports. This happens automatically when the filesystem is
+// created, so usually there is nothing to do. If `dryRun` is true, the
+// steps are checked, but no changes are kept.
+func (fs *FS) Migrate(dryRun bool) (*MigrationResult, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	result, err := migrateDatabase(fs.kv, migrations, fs.dbPath, abiVersion, dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Steps) > 0 {
+		// Nodes might be encoded differently now.
+		fs.lkr.MemIndexClear()
+	}
+
+	return result, nil
+}
@@ -0,0 +1,118 @@
+package catfs
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sahib/brig/catfs/db"
+	"github.com/sahib/brig/catfs/migrate"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brig-migrate")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	dbPath := filepath.Join(dir, "alice")
+	kv := db.NewMemoryDatabase()
+
+	reg := migrate.NewRegistry()
+	reg.Register(migrate.Step{
+		From:        1,
+		Description: "rename owner key",
+		Run: func(kv db.Database, batch db.Batch) error {
+			return migrate.MoveKeys(kv, batch, []string{"metadata", "user"}, []string{"metadata", "owner"})
+		},
+	})
+
+	// New databases get the current version without running anything:
+	result, err := migrateDatabase(kv, reg, dbPath, 1, false)
+	require.Nil(t, err)
+	require.Len(t, result.Steps, 0)
+	require.Equal(t, "", result.SnapshotPath)
+
+	batch := kv.Batch()
+	batch.Put([]byte("alice"), "metadata", "user")
+	require.Nil(t, batch.Flush())
+
+	result, err = migrateDatabase(kv, reg, dbPath, 2, true)
+	require.Nil(t, err)
+	require.Len(t, result.Steps, 1)
+	require.Equal(t, "", result.SnapshotPath)
+	_, err = kv.Get("metadata", "owner")
+	require.Equal(t, db.ErrNoSuchKey, err)
+
+	result, err = migrateDatabase(kv, reg, dbPath, 2, false)
+	require.Nil(t, err)
+	require.Equal(t, 1, result.Version)
+	require.Equal(t, 2, result.Target)
+	require.Equal(t, []MigrationStep{{From: 1, To: 2, Description: "rename owner key"}}, result.Steps)
+
+	owner, err := kv.Get("metadata", "owner")
+	require.Nil(t, err)
+	require.Equal(t, "alice", string(owner))
+
+	// The snapshot should still have the old state:
+	require.Equal(t, migrationSnapshotPath(dbPath, 1), result.SnapshotPath)
+	fd, err := os.Open(result.SnapshotPath)
+	require.Nil(t, err)
+	defer fd.Close()
+
+	oldKv := db.NewMemoryDatabase()
+	require.Nil(t, oldKv.Import(fd))
+	user, err := oldKv.Get("metadata", "user")
+	require.Nil(t, err)
+	require.Equal(t, "alice", string(user))
+
+	// Downgrades are not possible:
+	_, err = migrateDatabase(kv, reg, dbPath, 1, false)
+	require.NotNil(t, err)
+}
+
+func TestFSMigrate(t *testing.T) {
+	withDummyFS(t, func(fs *FS) {
+		result, err := fs.Migrate(true)
+		require.Nil(t, err)
+		require.Equal(t, abiVersion, result.Version)
+		require.Equal(t, abiVersion, result.Target)
+		require.Len(t, result.Steps, 0)
+	})
+}
+
+func TestMigrateDatabaseFailingStep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brig-migrate")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	dbPath := filepath.Join(dir, "alice")
+	kv := db.NewMemoryDatabase()
+	require.Nil(t, migrate.SetVersion(kv, 1))
+
+	reg := migrate.NewRegistry()
+	reg.Register(migrate.Step{
+		From:        1,
+		Description: "always fails",
+		Run: func(kv db.Database, batch db.Batch) error {
+			return errors.New("step failed")
+		},
+	})
+
+	// Every attempt should fail because of the step,
+	// not because of the snapshot of the attempt before:
+	for attempt := 0; attempt < 2; attempt++ {
+		_, err := migrateDatabase(kv, reg, dbPath, 2, false)
+		require.NotNil(t, err)
+		require.Contains(t, err.Error(), "step failed")
+
+		version, err := migrate.Version(kv)
+		require.Nil(t, err)
+		require.Equal(t, 1, version)
+
+		_, err = os.Stat(migrationSnapshotPath(dbPath, 1))
+		require.Nil(t, err)
+	}
+}
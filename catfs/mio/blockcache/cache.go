@@ -0,0 +1,338 @@
+// Package blockcache implements a size-bounded, persistent cache for blocks
+// of decrypted and decompressed file content.
+//
+// Blocks are identified by the backend hash of the file they belong to and
+// their index in it. They are stored as single files in one directory, so the
+// cache survives daemon restarts. If the cache grows over its maximum size,
+// the least recently used blocks are removed first.
+package blockcache
+
+import (
+	"container/list"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// BlockSize is the size of a cached block. Only the last block of a
+	// file may be smaller.
+	BlockSize = 64 * 1024
+)
+
+type blockKey struct {
+	id  string
+	idx int64
+}
+
+type blockEntry struct {
+	key  blockKey
+	size int64
+}
+
+// Stats gives information about the usage of the cache.
+type Stats struct {
+	// Hits is the number of blocks that were read from the cache.
+	Hits int64
+
+	// Misses is the number of blocks that were not in the cache.
+	Misses int64
+
+	// Evictions is the number of blocks that were removed,
+	// because the cache grew too big.
+	Evictions int64
+
+	// Blocks is the number of currently cached blocks.
+	Blocks int64
+
+	// Size is the number of bytes currently used by the cache.
+	Size int64
+
+	// MaxSize is the number of bytes the cache may use.
+	MaxSize int64
+}
+
+// Cache is a size-bounded LRU cache of blocks stored in a directory.
+// It is safe to use it from several goroutines. The lock only protects
+// the bookkeeping; blocks are read and written without holding it.
+type Cache struct {
+	mu sync.Mutex
+
+	dir     string
+	maxSize int64
+	size    int64
+
+	// lru has the most recently used entry at the front.
+	lru     *list.List
+	entries map[blockKey]*list.Element
+
+	// busy has the blocks whose file is currently written or removed.
+	busy map[blockKey]bool
+
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+func (k blockKey) fileName() string {
+	return fmt.Sprintf("%s-%d", k.id, k.idx)
+}
+
+func parseFileName(name string) (blockKey, bool) {
+	// Temporary files start with a dot.
+	sep := strings.LastIndexByte(name, '-')
+	if sep <= 0 || strings.HasPrefix(name, ".") {
+		return blockKey{}, false
+	}
+
+	idx, err := strconv.ParseInt(name[sep+1:], 10, 64)
+	if err != nil || idx < 0 {
+		return blockKey{}, false
+	}
+
+	return blockKey{id: name[:sep], idx: idx}, true
+}
+
+// Open loads the cache stored in `dir` (which is created if needed).
+// The cache will use at most `maxSize` bytes; if it is bigger already,
+// old blocks are removed.
+func Open(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// The modification time is updated on every hit,
+	// so the oldest files were used least recently.
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+
+	c := &Cache{
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[blockKey]*list.Element),
+		busy:    make(map[blockKey]bool),
+	}
+
+	for _, info := range infos {
+		key, ok := parseFileName(info.Name())
+		if !ok || !info.Mode().IsRegular() {
+			// Probably a left-over of an interrupted Put().
+			if err := os.Remove(filepath.Join(dir, info.Name())); err != nil {
+				log.Warningf("block cache: failed to remove %s: %v", info.Name(), err)
+			}
+
+			continue
+		}
+
+		entry := &blockEntry{key: key, size: info.Size()}
+		c.entries[key] = c.lru.PushBack(entry)
+		c.size += entry.size
+	}
+
+	c.removeFiles(c.evict())
+	return c, nil
+}
+
+func (c *Cache) path(key blockKey) string {
+	return filepath.Join(c.dir, key.fileName())
+}
+
+// unlink removes `elem` from the bookkeeping, but not its file.
+// c.mu needs to be locked.
+func (c *Cache) unlink(elem *list.Element) blockKey {
+	entry := elem.Value.(*blockEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+	return entry.key
+}
+
+// evict unlinks the least recently used blocks until the cache fits.
+// Their files need to be removed with removeFiles() afterwards.
+// c.mu needs to be locked.
+func (c *Cache) evict() []blockKey {
+	evicted := []blockKey{}
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		key := c.unlink(c.lru.Back())
+		c.busy[key] = true
+		evicted = append(evicted, key)
+		c.evictions++
+	}
+
+	return evicted
+}
+
+// removeFiles removes the files of the blocks returned by evict().
+// c.mu must not be locked.
+func (c *Cache) removeFiles(keys []blockKey) {
+	if len(keys) == 0 {
+		return
+	}
+
+	for _, key := range keys {
+		if err := os.Remove(c.path(key)); err != nil && !os.IsNotExist(err) {
+			log.Warningf("block cache: failed to remove block: %v", err)
+		}
+	}
+
+	c.mu.Lock()
+	for _, key := range keys {
+		delete(c.busy, key)
+	}
+	c.mu.Unlock()
+}
+
+// Get returns the block number `idx` of the stream identified by `id`.
+// The second return value is false if the block is not cached.
+func (c *Cache) Get(id string, idx int64) ([]byte, bool) {
+	key := blockKey{id: id, idx: idx}
+
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	c.hits++
+	c.mu.Unlock()
+
+	data, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		// Might have been evicted in the meantime.
+		log.Debugf("block cache: failed to read block: %v", err)
+
+		c.mu.Lock()
+		c.hits--
+		c.misses++
+
+		evicted := []blockKey{}
+		if c.entries[key] == elem {
+			c.unlink(elem)
+			c.busy[key] = true
+			evicted = append(evicted, key)
+		}
+
+		c.mu.Unlock()
+		c.removeFiles(evicted)
+		return nil, false
+	}
+
+	// Remember the usage also on disk for the next Open().
+	now := time.Now()
+	if err := os.Chtimes(c.path(key), now, now); err != nil {
+		log.Debugf("block cache: failed to touch block: %v", err)
+	}
+
+	return data, true
+}
+
+// writeFile writes `data` to the file of `key`. It writes to a temporary file
+// first, so no half-written blocks can be found after a crash.
+func (c *Cache) writeFile(key blockKey, data []byte) error {
+	fd, err := ioutil.TempFile(c.dir, ".put-")
+	if err != nil {
+		return err
+	}
+
+	if _, err := fd.Write(data); err != nil {
+		fd.Close()
+		os.Remove(fd.Name())
+		return err
+	}
+
+	if err := fd.Close(); err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+
+	if err := os.Rename(fd.Name(), c.path(key)); err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+
+	return nil
+}
+
+// Put stores `data` as block number `idx` of the stream identified by `id`.
+func (c *Cache) Put(id string, idx int64, data []byte) error {
+	key := blockKey{id: id, idx: idx}
+	size := int64(len(data))
+
+	c.mu.Lock()
+	if size > c.maxSize || c.busy[key] {
+		// Too big or somebody else is writing it right now.
+		c.mu.Unlock()
+		return nil
+	}
+
+	// The old file is replaced by the rename in writeFile().
+	if elem, ok := c.entries[key]; ok {
+		c.unlink(elem)
+	}
+
+	c.busy[key] = true
+	c.mu.Unlock()
+
+	if err := c.writeFile(key, data); err != nil {
+		// Do not leave an outdated version behind.
+		os.Remove(c.path(key))
+
+		c.mu.Lock()
+		delete(c.busy, key)
+		c.mu.Unlock()
+		return err
+	}
+
+	c.mu.Lock()
+	delete(c.busy, key)
+	c.entries[key] = c.lru.PushFront(&blockEntry{key: key, size: size})
+	c.size += size
+	evicted := c.evict()
+	c.mu.Unlock()
+
+	c.removeFiles(evicted)
+	return nil
+}
+
+// SetMaxSize changes the number of bytes the cache may use.
+func (c *Cache) SetMaxSize(maxSize int64) {
+	c.mu.Lock()
+	c.maxSize = maxSize
+	evicted := c.evict()
+	c.mu.Unlock()
+
+	c.removeFiles(evicted)
+}
+
+// Stats returns the current usage statistics.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Stats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Blocks:    int64(c.lru.Len()),
+		Size:      c.size,
+		MaxSize:   c.maxSize,
+	}
+}
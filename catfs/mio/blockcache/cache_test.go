@@ -0,0 +1,212 @@
+package blockcache
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/sahib/brig/catfs/mio"
+	"github.com/sahib/brig/util/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func withCache(t *testing.T, maxSize int64, fn func(c *Cache, dir string)) {
+	dir, err := ioutil.TempDir("", "brig-block-cache")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	c, err := Open(dir, maxSize)
+	require.Nil(t, err)
+	fn(c, dir)
+}
+
+func TestCacheGetPut(t *testing.T) {
+	withCache(t, 1024, func(c *Cache, dir string) {
+		_, ok := c.Get("a", 0)
+		require.False(t, ok)
+
+		require.Nil(t, c.Put("a", 0, []byte("hello")))
+		data, ok := c.Get("a", 0)
+		require.True(t, ok)
+		require.Equal(t, []byte("hello"), data)
+
+		_, ok = c.Get("a", 1)
+		require.False(t, ok)
+
+		stats := c.Stats()
+		require.Equal(t, int64(1), stats.Hits)
+		require.Equal(t, int64(2), stats.Misses)
+		require.Equal(t, int64(1), stats.Blocks)
+		require.Equal(t, int64(5), stats.Size)
+	})
+}
+
+func TestCacheEviction(t *testing.T) {
+	withCache(t, 30, func(c *Cache, dir string) {
+		require.Nil(t, c.Put("a", 0, make([]byte, 10)))
+		require.Nil(t, c.Put("a", 1, make([]byte, 10)))
+		require.Nil(t, c.Put("a", 2, make([]byte, 10)))
+
+		// Use the oldest one, so the second one gets evicted:
+		_, ok := c.Get("a", 0)
+		require.True(t, ok)
+
+		require.Nil(t, c.Put("b", 0, make([]byte, 10)))
+		_, ok = c.Get("a", 1)
+		require.False(t, ok)
+
+		for _, idx := range []int64{0, 2} {
+			_, ok = c.Get("a", idx)
+			require.True(t, ok)
+		}
+
+		require.Equal(t, int64(1), c.Stats().Evictions)
+
+		// Too big blocks are not stored at all:
+		require.Nil(t, c.Put("c", 0, make([]byte, 31)))
+		_, ok = c.Get("c", 0)
+		require.False(t, ok)
+
+		c.SetMaxSize(10)
+		stats := c.Stats()
+		require.Equal(t, int64(1), stats.Blocks)
+		require.Equal(t, int64(10), stats.Size)
+
+		infos, err := ioutil.ReadDir(dir)
+		require.Nil(t, err)
+		require.Len(t, infos, 1)
+	})
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	withCache(t, 50, func(c *Cache, dir string) {
+		wg := sync.WaitGroup{}
+		for worker := 0; worker < 8; worker++ {
+			wg.Add(1)
+			go func(worker int) {
+				defer wg.Done()
+				for idx := int64(0); idx < 50; idx++ {
+					data := bytes.Repeat([]byte{byte(idx)}, 10)
+					require.Nil(t, c.Put("a", idx%10, data))
+
+					// Blocks might be evicted, but never have wrong content:
+					if cached, ok := c.Get("a", idx%10); ok {
+						require.Equal(t, byte(idx%10), cached[0]%10)
+					}
+				}
+			}(worker)
+		}
+
+		wg.Wait()
+
+		stats := c.Stats()
+		require.True(t, stats.Size <= 50)
+
+		infos, err := ioutil.ReadDir(dir)
+		require.Nil(t, err)
+		require.Equal(t, int(stats.Blocks), len(infos))
+	})
+}
+
+func TestCachePersistence(t *testing.T) {
+	withCache(t, 1024, func(c *Cache, dir string) {
+		require.Nil(t, c.Put("a", 0, []byte("hello")))
+		require.Nil(t, c.Put("a", 1, []byte("world")))
+
+		// Left-over of a crash:
+		require.Nil(t, ioutil.WriteFile(dir+"/.put-123", []byte("x"), 0600))
+
+		c, err := Open(dir, 1024)
+		require.Nil(t, err)
+
+		data, ok := c.Get("a", 1)
+		require.True(t, ok)
+		require.Equal(t, []byte("world"), data)
+		require.Equal(t, int64(2), c.Stats().Blocks)
+
+		_, err = os.Stat(dir + "/.put-123")
+		require.True(t, os.IsNotExist(err))
+	})
+}
+
+type countingOpener struct {
+	data  []byte
+	opens int
+}
+
+func (co *countingOpener) open() (mio.Stream, error) {
+	co.opens++
+	r := bytes.NewReader(co.data)
+	return struct {
+		io.Reader
+		io.Seeker
+		io.Closer
+		io.WriterTo
+	}{
+		Reader:   r,
+		Seeker:   r,
+		WriterTo: r,
+		Closer:   ioutil.NopCloser(r),
+	}, nil
+}
+
+func TestCacheStream(t *testing.T) {
+	withCache(t, 1024*1024, func(c *Cache, dir string) {
+		data := testutil.CreateDummyBuf(3*BlockSize + 100)
+		co := &countingOpener{data: data}
+
+		// Data after `size` is not visible:
+		size := uint64(len(data) - 50)
+		stream := c.Stream("x", size, co.open)
+		outBuf := &bytes.Buffer{}
+		n, err := io.Copy(outBuf, stream)
+		require.Nil(t, err)
+		require.Equal(t, int64(size), n)
+		require.Equal(t, data[:size], outBuf.Bytes())
+		require.Nil(t, stream.Close())
+		require.Equal(t, 1, co.opens)
+
+		// Everything is cached now:
+		stream = c.Stream("x", size, co.open)
+		for _, offset := range []int64{BlockSize + 10, 0, int64(size) - 10} {
+			_, err := stream.Seek(offset, io.SeekStart)
+			require.Nil(t, err)
+
+			buf := make([]byte, BlockSize)
+			n, err := stream.Read(buf)
+			if err != io.EOF {
+				require.Nil(t, err)
+			}
+
+			require.Equal(t, data[offset:offset+int64(n)], buf[:n])
+		}
+
+		end, err := stream.Seek(0, io.SeekEnd)
+		require.Nil(t, err)
+		require.Equal(t, int64(size), end)
+
+		n2, err := stream.Read(make([]byte, 10))
+		require.Equal(t, io.EOF, err)
+		require.Equal(t, 0, n2)
+
+		require.Nil(t, stream.Close())
+		require.Equal(t, 1, co.opens)
+		require.Equal(t, int64(4), c.Stats().Blocks)
+	})
+}
+
+func TestCacheStreamShortData(t *testing.T) {
+	withCache(t, 1024*1024, func(c *Cache, dir string) {
+		co := &countingOpener{data: []byte("short")}
+		stream := c.Stream("x", 100, co.open)
+
+		_, err := ioutil.ReadAll(stream)
+		require.NotNil(t, err)
+
+		// Incomplete blocks are not cached:
+		require.Equal(t, int64(0), c.Stats().Blocks)
+	})
+}
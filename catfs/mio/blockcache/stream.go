@@ -0,0 +1,158 @@
+package blockcache
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/sahib/brig/catfs/mio"
+	log "github.com/sirupsen/logrus"
+)
+
+// stream reads `size` bytes of a stream block by block and serves
+// blocks from the cache if possible. The underlying stream is only
+// opened when a block is missing.
+type stream struct {
+	cache *Cache
+	id    string
+	size  int64
+	pos   int64
+	open  func() (mio.Stream, error)
+
+	// raw is nil until it was opened.
+	raw    mio.Stream
+	rawPos int64
+
+	// block is the data of the block with the index blockIdx.
+	block    []byte
+	blockIdx int64
+}
+
+// Stream returns a stream with the first `size` bytes of the stream
+// returned by `open`. All blocks that were read are added to the cache,
+// using `id` to identify the stream. `open` is only called if a block
+// is not cached yet.
+func (c *Cache) Stream(id string, size uint64, open func() (mio.Stream, error)) mio.Stream {
+	return &stream{
+		cache:    c,
+		id:       id,
+		size:     int64(size),
+		open:     open,
+		blockIdx: -1,
+	}
+}
+
+func (s *stream) loadBlock(idx int64) error {
+	if s.blockIdx == idx {
+		return nil
+	}
+
+	if data, ok := s.cache.Get(s.id, idx); ok {
+		s.block = data
+		s.blockIdx = idx
+		return nil
+	}
+
+	if s.raw == nil {
+		raw, err := s.open()
+		if err != nil {
+			return err
+		}
+
+		s.raw = raw
+		s.rawPos = 0
+	}
+
+	offset := idx * BlockSize
+	if s.rawPos != offset {
+		if _, err := s.raw.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+
+		s.rawPos = offset
+	}
+
+	blockSize := s.size - offset
+	if blockSize > BlockSize {
+		blockSize = BlockSize
+	}
+
+	data := make([]byte, blockSize)
+	n, err := io.ReadFull(s.raw, data)
+	s.rawPos += int64(n)
+	if err != nil {
+		// The data was most likely shorter than expected.
+		s.rawPos = -1
+		return fmt.Errorf("block %d: %v", idx, err)
+	}
+
+	if err := s.cache.Put(s.id, idx, data); err != nil {
+		log.Warningf("block cache: failed to store block: %v", err)
+	}
+
+	s.block = data
+	s.blockIdx = idx
+	return nil
+}
+
+func (s *stream) Read(buf []byte) (int, error) {
+	read := 0
+	for read < len(buf) {
+		if s.pos >= s.size {
+			return read, io.EOF
+		}
+
+		idx := s.pos / BlockSize
+		if err := s.loadBlock(idx); err != nil {
+			return read, err
+		}
+
+		n := copy(buf[read:], s.block[s.pos-idx*BlockSize:])
+		s.pos += int64(n)
+		read += n
+	}
+
+	return read, nil
+}
+
+func (s *stream) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("negative seek offset: %d", offset)
+	}
+
+	s.pos = offset
+	return offset, nil
+}
+
+func (s *stream) WriteTo(w io.Writer) (int64, error) {
+	written := int64(0)
+	for s.pos < s.size {
+		idx := s.pos / BlockSize
+		if err := s.loadBlock(idx); err != nil {
+			return written, err
+		}
+
+		n, err := w.Write(s.block[s.pos-idx*BlockSize:])
+		s.pos += int64(n)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+func (s *stream) Close() error {
+	if s.raw == nil {
+		return nil
+	}
+
+	return s.raw.Close()
+}
@@ -41,6 +41,18 @@ type Reader struct {
 	// Total size of the underlying stream in bytes.
 	// This is only set when SEEK_END was used.
 	endOffsetEnc int64
+
+	// How many blocks to read in advance when reading sequentially.
+	readAhead int
+
+	// Encrypted data that was read from the underlying stream, but not
+	// decrypted yet. It starts with the block number rawBlockNum.
+	raw         []byte
+	rawBuf      []byte
+	rawBlockNum int64
+
+	// Number of the last block that was decrypted.
+	lastReadBlockNum int64
 }
 
 func (r *Reader) readHeaderIfNotDone() error {
@@ -116,41 +128,113 @@ func (r *Reader) Read(dest []byte) (int, error) {
 	return readBytes, nil
 }
 
+// Size of a block in the underlying stream, including nonce and MAC.
+func (r *Reader) totalBlockSize() int {
+	return r.aead.NonceSize() + int(r.info.Blocklen) + r.aead.Overhead()
+}
+
+// SetReadAhead sets how many blocks are read from the underlying stream in
+// advance when reading sequentially. This saves round trips on streams
+// where every read is expensive. The default is 0.
+func (r *Reader) SetReadAhead(blocks int) {
+	if blocks < 0 {
+		blocks = 0
+	}
+
+	r.readAhead = blocks
+}
+
+// fillRaw reads the encrypted block `blockNum` from the underlying stream,
+// plus the read-ahead if the previous block was read before it.
+func (r *Reader) fillRaw(blockNum int64) error {
+	nBlocks := 1
+	if blockNum == r.lastReadBlockNum+1 {
+		nBlocks += r.readAhead
+	}
+
+	want := nBlocks * r.totalBlockSize()
+	if cap(r.rawBuf) < want {
+		r.rawBuf = make([]byte, want)
+	}
+
+	n, err := io.ReadAtLeast(r.Reader, r.rawBuf[:want], want)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	if n == 0 {
+		return io.EOF
+	}
+
+	r.raw = r.rawBuf[:n]
+	r.rawBlockNum = blockNum
+	return nil
+}
+
+// skipRawTo drops the read-ahead data before the block `blockNum`.
+// If the block was not read ahead, false is returned.
+func (r *Reader) skipRawTo(blockNum int64) bool {
+	if blockNum < r.rawBlockNum {
+		return false
+	}
+
+	skip := (blockNum - r.rawBlockNum) * int64(r.totalBlockSize())
+	if skip >= int64(len(r.raw)) {
+		return false
+	}
+
+	r.raw = r.raw[skip:]
+	r.rawBlockNum = blockNum
+	return true
+}
+
 // Fill internal buffer with current block
 func (r *Reader) readBlock() (int, error) {
 	if r.info == nil {
 		return 0, fmt.Errorf("Invalid header data")
 	}
 
+	currBlockNum := r.lastDecSeekPos / int64(r.info.Blocklen)
+	if len(r.raw) == 0 {
+		if err := r.fillRaw(currBlockNum); err != nil {
+			return 0, err
+		}
+	}
+
 	// Read nonce:
-	if n, err := r.Reader.Read(r.nonce); err != nil {
-		return 0, err
-	} else if n != r.aead.NonceSize() {
+	nonceSize := r.aead.NonceSize()
+	if len(r.raw) < nonceSize {
 		return 0, fmt.Errorf("nonce size mismatch; should: %d - have: %d",
-			r.aead.NonceSize(), n)
+			nonceSize, len(r.raw))
 	}
 
+	// Take the *whole* block from the raw stream
+	blockSize := r.totalBlockSize()
+	if blockSize > len(r.raw) {
+		blockSize = len(r.raw)
+	}
+
+	block := r.raw[:blockSize]
+	r.raw = r.raw[blockSize:]
+	r.rawBlockNum++
+
+	copy(r.nonce, block[:nonceSize])
+
 	// Convert to block number:
 	readBlockNum := binary.LittleEndian.Uint64(r.nonce)
 
 	// Check the block number:
-	currBlockNum := uint64(r.lastDecSeekPos / int64(r.info.Blocklen))
-	if currBlockNum != readBlockNum {
+	if uint64(currBlockNum) != readBlockNum {
 		return 0, fmt.Errorf(
 			"bad block number; as %d, should be %d", readBlockNum, currBlockNum,
 		)
 	}
 
-	// Read the *whole* block from the raw stream
-	N := int(r.info.Blocklen) + r.aead.Overhead()
-	n, err := io.ReadAtLeast(r.Reader, r.encBuf[:N], N)
-	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
-		return 0, err
-	}
-
-	r.lastEncSeekPos += int64(n) + int64(len(r.nonce))
+	r.lastEncSeekPos += int64(blockSize)
+	r.lastReadBlockNum = currBlockNum
 
-	r.decBuf, err = r.aead.Open(r.decBuf[:0], r.nonce, r.encBuf[:n], nil)
+	var err error
+	r.decBuf, err = r.aead.Open(r.decBuf[:0], r.nonce, block[nonceSize:], nil)
 	if err != nil {
 		return 0, err
 	}
@@ -236,7 +320,7 @@ func (r *Reader) Seek(offset int64, whence int) (int64, error) {
 		// is necessary further down this function.
 		defer func() {
 			if !wasMoved {
-				seeker.Seek(r.lastEncSeekPos, io.SeekStart)
+				seeker.Seek(r.lastEncSeekPos+int64(len(r.raw)), io.SeekStart)
 			}
 		}()
 	}
@@ -262,10 +346,14 @@ func (r *Reader) Seek(offset int64, whence int) (int64, error) {
 	if lastBlockNum != blockNum || r.isInitialRead || whence == io.SeekEnd {
 		r.lastEncSeekPos = absOffsetEnc
 
-		// Seek to the beginning of the encrypted block:
-		wasMoved = true
-		if _, err := seeker.Seek(absOffsetEnc, io.SeekStart); err != nil {
-			return 0, err
+		// Seek to the beginning of the encrypted block,
+		// unless it was read ahead already:
+		if whence == io.SeekEnd || !r.skipRawTo(blockNum) {
+			r.raw = nil
+			wasMoved = true
+			if _, err := seeker.Seek(absOffsetEnc, io.SeekStart); err != nil {
+				return 0, err
+			}
 		}
 
 		// Make read consume the current block:
@@ -343,6 +431,8 @@ func NewReader(r io.Reader, key []byte) (*Reader, error) {
 		parsedHeader:  false,
 		isInitialRead: true,
 		endOffsetEnc:  -1,
+		// Reading the first block counts as sequential.
+		lastReadBlockNum: -1,
 		aeadCommon: aeadCommon{
 			key: key,
 		},
@@ -22,11 +22,19 @@ type Stream interface {
 // `key` is used to decrypt the data. The compression algorithm is read
 // from the stream header.
 func NewOutStream(r io.ReadSeeker, key []byte) (Stream, error) {
+	return NewOutStreamWithReadAhead(r, key, 0)
+}
+
+// NewOutStreamWithReadAhead works like NewOutStream, but reads up to
+// `readAhead` encrypted blocks in advance while `r` is read sequentially.
+func NewOutStreamWithReadAhead(r io.ReadSeeker, key []byte, readAhead int) (Stream, error) {
 	rEnc, err := encrypt.NewReader(r, key)
 	if err != nil {
 		return nil, err
 	}
 
+	rEnc.SetReadAhead(readAhead)
+
 	rZip := compress.NewReader(rEnc)
 	return struct {
 		io.Reader
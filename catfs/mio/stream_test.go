@@ -434,3 +434,55 @@ func TestStreamSizeBySeek(t *testing.T) {
 	require.Equal(t, int64(len(data)), n)
 	require.Equal(t, outBuf.Bytes(), data)
 }
+
+// countingReader counts how often the underlying stream was read.
+type countingReader struct {
+	*bytes.Reader
+	reads int
+}
+
+func (cr *countingReader) Read(buf []byte) (int, error) {
+	cr.reads++
+	return cr.Reader.Read(buf)
+}
+
+func TestStreamReadAhead(t *testing.T) {
+	data := testutil.CreateRandomDummyBuf(1024*1024, 23)
+	packed := &bytes.Buffer{}
+	encStream, err := NewInStream(bytes.NewReader(data), TestKey, compress.AlgoSnappy)
+	require.Nil(t, err)
+
+	_, err = io.Copy(packed, encStream)
+	require.Nil(t, err)
+
+	reads := map[int]int{}
+	for _, readAhead := range []int{0, 1, 4} {
+		r := &countingReader{Reader: bytes.NewReader(packed.Bytes())}
+		stream, err := NewOutStreamWithReadAhead(r, TestKey, readAhead)
+		require.Nil(t, err)
+
+		// Sequential read:
+		outBuf := &bytes.Buffer{}
+		_, err = io.Copy(outBuf, stream)
+		require.Nil(t, err)
+		require.Equal(t, data, outBuf.Bytes())
+		reads[readAhead] = r.reads
+
+		// Seeking around should still work:
+		for _, offset := range []int64{500 * 1024, 10, 900 * 1024, 64*1024 - 1, 0} {
+			_, err := stream.Seek(offset, io.SeekStart)
+			require.Nil(t, err)
+
+			buf := make([]byte, 100*1024)
+			n, err := io.ReadFull(stream, buf)
+			if err != io.ErrUnexpectedEOF {
+				require.Nil(t, err)
+			}
+
+			require.Equal(t, data[offset:offset+int64(n)], buf[:n])
+		}
+	}
+
+	require.True(t, reads[1] < reads[0])
+	require.True(t, reads[4] < reads[1])
+}
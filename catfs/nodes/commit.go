@@ -220,7 +220,15 @@ func (c *Commit) BoxCommit(author string, message string) error {
 	}
 
 	c.author = author
+	c.message = message
+	c.tree = c.ComputeTreeHash()
+	return nil
+}
 
+// ComputeTreeHash calculates the hash of the commit from its parent,
+// root, author and message. It does not modify the commit.
+// For a boxed commit the result should always equal TreeHash().
+func (c *Commit) ComputeTreeHash() h.Hash {
 	buf := &bytes.Buffer{}
 
 	// If parent == nil, this will be EmptyBackendHash.
@@ -233,12 +241,9 @@ func (c *Commit) BoxCommit(author string, message string) error {
 	buf.Write(padHash(h.Sum([]byte(c.author))))
 
 	// Write the message last, it may be arbitrary length.
-	buf.Write([]byte(message))
+	buf.Write([]byte(c.message))
 
-	mh := h.Sum(buf.Bytes())
-	c.message = message
-	c.tree = h.Hash(mh)
-	return nil
+	return h.Sum(buf.Bytes())
 }
 
 // String will return a nice representation of a commit.
@@ -274,6 +279,11 @@ func (c *Commit) Message() string {
 	return c.message
 }
 
+// Author returns the user that made this commit.
+func (c *Commit) Author() string {
+	return c.author
+}
+
 // Path will return the path of the commit, which will
 func (c *Commit) Path() string {
 	return prefixSlash(path.Join(".snapshots", c.Name()))
@@ -319,6 +329,16 @@ func (c *Commit) Parent(lkr Linker) (Node, error) {
 	return lkr.NodeByHash(c.parent)
 }
 
+// ParentHash returns the hash of the parent commit or nil
+// if it is the first commit ever made. You shall not modify the returned hash.
+func (c *Commit) ParentHash() h.Hash {
+	if len(c.parent) == 0 {
+		return nil
+	}
+
+	return c.parent
+}
+
 // SetParent sets the parent of the commit to `nd`.
 func (c *Commit) SetParent(lkr Linker, nd Node) error {
 	c.parent = nd.TreeHash().Clone()
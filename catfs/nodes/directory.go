@@ -555,12 +555,33 @@ func (d *Directory) Copy(inode uint64) ModNode {
 	}
 }
 
+// ComputeTreeHash calculates the tree hash of the directory from its path
+// and the hashes of its children. It does not modify the directory.
+// The result should always equal TreeHash(); if it does not, the
+// directory's metadata is corrupted.
+func (d *Directory) ComputeTreeHash() h.Hash {
+	treeHash := h.Sum([]byte(path.Join(d.parentName, d.name)))
+	for _, name := range d.order {
+		treeHash = treeHash.Mix(d.children[name])
+	}
+
+	return treeHash
+}
+
+// ChildHashes returns a mapping of child names to their tree hashes.
+// Unlike VisitChildren, the children do not need to be resolvable.
+func (d *Directory) ChildHashes() map[string]h.Hash {
+	hashes := make(map[string]h.Hash, len(d.children))
+	for name, hash := range d.children {
+		hashes[name] = hash.Clone()
+	}
+
+	return hashes
+}
+
 func (d *Directory) rehash(lkr Linker, updateContentHash bool) error {
-	newTreeHash := h.Sum([]byte(path.Join(d.parentName, d.name)))
 	newContentHash := h.EmptyInternalHash.Clone()
 	for _, name := range d.order {
-		newTreeHash = newTreeHash.Mix(d.children[name])
-
 		if childContent := d.contents[name]; updateContentHash && childContent != nil {
 			// The child content might be nil in case of ghost.
 			// Those should not add to the content calculation.
@@ -569,7 +590,7 @@ func (d *Directory) rehash(lkr Linker, updateContentHash bool) error {
 	}
 
 	oldHash := d.tree.Clone()
-	d.tree = newTreeHash
+	d.tree = d.ComputeTreeHash()
 
 	if updateContentHash {
 		d.content = newContentHash
@@ -177,8 +177,7 @@ func (f *File) Copy(inode uint64) ModNode {
 	}
 }
 
-func (f *File) rehash(lkr Linker, newPath string) {
-	oldHash := f.tree.Clone()
+func (f *File) treeHashAt(filePath string) h.Hash {
 	var contentHash h.Hash
 	if f.Base.content != nil {
 		contentHash = f.Base.content.Clone()
@@ -186,7 +185,19 @@ func (f *File) rehash(lkr Linker, newPath string) {
 		contentHash = h.EmptyInternalHash.Clone()
 	}
 
-	f.tree = h.Sum([]byte(fmt.Sprintf("%s|%s", newPath, contentHash)))
+	return h.Sum([]byte(fmt.Sprintf("%s|%s", filePath, contentHash)))
+}
+
+// ComputeTreeHash calculates the tree hash of the file from its path and
+// content hash. It does not modify the file. The result should always
+// equal TreeHash(); if it does not, the file's metadata is corrupted.
+func (f *File) ComputeTreeHash() h.Hash {
+	return f.treeHashAt(f.Path())
+}
+
+func (f *File) rehash(lkr Linker, newPath string) {
+	oldHash := f.tree.Clone()
+	f.tree = f.treeHashAt(newPath)
 	lkr.MemIndexSwap(f, oldHash, true)
 }
 
@@ -294,6 +294,9 @@ func (fs *FS) repin(root string) error {
 	savedStorage += quotaUnpins
 	totalStorage -= quotaUnpins
 
+	metricRepinRuns.Inc()
+	metricRepinFreedBytes.Add(float64(savedStorage))
+
 	if savedStorage >= addedToStorage{
 		log.Infof("repin finished; freed %s, total storage is %s", humanize.Bytes(savedStorage-addedToStorage), humanize.Bytes(totalStorage))
 	} else {
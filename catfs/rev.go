@@ -20,8 +20,8 @@ var (
 // validateRev check is a rev spec looks like it's valid
 // from a syntactic point of view.
 //
-// A valid ref may contain only letters or numbers, but might end with an
-// arbitrary number of '^' at the end. Unicode is allowed.
+// A valid ref may contain only letters, numbers, '-' or '_' (for branch names),
+// but might end with an arbitrary number of '^' at the end. Unicode is allowed.
 // As special case it might also match indexCommitPattern.
 //
 // If any violation is dected, an error is returned.
@@ -40,6 +40,10 @@ func validateRev(rev string) error {
 		}
 
 		switch c {
+		case '-', '_':
+			if foundUp {
+				return fmt.Errorf("normal character after ^")
+			}
 		case '^':
 			foundUp = true
 		default:
@@ -74,6 +78,13 @@ func parseRev(lkr *c.Linker, rev string) (*n.Commit, error) {
 		// Either it was an hash and it is valid,
 		// Or it is a tag name like HEAD (or "head")
 		nd, err := lkr.ResolveRef(lowerRev)
+		if ie.IsErrNoSuchRef(err) {
+			// Last resort: it might be the name of a branch.
+			if cmt, branchErr := parseBranchRev(lkr, lowerRev); branchErr == nil {
+				return cmt, nil
+			}
+		}
+
 		if err != nil {
 			return nil, err
 		}
@@ -99,3 +110,24 @@ func parseRev(lkr *c.Linker, rev string) (*n.Commit, error) {
 
 	return cmt, nil
 }
+
+// parseBranchRev resolves `rev` as branch name, optionally followed by '^'.
+func parseBranchRev(lkr *c.Linker, rev string) (*n.Commit, error) {
+	pureRev := strings.TrimRight(rev, "^")
+	tip, err := lkr.BranchTip(pureRev)
+	if err != nil {
+		return nil, err
+	}
+
+	nd, err := lkr.ResolveRef(tip.TreeHash().B58String() + rev[len(pureRev):])
+	if err != nil {
+		return nil, err
+	}
+
+	cmt, ok := nd.(*n.Commit)
+	if !ok {
+		return nil, ie.ErrBadNode
+	}
+
+	return cmt, nil
+}
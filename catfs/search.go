@@ -0,0 +1,404 @@
+package catfs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	humanize "github.com/dustin/go-humanize"
+	c "github.com/sahib/brig/catfs/core"
+	"github.com/sahib/brig/catfs/db"
+	ie "github.com/sahib/brig/catfs/errors"
+	n "github.com/sahib/brig/catfs/nodes"
+	"github.com/sahib/brig/catfs/search"
+	h "github.com/sahib/brig/util/hashlib"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Only this many distinct words are indexed per file.
+	searchMaxWordsPerFile = 100000
+
+	// Only this many bytes are checked when guessing if a file is text.
+	searchSniffSize = 8000
+)
+
+// SearchOptions modify what Search() looks at.
+type SearchOptions struct {
+	// Rev is the revision to search in. If empty, the current state
+	// (including staged changes) is searched.
+	Rev string
+	// History makes Search() look at all commits reachable from Rev.
+	// Every version of a file is only reported once.
+	History bool
+}
+
+// SearchResult is a single node that matched a search.
+type SearchResult struct {
+	*StatInfo
+
+	// Commit is the hash of the commit the node was found in.
+	// It is nil if the current state was searched.
+	Commit h.Hash
+}
+
+// kvTextIndex implements search.TextIndex with the
+// full-text index stored in the metadata database.
+type kvTextIndex struct {
+	kv db.Database
+}
+
+func (idx kvTextIndex) Contains(term, contentHash string) bool {
+	_, err := idx.kv.Get("search", "terms", term, contentHash)
+	return err == nil
+}
+
+func (fs *FS) searchEntry(info *StatInfo) *search.Entry {
+	return &search.Entry{
+		Path:        info.Path,
+		Size:        info.Size,
+		ModTime:     info.ModTime,
+		User:        info.User,
+		IsDir:       info.IsDir,
+		IsPinned:    info.IsPinned,
+		IsExplicit:  info.IsExplicit,
+		ContentHash: info.ContentHash.B58String(),
+		IsCached: func() bool {
+			if info.IsDir {
+				return false
+			}
+
+			isCached, err := fs.bk.IsCached(info.BackendHash)
+			if err != nil {
+				log.Warningf("search: failed to check cache state of %s: %v", info.Path, err)
+				return false
+			}
+
+			return isCached
+		},
+	}
+}
+
+// searchTree collects all nodes below `rootNd` that match `query`.
+// `seen` is used to skip nodes that were already visited in other commits.
+func (fs *FS) searchTree(rootNd n.Node, query *search.Query, cmtHash h.Hash, seen map[string]bool, result []*SearchResult) ([]*SearchResult, error) {
+	idx := kvTextIndex{kv: fs.kv}
+	root := rootNd.Path()
+
+	err := n.Walk(fs.lkr, rootNd, false, func(child n.Node) error {
+		// Ghost nodes should not be visible to the outside.
+		if child.Type() == n.NodeTypeGhost {
+			return nil
+		}
+
+		// Directories with the same path and tree hash have the same
+		// children, so there is no need to look at them twice.
+		seenKey := child.Path() + "|" + child.TreeHash().B58String()
+		if seen[seenKey] {
+			return n.ErrSkipChild
+		}
+
+		seen[seenKey] = true
+
+		// The root itself should not be part of the results.
+		if child.Path() == root && child.Type() == n.NodeTypeDirectory {
+			return nil
+		}
+
+		info := fs.nodeToStat(child)
+		if !query.Match(fs.searchEntry(info), idx) {
+			return nil
+		}
+
+		result = append(result, &SearchResult{
+			StatInfo: info,
+			Commit:   cmtHash,
+		})
+
+		return nil
+	})
+
+	return result, err
+}
+
+// Search returns all nodes below `root` that match `query`.
+// See the search package for the syntax of the query.
+func (fs *FS) Search(root, query string, opts SearchOptions) ([]*SearchResult, error) {
+	parsedQuery, err := search.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.search(root, parsedQuery, opts)
+}
+
+// search is the implementation of Search. fs.mu needs to be locked.
+func (fs *FS) search(root string, parsedQuery *search.Query, opts SearchOptions) ([]*SearchResult, error) {
+	seen := make(map[string]bool)
+	result := []*SearchResult{}
+
+	if !opts.History {
+		rootNd, err := fs.lookupNodeAt(opts.Rev, root)
+		if err != nil {
+			return nil, err
+		}
+
+		if rootNd.Type() == n.NodeTypeGhost {
+			return nil, ie.NoSuchFile(root)
+		}
+
+		var cmtHash h.Hash
+		if opts.Rev != "" {
+			cmt, err := parseRev(fs.lkr, opts.Rev)
+			if err != nil {
+				return nil, err
+			}
+
+			cmtHash = cmt.TreeHash().Clone()
+		}
+
+		result, err = fs.searchTree(rootNd, parsedQuery, cmtHash, seen, result)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		rev := opts.Rev
+		if rev == "" {
+			rev = "curr"
+		}
+
+		headCmt, err := parseRev(fs.lkr, rev)
+		if err != nil {
+			return nil, err
+		}
+
+		err = c.Log(fs.lkr, headCmt, func(cmt *n.Commit) error {
+			rootNd, err := fs.lkr.LookupNodeAt(cmt, root)
+			if ie.IsNoSuchFileError(err) || rootNd == nil {
+				// Did not exist back then.
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if rootNd.Type() == n.NodeTypeGhost {
+				return nil
+			}
+
+			result, err = fs.searchTree(rootNd, parsedQuery, cmt.TreeHash().Clone(), seen, result)
+			return err
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		iDepth := result[i].Depth
+		jDepth := result[j].Depth
+
+		if iDepth == jDepth {
+			return result[i].Path < result[j].Path
+		}
+
+		return iDepth < jDepth
+	})
+
+	return result, nil
+}
+
+/////////////////////
+// FULL-TEXT INDEX //
+/////////////////////
+
+type searchIndexCandidate struct {
+	path        string
+	contentHash string
+	backendHash h.Hash
+	key         []byte
+	size        uint64
+}
+
+// searchIndexCandidates returns all files in the current state
+// whose content was not indexed yet. fs.mu needs to be locked.
+func (fs *FS) searchIndexCandidates(maxSize uint64) ([]searchIndexCandidate, error) {
+	rootNd, err := fs.lkr.LookupNode("/")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	candidates := []searchIndexCandidate{}
+	err = n.Walk(fs.lkr, rootNd, true, func(child n.Node) error {
+		file, ok := child.(*n.File)
+		if !ok || file.Size() > maxSize {
+			return nil
+		}
+
+		contentHash := file.ContentHash().B58String()
+		if seen[contentHash] {
+			return nil
+		}
+
+		seen[contentHash] = true
+		if _, err := fs.kv.Get("search", "docs", contentHash); err == nil {
+			// Already indexed.
+			return nil
+		}
+
+		// Do not make the backend fetch anything just for the index.
+		isCached, err := fs.bk.IsCached(file.BackendHash())
+		if err != nil || !isCached {
+			return nil
+		}
+
+		candidates = append(candidates, searchIndexCandidate{
+			path:        file.Path(),
+			contentHash: contentHash,
+			backendHash: file.BackendHash().Clone(),
+			key:         append([]byte{}, file.Key()...),
+			size:        file.Size(),
+		})
+
+		return nil
+	})
+
+	return candidates, err
+}
+
+// searchIndexWords returns the words of the candidate's content.
+// If the content is not text, nil is returned.
+// NOTE: This method can be called without locking fs.mu!
+func (fs *FS) searchIndexWords(cand searchIndexCandidate) ([]string, error) {
+	stream, err := fs.catHash(cand.backendHash, cand.key, cand.size)
+	if err != nil {
+		return nil, err
+	}
+
+	defer stream.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(stream, int64(cand.size)))
+	if err != nil {
+		return nil, err
+	}
+
+	header := data
+	if len(header) > searchSniffSize {
+		header = header[:searchSniffSize]
+	}
+
+	if !search.IsText(header) {
+		return nil, nil
+	}
+
+	return search.TokenizeReader(bytes.NewReader(data), searchMaxWordsPerFile)
+}
+
+// UpdateSearchIndex adds the content of all text files in the current state
+// to the full-text index, if it is enabled. Only content that was not
+// indexed yet and that is available locally is read. It returns the
+// number of newly indexed files. Once the filesystem is closed, it does
+// nothing.
+func (fs *FS) UpdateSearchIndex() (int, error) {
+	if !fs.cfg.Bool("search.fulltext.enabled") {
+		return 0, nil
+	}
+
+	maxSize, err := humanize.ParseBytes(fs.cfg.String("search.fulltext.max_size"))
+	if err != nil {
+		return 0, err
+	}
+
+	fs.mu.Lock()
+	if fs.isClosed {
+		fs.mu.Unlock()
+		return 0, nil
+	}
+
+	candidates, err := fs.searchIndexCandidates(maxSize)
+	fs.mu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	// Reading the content might take a while, so do it without the lock.
+	docs := make(map[string][]string)
+	for _, cand := range candidates {
+		words, err := fs.searchIndexWords(cand)
+		if err != nil {
+			log.Warningf("search: failed to index %s: %v", cand.path, err)
+			continue
+		}
+
+		docs[cand.contentHash] = words
+	}
+
+	if len(docs) == 0 {
+		return 0, nil
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// The filesystem might have been closed while we read the content.
+	if fs.isClosed {
+		return 0, nil
+	}
+
+	batch := fs.kv.Batch()
+	for contentHash, words := range docs {
+		for _, word := range words {
+			batch.Put([]byte{1}, "search", "terms", word, contentHash)
+		}
+
+		// Also remember binary files, so they are not read again:
+		batch.Put([]byte(strconv.Itoa(len(words))), "search", "docs", contentHash)
+	}
+
+	if err := batch.Flush(); err != nil {
+		return 0, err
+	}
+
+	return len(docs), nil
+}
+
+// scheduleSearchIndexUpdate makes the index loop update the full-text
+// index soon. It does not block if an update is already scheduled.
+func (fs *FS) scheduleSearchIndexUpdate() {
+	if fs.readOnly {
+		return
+	}
+
+	select {
+	case fs.searchIndexControl <- true:
+	default:
+	}
+}
+
+func (fs *FS) searchIndexLoop() {
+	for update := range fs.searchIndexControl {
+		if !update {
+			log.Debugf("quitting the search index loop")
+			return
+		}
+
+		count, err := fs.UpdateSearchIndex()
+		if err != nil {
+			log.Warningf("failed to update the search index: %v", err)
+			continue
+		}
+
+		if count > 0 {
+			log.Debugf("added %d files to the search index", count)
+		}
+	}
+}
@@ -0,0 +1,434 @@
+// Package search implements the query language used to find files.
+//
+// A query consists of terms separated by whitespace. A file matches if it
+// matches all terms. A term can be negated by prefixing it with »-«.
+// Values with spaces can be put in double quotes. Supported terms are:
+//
+//	word            Path contains »word« (or the name matches it, if it is a glob).
+//	name:GLOB       Base name matches GLOB (e.g. »name:*.jpg«).
+//	path:GLOB       Full path matches GLOB or starts with it.
+//	size:RANGE      Size is in RANGE (e.g. »size:>10M«, »size:1K..2M«).
+//	mtime:RANGE     Modification time is in RANGE (e.g. »mtime:>7d«,
+//	                »mtime:2020-01-01..2020-02-01«, »mtime:2020-05-03«).
+//	user:NAME       Last modified by NAME.
+//	mime:GLOB       Mime type (guessed from the extension) matches GLOB.
+//	is:STATE        STATE is one of pinned, explicit, cached, dir or file.
+//	text:WORD       The content contains WORD (needs the full-text index).
+//
+// Values of name, path, user and mime may be a comma separated list of
+// alternatives. All matches are case insensitive.
+package search
+
+import (
+	"fmt"
+	"math"
+	"mime"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/dustin/go-humanize"
+	"github.com/sahib/brig/catfs/mio/compress"
+)
+
+// Entry is what a query is matched against.
+type Entry struct {
+	Path        string
+	Size        uint64
+	ModTime     time.Time
+	User        string
+	IsDir       bool
+	IsPinned    bool
+	IsExplicit  bool
+	ContentHash string
+
+	// IsCached is only called if the query needs it, since it might be
+	// expensive to find out.
+	IsCached func() bool
+}
+
+// TextIndex tells if the content with `contentHash` contains `term`.
+type TextIndex interface {
+	Contains(term, contentHash string) bool
+}
+
+type matcher func(e *Entry, idx TextIndex) bool
+
+type term struct {
+	negate bool
+	match  matcher
+}
+
+// Query is a parsed search query.
+type Query struct {
+	terms     []term
+	textTerms []string
+}
+
+// MimeType guesses the mime type of the file at `path` by its extension.
+func MimeType(path string) string {
+	ext := strings.ToLower(pathExt(path))
+	if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+		// Strip parameters like »; charset=utf-8«:
+		if idx := strings.IndexByte(mimeType, ';'); idx >= 0 {
+			mimeType = mimeType[:idx]
+		}
+
+		return strings.TrimSpace(mimeType)
+	}
+
+	if compress.TextFileExtensions[ext] {
+		return "text/plain"
+	}
+
+	return "application/octet-stream"
+}
+
+func pathExt(p string) string {
+	return path.Ext(path.Base(p))
+}
+
+// splitQuery splits `query` at whitespace, but keeps quoted parts together.
+func splitQuery(query string) ([]string, error) {
+	parts := []string{}
+	curr := &strings.Builder{}
+	inQuotes, hasPart := false, false
+
+	for _, c := range query {
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+			hasPart = true
+		case unicode.IsSpace(c) && !inQuotes:
+			if hasPart {
+				parts = append(parts, curr.String())
+				curr.Reset()
+				hasPart = false
+			}
+		default:
+			curr.WriteRune(c)
+			hasPart = true
+		}
+	}
+
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated quote in query")
+	}
+
+	if hasPart {
+		parts = append(parts, curr.String())
+	}
+
+	return parts, nil
+}
+
+// Parse parses `query` as described in the package docs.
+// An empty query matches everything.
+func Parse(query string) (*Query, error) {
+	parts, err := splitQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Query{}
+	for _, part := range parts {
+		t := term{}
+		if strings.HasPrefix(part, "-") && len(part) > 1 {
+			t.negate = true
+			part = part[1:]
+		}
+
+		key, value := "", part
+		if idx := strings.IndexByte(part, ':'); idx > 0 {
+			key, value = strings.ToLower(part[:idx]), part[idx+1:]
+		}
+
+		if value == "" {
+			return nil, fmt.Errorf("empty value in »%s«", part)
+		}
+
+		match, err := q.parseTerm(key, value)
+		if err != nil {
+			return nil, err
+		}
+
+		t.match = match
+		q.terms = append(q.terms, t)
+	}
+
+	return q, nil
+}
+
+func alternatives(value string) []string {
+	alts := []string{}
+	for _, alt := range strings.Split(strings.ToLower(value), ",") {
+		if alt != "" {
+			alts = append(alts, alt)
+		}
+	}
+
+	return alts
+}
+
+func anyMatches(alts []string, fn func(alt string) bool) bool {
+	for _, alt := range alts {
+		if fn(alt) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func globMatch(pattern, s string) bool {
+	matched, err := path.Match(pattern, s)
+	return err == nil && matched
+}
+
+func isGlob(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+func (q *Query) parseTerm(key, value string) (matcher, error) {
+	switch key {
+	case "":
+		value = strings.ToLower(value)
+		if isGlob(value) {
+			return func(e *Entry, idx TextIndex) bool {
+				return globMatch(value, strings.ToLower(path.Base(e.Path)))
+			}, nil
+		}
+
+		return func(e *Entry, idx TextIndex) bool {
+			return strings.Contains(strings.ToLower(e.Path), value)
+		}, nil
+	case "name":
+		alts := alternatives(value)
+		for _, alt := range alts {
+			if _, err := path.Match(alt, ""); err != nil {
+				return nil, fmt.Errorf("bad pattern »%s«: %v", alt, err)
+			}
+		}
+
+		return func(e *Entry, idx TextIndex) bool {
+			name := strings.ToLower(path.Base(e.Path))
+			return anyMatches(alts, func(alt string) bool {
+				return globMatch(alt, name)
+			})
+		}, nil
+	case "path":
+		alts := alternatives(value)
+		return func(e *Entry, idx TextIndex) bool {
+			lowerPath := strings.ToLower(e.Path)
+			return anyMatches(alts, func(alt string) bool {
+				if isGlob(alt) {
+					return globMatch(alt, lowerPath)
+				}
+
+				prefix := strings.TrimRight("/"+strings.TrimLeft(alt, "/"), "/")
+				return prefix == "" || lowerPath == prefix || strings.HasPrefix(lowerPath, prefix+"/")
+			})
+		}, nil
+	case "size":
+		min, max, err := parseRange(value, parseSize)
+		if err != nil {
+			return nil, fmt.Errorf("bad size range »%s«: %v", value, err)
+		}
+
+		return func(e *Entry, idx TextIndex) bool {
+			return int64(e.Size) >= min && int64(e.Size) <= max
+		}, nil
+	case "mtime":
+		min, max, err := parseRange(value, parseTime)
+		if err != nil {
+			return nil, fmt.Errorf("bad time range »%s«: %v", value, err)
+		}
+
+		return func(e *Entry, idx TextIndex) bool {
+			mtime := e.ModTime.UnixNano()
+			return mtime >= min && mtime <= max
+		}, nil
+	case "user":
+		alts := alternatives(value)
+		return func(e *Entry, idx TextIndex) bool {
+			user := strings.ToLower(e.User)
+			return anyMatches(alts, func(alt string) bool {
+				return globMatch(alt, user)
+			})
+		}, nil
+	case "mime":
+		alts := alternatives(value)
+		return func(e *Entry, idx TextIndex) bool {
+			if e.IsDir {
+				return false
+			}
+
+			mimeType := MimeType(e.Path)
+			return anyMatches(alts, func(alt string) bool {
+				return globMatch(alt, mimeType)
+			})
+		}, nil
+	case "is", "type":
+		return parseState(strings.ToLower(value))
+	case "text":
+		words := Tokenize(value)
+		if len(words) == 0 {
+			return nil, fmt.Errorf("»%s« contains no searchable words", value)
+		}
+
+		q.textTerms = append(q.textTerms, words...)
+		return func(e *Entry, idx TextIndex) bool {
+			if e.IsDir || idx == nil {
+				return false
+			}
+
+			for _, word := range words {
+				if !idx.Contains(word, e.ContentHash) {
+					return false
+				}
+			}
+
+			return true
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown search key »%s«", key)
+	}
+}
+
+func parseState(state string) (matcher, error) {
+	switch state {
+	case "pinned":
+		return func(e *Entry, idx TextIndex) bool { return e.IsPinned }, nil
+	case "explicit":
+		return func(e *Entry, idx TextIndex) bool { return e.IsExplicit }, nil
+	case "cached":
+		return func(e *Entry, idx TextIndex) bool {
+			return e.IsCached != nil && e.IsCached()
+		}, nil
+	case "dir", "directory":
+		return func(e *Entry, idx TextIndex) bool { return e.IsDir }, nil
+	case "file":
+		return func(e *Entry, idx TextIndex) bool { return !e.IsDir }, nil
+	default:
+		return nil, fmt.Errorf("unknown state »%s«", state)
+	}
+}
+
+// parseRange parses ranges like »>X«, »<=X«, »X..Y« or just »X«.
+// `parse` returns the lower and upper bound of a single value.
+func parseRange(value string, parse func(s string) (int64, int64, error)) (int64, int64, error) {
+	min, max := int64(math.MinInt64), int64(math.MaxInt64)
+
+	if idx := strings.Index(value, ".."); idx >= 0 {
+		if lower := value[:idx]; lower != "" {
+			lo, _, err := parse(lower)
+			if err != nil {
+				return 0, 0, err
+			}
+
+			min = lo
+		}
+
+		if upper := value[idx+2:]; upper != "" {
+			_, hi, err := parse(upper)
+			if err != nil {
+				return 0, 0, err
+			}
+
+			max = hi
+		}
+
+		return min, max, nil
+	}
+
+	ops := []string{">=", "<=", ">", "<", "="}
+	op := ""
+	for _, candidate := range ops {
+		if strings.HasPrefix(value, candidate) {
+			op = candidate
+			value = value[len(candidate):]
+			break
+		}
+	}
+
+	lo, hi, err := parse(value)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	switch op {
+	case ">":
+		return hi + 1, max, nil
+	case ">=":
+		return lo, max, nil
+	case "<":
+		return min, lo - 1, nil
+	case "<=":
+		return min, hi, nil
+	default:
+		return lo, hi, nil
+	}
+}
+
+func parseSize(s string) (int64, int64, error) {
+	size, err := humanize.ParseBytes(s)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int64(size), int64(size), nil
+}
+
+// now is a variable so tests can fake the current time.
+var now = time.Now
+
+func parseTime(s string) (int64, int64, error) {
+	// Relative to now, like »7d« or »3h«:
+	if len(s) > 1 {
+		units := map[byte]time.Duration{
+			'm': time.Minute,
+			'h': time.Hour,
+			'd': 24 * time.Hour,
+			'w': 7 * 24 * time.Hour,
+		}
+
+		if unit, ok := units[s[len(s)-1]]; ok {
+			var count float64
+			if _, err := fmt.Sscanf(s[:len(s)-1], "%g", &count); err == nil {
+				t := now().Add(-time.Duration(count * float64(unit))).UnixNano()
+				return t, t, nil
+			}
+		}
+	}
+
+	// A single day covers the whole day:
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		end := t.AddDate(0, 0, 1)
+		return t.UnixNano(), end.UnixNano() - 1, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, 0, fmt.Errorf("expected a date like 2006-01-02, a timestamp or a duration like 7d")
+	}
+
+	return t.UnixNano(), t.UnixNano(), nil
+}
+
+// TextTerms returns all words that were searched for with »text:«.
+func (q *Query) TextTerms() []string {
+	return q.textTerms
+}
+
+// Match returns true if `e` matches all terms of the query.
+// `idx` is only used for »text:« terms and may be nil otherwise.
+func (q *Query) Match(e *Entry, idx TextIndex) bool {
+	for _, t := range q.terms {
+		if t.match(e, idx) == t.negate {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,122 @@
+package search
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type dummyIndex map[string][]string
+
+func (idx dummyIndex) Contains(term, contentHash string) bool {
+	for _, word := range idx[contentHash] {
+		if word == term {
+			return true
+		}
+	}
+
+	return false
+}
+
+func withFakeNow(t *testing.T, fakeNow time.Time, fn func()) {
+	oldNow := now
+	now = func() time.Time { return fakeNow }
+	defer func() { now = oldNow }()
+
+	fn()
+}
+
+func mustMatch(t *testing.T, query string, e *Entry, idx TextIndex) bool {
+	q, err := Parse(query)
+	require.Nil(t, err, query)
+	return q.Match(e, idx)
+}
+
+func TestQueryMatch(t *testing.T) {
+	fakeNow := time.Date(2020, 5, 3, 12, 0, 0, 0, time.Local)
+	entry := &Entry{
+		Path:        "/photos/Holiday Cat.JPG",
+		Size:        2 * 1024 * 1024,
+		ModTime:     fakeNow.Add(-2 * 24 * time.Hour),
+		User:        "alice",
+		IsPinned:    true,
+		ContentHash: "QmCat",
+		IsCached:    func() bool { return true },
+	}
+
+	idx := dummyIndex{"QmCat": {"meow", "purr"}}
+
+	withFakeNow(t, fakeNow, func() {
+		tcs := []struct {
+			query string
+			match bool
+		}{
+			{"", true},
+			{"cat", true},
+			{"dog", false},
+			{"-dog", true},
+			{"*.jpg", true},
+			{"name:*.png,*.jpg", true},
+			{"name:cat", false},
+			{`name:"holiday cat.jpg"`, true},
+			{"path:/photos", true},
+			{"path:/photo", false},
+			{"path:/photos/*", true},
+			{"size:>1M", true},
+			{"size:<1M", false},
+			{"size:1M..3M", true},
+			{"size:2MiB", true},
+			{"mtime:>7d", true},
+			{"mtime:>1d", false},
+			{"mtime:2020-05-01", true},
+			{"mtime:2020-05-02..", false},
+			{"mtime:..2020-05-01", true},
+			{"user:bob,alice", true},
+			{"user:b*", false},
+			{"mime:image/*", true},
+			{"mime:text/*", false},
+			{"is:pinned", true},
+			{"is:explicit", false},
+			{"is:cached", true},
+			{"is:file -is:dir", true},
+			{"text:meow", true},
+			{"text:meow,purr", true},
+			{"text:bark", false},
+			{"cat text:meow -user:bob", true},
+		}
+
+		for _, tc := range tcs {
+			require.Equal(t, tc.match, mustMatch(t, tc.query, entry, idx), tc.query)
+		}
+	})
+}
+
+func TestQueryParseErrors(t *testing.T) {
+	for _, query := range []string{
+		`"unterminated`,
+		"size:big",
+		"mtime:yesterday",
+		"is:happy",
+		"color:red",
+		"name:[",
+		"text:!!",
+		"user:",
+	} {
+		_, err := Parse(query)
+		require.NotNil(t, err, query)
+	}
+}
+
+func TestQueryTextTerms(t *testing.T) {
+	q, err := Parse(`text:"Hello World" name:x`)
+	require.Nil(t, err)
+	require.Equal(t, []string{"hello", "world"}, q.TextTerms())
+}
+
+func TestMimeType(t *testing.T) {
+	require.Equal(t, "image/jpeg", MimeType("/a/b.JPG"))
+	require.True(t, strings.HasPrefix(MimeType("/a/b.go"), "text/"))
+	require.Equal(t, "application/octet-stream", MimeType("/a/b"))
+}
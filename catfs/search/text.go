@@ -0,0 +1,122 @@
+package search
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MinWordLength is the minimum length of an indexed word.
+	MinWordLength = 2
+
+	// MaxWordLength is the maximum length of an indexed word.
+	// Longer words are most likely not words at all.
+	MaxWordLength = 64
+)
+
+func isWordRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+func addWord(words map[string]bool, word string) {
+	n := utf8.RuneCountInString(word)
+	if n < MinWordLength || n > MaxWordLength {
+		return
+	}
+
+	words[strings.ToLower(word)] = true
+}
+
+func sortedWords(words map[string]bool) []string {
+	result := make([]string, 0, len(words))
+	for word := range words {
+		result = append(result, word)
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// Tokenize splits `text` into lower case words, like they are stored in
+// the full-text index. Every word is only returned once.
+func Tokenize(text string) []string {
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(text, func(c rune) bool {
+		return !isWordRune(c)
+	}) {
+		addWord(words, word)
+	}
+
+	return sortedWords(words)
+}
+
+// TokenizeReader is like Tokenize, but reads the text from `r`.
+// It stops after `maxWords` distinct words.
+func TokenizeReader(r io.Reader, maxWords int) ([]string, error) {
+	words := make(map[string]bool)
+	curr := &strings.Builder{}
+	br := bufio.NewReader(r)
+
+	for len(words) < maxWords {
+		c, _, err := br.ReadRune()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		if err == nil && isWordRune(c) {
+			curr.WriteRune(c)
+			continue
+		}
+
+		addWord(words, curr.String())
+		curr.Reset()
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	return sortedWords(words), nil
+}
+
+// IsText returns true if `header` (the start of a file)
+// looks like it belongs to a text file.
+func IsText(header []byte) bool {
+	if strings.HasPrefix(http.DetectContentType(header), "text/") {
+		return true
+	}
+
+	// DetectContentType does not know about many text formats,
+	// so also accept everything that looks like UTF-8 without control chars.
+	if !utf8.Valid(trimIncompleteRune(header)) {
+		return false
+	}
+
+	for _, c := range header {
+		if c < 0x20 && c != '\n' && c != '\r' && c != '\t' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// trimIncompleteRune cuts off a rune that was split at the end of `buf`.
+func trimIncompleteRune(buf []byte) []byte {
+	for idx := len(buf) - 1; idx >= 0 && idx >= len(buf)-utf8.UTFMax; idx-- {
+		if utf8.RuneStart(buf[idx]) {
+			if !utf8.FullRune(buf[idx:]) {
+				return buf[:idx]
+			}
+
+			break
+		}
+	}
+
+	return buf
+}
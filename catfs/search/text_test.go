@@ -0,0 +1,29 @@
+package search
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	require.Equal(t, []string{"brown", "fox", "the", "über"}, Tokenize("The brown fox, the Über-fox! a"))
+	require.Equal(t, []string{}, Tokenize("a b c"))
+
+	words, err := TokenizeReader(strings.NewReader("The brown fox, the Über-fox! a"), 100)
+	require.Nil(t, err)
+	require.Equal(t, []string{"brown", "fox", "the", "über"}, words)
+
+	words, err = TokenizeReader(strings.NewReader("one two three four"), 2)
+	require.Nil(t, err)
+	require.Equal(t, []string{"one", "two"}, words)
+}
+
+func TestIsText(t *testing.T) {
+	require.True(t, IsText([]byte("hello world\n")))
+	require.True(t, IsText([]byte("package main\n\nfunc main() {}\n")))
+	require.True(t, IsText([]byte("Über")[:2]))
+	require.False(t, IsText([]byte{0, 1, 2, 3}))
+	require.False(t, IsText([]byte{0xff, 0x00, 0x41, 0xfa}))
+}
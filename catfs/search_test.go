@@ -0,0 +1,121 @@
+package catfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/sahib/brig/defaults"
+	"github.com/sahib/config"
+	"github.com/stretchr/testify/require"
+)
+
+func searchPaths(t *testing.T, fs *FS, root, query string, opts SearchOptions) []string {
+	results, err := fs.Search(root, query, opts)
+	require.Nil(t, err)
+
+	paths := []string{}
+	for _, result := range results {
+		paths = append(paths, result.Path)
+	}
+
+	return paths
+}
+
+func TestSearch(t *testing.T) {
+	withDummyFS(t, func(fs *FS) {
+		require.Nil(t, fs.Mkdir("/photos", true))
+		require.Nil(t, fs.Stage("/photos/cat.jpg", bytes.NewReader(make([]byte, 2048))))
+		require.Nil(t, fs.Stage("/photos/dog.png", bytes.NewReader(make([]byte, 10))))
+		require.Nil(t, fs.Stage("/notes.txt", bytes.NewReader([]byte("hello world"))))
+		require.Nil(t, fs.Pin("/notes.txt", "", true))
+
+		require.Equal(t, []string{"/photos", "/photos/cat.jpg", "/photos/dog.png"}, searchPaths(t, fs, "/", "PHOTOS", SearchOptions{}))
+		require.Equal(t, []string{"/photos/cat.jpg"}, searchPaths(t, fs, "/", "*.jpg", SearchOptions{}))
+		require.Equal(t, []string{"/photos/cat.jpg", "/photos/dog.png"}, searchPaths(t, fs, "/", "mime:image/*", SearchOptions{}))
+		require.Equal(t, []string{"/photos/cat.jpg"}, searchPaths(t, fs, "/", "size:>1K is:file", SearchOptions{}))
+		require.Equal(t, []string{"/notes.txt"}, searchPaths(t, fs, "/", "is:explicit", SearchOptions{}))
+		require.Equal(t, []string{"/photos"}, searchPaths(t, fs, "/", "is:dir", SearchOptions{}))
+		require.Equal(t, []string{"/notes.txt"}, searchPaths(t, fs, "/", "user:alice -path:/photos", SearchOptions{}))
+		require.Equal(t, []string{"/photos/dog.png"}, searchPaths(t, fs, "/photos", "-name:cat.*", SearchOptions{}))
+
+		_, err := fs.Search("/", "size:huge", SearchOptions{})
+		require.NotNil(t, err)
+	})
+}
+
+func TestSearchHistory(t *testing.T) {
+	withDummyFS(t, func(fs *FS) {
+		require.Nil(t, fs.Stage("/x.txt", bytes.NewReader([]byte("1"))))
+		require.Nil(t, fs.MakeCommit("added x"))
+		require.Nil(t, fs.Move("/x.txt", "/y.txt"))
+		require.Nil(t, fs.MakeCommit("moved x"))
+
+		require.Equal(t, []string{}, searchPaths(t, fs, "/", "x.txt", SearchOptions{}))
+		require.Equal(t, []string{"/x.txt"}, searchPaths(t, fs, "/", "x.txt", SearchOptions{Rev: "head^"}))
+
+		results, err := fs.Search("/", "name:*.txt", SearchOptions{History: true})
+		require.Nil(t, err)
+		require.Len(t, results, 2)
+		require.Equal(t, "/x.txt", results[0].Path)
+		require.Equal(t, "/y.txt", results[1].Path)
+
+		// The newest commit is the one with the staged changes:
+		curr, err := fs.Curr()
+		require.Nil(t, err)
+		require.Equal(t, curr, results[1].Commit.B58String())
+	})
+}
+
+func TestSearchFullText(t *testing.T) {
+	withDummyFS(t, func(fs *FS) {
+		require.Nil(t, fs.Stage("/a.txt", bytes.NewReader([]byte("The quick brown fox"))))
+		require.Nil(t, fs.Stage("/b.md", bytes.NewReader([]byte("The lazy dog"))))
+		require.Nil(t, fs.Stage("/c.bin", bytes.NewReader([]byte{0, 1, 2, 'f', 'o', 'x'})))
+
+		// Disabled by default:
+		count, err := fs.UpdateSearchIndex()
+		require.Nil(t, err)
+		require.Equal(t, 0, count)
+		require.Equal(t, []string{}, searchPaths(t, fs, "/", "text:fox", SearchOptions{}))
+
+		require.Nil(t, fs.cfg.SetBool("search.fulltext.enabled", true))
+		count, err = fs.UpdateSearchIndex()
+		require.Nil(t, err)
+		require.Equal(t, 3, count)
+
+		require.Equal(t, []string{"/a.txt"}, searchPaths(t, fs, "/", "text:fox", SearchOptions{}))
+		require.Equal(t, []string{"/a.txt", "/b.md"}, searchPaths(t, fs, "/", "text:the", SearchOptions{}))
+		require.Equal(t, []string{"/b.md"}, searchPaths(t, fs, "/", `text:"lazy DOG"`, SearchOptions{}))
+		require.Equal(t, []string{"/b.md"}, searchPaths(t, fs, "/", "text:the -text:quick", SearchOptions{}))
+
+		// Nothing new to index:
+		count, err = fs.UpdateSearchIndex()
+		require.Nil(t, err)
+		require.Equal(t, 0, count)
+	})
+}
+
+func TestSearchIndexAfterClose(t *testing.T) {
+	backend := NewMemFsBackend()
+	dbPath, err := ioutil.TempDir("", "brig-fs-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dbPath)
+
+	cfg, err := config.Open(nil, defaults.Defaults, config.StrictnessPanic)
+	require.Nil(t, err)
+
+	fsCfg := cfg.Section("fs")
+	require.Nil(t, fsCfg.SetBool("search.fulltext.enabled", true))
+
+	fs, err := NewFilesystem(backend, dbPath, "alice", false, fsCfg)
+	require.Nil(t, err)
+	require.Nil(t, fs.Stage("/a.txt", bytes.NewReader([]byte("The quick brown fox"))))
+	require.Nil(t, fs.Close())
+
+	// A late update from the index loop must not touch the closed database:
+	count, err := fs.UpdateSearchIndex()
+	require.Nil(t, err)
+	require.Equal(t, 0, count)
+}
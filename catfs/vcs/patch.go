@@ -320,6 +320,138 @@ func MakePatchFromTo(lkr *c.Linker, from, to *n.Commit, prefixes []string) (*Pat
 	return patch, nil
 }
 
+// CommitChanges returns the changes that are part of `cmt` itself,
+// i.e. the changes between the parent of `cmt` and `cmt`. Unlike the changes
+// in a patch they are not combined with any earlier commit. Directories are
+// only reported when they were added, moved or removed themselves.
+//
+// The changes are found by mapping `cmt` against its parent (like a diff
+// does), so only the parts of the tree that differ are looked at.
+func CommitChanges(lkr *c.Linker, cmt *n.Commit) ([]*Change, error) {
+	root, err := lkr.DirectoryByHash(cmt.Root())
+	if err != nil {
+		return nil, err
+	}
+
+	parentNd, err := cmt.Parent(lkr)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []*Change{}
+	if parentNd == nil {
+		// The initial commit added everything it contains.
+		return changes, commitAddChanges(lkr, cmt, nil, root, &changes)
+	}
+
+	parent, ok := parentNd.(*n.Commit)
+	if !ok {
+		return nil, ie.ErrBadNode
+	}
+
+	mapper, err := NewMapper(lkr, lkr, cmt, parent, root)
+	if err != nil {
+		return nil, err
+	}
+
+	err = mapper.Map(func(pair MapPair) error {
+		switch {
+		case pair.Src == nil:
+			// Gone in `cmt`; prefer the ghost it left behind.
+			curr, err := commitRemovedNode(lkr, cmt, pair.Dst)
+			if err != nil {
+				return err
+			}
+
+			changes = append(changes, &Change{
+				Mask: ChangeTypeRemove,
+				Head: cmt,
+				Next: parent,
+				Curr: curr,
+			})
+		case pair.Dst == nil || pair.TypeMismatch:
+			return commitAddChanges(lkr, cmt, parent, pair.Src, &changes)
+		default:
+			change := &Change{
+				Head: cmt,
+				Next: parent,
+				Curr: pair.Src,
+			}
+
+			if !pair.SrcWasMoved {
+				change.Mask |= ChangeTypeModify
+			}
+
+			if pair.Src.Path() != pair.Dst.Path() {
+				change.Mask |= ChangeTypeMove
+				change.WasPreviouslyAt = pair.Dst.Path()
+			}
+
+			if change.Mask == ChangeTypeNone {
+				return nil
+			}
+
+			if pair.Src.Type() == n.NodeTypeDirectory && change.Mask == ChangeTypeModify {
+				// The directory only changed because one of its children did.
+				return nil
+			}
+
+			changes = append(changes, change)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// commitAddChanges adds a change for `nd` and everything below it,
+// since all of it was added by `cmt`.
+func commitAddChanges(lkr *c.Linker, cmt, parent *n.Commit, nd n.Node, changes *[]*Change) error {
+	return n.Walk(lkr, nd, false, func(child n.Node) error {
+		if child.Type() == n.NodeTypeGhost {
+			return nil
+		}
+
+		if child.Type() == n.NodeTypeDirectory && child.Path() == "/" {
+			return nil
+		}
+
+		childModNode, ok := child.(n.ModNode)
+		if !ok {
+			return e.Wrapf(ie.ErrBadNode, "commit-changes: walk")
+		}
+
+		*changes = append(*changes, &Change{
+			Mask: ChangeTypeAdd,
+			Head: cmt,
+			Next: parent,
+			Curr: childModNode,
+		})
+
+		return nil
+	})
+}
+
+// commitRemovedNode returns the ghost of `old` in `cmt`.
+// If there is none, a ghost is made up from `old`.
+func commitRemovedNode(lkr *c.Linker, cmt *n.Commit, old n.ModNode) (n.ModNode, error) {
+	nd, err := lkr.LookupModNodeAt(cmt, old.Path())
+	if err != nil && !ie.IsNoSuchFileError(err) {
+		return nil, err
+	}
+
+	if nd != nil && nd.Type() == n.NodeTypeGhost {
+		return nd, nil
+	}
+
+	return n.MakeGhost(old, old.Inode())
+}
+
 // ApplyPatch applies the patch `p` to the linker `lkr`.
 func ApplyPatch(lkr *c.Linker, p *Patch) error {
 	sort.Sort(p)
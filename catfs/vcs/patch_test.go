@@ -302,3 +302,38 @@ func TestSyncPartialTwiceWithMovedFile(t *testing.T) {
 		require.Len(t, diff.Ignored, 0)
 	})
 }
+
+func TestCommitChanges(t *testing.T) {
+	c.WithDummyLinker(t, func(lkr *c.Linker) {
+		c.MustTouchAndCommit(t, lkr, "/keep.png", 1)
+		modify, _ := c.MustTouchAndCommit(t, lkr, "/modify.png", 2)
+		remove, _ := c.MustTouchAndCommit(t, lkr, "/remove.png", 3)
+		move, _ := c.MustTouchAndCommit(t, lkr, "/move.png", 4)
+
+		c.MustModify(t, lkr, modify, 5)
+		c.MustRemove(t, lkr, remove)
+		c.MustMove(t, lkr, move, "/moved.png")
+		c.MustMkdir(t, lkr, "/sub")
+		c.MustTouch(t, lkr, "/sub/add.png", 6)
+		cmt := c.MustCommit(t, lkr, "everything")
+
+		changes, err := CommitChanges(lkr, cmt)
+		require.Nil(t, err)
+
+		masks := make(map[string]ChangeType)
+		for _, change := range changes {
+			masks[change.Curr.Path()] = change.Mask
+			if change.WasPreviouslyAt != "" {
+				require.Equal(t, "/move.png", change.WasPreviouslyAt)
+			}
+		}
+
+		require.Equal(t, map[string]ChangeType{
+			"/modify.png":  ChangeTypeModify,
+			"/remove.png":  ChangeTypeRemove,
+			"/moved.png":   ChangeTypeMove,
+			"/sub":         ChangeTypeAdd,
+			"/sub/add.png": ChangeTypeAdd,
+		}, masks)
+	})
+}
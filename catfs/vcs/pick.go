@@ -0,0 +1,376 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	e "github.com/pkg/errors"
+	c "github.com/sahib/brig/catfs/core"
+	ie "github.com/sahib/brig/catfs/errors"
+	n "github.com/sahib/brig/catfs/nodes"
+	log "github.com/sirupsen/logrus"
+)
+
+// transition describes how a single node changed from one state to another.
+// `from` or `to` may be nil if the node did not exist in that state.
+type transition struct {
+	from, to         n.ModNode
+	fromPath, toPath string
+}
+
+func (t transition) String() string {
+	return fmt.Sprintf("%s (%v) -> %s (%v)", t.fromPath, t.from != nil, t.toPath, t.to != nil)
+}
+
+// lookupAlive returns the node at `repoPath` in `cmt` (or the staging area
+// if `cmt` is nil). Ghosts and non-existing nodes are returned as nil.
+func lookupAlive(lkr *c.Linker, cmt *n.Commit, repoPath string) (n.ModNode, error) {
+	var nd n.ModNode
+	var err error
+
+	if cmt == nil {
+		nd, err = lkr.LookupModNode(repoPath)
+	} else {
+		nd, err = lkr.LookupModNodeAt(cmt, repoPath)
+	}
+
+	if ie.IsNoSuchFileError(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if nd == nil || nd.Type() == n.NodeTypeGhost {
+		return nil, nil
+	}
+
+	return nd, nil
+}
+
+// commitTransitions returns the transitions that lead from the parent of `cmt` to `cmt`.
+func commitTransitions(lkr *c.Linker, cmt *n.Commit) ([]transition, error) {
+	parentNd, err := cmt.Parent(lkr)
+	if err != nil {
+		return nil, err
+	}
+
+	if parentNd == nil {
+		return nil, errors.New("the initial commit has no parent to compare with")
+	}
+
+	parent, ok := parentNd.(*n.Commit)
+	if !ok {
+		return nil, ie.ErrBadNode
+	}
+
+	// Note: MakePatchFromTo() would include the changes of `parent` too.
+	changes, err := CommitChanges(lkr, cmt)
+	if err != nil {
+		return nil, err
+	}
+
+	transitions := []transition{}
+	for _, ch := range changes {
+		// The source of a move is handled by the change of the destination:
+		if ch.Curr.Type() == n.NodeTypeGhost && ch.MovedTo != "" {
+			continue
+		}
+
+		t := transition{
+			to:       ch.Curr,
+			toPath:   ch.Curr.Path(),
+			fromPath: ch.Curr.Path(),
+		}
+
+		if ch.Curr.Type() == n.NodeTypeGhost {
+			t.to = nil
+		}
+
+		if ch.WasPreviouslyAt != "" {
+			t.fromPath = ch.WasPreviouslyAt
+		}
+
+		t.from, err = lookupAlive(lkr, parent, t.fromPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if t.from == nil && t.to == nil {
+			continue
+		}
+
+		transitions = append(transitions, t)
+	}
+
+	return transitions, nil
+}
+
+// sameState checks if `a` and `b` can be considered as the same version.
+func sameState(a, b n.ModNode) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	if a.Type() == n.NodeTypeFile {
+		return a.ContentHash().Equal(b.ContentHash())
+	}
+
+	return true
+}
+
+type picker struct {
+	lkrSrc   *c.Linker
+	lkrDst   *c.Linker
+	strategy ConflictStrategy
+
+	// conflicts are the paths where the transition could not be applied cleanly.
+	conflicts []string
+}
+
+func (pk *picker) add(nd n.ModNode) error {
+	switch nd.Type() {
+	case n.NodeTypeFile:
+		file, ok := nd.(*n.File)
+		if !ok {
+			return ie.ErrBadNode
+		}
+
+		return pk.addFileAt(file, file.Path())
+	case n.NodeTypeDirectory:
+		dir, ok := nd.(*n.Directory)
+		if !ok {
+			return ie.ErrBadNode
+		}
+
+		// Bring back the whole tree, not only the directory itself:
+		return n.Walk(pk.lkrSrc, dir, true, func(child n.Node) error {
+			switch child.Type() {
+			case n.NodeTypeDirectory:
+				_, err := c.Mkdir(pk.lkrDst, child.Path(), true)
+				return err
+			case n.NodeTypeFile:
+				file, ok := child.(*n.File)
+				if !ok {
+					return ie.ErrBadNode
+				}
+
+				return pk.addFileAt(file, file.Path())
+			default:
+				return nil
+			}
+		})
+	default:
+		return e.Wrapf(ie.ErrBadNode, "pick: add")
+	}
+}
+
+func (pk *picker) addFileAt(file *n.File, repoPath string) error {
+	if _, err := c.Mkdir(pk.lkrDst, path.Dir(repoPath), true); err != nil {
+		return e.Wrapf(err, "pick: mkdir")
+	}
+
+	_, err := c.StageWithFullInfo(
+		pk.lkrDst,
+		repoPath,
+		file.ContentHash(),
+		file.BackendHash(),
+		file.Size(),
+		file.CachedSize(),
+		file.Key(),
+		file.ModTime(),
+	)
+
+	return err
+}
+
+func (pk *picker) remove(nd n.ModNode) error {
+	_, _, err := c.Remove(pk.lkrDst, nd, true, true)
+	return err
+}
+
+func (pk *picker) transition(t transition, curr n.ModNode) error {
+	if t.to == nil {
+		if curr == nil {
+			return nil
+		}
+
+		return pk.remove(curr)
+	}
+
+	if curr == nil {
+		return pk.add(t.to)
+	}
+
+	if curr.Type() != t.to.Type() {
+		if err := pk.remove(curr); err != nil {
+			return err
+		}
+
+		return pk.add(t.to)
+	}
+
+	if curr.Path() != t.toPath {
+		if _, err := c.Mkdir(pk.lkrDst, path.Dir(t.toPath), true); err != nil {
+			return e.Wrapf(err, "pick: mkdir")
+		}
+
+		if err := c.Move(pk.lkrDst, curr, t.toPath); err != nil {
+			return e.Wrapf(err, "pick: move")
+		}
+	}
+
+	if t.to.Type() == n.NodeTypeFile && !sameState(curr, t.to) {
+		return pk.add(t.to)
+	}
+
+	return nil
+}
+
+func (pk *picker) conflict(t transition, curr n.ModNode) error {
+	log.Debugf("pick: conflict at %s (strategy %s)", t.toPath, pk.strategy)
+	pk.conflicts = append(pk.conflicts, t.toPath)
+
+	switch pk.strategy {
+	case ConflictStragetyIgnore:
+		return nil
+	case ConflictStragetyEmbrace:
+		if curr == nil {
+			// There might be something else in the way at the destination:
+			existing, err := lookupAlive(pk.lkrDst, nil, t.toPath)
+			if err != nil {
+				return err
+			}
+
+			curr = existing
+		}
+
+		return pk.transition(t, curr)
+	default:
+		// Only files can be stored next to the conflicting version:
+		if t.to == nil || t.to.Type() != n.NodeTypeFile {
+			return nil
+		}
+
+		file, ok := t.to.(*n.File)
+		if !ok {
+			return ie.ErrBadNode
+		}
+
+		conflictPath, err := pk.conflictPath(t.toPath)
+		if err != nil {
+			return err
+		}
+
+		return pk.addFileAt(file, conflictPath)
+	}
+}
+
+func (pk *picker) conflictPath(repoPath string) (string, error) {
+	for tries := 0; ; tries++ {
+		conflictPath := fmt.Sprintf("%s.conflict.%d", repoPath, tries)
+		nd, err := lookupAlive(pk.lkrDst, nil, conflictPath)
+		if err != nil {
+			return "", err
+		}
+
+		if nd == nil {
+			return conflictPath, nil
+		}
+	}
+}
+
+// apply checks that the staging area of the destination still has the state
+// `t` starts with. If so, the transition is done, otherwise it's a conflict.
+func (pk *picker) apply(t transition) error {
+	currPath := t.toPath
+	if t.from != nil {
+		currPath = t.fromPath
+	}
+
+	curr, err := lookupAlive(pk.lkrDst, nil, currPath)
+	if err != nil {
+		return err
+	}
+
+	if sameState(curr, t.from) {
+		log.Debugf("pick: %s", t)
+		return pk.transition(t, curr)
+	}
+
+	// Maybe the change was done already?
+	done, err := lookupAlive(pk.lkrDst, nil, t.toPath)
+	if err != nil {
+		return err
+	}
+
+	if sameState(done, t.to) {
+		return nil
+	}
+
+	return pk.conflict(t, curr)
+}
+
+func (pk *picker) applyAll(transitions []transition) error {
+	return pk.lkrDst.Atomic(func() (bool, error) {
+		for _, t := range transitions {
+			if err := pk.apply(t); err != nil {
+				return true, err
+			}
+		}
+
+		return false, nil
+	})
+}
+
+// Revert undoes the changes that were introduced by `cmt` in the staging area
+// of `lkr`. Paths that were changed again after `cmt` are treated as conflicts
+// and are handled according to `strategy`. The conflicting paths are returned.
+// No commit is made.
+func Revert(lkr *c.Linker, cmt *n.Commit, strategy ConflictStrategy) ([]string, error) {
+	transitions, err := commitTransitions(lkr, cmt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reverting means going the way back:
+	for idx, t := range transitions {
+		transitions[idx] = transition{
+			from:     t.to,
+			to:       t.from,
+			fromPath: t.toPath,
+			toPath:   t.fromPath,
+		}
+	}
+
+	pk := &picker{lkrSrc: lkr, lkrDst: lkr, strategy: strategy}
+	if err := pk.applyAll(transitions); err != nil {
+		return nil, err
+	}
+
+	return pk.conflicts, nil
+}
+
+// CherryPick applies the changes that were introduced by `cmt` of `lkrSrc`
+// to the staging area of `lkrDst`. Paths where `lkrDst` differs from the
+// parent of `cmt` are treated as conflicts and are handled according to
+// `strategy`. The conflicting paths are returned. No commit is made.
+func CherryPick(lkrSrc, lkrDst *c.Linker, cmt *n.Commit, strategy ConflictStrategy) ([]string, error) {
+	transitions, err := commitTransitions(lkrSrc, cmt)
+	if err != nil {
+		return nil, err
+	}
+
+	pk := &picker{lkrSrc: lkrSrc, lkrDst: lkrDst, strategy: strategy}
+	if err := pk.applyAll(transitions); err != nil {
+		return nil, err
+	}
+
+	return pk.conflicts, nil
+}
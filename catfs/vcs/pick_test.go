@@ -0,0 +1,149 @@
+package vcs
+
+import (
+	"testing"
+
+	c "github.com/sahib/brig/catfs/core"
+	ie "github.com/sahib/brig/catfs/errors"
+	n "github.com/sahib/brig/catfs/nodes"
+	h "github.com/sahib/brig/util/hashlib"
+	"github.com/stretchr/testify/require"
+)
+
+func mustLookupContent(t *testing.T, lkr *c.Linker, path string) h.Hash {
+	file, err := lkr.LookupFile(path)
+	require.Nil(t, err)
+	return file.ContentHash()
+}
+
+func TestRevert(t *testing.T) {
+	c.WithDummyLinker(t, func(lkr *c.Linker) {
+		c.MustTouchAndCommit(t, lkr, "/keep.png", 1)
+		c.MustTouchAndCommit(t, lkr, "/modify.png", 2)
+		c.MustMkdir(t, lkr, "/sub")
+		c.MustTouchAndCommit(t, lkr, "/sub/remove.png", 3)
+		c.MustTouchAndCommit(t, lkr, "/move.png", 4)
+
+		// One commit with all kinds of changes:
+		c.MustTouch(t, lkr, "/add.png", 5)
+
+		modify, err := lkr.LookupFile("/modify.png")
+		require.Nil(t, err)
+		c.MustModify(t, lkr, modify, 6)
+
+		sub, err := lkr.LookupModNode("/sub")
+		require.Nil(t, err)
+		c.MustRemove(t, lkr, sub)
+
+		move, err := lkr.LookupModNode("/move.png")
+		require.Nil(t, err)
+		c.MustMove(t, lkr, move, "/moved.png")
+
+		cmt := c.MustCommit(t, lkr, "everything")
+
+		conflicts, err := Revert(lkr, cmt, ConflictStragetyMarker)
+		require.Nil(t, err)
+		require.Empty(t, conflicts)
+
+		for _, path := range []string{"/add.png", "/moved.png"} {
+			nd, err := lookupAlive(lkr, nil, path)
+			require.Nil(t, err)
+			require.Nil(t, nd)
+		}
+
+		require.Equal(t, h.TestDummy(t, 2), mustLookupContent(t, lkr, "/modify.png"))
+		require.Equal(t, h.TestDummy(t, 3), mustLookupContent(t, lkr, "/sub/remove.png"))
+		require.Equal(t, h.TestDummy(t, 4), mustLookupContent(t, lkr, "/move.png"))
+		require.Equal(t, h.TestDummy(t, 1), mustLookupContent(t, lkr, "/keep.png"))
+	})
+}
+
+func TestRevertConflict(t *testing.T) {
+	tcs := []struct {
+		name     string
+		strategy ConflictStrategy
+		expect   byte
+	}{
+		{"marker", ConflictStragetyMarker, 3},
+		{"ignore", ConflictStragetyIgnore, 3},
+		{"embrace", ConflictStragetyEmbrace, 1},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c.WithDummyLinker(t, func(lkr *c.Linker) {
+				file, _ := c.MustTouchAndCommit(t, lkr, "/x.png", 1)
+				c.MustModify(t, lkr, file, 2)
+				cmt := c.MustCommit(t, lkr, "modify once")
+				c.MustModify(t, lkr, file, 3)
+				c.MustCommit(t, lkr, "modify twice")
+
+				conflicts, err := Revert(lkr, cmt, tc.strategy)
+				require.Nil(t, err)
+				require.Equal(t, []string{"/x.png"}, conflicts)
+				require.Equal(t, h.TestDummy(t, tc.expect), mustLookupContent(t, lkr, "/x.png"))
+
+				_, err = lkr.LookupFile("/x.png.conflict.0")
+				if tc.strategy == ConflictStragetyMarker {
+					require.Nil(t, err)
+					require.Equal(t, h.TestDummy(t, 1), mustLookupContent(t, lkr, "/x.png.conflict.0"))
+				} else {
+					require.True(t, ie.IsNoSuchFileError(err))
+				}
+			})
+		})
+	}
+}
+
+func TestRevertInitialCommit(t *testing.T) {
+	c.WithDummyLinker(t, func(lkr *c.Linker) {
+		cmt, err := lkr.CommitByIndex(0)
+		require.Nil(t, err)
+
+		_, err = Revert(lkr, cmt, ConflictStragetyMarker)
+		require.NotNil(t, err)
+	})
+}
+
+func TestCherryPick(t *testing.T) {
+	c.WithLinkerPair(t, func(lkrSrc, lkrDst *c.Linker) {
+		c.MustTouchAndCommit(t, lkrSrc, "/shared.png", 1)
+		c.MustTouchAndCommit(t, lkrDst, "/shared.png", 1)
+
+		c.MustTouchAndCommit(t, lkrSrc, "/skipped.png", 2)
+		shared, err := lkrSrc.LookupFile("/shared.png")
+		require.Nil(t, err)
+		c.MustModify(t, lkrSrc, shared, 3)
+		c.MustTouch(t, lkrSrc, "/picked.png", 4)
+		cmt := c.MustCommit(t, lkrSrc, "pick me")
+
+		conflicts, err := CherryPick(lkrSrc, lkrDst, cmt, ConflictStragetyMarker)
+		require.Nil(t, err)
+		require.Empty(t, conflicts)
+
+		require.Equal(t, h.TestDummy(t, 3), mustLookupContent(t, lkrDst, "/shared.png"))
+		require.Equal(t, h.TestDummy(t, 4), mustLookupContent(t, lkrDst, "/picked.png"))
+		_, err = lkrDst.LookupNode("/skipped.png")
+		require.True(t, ie.IsNoSuchFileError(err))
+
+		// Picking the same commit again does not change anything:
+		conflicts, err = CherryPick(lkrSrc, lkrDst, cmt, ConflictStragetyMarker)
+		require.Nil(t, err)
+		require.Empty(t, conflicts)
+
+		status, err := lkrDst.Status()
+		require.Nil(t, err)
+		changes, err := CommitChanges(lkrDst, status)
+		require.Nil(t, err)
+
+		paths := []string{}
+		for _, change := range changes {
+			if change.Curr.Type() == n.NodeTypeFile {
+				paths = append(paths, change.Curr.Path())
+			}
+		}
+
+		require.ElementsMatch(t, []string{"/shared.png", "/picked.png"}, paths)
+	})
+}
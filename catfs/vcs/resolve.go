@@ -89,6 +89,9 @@ type resolver struct {
 	dstHead *n.Commit
 	srcHead *n.Commit
 
+	// Name used in the merge marker of src; defaults to its owner.
+	with string
+
 	// cached attributes:
 	dstMergeCmt *n.Commit
 	srcMergeCmt *n.Commit
@@ -157,9 +160,12 @@ func (rv *resolver) resolve() error {
 }
 
 func (rv *resolver) cacheLastCommonMerge() error {
-	srcOwner, err := rv.lkrSrc.Owner()
-	if err != nil {
-		return err
+	srcOwner := rv.with
+	if srcOwner == "" {
+		var err error
+		if srcOwner, err = rv.lkrSrc.Owner(); err != nil {
+			return err
+		}
 	}
 
 	currHead := rv.dstHead
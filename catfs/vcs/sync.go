@@ -71,6 +71,10 @@ type SyncOptions struct {
 	ReadOnlyFolders           map[string]bool
 	ConflictStrategyPerFolder map[string]ConflictStrategy
 
+	// Branch of the source to sync with.
+	// If empty, the current state of the source is used.
+	Branch string
+
 	OnAdd      func(newNd n.ModNode) bool
 	OnRemove   func(oldNd n.ModNode) bool
 	OnMerge    func(nd n.ModNode, isGet bool, ndPinStats *PinStats) bool
@@ -415,17 +419,62 @@ func Sync(lkrSrc, lkrDst *c.Linker, cfg *SyncOptions) error {
 		cfg = defaultSyncConfig
 	}
 
+	srcOwner, err := lkrSrc.Owner()
+	if err != nil {
+		return err
+	}
+
+	// By default the current state of src is used (i.e. its staging commit).
+	var srcHead *n.Commit
+	if cfg.Branch != "" {
+		srcHead, err = lkrSrc.BranchTip(cfg.Branch)
+		if err != nil {
+			return err
+		}
+	}
+
+	message := cfg.Message
+	if message == "" {
+		message = fmt.Sprintf("merge with »%s«", srcOwner)
+	}
+
+	return doSync(lkrSrc, lkrDst, srcHead, srcOwner, message, cfg)
+}
+
+// Merge merges the changes of `src` into the staging area of `lkr` and
+// creates a merge commit if something changed. `src` must be a commit of
+// `lkr`, usually the tip of another branch. `with` identifies where `src`
+// came from (e.g. the branch name) and is used to find earlier merges.
+func Merge(lkr *c.Linker, src *n.Commit, with string, cfg *SyncOptions) error {
+	if cfg == nil {
+		cfg = defaultSyncConfig
+	}
+
+	message := cfg.Message
+	if message == "" {
+		message = fmt.Sprintf("merge with »%s«", with)
+	}
+
+	// Use a separate linker for reading src, so that nodes
+	// modified in the staging area do not leak into src by the cache.
+	lkrSrc := c.NewLinker(lkr.KV())
+	return doSync(lkrSrc, lkr, src, with, message, cfg)
+}
+
+func doSync(lkrSrc, lkrDst *c.Linker, srcHead *n.Commit, with, message string, cfg *SyncOptions) error {
 	syncer := &syncer{
 		cfg:    cfg,
 		lkrSrc: lkrSrc,
 		lkrDst: lkrDst,
 	}
 
-	resolver, err := newResolver(lkrSrc, lkrDst, nil, nil, syncer)
+	resolver, err := newResolver(lkrSrc, lkrDst, srcHead, nil, syncer)
 	if err != nil {
 		return err
 	}
 
+	resolver.with = with
+
 	// Make sure the complete sync goes through in one disk transaction.
 	return lkrDst.Atomic(func() (bool, error) {
 		// This calls all the handleXXX() callbacks above.
@@ -441,28 +490,26 @@ func Sync(lkrSrc, lkrDst *c.Linker, cfg *SyncOptions) error {
 		// If something was changed, we should set the merge marker
 		// and also create a new commit.
 		if wasModified {
-			srcOwner, err := lkrSrc.Owner()
-			if err != nil {
-				return true, err
-			}
-
-			srcHead, err := lkrSrc.Head()
-			if err != nil {
-				return true, err
+			markerHead := srcHead
+			if markerHead == nil {
+				markerHead, err = lkrSrc.Head()
+				if err != nil {
+					return true, err
+				}
 			}
 
 			// If something was changed, remember that we merged with src.
 			// This avoids merging conflicting files a second time in the next resolve().
-			if err := lkrDst.SetMergeMarker(srcOwner, srcHead.TreeHash()); err != nil {
+			if err := lkrDst.SetMergeMarker(with, markerHead.TreeHash()); err != nil {
 				return true, err
 			}
 
-			message := cfg.Message
-			if message == "" {
-				message = fmt.Sprintf("merge with »%s«", srcOwner)
+			author, err := lkrSrc.Owner()
+			if err != nil {
+				return true, err
 			}
 
-			if err := lkrDst.MakeCommit(srcOwner, message); err != nil {
+			if err := lkrDst.MakeCommit(author, message); err != nil {
 				return true, err
 			}
 		}
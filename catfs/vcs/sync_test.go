@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	c "github.com/sahib/brig/catfs/core"
+	ie "github.com/sahib/brig/catfs/errors"
 	h "github.com/sahib/brig/util/hashlib"
 	"github.com/stretchr/testify/require"
 )
@@ -380,3 +381,68 @@ func TestSyncReadOnlyFolders(t *testing.T) {
 		require.Equal(t, srcX.ContentHash(), h.TestDummy(t, byte(1)))
 	})
 }
+
+func TestSyncBranch(t *testing.T) {
+	c.WithLinkerPair(t, func(lkrSrc, lkrDst *c.Linker) {
+		_, base := c.MustTouchAndCommit(t, lkrSrc, "/x.png", 1)
+		require.Nil(t, lkrSrc.MakeBranch("dev", base))
+		require.Nil(t, lkrSrc.SwitchBranch("dev", false))
+		c.MustTouchAndCommit(t, lkrSrc, "/dev.png", 2)
+		require.Nil(t, lkrSrc.SwitchBranch(c.DefaultBranch, false))
+		c.MustTouchAndCommit(t, lkrSrc, "/master.png", 3)
+
+		require.Nil(t, Sync(lkrSrc, lkrDst, &SyncOptions{Branch: "dev"}))
+
+		_, err := lkrDst.LookupFile("/dev.png")
+		require.Nil(t, err)
+		_, err = lkrDst.LookupNode("/master.png")
+		require.True(t, ie.IsNoSuchFileError(err))
+
+		require.True(t, ie.IsErrNoSuchBranch(Sync(lkrSrc, lkrDst, &SyncOptions{Branch: "nope"})))
+	})
+}
+
+func TestMergeBranch(t *testing.T) {
+	c.WithDummyLinker(t, func(lkr *c.Linker) {
+		shared, base := c.MustTouchAndCommit(t, lkr, "/shared.png", 1)
+		require.Nil(t, lkr.MakeBranch("dev", base))
+		require.Nil(t, lkr.SwitchBranch("dev", false))
+
+		c.MustTouchAndCommit(t, lkr, "/dev.png", 2)
+		shared, err := lkr.LookupFile(shared.Path())
+		require.Nil(t, err)
+		c.MustModify(t, lkr, shared, 3)
+		c.MustCommit(t, lkr, "modify on dev")
+
+		devTip, err := lkr.Head()
+		require.Nil(t, err)
+
+		require.Nil(t, lkr.SwitchBranch(c.DefaultBranch, false))
+		c.MustTouchAndCommit(t, lkr, "/master.png", 4)
+
+		require.Nil(t, Merge(lkr, devTip, "branch:dev", nil))
+
+		head, err := lkr.Head()
+		require.Nil(t, err)
+		require.Equal(t, "merge with »branch:dev«", head.Message())
+
+		with, ref := head.MergeMarker()
+		require.Equal(t, "branch:dev", with)
+		require.Equal(t, devTip.TreeHash(), ref)
+
+		for _, path := range []string{"/dev.png", "/master.png"} {
+			_, err := lkr.LookupFile(path)
+			require.Nil(t, err)
+		}
+
+		shared, err = lkr.LookupFile("/shared.png")
+		require.Nil(t, err)
+		require.Equal(t, h.TestDummy(t, 3), shared.BackendHash())
+
+		// Merging again should not change anything:
+		require.Nil(t, Merge(lkr, devTip, "branch:dev", nil))
+		newHead, err := lkr.Head()
+		require.Nil(t, err)
+		require.Equal(t, head.TreeHash(), newHead.TreeHash())
+	})
+}
@@ -2,8 +2,8 @@ package client
 
 import (
 	"context"
-	"fmt"
 	"net"
+	"strconv"
 
 	"github.com/sahib/brig/server/capnp"
 	"zombiezen.com/go/capnproto2/rpc"
@@ -22,7 +22,13 @@ type Client struct {
 
 // Dial will attempt to connect to brigd under the specified port
 func Dial(ctx context.Context, port int) (*Client, error) {
-	addr := fmt.Sprintf("localhost:%d", port)
+	return DialHost(ctx, "localhost", port)
+}
+
+// DialHost is like Dial, but connects to brigd on `host`.
+// Content is transferred over further connections to the same host.
+func DialHost(ctx context.Context, host string, port int) (*Client, error) {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
 	tcpConn, err := net.Dial("tcp", addr)
 	if err != nil {
 		return nil, err
@@ -50,6 +56,21 @@ func (cl *Client) RemoteAddr() net.Addr {
 	return cl.tcpConn.RemoteAddr()
 }
 
+// dialTransfer connects to a port the daemon opened for a single transfer.
+func (cl *Client) dialTransfer(port int32) (*net.TCPConn, error) {
+	host, _, err := net.SplitHostPort(cl.tcpConn.RemoteAddr().String())
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.(*net.TCPConn), nil
+}
+
 // Close will close the connection from the client side
 func (cl *Client) Close() error {
 	return cl.conn.Close()
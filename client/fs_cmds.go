@@ -1,14 +1,14 @@
 package client
 
 import (
-	"fmt"
+	"errors"
 	"io"
 	"io/ioutil"
-	"net"
 	"os"
 	"time"
 
 	"github.com/sahib/brig/server/capnp"
+	"github.com/sahib/brig/util"
 	h "github.com/sahib/brig/util/hashlib"
 )
 
@@ -93,8 +93,17 @@ func convertCapStatInfo(capInfo *capnp.StatInfo) (*StatInfo, error) {
 
 // List will list all nodes beneath and including `root` up to `maxDepth`.
 func (cl *Client) List(root string, maxDepth int) ([]StatInfo, error) {
+	return cl.ListAt(root, "", maxDepth)
+}
+
+// ListAt is like List, but lists the nodes in the commit referenced by `rev`.
+func (cl *Client) ListAt(root, rev string, maxDepth int) ([]StatInfo, error) {
 	call := cl.api.List(cl.ctx, func(p capnp.FS_list_Params) error {
 		p.SetMaxDepth(int32(maxDepth))
+		if err := p.SetRev(rev); err != nil {
+			return err
+		}
+
 		return p.SetRoot(root)
 	})
 
@@ -123,40 +132,173 @@ func (cl *Client) List(root string, maxDepth int) ([]StatInfo, error) {
 }
 
 // Stage will add a new node at `repoPath` with the contents of `localPath`.
+// The content is sent to the daemon, so `localPath` does not need to be
+// visible to it.
 func (cl *Client) Stage(localPath, repoPath string) error {
-	call := cl.api.Stage(cl.ctx, func(p capnp.FS_stage_Params) error {
+	_, err := cl.StageIncremental(localPath, repoPath, "")
+	return err
+}
+
+// StageIncremental works like Stage, but remembers `localPath` as part of
+// `source` (usually the file or directory the user wants to stage). If the
+// file did not change since it was staged like this the last time, it is
+// skipped and true is returned. An empty `source` always stages.
+func (cl *Client) StageIncremental(localPath, repoPath, source string) (bool, error) {
+	fd, err := os.Open(localPath) // #nosec
+	if err != nil {
+		return false, err
+	}
+
+	defer fd.Close()
+
+	info, err := fd.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	local := localFileInfo{
+		path:    localPath,
+		size:    info.Size(),
+		modTime: info.ModTime().UnixNano(),
+		inode:   util.Inode(info),
+	}
+
+	return cl.stageStream(repoPath, source, local, fd)
+}
+
+// StageFromReader will create a new node at `repoPath` from the contents of `r`.
+func (cl *Client) StageFromReader(repoPath string, r io.Reader) error {
+	_, err := cl.stageStream(repoPath, "", localFileInfo{size: -1}, r)
+	return err
+}
+
+type localFileInfo struct {
+	path    string
+	size    int64
+	modTime int64
+	inode   uint64
+}
+
+func (cl *Client) stageStream(repoPath, source string, local localFileInfo, r io.Reader) (bool, error) {
+	call := cl.api.StageStream(cl.ctx, func(p capnp.FS_stageStream_Params) error {
 		if err := p.SetRepoPath(repoPath); err != nil {
 			return err
 		}
 
-		return p.SetLocalPath(localPath)
+		if err := p.SetSource(source); err != nil {
+			return err
+		}
+
+		capLocal, err := p.NewLocal()
+		if err != nil {
+			return err
+		}
+
+		capLocal.SetSize(local.size)
+		capLocal.SetModTime(local.modTime)
+		capLocal.SetInode(local.inode)
+		return capLocal.SetPath(local.path)
 	})
 
-	_, err := call.Struct()
-	return err
-}
+	result, err := call.Struct()
+	if err != nil {
+		return false, err
+	}
 
-// StageFromReader will create a new node at `repoPath` from the contents of `r`.
-func (cl *Client) StageFromReader(repoPath string, r io.Reader) error {
-	fd, err := ioutil.TempFile("", "brig-stage-temp")
+	if result.Skipped() {
+		return true, nil
+	}
+
+	token, err := result.Token()
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	defer os.Remove(fd.Name())
+	conn, err := cl.dialTransfer(result.Port())
+	if err != nil {
+		return false, err
+	}
 
-	if _, err := io.Copy(fd, r); err != nil {
-		return err
+	defer conn.Close()
+
+	// The daemon only accepts content after the token:
+	if _, err := conn.Write(token); err != nil {
+		return false, err
 	}
 
-	return cl.Stage(fd.Name(), repoPath)
+	if _, err := io.Copy(conn, r); err != nil {
+		return false, err
+	}
+
+	// Tell the daemon that we are done; it answers with an error
+	// message (or nothing) once the content was staged.
+	if err := conn.CloseWrite(); err != nil {
+		return false, err
+	}
+
+	msg, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return false, err
+	}
+
+	if len(msg) > 0 {
+		return false, errors.New(string(msg))
+	}
+
+	return false, nil
+}
+
+// StageRemoveMissing removes all files that were staged from `source`
+// with StageIncremental, but are not in `existing` (local paths) anymore.
+// Files that were modified in the repository since are kept. The removed
+// paths are returned.
+func (cl *Client) StageRemoveMissing(source string, existing []string) ([]string, error) {
+	call := cl.api.StageRemoveMissing(cl.ctx, func(p capnp.FS_stageRemoveMissing_Params) error {
+		if err := p.SetSource(source); err != nil {
+			return err
+		}
+
+		capExisting, err := p.NewExisting(int32(len(existing)))
+		if err != nil {
+			return err
+		}
+
+		for idx, localPath := range existing {
+			if err := capExisting.Set(idx, localPath); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, err
+	}
+
+	capRemoved, err := result.Removed()
+	if err != nil {
+		return nil, err
+	}
+
+	return textListToStrings(capRemoved)
 }
 
 // Cat outputs the contents of the node at `path`.
 // The node must be a file.
 func (cl *Client) Cat(path string, offline bool) (io.ReadCloser, error) {
+	return cl.CatAt(path, "", offline)
+}
+
+// CatAt is like Cat, but outputs the file in the commit referenced by `rev`.
+func (cl *Client) CatAt(path, rev string, offline bool) (io.ReadCloser, error) {
 	call := cl.api.Cat(cl.ctx, func(p capnp.FS_cat_Params) error {
 		p.SetOffline(offline)
+		if err := p.SetRev(rev); err != nil {
+			return err
+		}
+
 		return p.SetPath(path)
 	})
 
@@ -165,13 +307,7 @@ func (cl *Client) Cat(path string, offline bool) (io.ReadCloser, error) {
 		return nil, err
 	}
 
-	port := result.Port()
-	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
-	if err != nil {
-		return nil, err
-	}
-
-	return conn, nil
+	return cl.dialTransfer(result.Port())
 }
 
 // Tar outputs a tar archive with the contents of `path`.
@@ -187,13 +323,7 @@ func (cl *Client) Tar(path string, offline bool) (io.ReadCloser, error) {
 		return nil, err
 	}
 
-	port := result.Port()
-	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
-	if err != nil {
-		return nil, err
-	}
-
-	return conn, nil
+	return cl.dialTransfer(result.Port())
 }
 
 // Mkdir creates a new empty directory at `path`, possibly creating
@@ -361,7 +491,17 @@ func (cl *Client) DeletedNodes(root string) ([]StatInfo, error) {
 }
 
 func (cl *Client) IsCached(path string) (bool, error) {
+	return cl.IsCachedAt(path, "")
+}
+
+// IsCachedAt checks if the node at `path` in the commit
+// referenced by `rev` is completely in the local cache.
+func (cl *Client) IsCachedAt(path, rev string) (bool, error) {
 	call := cl.api.IsCached(cl.ctx, func(p capnp.FS_isCached_Params) error {
+		if err := p.SetRev(rev); err != nil {
+			return err
+		}
+
 		return p.SetPath(path)
 	})
 
@@ -372,3 +512,198 @@ func (cl *Client) IsCached(path string) (bool, error) {
 
 	return result.IsCached(), nil
 }
+
+// FindOptions modify what Find() looks at.
+type FindOptions struct {
+	// Rev is the revision to search in. If empty, the current state is searched.
+	Rev string
+	// History makes Find() look at all commits reachable from Rev.
+	History bool
+}
+
+// FindResult is a single node that matched a search.
+type FindResult struct {
+	StatInfo
+
+	// Commit is the commit the node was found in.
+	// It is nil if the current state was searched.
+	Commit h.Hash
+}
+
+// Find returns all nodes below `root` that match `query`.
+// See »brig help find« for the syntax of the query.
+func (cl *Client) Find(root, query string, opts FindOptions) ([]FindResult, error) {
+	call := cl.api.Find(cl.ctx, func(p capnp.FS_find_Params) error {
+		p.SetHistory(opts.History)
+		if err := p.SetRev(opts.Rev); err != nil {
+			return err
+		}
+
+		if err := p.SetQuery(query); err != nil {
+			return err
+		}
+
+		return p.SetRoot(root)
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, err
+	}
+
+	capResults, err := result.Results()
+	if err != nil {
+		return nil, err
+	}
+
+	return convertCapFindResults(capResults)
+}
+
+func convertCapFindResults(capResults capnp.FindResult_List) ([]FindResult, error) {
+	results := []FindResult{}
+	for idx := 0; idx < capResults.Len(); idx++ {
+		capResult := capResults.At(idx)
+		capInfo, err := capResult.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := convertCapStatInfo(&capInfo)
+		if err != nil {
+			return nil, err
+		}
+
+		findResult := FindResult{StatInfo: *info}
+		commitData, err := capResult.Commit()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(commitData) > 0 {
+			if findResult.Commit, err = h.Cast(commitData); err != nil {
+				return nil, err
+			}
+		}
+
+		results = append(results, findResult)
+	}
+
+	return results, nil
+}
+
+// DupeGroup is a set of files with the same content.
+type DupeGroup struct {
+	ContentHash h.Hash
+	Size        uint64
+	// Files are the files with this content.
+	// The first one is kept when deduplicating.
+	Files []FindResult
+	// Blobs is the number of different backend blobs for the content.
+	Blobs int
+	// WastedBytes is how much more the files take than a single copy.
+	WastedBytes uint64
+	// CachedBytes is the local storage taken by redundant blobs.
+	CachedBytes uint64
+}
+
+// Dupes returns all groups of files below `root` that have the same content.
+// If `history` is true, older versions of the files are considered too.
+func (cl *Client) Dupes(root string, history bool) ([]DupeGroup, error) {
+	call := cl.api.Dupes(cl.ctx, func(p capnp.FS_dupes_Params) error {
+		p.SetHistory(history)
+		return p.SetRoot(root)
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, err
+	}
+
+	capGroups, err := result.Groups()
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []DupeGroup{}
+	for idx := 0; idx < capGroups.Len(); idx++ {
+		capGroup := capGroups.At(idx)
+		contentHash, err := convertHash(capGroup.ContentHash())
+		if err != nil {
+			return nil, err
+		}
+
+		capFiles, err := capGroup.Files()
+		if err != nil {
+			return nil, err
+		}
+
+		files, err := convertCapFindResults(capFiles)
+		if err != nil {
+			return nil, err
+		}
+
+		groups = append(groups, DupeGroup{
+			ContentHash: contentHash,
+			Size:        capGroup.Size(),
+			Files:       files,
+			Blobs:       int(capGroup.Blobs()),
+			WastedBytes: capGroup.WastedBytes(),
+			CachedBytes: capGroup.CachedBytes(),
+		})
+	}
+
+	return groups, nil
+}
+
+// DedupeChange is a duplicate that was changed by Dedupe.
+type DedupeChange struct {
+	Path       string
+	KeptPath   string
+	FreedBytes uint64
+}
+
+// Dedupe gets rid of the redundant blobs of duplicates below `root`.
+// `mode` is either "link" (make duplicates share one blob)
+// or "unpin" (unpin the redundant blobs).
+func (cl *Client) Dedupe(root, mode string, dryRun bool) ([]DedupeChange, error) {
+	call := cl.api.Dedupe(cl.ctx, func(p capnp.FS_dedupe_Params) error {
+		p.SetDryRun(dryRun)
+		if err := p.SetMode(mode); err != nil {
+			return err
+		}
+
+		return p.SetRoot(root)
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, err
+	}
+
+	capChanges, err := result.Changes()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []DedupeChange{}
+	for idx := 0; idx < capChanges.Len(); idx++ {
+		capChange := capChanges.At(idx)
+		path, err := capChange.Path()
+		if err != nil {
+			return nil, err
+		}
+
+		keptPath, err := capChange.KeptPath()
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, DedupeChange{
+			Path:       path,
+			KeptPath:   keptPath,
+			FreedBytes: capChange.FreedBytes(),
+		})
+	}
+
+	return changes, nil
+}
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"context"
 	"io/ioutil"
+	"net"
 	"os"
+	"path/filepath"
 	"sort"
 	"testing"
 	"time"
@@ -13,6 +15,7 @@ import (
 	"github.com/sahib/brig/server"
 	"github.com/sahib/brig/util"
 	colorLog "github.com/sahib/brig/util/log"
+	"github.com/sahib/brig/util/testutil"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 )
@@ -118,6 +121,181 @@ func TestStageAndCat(t *testing.T) {
 	})
 }
 
+func TestStageIncremental(t *testing.T) {
+	withDaemon(t, "ali", func(ctl *Client) {
+		dir, err := ioutil.TempDir("", "brig-stage-source")
+		require.Nil(t, err)
+		defer os.RemoveAll(dir)
+
+		pathA := filepath.Join(dir, "a")
+		pathB := filepath.Join(dir, "b")
+		require.Nil(t, ioutil.WriteFile(pathA, []byte("a"), 0600))
+		require.Nil(t, ioutil.WriteFile(pathB, []byte("b"), 0600))
+
+		for _, skipped := range []bool{false, true} {
+			wasSkipped, err := ctl.StageIncremental(pathA, "/dir/a", dir)
+			require.Nil(t, err, stringify(err))
+			require.Equal(t, skipped, wasSkipped)
+
+			wasSkipped, err = ctl.StageIncremental(pathB, "/dir/b", dir)
+			require.Nil(t, err, stringify(err))
+			require.Equal(t, skipped, wasSkipped)
+		}
+
+		// Changed in the repo; needs to be staged again:
+		require.Nil(t, ctl.StageFromReader("/dir/a", bytes.NewReader([]byte("x"))))
+		wasSkipped, err := ctl.StageIncremental(pathA, "/dir/a", dir)
+		require.Nil(t, err, stringify(err))
+		require.False(t, wasSkipped)
+
+		// Changed locally:
+		require.Nil(t, ioutil.WriteFile(pathB, []byte("bb"), 0600))
+		wasSkipped, err = ctl.StageIncremental(pathB, "/dir/b", dir)
+		require.Nil(t, err, stringify(err))
+		require.False(t, wasSkipped)
+
+		// Deleted locally; b was modified in the repo since and is kept:
+		require.Nil(t, ctl.StageFromReader("/dir/b", bytes.NewReader([]byte("y"))))
+		require.Nil(t, os.Remove(pathA))
+		require.Nil(t, os.Remove(pathB))
+
+		// Files that the client still has are kept:
+		removed, err := ctl.StageRemoveMissing(dir, []string{pathA})
+		require.Nil(t, err, stringify(err))
+		require.Empty(t, removed)
+
+		removed, err = ctl.StageRemoveMissing(dir, nil)
+		require.Nil(t, err, stringify(err))
+		require.Equal(t, []string{"/dir/a"}, removed)
+
+		exists, err := ctl.Exists("/dir/a")
+		require.Nil(t, err)
+		require.False(t, exists)
+
+		exists, err = ctl.Exists("/dir/b")
+		require.Nil(t, err)
+		require.True(t, exists)
+
+		removed, err = ctl.StageRemoveMissing(dir, nil)
+		require.Nil(t, err, stringify(err))
+		require.Empty(t, removed)
+	})
+}
+
+func TestStageFromReaderLarge(t *testing.T) {
+	withDaemon(t, "ali", func(ctl *Client) {
+		data := testutil.CreateDummyBuf(4 * 1024 * 1024)
+		require.Nil(t, ctl.StageFromReader("/large", bytes.NewReader(data)))
+
+		stream, err := ctl.Cat("/large", false)
+		require.Nil(t, err, stringify(err))
+
+		streamData, err := ioutil.ReadAll(stream)
+		require.Nil(t, err, stringify(err))
+		require.Nil(t, stream.Close())
+		require.Equal(t, data, streamData)
+	})
+}
+
+func TestAtRev(t *testing.T) {
+	withDaemon(t, "ali", func(ctl *Client) {
+		require.Nil(t, ctl.StageFromReader("/dir/x", bytes.NewReader([]byte{1, 2, 3})))
+		require.Nil(t, ctl.MakeCommit("first"))
+		require.Nil(t, ctl.Tag("head", "v1"))
+		require.Nil(t, ctl.StageFromReader("/dir/x", bytes.NewReader([]byte{4, 5})))
+		require.Nil(t, ctl.StageFromReader("/dir/y", bytes.NewReader([]byte{6})))
+		require.Nil(t, ctl.MakeCommit("second"))
+
+		entries, err := ctl.ListAt("/dir", "v1", -1)
+		require.Nil(t, err, stringify(err))
+		require.Len(t, entries, 2)
+		require.Equal(t, "/dir", entries[0].Path)
+		require.Equal(t, "/dir/x", entries[1].Path)
+
+		entries, err = ctl.ListAt("/dir", "", -1)
+		require.Nil(t, err, stringify(err))
+		require.Len(t, entries, 3)
+
+		stream, err := ctl.CatAt("/dir/x", "v1", false)
+		require.Nil(t, err, stringify(err))
+		data, err := ioutil.ReadAll(stream)
+		require.Nil(t, err)
+		require.Nil(t, stream.Close())
+		require.Equal(t, []byte{1, 2, 3}, data)
+
+		isCached, err := ctl.IsCachedAt("/dir/x", "v1")
+		require.Nil(t, err, stringify(err))
+		require.True(t, isCached)
+
+		_, err = ctl.IsCachedAt("/dir/y", "v1")
+		require.NotNil(t, err)
+	})
+}
+
+func TestCacheStats(t *testing.T) {
+	withDaemon(t, "ali", func(ctl *Client) {
+		data := testutil.CreateDummyBuf(128 * 1024)
+		require.Nil(t, ctl.StageFromReader("/x", bytes.NewReader(data)))
+
+		for round := 0; round < 2; round++ {
+			stream, err := ctl.Cat("/x", false)
+			require.Nil(t, err, stringify(err))
+
+			streamData, err := ioutil.ReadAll(stream)
+			require.Nil(t, err)
+			require.Nil(t, stream.Close())
+			require.Equal(t, data, streamData)
+		}
+
+		stats, err := ctl.DebugCacheStats()
+		require.Nil(t, err, stringify(err))
+		require.Equal(t, int64(2), stats.Blocks)
+		require.Equal(t, int64(2), stats.Hits)
+		require.True(t, stats.MaxSize > 0)
+	})
+}
+
+func TestDebugMigrate(t *testing.T) {
+	withDaemon(t, "ali", func(ctl *Client) {
+		// The metadata was migrated on start already:
+		for _, dryRun := range []bool{true, false} {
+			result, err := ctl.DebugMigrate(dryRun)
+			require.Nil(t, err, stringify(err))
+			require.Equal(t, result.Target, result.Version)
+			require.Len(t, result.Steps, 0)
+			require.Equal(t, "", result.SnapshotPath)
+		}
+	})
+}
+
+func TestDebugConvertDb(t *testing.T) {
+	withDaemon(t, "ali", func(ctl *Client) {
+		require.Nil(t, ctl.StageFromReader("/x", bytes.NewReader([]byte("hello"))))
+
+		from, err := ctl.DebugConvertDb("bolt")
+		require.Nil(t, err, stringify(err))
+		require.Equal(t, "badger", from)
+
+		backend, err := ctl.ConfigGet("fs.db.backend")
+		require.Nil(t, err)
+		require.Equal(t, "bolt", backend)
+
+		// The metadata is converted without a restart:
+		stream, err := ctl.Cat("/x", false)
+		require.Nil(t, err, stringify(err))
+		data, err := ioutil.ReadAll(stream)
+		require.Nil(t, err)
+		require.Equal(t, []byte("hello"), data)
+		require.Nil(t, stream.Close())
+
+		require.Nil(t, ctl.StageFromReader("/y", bytes.NewReader([]byte("world"))))
+		require.Nil(t, ctl.MakeCommit("after conversion"))
+
+		_, err = ctl.DebugConvertDb("leveldb")
+		require.NotNil(t, err)
+	})
+}
+
 func TestMkdir(t *testing.T) {
 	withDaemon(t, "ali", func(ctl *Client) {
 		// Create something nested with -p...
@@ -406,3 +584,343 @@ func TestSyncMovedFile(t *testing.T) {
 		require.Len(t, bobDiffAfter.Moved, 1)
 	})
 }
+
+func TestBranchAndMerge(t *testing.T) {
+	withDaemon(t, "ali", func(ctl *Client) {
+		require.Nil(t, ctl.StageFromReader("/base", bytes.NewReader([]byte{1})))
+		require.Nil(t, ctl.MakeCommit("base"))
+		require.Nil(t, ctl.BranchMake("dev", ""))
+		require.Nil(t, ctl.BranchSwitch("dev", false))
+
+		require.Nil(t, ctl.StageFromReader("/dev_file", bytes.NewReader([]byte{2})))
+		require.Nil(t, ctl.MakeCommit("dev"))
+
+		require.Nil(t, ctl.BranchSwitch("master", false))
+		_, err := ctl.Stat("/dev_file")
+		require.NotNil(t, err)
+
+		diff, err := ctl.Merge("dev")
+		require.Nil(t, err, stringify(err))
+		require.Len(t, diff.Added, 1)
+		require.Equal(t, "/dev_file", diff.Added[0].Path)
+
+		branches, err := ctl.BranchList()
+		require.Nil(t, err, stringify(err))
+		require.Len(t, branches, 2)
+		require.Equal(t, "dev", branches[0].Name)
+		require.Equal(t, "user: dev", branches[0].Tip.Msg)
+		require.True(t, branches[1].IsCurrent)
+
+		require.Nil(t, ctl.BranchRemove("dev"))
+	})
+}
+
+func TestBundle(t *testing.T) {
+	withDaemonPair(t, "ali", "bob", func(aliCtl, bobCtl *Client) {
+		err := aliCtl.StageFromReader("/ali_file", bytes.NewReader([]byte{42}))
+		require.Nil(t, err, stringify(err))
+
+		bundleDir, err := ioutil.TempDir("", "brig-client-bundle")
+		require.Nil(t, err)
+		defer os.RemoveAll(bundleDir)
+
+		bundlePath := filepath.Join(bundleDir, "ali.brigbundle")
+		info, err := aliCtl.BundleCreate(bundlePath, "bob", "", true)
+		require.Nil(t, err, stringify(err))
+		require.Equal(t, "ali", info.Owner)
+		require.Equal(t, "bob", info.Remote)
+		require.Equal(t, 1, info.NBlobs)
+
+		// Refuse to overwrite existing bundles:
+		_, err = aliCtl.BundleCreate(bundlePath, "bob", "", true)
+		require.NotNil(t, err)
+
+		// ali does not know herself as remote:
+		_, _, err = aliCtl.BundleApply(bundlePath, false)
+		require.NotNil(t, err)
+
+		info, diff, err := bobCtl.BundleApply(bundlePath, false)
+		require.Nil(t, err, stringify(err))
+		require.Equal(t, "ali", info.Owner)
+		require.Len(t, diff.Added, 1)
+		require.Equal(t, "/ali_file", diff.Added[0].Path)
+
+		aliFileStat, err := bobCtl.Stat("/ali_file")
+		require.Nil(t, err, stringify(err))
+		require.Equal(t, "/ali_file", aliFileStat.Path)
+	})
+}
+
+func TestRevertAndCherryPick(t *testing.T) {
+	withDaemonPair(t, "ali", "bob", func(aliCtl, bobCtl *Client) {
+		require.Nil(t, aliCtl.StageFromReader("/skipped", bytes.NewReader([]byte{1})))
+		require.Nil(t, aliCtl.MakeCommit("skip me"))
+		require.Nil(t, aliCtl.StageFromReader("/picked", bytes.NewReader([]byte{2})))
+		require.Nil(t, aliCtl.MakeCommit("pick me"))
+
+		conflicts, diff, err := bobCtl.CherryPick("ali", "HEAD", true)
+		require.Nil(t, err, stringify(err))
+		require.Empty(t, conflicts)
+		require.Len(t, diff.Added, 1)
+		require.Equal(t, "/picked", diff.Added[0].Path)
+
+		_, err = bobCtl.Stat("/skipped")
+		require.NotNil(t, err)
+
+		conflicts, diff, err = aliCtl.Revert("HEAD")
+		require.Nil(t, err, stringify(err))
+		require.Empty(t, conflicts)
+		require.Len(t, diff.Removed, 1)
+		require.Equal(t, "/picked", diff.Removed[0].Path)
+	})
+}
+
+func TestStash(t *testing.T) {
+	withDaemon(t, "ali", func(ctl *Client) {
+		require.Nil(t, ctl.StageFromReader("/wip", bytes.NewReader([]byte{1})))
+		require.Nil(t, ctl.StashPush("wip"))
+
+		_, err := ctl.Stat("/wip")
+		require.NotNil(t, err)
+
+		stashes, err := ctl.StashList()
+		require.Nil(t, err, stringify(err))
+		require.Len(t, stashes, 1)
+		require.Equal(t, "wip", stashes[0].Msg)
+
+		conflicts, err := ctl.StashPop(0)
+		require.Nil(t, err, stringify(err))
+		require.Empty(t, conflicts)
+
+		_, err = ctl.Stat("/wip")
+		require.Nil(t, err, stringify(err))
+		require.NotNil(t, ctl.StashDrop(0))
+	})
+}
+
+func TestBackup(t *testing.T) {
+	withDaemonPair(t, "ali", "bob", func(aliCtl, bobCtl *Client) {
+		require.Nil(t, aliCtl.StageFromReader("/first", bytes.NewReader([]byte{1})))
+
+		backupDir, err := ioutil.TempDir("", "brig-client-backup")
+		require.Nil(t, err)
+		defer os.RemoveAll(backupDir)
+
+		fullPath := filepath.Join(backupDir, "full.brigbackup")
+		full, err := aliCtl.BackupCreate(fullPath, "secret", "", true)
+		require.Nil(t, err, stringify(err))
+		require.Equal(t, "ali", full.Owner)
+		require.Empty(t, full.Parent)
+		require.Equal(t, 1, full.NBlobs)
+
+		require.Nil(t, aliCtl.StageFromReader("/second", bytes.NewReader([]byte{2})))
+		incrPath := filepath.Join(backupDir, "incr.brigbackup")
+		incr, err := aliCtl.BackupCreate(incrPath, "secret", fullPath, true)
+		require.Nil(t, err, stringify(err))
+		require.Equal(t, full.ID, incr.Parent)
+		require.Equal(t, 1, incr.NBlobs)
+		require.Equal(t, 1, incr.NInherited)
+
+		// Backups only fit to repos of the same owner:
+		_, err = bobCtl.BackupRestore(fullPath, "secret")
+		require.NotNil(t, err)
+
+		withDaemon(t, "ali", func(newCtl *Client) {
+			_, err := newCtl.BackupRestore(fullPath, "wrong")
+			require.NotNil(t, err)
+
+			// The content of the full backup is still missing:
+			_, err = newCtl.BackupRestore(incrPath, "secret")
+			require.NotNil(t, err)
+
+			_, err = newCtl.BackupRestore(fullPath, "secret")
+			require.Nil(t, err, stringify(err))
+			info, err := newCtl.BackupRestore(incrPath, "secret")
+			require.Nil(t, err, stringify(err))
+			require.Equal(t, incr.ID, info.ID)
+
+			for path, expect := range map[string][]byte{"/first": {1}, "/second": {2}} {
+				stream, err := newCtl.Cat(path, false)
+				require.Nil(t, err, stringify(err))
+				data, err := ioutil.ReadAll(stream)
+				require.Nil(t, err)
+				require.Nil(t, stream.Close())
+				require.Equal(t, expect, data)
+			}
+
+			remotes, err := newCtl.RemoteLs()
+			require.Nil(t, err)
+			require.Len(t, remotes, 1)
+			require.Equal(t, "bob", remotes[0].Name)
+		})
+	})
+}
+
+func TestPasswd(t *testing.T) {
+	withDaemon(t, "ali", func(ctl *Client) {
+		require.NotNil(t, ctl.Passwd("wrong", "new-pass", "", true))
+		require.Nil(t, ctl.Passwd("no-pass", "new-pass", "", true))
+		require.NotNil(t, ctl.Passwd("no-pass", "other-pass", "", true))
+		require.Nil(t, ctl.Passwd("new-pass", "no-pass", "", false))
+	})
+}
+
+func TestMultipleRepos(t *testing.T) {
+	withDaemon(t, "ali", func(aliCtl *Client) {
+		bobPath, err := ioutil.TempDir("", "brig-client-repo")
+		require.Nil(t, err)
+		defer os.RemoveAll(bobPath)
+
+		err = repo.Init(bobPath, "bob", "no-pass", "mock", int64(util.FindFreePort()))
+		require.Nil(t, err, stringify(err))
+
+		addr := aliCtl.RemoteAddr().(*net.TCPAddr)
+		bobCtl, err := Dial(context.Background(), addr.Port)
+		require.Nil(t, err)
+		defer bobCtl.Close()
+
+		require.NotNil(t, bobCtl.RepoSelect(bobPath, "wrong"))
+		require.Nil(t, bobCtl.RepoSelect(bobPath, "no-pass"))
+
+		whoami, err := bobCtl.Whoami()
+		require.Nil(t, err, stringify(err))
+		require.Equal(t, "bob", whoami.Owner)
+
+		require.Nil(t, bobCtl.StageFromReader("/bob_file", bytes.NewReader([]byte{23})))
+		_, err = bobCtl.Stat("/bob_file")
+		require.Nil(t, err, stringify(err))
+		_, err = aliCtl.Stat("/bob_file")
+		require.NotNil(t, err)
+
+		infos, err := aliCtl.RepoList()
+		require.Nil(t, err, stringify(err))
+		require.Len(t, infos, 2)
+		require.Equal(t, "ali", infos[0].Owner)
+		require.True(t, infos[0].IsPrimary)
+		require.Equal(t, RepoInfo{Path: bobPath, Owner: "bob"}, infos[1])
+
+		// The primary repository can only be closed by quitting:
+		require.NotNil(t, aliCtl.RepoClose(infos[0].Path))
+		require.Nil(t, aliCtl.RepoClose(bobPath))
+
+		infos, err = aliCtl.RepoList()
+		require.Nil(t, err, stringify(err))
+		require.Len(t, infos, 1)
+
+		// Selecting it again loads the repository again;
+		// it is closed together with the daemon this time.
+		require.Nil(t, aliCtl.RepoSelect(bobPath, "no-pass"))
+		_, err = aliCtl.Stat("/bob_file")
+		require.Nil(t, err, stringify(err))
+	})
+}
+
+func TestMultipleReposFailedLoad(t *testing.T) {
+	withDaemon(t, "ali", func(aliCtl *Client) {
+		bobPath, err := ioutil.TempDir("", "brig-client-repo")
+		require.Nil(t, err)
+		defer os.RemoveAll(bobPath)
+
+		err = repo.Init(bobPath, "bob", "no-pass", "mock", int64(util.FindFreePort()))
+		require.Nil(t, err, stringify(err))
+
+		// The stage index cannot be opened if there is a file in its place.
+		// It is loaded after the repository, the mounts and the mirrors:
+		stageIdxPath := filepath.Join(bobPath, "stage-index")
+		require.Nil(t, ioutil.WriteFile(stageIdxPath, []byte("in the way"), 0600))
+		require.NotNil(t, aliCtl.RepoSelect(bobPath, "no-pass"))
+
+		infos, err := aliCtl.RepoList()
+		require.Nil(t, err, stringify(err))
+		require.Len(t, infos, 1)
+
+		// The repository should have been locked again:
+		_, err = os.Stat(stageIdxPath + repo.LockPathSuffix)
+		require.Nil(t, err)
+
+		// Nothing of the failed attempt should be left open:
+		require.Nil(t, os.Remove(stageIdxPath+repo.LockPathSuffix))
+		require.Nil(t, aliCtl.RepoSelect(bobPath, "no-pass"))
+
+		whoami, err := aliCtl.Whoami()
+		require.Nil(t, err, stringify(err))
+		require.Equal(t, "bob", whoami.Owner)
+	})
+}
+
+func TestBlame(t *testing.T) {
+	withDaemon(t, "ali", func(ctl *Client) {
+		require.Nil(t, ctl.StageFromReader("/x", bytes.NewReader([]byte("a\nb\n"))))
+		require.Nil(t, ctl.MakeCommit("first"))
+		require.Nil(t, ctl.StageFromReader("/x", bytes.NewReader([]byte("a\nc\n"))))
+
+		blame, err := ctl.Blame("/x")
+		require.Nil(t, err, stringify(err))
+		require.False(t, blame.IsBinary)
+		require.Len(t, blame.Versions, 2)
+		require.Equal(t, "ali", blame.Versions[0].Author)
+		require.Equal(t, "user: first", blame.Versions[1].Commit.Msg)
+		require.Equal(t, []BlameLine{
+			{Text: "a", Version: 1},
+			{Text: "c", Version: 0},
+		}, blame.Lines)
+	})
+}
+
+func TestFind(t *testing.T) {
+	withDaemon(t, "ali", func(ctl *Client) {
+		require.Nil(t, ctl.StageFromReader("/photos/cat.jpg", bytes.NewReader([]byte("meow"))))
+		require.Nil(t, ctl.StageFromReader("/notes.txt", bytes.NewReader([]byte("hello"))))
+		require.Nil(t, ctl.MakeCommit("first"))
+		require.Nil(t, ctl.Remove("/notes.txt"))
+
+		results, err := ctl.Find("/", "mime:image/*", FindOptions{})
+		require.Nil(t, err, stringify(err))
+		require.Len(t, results, 1)
+		require.Equal(t, "/photos/cat.jpg", results[0].Path)
+		require.Nil(t, results[0].Commit)
+
+		results, err = ctl.Find("/", "name:*.txt", FindOptions{})
+		require.Nil(t, err, stringify(err))
+		require.Len(t, results, 0)
+
+		results, err = ctl.Find("/", "name:*.txt", FindOptions{History: true})
+		require.Nil(t, err, stringify(err))
+		require.Len(t, results, 1)
+		require.Equal(t, "/notes.txt", results[0].Path)
+		require.NotNil(t, results[0].Commit)
+
+		_, err = ctl.Find("/", "size:", FindOptions{})
+		require.NotNil(t, err)
+	})
+}
+
+func TestDupes(t *testing.T) {
+	withDaemon(t, "ali", func(ctl *Client) {
+		data := []byte("hello")
+		require.Nil(t, ctl.StageFromReader("/a", bytes.NewReader(data)))
+		require.Nil(t, ctl.StageFromReader("/b", bytes.NewReader([]byte("world"))))
+		require.Nil(t, ctl.StageFromReader("/b", bytes.NewReader(data)))
+
+		groups, err := ctl.Dupes("/", false)
+		require.Nil(t, err, stringify(err))
+		require.Len(t, groups, 1)
+		require.Len(t, groups[0].Files, 2)
+		require.Equal(t, "/a", groups[0].Files[0].Path)
+		require.Equal(t, 2, groups[0].Blobs)
+		require.Equal(t, uint64(len(data)), groups[0].WastedBytes)
+
+		changes, err := ctl.Dedupe("/", "link", false)
+		require.Nil(t, err, stringify(err))
+		require.Len(t, changes, 1)
+		require.Equal(t, "/b", changes[0].Path)
+		require.Equal(t, "/a", changes[0].KeptPath)
+
+		groups, err = ctl.Dupes("/", false)
+		require.Nil(t, err, stringify(err))
+		require.Equal(t, 1, groups[0].Blobs)
+
+		_, err = ctl.Dedupe("/", "delete", false)
+		require.NotNil(t, err)
+	})
+}
@@ -1,6 +1,8 @@
 package client
 
 import (
+	"time"
+
 	gwdb "github.com/sahib/brig/gateway/db"
 	"github.com/sahib/brig/server/capnp"
 	h "github.com/sahib/brig/util/hashlib"
@@ -34,9 +36,11 @@ func (ctl *Client) Ping() error {
 
 // MountOptions holds the possible option for a single mount.
 type MountOptions struct {
-	ReadOnly bool
-	RootPath string
-	Offline  bool
+	ReadOnly  bool
+	RootPath  string
+	Offline   bool
+	Rev       string
+	Snapshots bool
 }
 
 func mountOptionsToCapnp(opts MountOptions, seg *capnplib.Segment) (*capnp.MountOptions, error) {
@@ -47,10 +51,15 @@ func mountOptionsToCapnp(opts MountOptions, seg *capnplib.Segment) (*capnp.Mount
 
 	capOpts.SetReadOnly(opts.ReadOnly)
 	capOpts.SetOffline(opts.Offline)
+	capOpts.SetSnapshots(opts.Snapshots)
 	if err := capOpts.SetRootPath(opts.RootPath); err != nil {
 		return nil, err
 	}
 
+	if err := capOpts.SetRev(opts.Rev); err != nil {
+		return nil, err
+	}
+
 	return &capOpts, nil
 }
 
@@ -309,12 +318,14 @@ func (ctl *Client) FstabUnmountAll() error {
 
 // FsTabEntry describes a single entry in the filesystem tab
 type FsTabEntry struct {
-	Name     string
-	Path     string
-	Root     string
-	Active   bool
-	ReadOnly bool
-	Offline  bool
+	Name      string
+	Path      string
+	Root      string
+	Active    bool
+	ReadOnly  bool
+	Offline   bool
+	Rev       string
+	Snapshots bool
 }
 
 func capMountToMount(capEntry capnp.FsTabEntry) (*FsTabEntry, error) {
@@ -333,13 +344,20 @@ func capMountToMount(capEntry capnp.FsTabEntry) (*FsTabEntry, error) {
 		return nil, err
 	}
 
+	rev, err := capEntry.Rev()
+	if err != nil {
+		return nil, err
+	}
+
 	return &FsTabEntry{
-		Path:     path,
-		Name:     name,
-		Root:     root,
-		Active:   capEntry.Active(),
-		ReadOnly: capEntry.ReadOnly(),
-		Offline:  capEntry.Offline(),
+		Path:      path,
+		Name:      name,
+		Root:      root,
+		Active:    capEntry.Active(),
+		ReadOnly:  capEntry.ReadOnly(),
+		Offline:   capEntry.Offline(),
+		Rev:       rev,
+		Snapshots: capEntry.Snapshots(),
 	}, nil
 }
 
@@ -373,6 +391,70 @@ func (ctl *Client) FsTabList() ([]FsTabEntry, error) {
 	return mounts, nil
 }
 
+// FsckProblem is a single inconsistency found by Fsck.
+type FsckProblem struct {
+	Kind     string
+	Path     string
+	Commit   h.Hash
+	Message  string
+	Repaired bool
+}
+
+// Fsck checks the metadata of the repository for consistency.
+// If `repair` is true, fixable problems are fixed. If `checkContent` is
+// true, the content of every file is fetched and decrypted as well.
+func (ctl *Client) Fsck(repair, checkContent bool) ([]FsckProblem, error) {
+	call := ctl.api.Fsck(ctl.ctx, func(p capnp.FS_fsck_Params) error {
+		p.SetRepair(repair)
+		p.SetCheckContent(checkContent)
+		return nil
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, err
+	}
+
+	capProblems, err := result.Problems()
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []FsckProblem{}
+	for idx := 0; idx < capProblems.Len(); idx++ {
+		capProblem := capProblems.At(idx)
+		problem := FsckProblem{Repaired: capProblem.Repaired()}
+
+		problem.Kind, err = capProblem.Kind()
+		if err != nil {
+			return nil, err
+		}
+
+		problem.Path, err = capProblem.Path()
+		if err != nil {
+			return nil, err
+		}
+
+		commit, err := capProblem.Commit()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(commit) > 0 {
+			problem.Commit = h.Hash(commit)
+		}
+
+		problem.Message, err = capProblem.Message()
+		if err != nil {
+			return nil, err
+		}
+
+		problems = append(problems, problem)
+	}
+
+	return problems, nil
+}
+
 // GarbageItem is a single path that was reaped by the garbage collector.
 type GarbageItem struct {
 	Path    string
@@ -560,3 +642,525 @@ func (ctl *Client) DebugProfilePort() (int, error) {
 
 	return int(result.Port()), nil
 }
+
+// BlockCacheStats gives information about the usage of the block cache.
+type BlockCacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Blocks    int64
+	Size      int64
+	MaxSize   int64
+}
+
+// DebugCacheStats returns the usage statistics of the block cache.
+func (ctl *Client) DebugCacheStats() (*BlockCacheStats, error) {
+	call := ctl.api.DebugCacheStats(ctl.ctx, func(p capnp.Repo_debugCacheStats_Params) error {
+		return nil
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, err
+	}
+
+	capStats, err := result.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	return &BlockCacheStats{
+		Hits:      capStats.Hits(),
+		Misses:    capStats.Misses(),
+		Evictions: capStats.Evictions(),
+		Blocks:    capStats.Blocks(),
+		Size:      capStats.Size(),
+		MaxSize:   capStats.MaxSize(),
+	}, nil
+}
+
+// MigrationStep is a single step of a metadata migration.
+type MigrationStep struct {
+	From        int
+	To          int
+	Description string
+}
+
+// MigrationResult describes what a metadata migration did.
+type MigrationResult struct {
+	Version      int
+	Target       int
+	Steps        []MigrationStep
+	SnapshotPath string
+}
+
+// DebugMigrate migrates the metadata of the current filesystem to the
+// version supported by the daemon. If `dryRun` is true, nothing is changed.
+func (ctl *Client) DebugMigrate(dryRun bool) (*MigrationResult, error) {
+	call := ctl.api.DebugMigrate(ctl.ctx, func(p capnp.Repo_debugMigrate_Params) error {
+		p.SetDryRun(dryRun)
+		return nil
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, err
+	}
+
+	capResult, err := result.Result()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotPath, err := capResult.SnapshotPath()
+	if err != nil {
+		return nil, err
+	}
+
+	capSteps, err := capResult.Steps()
+	if err != nil {
+		return nil, err
+	}
+
+	steps := []MigrationStep{}
+	for idx := 0; idx < capSteps.Len(); idx++ {
+		capStep := capSteps.At(idx)
+		description, err := capStep.Description()
+		if err != nil {
+			return nil, err
+		}
+
+		steps = append(steps, MigrationStep{
+			From:        int(capStep.From()),
+			To:          int(capStep.To()),
+			Description: description,
+		})
+	}
+
+	return &MigrationResult{
+		Version:      int(capResult.Version()),
+		Target:       int(capResult.Target()),
+		Steps:        steps,
+		SnapshotPath: snapshotPath,
+	}, nil
+}
+
+// DebugConvertDb selects `to` as key/value store for the metadata and
+// converts the metadata to it. The previously selected store is returned.
+func (ctl *Client) DebugConvertDb(to string) (string, error) {
+	call := ctl.api.DebugConvertDb(ctl.ctx, func(p capnp.Repo_debugConvertDb_Params) error {
+		return p.SetTo(to)
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return "", err
+	}
+
+	return result.From()
+}
+
+// GatewayAuditEntry is a single entry of the gateway audit log.
+type GatewayAuditEntry struct {
+	Time     time.Time
+	User     string
+	Action   string
+	Paths    []string
+	IP       string
+	Status   int
+	BytesIn  int64
+	BytesOut int64
+}
+
+func auditEntryFromCapnp(capEntry capnp.AuditEntry) (*GatewayAuditEntry, error) {
+	entry := &GatewayAuditEntry{}
+
+	timeStr, err := capEntry.Time()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := entry.Time.UnmarshalText([]byte(timeStr)); err != nil {
+		return nil, err
+	}
+
+	entry.User, err = capEntry.User()
+	if err != nil {
+		return nil, err
+	}
+
+	entry.Action, err = capEntry.Action()
+	if err != nil {
+		return nil, err
+	}
+
+	entry.IP, err = capEntry.Ip()
+	if err != nil {
+		return nil, err
+	}
+
+	capPaths, err := capEntry.Paths()
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := 0; idx < capPaths.Len(); idx++ {
+		path, err := capPaths.At(idx)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.Paths = append(entry.Paths, path)
+	}
+
+	entry.Status = int(capEntry.Status())
+	entry.BytesIn = capEntry.BytesIn()
+	entry.BytesOut = capEntry.BytesOut()
+	return entry, nil
+}
+
+// GatewayAudit queries the audit log of the gateway.
+// Empty `user` and `path` and a zero `since` match everything.
+// If `limit` is > 0, only the last `limit` entries are returned.
+func (ctl *Client) GatewayAudit(user, path string, since time.Time, limit int) ([]GatewayAuditEntry, error) {
+	call := ctl.api.GatewayAudit(ctl.ctx, func(p capnp.Repo_gatewayAudit_Params) error {
+		if err := p.SetUser(user); err != nil {
+			return err
+		}
+
+		if err := p.SetPath(path); err != nil {
+			return err
+		}
+
+		if !since.IsZero() {
+			sinceData, err := since.MarshalText()
+			if err != nil {
+				return err
+			}
+
+			if err := p.SetSince(string(sinceData)); err != nil {
+				return err
+			}
+		}
+
+		p.SetLimit(int32(limit))
+		return nil
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, err
+	}
+
+	capEntries, err := result.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []GatewayAuditEntry{}
+	for idx := 0; idx < capEntries.Len(); idx++ {
+		entry, err := auditEntryFromCapnp(capEntries.At(idx))
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, *entry)
+	}
+
+	return entries, nil
+}
+
+// BackupInfo describes a repository backup.
+type BackupInfo struct {
+	ID         string
+	Owner      string
+	Parent     string
+	Created    time.Time
+	NBlobs     int
+	NInherited int
+}
+
+func convertCapBackupInfo(capInfo capnp.BackupInfo) (*BackupInfo, error) {
+	id, err := capInfo.Id()
+	if err != nil {
+		return nil, err
+	}
+
+	owner, err := capInfo.Owner()
+	if err != nil {
+		return nil, err
+	}
+
+	parent, err := capInfo.Parent()
+	if err != nil {
+		return nil, err
+	}
+
+	createdStr, err := capInfo.Created()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &BackupInfo{
+		ID:         id,
+		Owner:      owner,
+		Parent:     parent,
+		NBlobs:     int(capInfo.NBlobs()),
+		NInherited: int(capInfo.NInherited()),
+	}
+
+	if err := info.Created.UnmarshalText([]byte(createdStr)); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// BackupCreate writes a backup of the whole repository, protected by
+// `password`, to `path`. If `withContent` is true, all pinned content
+// is included. If `since` is the path of an older backup, only content
+// that is not part of it is included.
+func (ctl *Client) BackupCreate(path, password, since string, withContent bool) (*BackupInfo, error) {
+	call := ctl.api.BackupCreate(ctl.ctx, func(p capnp.Repo_backupCreate_Params) error {
+		p.SetWithContent(withContent)
+		if err := p.SetPassword(password); err != nil {
+			return err
+		}
+
+		if err := p.SetSince(since); err != nil {
+			return err
+		}
+
+		return p.SetPath(path)
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, err
+	}
+
+	capInfo, err := result.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	return convertCapBackupInfo(capInfo)
+}
+
+// BackupRestore restores the backup at `path` into the repository.
+// A restart of the daemon is needed to pick up the restored keys.
+func (ctl *Client) BackupRestore(path, password string) (*BackupInfo, error) {
+	call := ctl.api.BackupRestore(ctl.ctx, func(p capnp.Repo_backupRestore_Params) error {
+		if err := p.SetPassword(password); err != nil {
+			return err
+		}
+
+		return p.SetPath(path)
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, err
+	}
+
+	capInfo, err := result.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	return convertCapBackupInfo(capInfo)
+}
+
+// Passwd changes the repository password from `oldPassword` to `newPassword`.
+// If `keepKeyfile` is true, the current keyfile (if any) stays in use.
+// Otherwise `keyfile` is used from now on; an empty one disables it.
+func (ctl *Client) Passwd(oldPassword, newPassword, keyfile string, keepKeyfile bool) error {
+	call := ctl.api.Passwd(ctl.ctx, func(p capnp.Repo_passwd_Params) error {
+		p.SetKeepKeyfile(keepKeyfile)
+		if err := p.SetOldPassword(oldPassword); err != nil {
+			return err
+		}
+
+		if err := p.SetNewPassword(newPassword); err != nil {
+			return err
+		}
+
+		return p.SetKeyfile(keyfile)
+	})
+
+	_, err := call.Struct()
+	return err
+}
+
+// RepoInfo describes a repository served by the daemon.
+type RepoInfo struct {
+	Path      string
+	Owner     string
+	IsPrimary bool
+}
+
+// RepoSelect makes all further calls of this client go to the repository
+// at `path`. The daemon loads it if it does not serve it yet, in which case
+// `password` is used unless the repository has a password command.
+func (ctl *Client) RepoSelect(path, password string) error {
+	call := ctl.api.RepoSelect(ctl.ctx, func(p capnp.Repo_repoSelect_Params) error {
+		if err := p.SetPath(path); err != nil {
+			return err
+		}
+
+		return p.SetPassword(password)
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return err
+	}
+
+	ctl.api = result.Api()
+	return nil
+}
+
+// RepoList lists all repositories served by the daemon.
+// The repository the daemon was started with comes first.
+func (ctl *Client) RepoList() ([]RepoInfo, error) {
+	call := ctl.api.RepoList(ctl.ctx, func(p capnp.Repo_repoList_Params) error {
+		return nil
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, err
+	}
+
+	capInfos, err := result.Repos()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []RepoInfo{}
+	for idx := 0; idx < capInfos.Len(); idx++ {
+		capInfo := capInfos.At(idx)
+		path, err := capInfo.Path()
+		if err != nil {
+			return nil, err
+		}
+
+		owner, err := capInfo.Owner()
+		if err != nil {
+			return nil, err
+		}
+
+		infos = append(infos, RepoInfo{
+			Path:      path,
+			Owner:     owner,
+			IsPrimary: capInfo.IsPrimary(),
+		})
+	}
+
+	return infos, nil
+}
+
+// RepoClose stops serving the repository at `path`.
+// The repository the daemon was started with cannot be closed.
+func (ctl *Client) RepoClose(path string) error {
+	call := ctl.api.RepoClose(ctl.ctx, func(p capnp.Repo_repoClose_Params) error {
+		return p.SetPath(path)
+	})
+
+	_, err := call.Struct()
+	return err
+}
+
+// MirrorEntry describes a local directory mirrored to the repository.
+type MirrorEntry struct {
+	Name      string
+	LocalPath string
+	RepoPath  string
+	Active    bool
+}
+
+// MirrorAdd starts mirroring `localPath` to `repoPath` under `name`.
+// The mirror is remembered and started again with the daemon.
+func (ctl *Client) MirrorAdd(name, localPath, repoPath string) error {
+	call := ctl.api.MirrorAdd(ctl.ctx, func(p capnp.Repo_mirrorAdd_Params) error {
+		if err := p.SetName(name); err != nil {
+			return err
+		}
+
+		if err := p.SetLocalPath(localPath); err != nil {
+			return err
+		}
+
+		return p.SetRepoPath(repoPath)
+	})
+
+	_, err := call.Struct()
+	return err
+}
+
+// MirrorRemove stops the mirror `name` and forgets about it.
+// No files are removed on either side.
+func (ctl *Client) MirrorRemove(name string) error {
+	call := ctl.api.MirrorRemove(ctl.ctx, func(p capnp.Repo_mirrorRemove_Params) error {
+		return p.SetName(name)
+	})
+
+	_, err := call.Struct()
+	return err
+}
+
+// MirrorList lists all configured mirrors.
+func (ctl *Client) MirrorList() ([]MirrorEntry, error) {
+	call := ctl.api.MirrorList(ctl.ctx, func(p capnp.Repo_mirrorList_Params) error {
+		return nil
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, err
+	}
+
+	capEntries, err := result.Mirrors()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []MirrorEntry{}
+	for idx := 0; idx < capEntries.Len(); idx++ {
+		capEntry := capEntries.At(idx)
+		name, err := capEntry.Name()
+		if err != nil {
+			return nil, err
+		}
+
+		localPath, err := capEntry.LocalPath()
+		if err != nil {
+			return nil, err
+		}
+
+		repoPath, err := capEntry.RepoPath()
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, MirrorEntry{
+			Name:      name,
+			LocalPath: localPath,
+			RepoPath:  repoPath,
+			Active:    capEntry.Active(),
+		})
+	}
+
+	return entries, nil
+}
+
+// MirrorSync reconciles the mirror `name` right away,
+// instead of waiting for the next change or rescan.
+func (ctl *Client) MirrorSync(name string) error {
+	call := ctl.api.MirrorSync(ctl.ctx, func(p capnp.Repo_mirrorSync_Params) error {
+		return p.SetName(name)
+	})
+
+	_, err := call.Struct()
+	return err
+}
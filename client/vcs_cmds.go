@@ -6,6 +6,7 @@ import (
 
 	"github.com/sahib/brig/server/capnp"
 	h "github.com/sahib/brig/util/hashlib"
+	capnplib "zombiezen.com/go/capnproto2"
 )
 
 // MakeCommit creates a new commit from the current staging area.
@@ -429,8 +430,18 @@ func (ctl *Client) Fetch(remote string) error {
 // Sync triggers a sync with the data from `remote`.
 // If `needFetch` is true, the data is first updated from the remote.
 func (ctl *Client) Sync(remote string, needFetch bool) (*Diff, error) {
+	return ctl.SyncBranch(remote, "", needFetch)
+}
+
+// SyncBranch is like Sync, but syncs with the branch called `branch`
+// of `remote` instead of its current state.
+func (ctl *Client) SyncBranch(remote, branch string, needFetch bool) (*Diff, error) {
 	call := ctl.api.Sync(ctl.ctx, func(p capnp.VCS_sync_Params) error {
 		p.SetNeedFetch(needFetch)
+		if err := p.SetBranch(branch); err != nil {
+			return err
+		}
+
 		return p.SetWithWhom(remote)
 	})
 
@@ -474,3 +485,499 @@ func (ctl *Client) CommitInfo(rev string) (bool, *Commit, error) {
 
 	return true, cmt, nil
 }
+
+// Branch is a named line of development.
+type Branch struct {
+	Name      string
+	Tip       *Commit
+	IsCurrent bool
+}
+
+// BranchList returns all branches, sorted by name.
+func (ctl *Client) BranchList() ([]Branch, error) {
+	call := ctl.api.BranchList(ctl.ctx, func(p capnp.VCS_branchList_Params) error {
+		return nil
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, err
+	}
+
+	capBranches, err := result.Branches()
+	if err != nil {
+		return nil, err
+	}
+
+	branches := []Branch{}
+	for idx := 0; idx < capBranches.Len(); idx++ {
+		capBranch := capBranches.At(idx)
+		name, err := capBranch.Name()
+		if err != nil {
+			return nil, err
+		}
+
+		capTip, err := capBranch.Tip()
+		if err != nil {
+			return nil, err
+		}
+
+		tip, err := convertCapCommit(&capTip)
+		if err != nil {
+			return nil, err
+		}
+
+		branches = append(branches, Branch{
+			Name:      name,
+			Tip:       tip,
+			IsCurrent: capBranch.IsCurrent(),
+		})
+	}
+
+	return branches, nil
+}
+
+// BranchMake creates a new branch called `name` starting at `rev`.
+// If `rev` is empty, HEAD is used.
+func (ctl *Client) BranchMake(name, rev string) error {
+	call := ctl.api.BranchMake(ctl.ctx, func(p capnp.VCS_branchMake_Params) error {
+		if err := p.SetName(name); err != nil {
+			return err
+		}
+
+		return p.SetRev(rev)
+	})
+
+	_, err := call.Struct()
+	return err
+}
+
+// BranchRemove removes the branch called `name`.
+func (ctl *Client) BranchRemove(name string) error {
+	call := ctl.api.BranchRemove(ctl.ctx, func(p capnp.VCS_branchRemove_Params) error {
+		return p.SetName(name)
+	})
+
+	_, err := call.Struct()
+	return err
+}
+
+// BranchSwitch makes `name` the current branch.
+// If `force` is true, uncommitted changes are thrown away.
+func (ctl *Client) BranchSwitch(name string, force bool) error {
+	call := ctl.api.BranchSwitch(ctl.ctx, func(p capnp.VCS_branchSwitch_Params) error {
+		p.SetForce(force)
+		return p.SetName(name)
+	})
+
+	_, err := call.Struct()
+	return err
+}
+
+// Merge merges the branch called `branch` into the current one.
+// The returned diff describes what changed by the merge.
+func (ctl *Client) Merge(branch string) (*Diff, error) {
+	call := ctl.api.Merge(ctl.ctx, func(p capnp.VCS_merge_Params) error {
+		return p.SetBranch(branch)
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, err
+	}
+
+	capDiff, err := result.Diff()
+	if err != nil {
+		return nil, err
+	}
+
+	return convertCapDiffToDiff(capDiff)
+}
+
+// BundleInfo describes an offline bundle.
+type BundleInfo struct {
+	Owner   string
+	Remote  string
+	Since   string
+	Created time.Time
+	NBlobs  int
+}
+
+func convertCapBundleInfo(capInfo capnp.BundleInfo) (*BundleInfo, error) {
+	owner, err := capInfo.Owner()
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := capInfo.Remote()
+	if err != nil {
+		return nil, err
+	}
+
+	since, err := capInfo.Since()
+	if err != nil {
+		return nil, err
+	}
+
+	createdStr, err := capInfo.Created()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &BundleInfo{
+		Owner:  owner,
+		Remote: remote,
+		Since:  since,
+		NBlobs: int(capInfo.NBlobs()),
+	}
+
+	if err := info.Created.UnmarshalText([]byte(createdStr)); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// BundleCreate writes all changes since `since` that `remote` may see
+// to a bundle file at `path`. If `withContent` is true, the bundle
+// also contains the content of all added or modified files.
+func (ctl *Client) BundleCreate(path, remote, since string, withContent bool) (*BundleInfo, error) {
+	call := ctl.api.BundleCreate(ctl.ctx, func(p capnp.VCS_bundleCreate_Params) error {
+		p.SetWithContent(withContent)
+		if err := p.SetRemote(remote); err != nil {
+			return err
+		}
+
+		if err := p.SetSince(since); err != nil {
+			return err
+		}
+
+		return p.SetPath(path)
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, err
+	}
+
+	capInfo, err := result.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	return convertCapBundleInfo(capInfo)
+}
+
+// BundleApply verifies and imports the bundle at `path`.
+// Unless `noSync` is true, the changes are synced into our own state
+// and the diff of this sync is returned; otherwise the diff is nil.
+func (ctl *Client) BundleApply(path string, noSync bool) (*BundleInfo, *Diff, error) {
+	call := ctl.api.BundleApply(ctl.ctx, func(p capnp.VCS_bundleApply_Params) error {
+		p.SetNoSync(noSync)
+		return p.SetPath(path)
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	capInfo, err := result.Info()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := convertCapBundleInfo(capInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !result.HasDiff() {
+		return info, nil, nil
+	}
+
+	capDiff, err := result.Diff()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	diff, err := convertCapDiffToDiff(capDiff)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return info, diff, nil
+}
+
+func textListToStrings(lst capnplib.TextList) ([]string, error) {
+	strs := []string{}
+	for idx := 0; idx < lst.Len(); idx++ {
+		str, err := lst.At(idx)
+		if err != nil {
+			return nil, err
+		}
+
+		strs = append(strs, str)
+	}
+
+	return strs, nil
+}
+
+// pickResult is implemented by the results of revert and cherry-pick.
+type pickResult interface {
+	Conflicts() (capnplib.TextList, error)
+	Diff() (capnp.Diff, error)
+}
+
+func convertPickResult(result pickResult) ([]string, *Diff, error) {
+	capConflicts, err := result.Conflicts()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conflicts, err := textListToStrings(capConflicts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	capDiff, err := result.Diff()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	diff, err := convertCapDiffToDiff(capDiff)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return conflicts, diff, nil
+}
+
+// Revert creates a new commit that undoes the changes of the commit `rev`.
+// It returns the paths that had conflicts and the diff of the new commit.
+func (ctl *Client) Revert(rev string) ([]string, *Diff, error) {
+	call := ctl.api.Revert(ctl.ctx, func(p capnp.VCS_revert_Params) error {
+		return p.SetRev(rev)
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return convertPickResult(result)
+}
+
+// CherryPick applies the changes of the commit `rev` of `remote` to our
+// state and commits them. If `needFetch` is true, the metadata of the remote
+// is fetched first. It returns the paths that had conflicts and the diff
+// of the new commit.
+func (ctl *Client) CherryPick(remote, rev string, needFetch bool) ([]string, *Diff, error) {
+	call := ctl.api.CherryPick(ctl.ctx, func(p capnp.VCS_cherryPick_Params) error {
+		p.SetNeedFetch(needFetch)
+		if err := p.SetRemote(remote); err != nil {
+			return err
+		}
+
+		return p.SetRev(rev)
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return convertPickResult(result)
+}
+
+// StashPush saves all staged changes as new stash with `msg`
+// and resets the staging area to HEAD.
+func (ctl *Client) StashPush(msg string) error {
+	call := ctl.api.StashPush(ctl.ctx, func(p capnp.VCS_stashPush_Params) error {
+		return p.SetMsg(msg)
+	})
+
+	_, err := call.Struct()
+	return err
+}
+
+// StashList returns all stashes, newest first.
+func (ctl *Client) StashList() ([]Commit, error) {
+	call := ctl.api.StashList(ctl.ctx, func(p capnp.VCS_stashList_Params) error {
+		return nil
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, err
+	}
+
+	capStashes, err := result.Stashes()
+	if err != nil {
+		return nil, err
+	}
+
+	stashes := []Commit{}
+	for idx := 0; idx < capStashes.Len(); idx++ {
+		capStash := capStashes.At(idx)
+		stash, err := convertCapCommit(&capStash)
+		if err != nil {
+			return nil, err
+		}
+
+		stashes = append(stashes, *stash)
+	}
+
+	return stashes, nil
+}
+
+// StashPop applies the stash at `idx` (0 is the newest) to the staging area.
+// The stash is dropped if there were no conflicts. The conflicting paths are
+// returned.
+func (ctl *Client) StashPop(idx int) ([]string, error) {
+	call := ctl.api.StashPop(ctl.ctx, func(p capnp.VCS_stashPop_Params) error {
+		p.SetIndex(int32(idx))
+		return nil
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, err
+	}
+
+	capConflicts, err := result.Conflicts()
+	if err != nil {
+		return nil, err
+	}
+
+	return textListToStrings(capConflicts)
+}
+
+// StashDrop removes the stash at `idx` (0 is the newest).
+func (ctl *Client) StashDrop(idx int) error {
+	call := ctl.api.StashDrop(ctl.ctx, func(p capnp.VCS_stashDrop_Params) error {
+		p.SetIndex(int32(idx))
+		return nil
+	})
+
+	_, err := call.Struct()
+	return err
+}
+
+// BlameVersion is a version of a file that changed its content.
+type BlameVersion struct {
+	Commit      *Commit
+	Author      string
+	MergedWith  string
+	Path        string
+	Size        uint64
+	ContentHash h.Hash
+}
+
+// BlameLine is a single line of a text file.
+type BlameLine struct {
+	Text string
+	// Version is the index in Blame.Versions.
+	Version int
+}
+
+// Blame tells which version introduced which content of a file.
+type Blame struct {
+	Versions []BlameVersion
+	IsBinary bool
+	Lines    []BlameLine
+}
+
+func convertCapBlameVersion(capVersion capnp.BlameVersion) (*BlameVersion, error) {
+	capCommit, err := capVersion.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := convertCapCommit(&capCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	author, err := capVersion.Author()
+	if err != nil {
+		return nil, err
+	}
+
+	mergedWith, err := capVersion.MergedWith()
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := capVersion.Path()
+	if err != nil {
+		return nil, err
+	}
+
+	contentHash, err := capVersion.ContentHash()
+	if err != nil {
+		return nil, err
+	}
+
+	return &BlameVersion{
+		Commit:      commit,
+		Author:      author,
+		MergedWith:  mergedWith,
+		Path:        path,
+		Size:        capVersion.Size(),
+		ContentHash: contentHash,
+	}, nil
+}
+
+// Blame tells which version last changed each line of the file at `path`.
+// For binary files only the versions are returned.
+func (ctl *Client) Blame(path string) (*Blame, error) {
+	call := ctl.api.Blame(ctl.ctx, func(p capnp.VCS_blame_Params) error {
+		return p.SetPath(path)
+	})
+
+	result, err := call.Struct()
+	if err != nil {
+		return nil, err
+	}
+
+	capBlame, err := result.Blame()
+	if err != nil {
+		return nil, err
+	}
+
+	capVersions, err := capBlame.Versions()
+	if err != nil {
+		return nil, err
+	}
+
+	blame := &Blame{IsBinary: capBlame.IsBinary()}
+	for idx := 0; idx < capVersions.Len(); idx++ {
+		version, err := convertCapBlameVersion(capVersions.At(idx))
+		if err != nil {
+			return nil, err
+		}
+
+		blame.Versions = append(blame.Versions, *version)
+	}
+
+	capLines, err := capBlame.Lines()
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := 0; idx < capLines.Len(); idx++ {
+		capLine := capLines.At(idx)
+		text, err := capLine.Text()
+		if err != nil {
+			return nil, err
+		}
+
+		blame.Lines = append(blame.Lines, BlameLine{
+			Text:    text,
+			Version: int(capLine.Version()),
+		})
+	}
+
+	return blame, nil
+}
@@ -9,10 +9,13 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sahib/brig/cmd/tabwriter"
 	"github.com/sahib/brig/util"
+	h "github.com/sahib/brig/util/hashlib"
+	"github.com/sahib/brig/util/ignore"
 
 	"github.com/dustin/go-humanize"
 	"github.com/fatih/color"
@@ -37,22 +40,8 @@ func handleStage(ctx *cli.Context, ctl *client.Client) error {
 	}
 
 	if readFromStdin {
-		tmpFd, err := ioutil.TempFile("", "-brig.stdin.buffer")
-		if err != nil {
-			return err
-		}
-
-		if _, err := io.Copy(tmpFd, os.Stdin); err != nil {
-			tmpFd.Close()
-			return err
-		}
-
-		if err := tmpFd.Close(); err != nil {
-			return err
-		}
-
-		repoPath = ctx.Args().Get(0)
-		localPath = tmpFd.Name()
+		// The content is streamed to the daemon, no need to buffer it here.
+		return ctl.StageFromReader(ctx.Args().Get(0), os.Stdin)
 	}
 
 	absLocalPath, err := filepath.Abs(localPath)
@@ -73,7 +62,33 @@ func handleStage(ctx *cli.Context, ctl *client.Client) error {
 		fmt.Printf("Not adding non-regular file: %s\n", absLocalPath)
 	}
 
-	return ctl.Stage(absLocalPath, repoPath)
+	if ctx.Bool("dry-run") {
+		fmt.Printf("%s\t%s\n", absLocalPath, repoPath)
+		return nil
+	}
+
+	// Remember the file, so unchanged files are skipped next time:
+	_, err = ctl.StageIncremental(absLocalPath, repoPath, absLocalPath)
+	return err
+}
+
+// stageIgnoreMatcher returns the ignore rules from the config
+// or nil if the user does not want to ignore anything.
+func stageIgnoreMatcher(ctx *cli.Context, ctl *client.Client) (*ignore.Matcher, error) {
+	if ctx.Bool("no-ignore") {
+		return nil, nil
+	}
+
+	patterns, err := ctl.ConfigGet("fs.ignore.patterns")
+	if err != nil {
+		return nil, err
+	}
+
+	if patterns == "" {
+		return ignore.New(nil), nil
+	}
+
+	return ignore.New(strings.Split(patterns, " ;; ")), nil
 }
 
 func handleStageDirectory(ctx *cli.Context, ctl *client.Client, root, repoRoot string) error {
@@ -85,17 +100,32 @@ func handleStageDirectory(ctx *cli.Context, ctl *client.Client, root, repoRoot s
 	}
 
 	toBeStaged := []stagePair{}
+	toBeCreated := []string{}
 
 	root = filepath.Clean(root)
 	repoRoot = filepath.Clean(repoRoot)
 
-	err := filepath.Walk(root, func(childPath string, info os.FileInfo, err error) error {
+	matcher, err := stageIgnoreMatcher(ctx, ctl)
+	if err != nil {
+		return fmt.Errorf("failed to get ignore patterns: %v", err)
+	}
+
+	walk := filepath.Walk
+	if matcher != nil {
+		walk = func(root string, fn filepath.WalkFunc) error {
+			return ignore.Walk(root, matcher, fn)
+		}
+	}
+
+	err = walk(root, func(childPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
 		repoPath := filepath.Join("/", repoRoot, childPath[len(root):])
 
 		if info.IsDir() {
-			if err := ctl.Mkdir(repoPath, true); err != nil {
-				return e.Wrapf(err, "mkdir: %s", repoPath)
-			}
+			toBeCreated = append(toBeCreated, repoPath)
 		}
 
 		if info.Mode().IsRegular() {
@@ -106,7 +136,22 @@ func handleStageDirectory(ctx *cli.Context, ctl *client.Client, root, repoRoot s
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to create sub directories: %v", err)
+		return fmt.Errorf("failed to walk %s: %v", root, err)
+	}
+
+	if ctx.Bool("dry-run") {
+		tabW := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.StripEscape)
+		for _, pair := range toBeStaged {
+			fmt.Fprintf(tabW, "%s\t%s\n", pair.local, pair.repo)
+		}
+
+		return tabW.Flush()
+	}
+
+	for _, repoPath := range toBeCreated {
+		if err := ctl.Mkdir(repoPath, true); err != nil {
+			return fmt.Errorf("failed to create sub directories: %v", e.Wrapf(err, "mkdir: %s", repoPath))
+		}
 	}
 
 	width, err := terminal.Width()
@@ -150,7 +195,9 @@ func handleStageDirectory(ctx *cli.Context, ctl *client.Client, root, repoRoot s
 					return
 				}
 
-				if err := ctl.Stage(pair.local, pair.repo); err != nil {
+				// Files that did not change since the last time
+				// they were staged from `root` are skipped.
+				if _, err := ctl.StageIncremental(pair.local, pair.repo, root); err != nil {
 					fmt.Printf("failed to stage %s: %v\n", pair.local, err)
 				}
 
@@ -175,6 +222,258 @@ func handleStageDirectory(ctx *cli.Context, ctl *client.Client, root, repoRoot s
 
 	close(jobs)
 	pbars.Wait()
+
+	if !ctx.Bool("sync-deletes") {
+		return nil
+	}
+
+	// Ignored files still exist, so they should not be removed:
+	existing := []string{}
+	err = filepath.Walk(root, func(childPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			existing = append(existing, childPath)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to walk %s: %v", root, err)
+	}
+
+	removed, err := ctl.StageRemoveMissing(root, existing)
+	if err != nil {
+		return fmt.Errorf("failed to remove deleted files: %v", err)
+	}
+
+	for _, path := range removed {
+		fmt.Printf("Removed %s\n", path)
+	}
+
+	return nil
+}
+
+type checkoutResult int
+
+const (
+	checkoutWritten = checkoutResult(iota)
+	checkoutUnchanged
+	checkoutUncached
+)
+
+// localFileMatches checks if the file at `localPath` exists
+// and has the same content as the repository file `info`.
+func localFileMatches(localPath string, info *client.StatInfo) (bool, error) {
+	fd, err := os.Open(localPath) // #nosec
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	defer fd.Close()
+
+	localInfo, err := fd.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	if !localInfo.Mode().IsRegular() || uint64(localInfo.Size()) != info.Size {
+		return false, nil
+	}
+
+	hashWriter := h.NewHashWriter()
+	if _, err := io.Copy(hashWriter, fd); err != nil {
+		return false, err
+	}
+
+	return hashWriter.Finalize().Equal(info.ContentHash), nil
+}
+
+func checkoutFile(ctl *client.Client, info *client.StatInfo, repoPath, rev, localPath string, onlyCached bool) (checkoutResult, error) {
+	isSame, err := localFileMatches(localPath, info)
+	if err != nil {
+		return checkoutWritten, err
+	}
+
+	if isSame {
+		return checkoutUnchanged, os.Chtimes(localPath, info.ModTime, info.ModTime)
+	}
+
+	if onlyCached {
+		isCached, err := ctl.IsCachedAt(repoPath, rev)
+		if err != nil {
+			return checkoutWritten, err
+		}
+
+		if !isCached {
+			return checkoutUncached, nil
+		}
+	}
+
+	stream, err := ctl.CatAt(repoPath, rev, onlyCached)
+	if err != nil {
+		return checkoutWritten, err
+	}
+
+	defer util.Closer(stream)
+
+	// Write to a temporary file next to the destination first, so an
+	// interrupted checkout does not leave half-written files behind.
+	tmpFd, err := ioutil.TempFile(filepath.Dir(localPath), "."+filepath.Base(localPath)+".brig-checkout-")
+	if err != nil {
+		return checkoutWritten, err
+	}
+
+	defer os.Remove(tmpFd.Name())
+
+	hashWriter := h.NewHashWriter()
+	n, err := io.Copy(io.MultiWriter(tmpFd, hashWriter), stream)
+	if err != nil {
+		tmpFd.Close()
+		return checkoutWritten, err
+	}
+
+	if err := tmpFd.Close(); err != nil {
+		return checkoutWritten, err
+	}
+
+	if uint64(n) != info.Size || !hashWriter.Finalize().Equal(info.ContentHash) {
+		return checkoutWritten, fmt.Errorf("received content does not match (got %d of %d bytes)", n, info.Size)
+	}
+
+	if err := os.Chtimes(tmpFd.Name(), info.ModTime, info.ModTime); err != nil {
+		return checkoutWritten, err
+	}
+
+	return checkoutWritten, os.Rename(tmpFd.Name(), localPath)
+}
+
+func handleCheckoutTo(ctx *cli.Context, ctl *client.Client) error {
+	repoPath := ctx.Args().Get(0)
+	localDir := ctx.Args().Get(1)
+	rev := ctx.String("rev")
+	onlyCached := ctx.Bool("only-cached")
+
+	nWorkers := ctx.Int("jobs")
+	if nWorkers <= 0 {
+		return ExitCode{BadArgs, "--jobs must be at least 1"}
+	}
+
+	entries, err := ctl.ListAt(repoPath, rev, -1)
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("checkout-to: %v", err)}
+	}
+
+	if len(entries) == 0 {
+		return ExitCode{UnknownError, fmt.Sprintf("checkout-to: no such file or directory: %s", repoPath)}
+	}
+
+	// Paths of remote users look like »user:/path«;
+	// the files need to be read from the same user.
+	userPrefix := ""
+	if idx := strings.IndexRune(repoPath, ':'); idx > 0 && !strings.HasPrefix(repoPath, "/") {
+		userPrefix = repoPath[:idx+1]
+	}
+
+	// Entries are sorted by depth, so the root comes first:
+	root := entries[0]
+	localPathOf := func(info *client.StatInfo) string {
+		if !root.IsDir {
+			return filepath.Join(localDir, path.Base(info.Path))
+		}
+
+		relPath := strings.TrimPrefix(info.Path, root.Path)
+		return filepath.Join(localDir, filepath.FromSlash(relPath))
+	}
+
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("checkout-to: %v", err)}
+	}
+
+	dirs := []client.StatInfo{}
+	files := make(chan client.StatInfo, nWorkers)
+	for _, entry := range entries {
+		if !entry.IsDir {
+			continue
+		}
+
+		// Parents are always created before their children:
+		if err := os.MkdirAll(localPathOf(&entry), 0755); err != nil {
+			return ExitCode{UnknownError, fmt.Sprintf("checkout-to: %v", err)}
+		}
+
+		dirs = append(dirs, entry)
+	}
+
+	mu := sync.Mutex{}
+	counts := map[checkoutResult]int{}
+	nFailed := 0
+
+	wg := sync.WaitGroup{}
+	wg.Add(nWorkers)
+
+	// Fetching is mostly waiting on the network, so do it in parallel:
+	for idx := 0; idx < nWorkers; idx++ {
+		go func() {
+			defer wg.Done()
+
+			for info := range files {
+				localPath := localPathOf(&info)
+				result, err := checkoutFile(ctl, &info, userPrefix+info.Path, rev, localPath, onlyCached)
+
+				mu.Lock()
+				if err != nil {
+					fmt.Printf("failed to checkout %s: %v\n", info.Path, err)
+					nFailed++
+				} else {
+					counts[result]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir {
+			files <- entry
+		}
+	}
+
+	close(files)
+	wg.Wait()
+
+	// Set the times of directories last, since writing files changes them.
+	// Deeper directories come last in the list.
+	for idx := len(dirs) - 1; idx >= 0; idx-- {
+		dir := dirs[idx]
+		if err := os.Chtimes(localPathOf(&dir), dir.ModTime, dir.ModTime); err != nil {
+			return ExitCode{UnknownError, fmt.Sprintf("checkout-to: %v", err)}
+		}
+	}
+
+	fmt.Printf(
+		"Wrote %d files, %d were unchanged",
+		counts[checkoutWritten],
+		counts[checkoutUnchanged],
+	)
+
+	if onlyCached {
+		fmt.Printf(", %d are not cached", counts[checkoutUncached])
+	}
+
+	fmt.Println(".")
+
+	if nFailed > 0 {
+		return ExitCode{UnknownError, fmt.Sprintf("checkout-to: %d files failed", nFailed)}
+	}
+
 	return nil
 }
 
@@ -397,6 +696,201 @@ func handleTree(ctx *cli.Context, ctl *client.Client) error {
 	return nil
 }
 
+// findQuery joins the arguments of »brig find« to a query again.
+// The shell removed the quotes already, so put them back where needed.
+func findQuery(args []string) string {
+	terms := []string{}
+	for _, arg := range args {
+		if !strings.ContainsAny(arg, " \t") {
+			terms = append(terms, arg)
+			continue
+		}
+
+		if idx := strings.IndexByte(arg, ':'); idx > 0 && !strings.ContainsAny(arg[:idx], " \t") {
+			terms = append(terms, fmt.Sprintf("%s:\"%s\"", arg[:idx], arg[idx+1:]))
+			continue
+		}
+
+		terms = append(terms, fmt.Sprintf("\"%s\"", arg))
+	}
+
+	return strings.Join(terms, " ")
+}
+
+func handleFind(ctx *cli.Context, ctl *client.Client) error {
+	opts := client.FindOptions{
+		Rev:     ctx.String("rev"),
+		History: ctx.Bool("history"),
+	}
+
+	results, err := ctl.Find(ctx.String("root"), findQuery(ctx.Args()), opts)
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("find: %v", err)}
+	}
+
+	tmpl, err := readFormatTemplate(ctx)
+	if err != nil {
+		return err
+	}
+
+	if tmpl != nil {
+		for _, result := range results {
+			if err := tmpl.Execute(os.Stdout, result); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	tabW := tabwriter.NewWriter(
+		os.Stdout, 0, 0, 2, ' ',
+		tabwriter.StripEscape,
+	)
+
+	showCommit := opts.History || opts.Rev != ""
+	if len(results) != 0 {
+		commitColumn := ""
+		if showCommit {
+			commitColumn = "COMMIT\t"
+		}
+
+		fmt.Fprintf(tabW, "SIZE\tMODTIME\tUSER\t%sPATH\tPIN\n", commitColumn)
+	}
+
+	for _, result := range results {
+		coloredPath := color.WhiteString(result.Path)
+		if result.IsDir {
+			coloredPath = color.GreenString(result.Path)
+		}
+
+		commitEntry := ""
+		if showCommit {
+			commitEntry = color.RedString(result.Commit.ShortB58()) + "\t"
+		}
+
+		fmt.Fprintf(
+			tabW,
+			"%s\t%s\t%s\t%s%s\t%s\n",
+			colorForSize(result.Size)(humanize.Bytes(result.Size)),
+			result.ModTime.Format("2006-01-02 15:04:05 MST"),
+			color.GreenString(result.User),
+			commitEntry,
+			coloredPath,
+			" "+pinStateToSymbol(result.IsPinned, result.IsExplicit),
+		)
+	}
+
+	return tabW.Flush()
+}
+
+func handleDedupe(ctx *cli.Context, ctl *client.Client, root, mode string) error {
+	dryRun := ctx.Bool("dry-run")
+	changes, err := ctl.Dedupe(root, mode, dryRun)
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("dupes: %v", err)}
+	}
+
+	if len(changes) == 0 {
+		fmt.Println("No redundant blobs found.")
+		return nil
+	}
+
+	freed := uint64(0)
+	for _, change := range changes {
+		fmt.Printf(
+			"%s %s %s\n",
+			color.WhiteString(change.Path),
+			color.YellowString("→"),
+			color.GreenString(change.KeptPath),
+		)
+
+		freed += change.FreedBytes
+	}
+
+	verb := "Freed"
+	if dryRun {
+		verb = "Would free"
+	}
+
+	fmt.Printf("%s %s of local storage.\n", verb, humanize.Bytes(freed))
+	return nil
+}
+
+func handleDupes(ctx *cli.Context, ctl *client.Client) error {
+	root := "/"
+	if ctx.NArg() > 0 {
+		root = ctx.Args().First()
+	}
+
+	if ctx.Bool("link") && ctx.Bool("unpin") {
+		return ExitCode{BadArgs, "--link and --unpin can not be used together"}
+	}
+
+	if ctx.Bool("link") {
+		return handleDedupe(ctx, ctl, root, "link")
+	}
+
+	if ctx.Bool("unpin") {
+		return handleDedupe(ctx, ctl, root, "unpin")
+	}
+
+	groups, err := ctl.Dupes(root, ctx.Bool("history"))
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("dupes: %v", err)}
+	}
+
+	if len(groups) == 0 {
+		fmt.Println("No duplicates found.")
+		return nil
+	}
+
+	wasted, cached := uint64(0), uint64(0)
+	for _, group := range groups {
+		fmt.Printf(
+			"%s %s × %d (%d blobs, %s wasted, %s cached)\n",
+			color.RedString(group.ContentHash.ShortB58()),
+			colorForSize(group.Size)(humanize.Bytes(group.Size)),
+			len(group.Files),
+			group.Blobs,
+			humanize.Bytes(group.WastedBytes),
+			humanize.Bytes(group.CachedBytes),
+		)
+
+		for idx, file := range group.Files {
+			marker := " "
+			if idx == 0 {
+				marker = color.GreenString("*")
+			}
+
+			commitInfo := ""
+			if file.Commit != nil {
+				commitInfo = color.CyanString(" (in %s)", file.Commit.ShortB58())
+			}
+
+			fmt.Printf(
+				"  %s %s %s%s\n",
+				marker,
+				color.WhiteString(file.Path),
+				pinStateToSymbol(file.IsPinned, file.IsExplicit),
+				commitInfo,
+			)
+		}
+
+		wasted += group.WastedBytes
+		cached += group.CachedBytes
+	}
+
+	fmt.Printf(
+		"\n%d groups: %s wasted, %s of local storage in redundant blobs.\n",
+		len(groups),
+		humanize.Bytes(wasted),
+		humanize.Bytes(cached),
+	)
+
+	return nil
+}
+
 func handleMkdir(ctx *cli.Context, ctl *client.Client) error {
 	path := ctx.Args().First()
 	createParents := ctx.Bool("parents")
@@ -595,6 +595,232 @@ EXAMPLES:
    $ brig tag -d my-tag-name           # Delete the tag name again.
    $ brig tag HEAD^ previous-head      # Tag the commit before the current HEAD with "previous-head".
    $ brig tag 'commit[1]' second       # Tag the commit directly after init with "second".
+`,
+	},
+	"branch": {
+		Usage:     "List, create or delete branches",
+		Complete:  completeArgsUsage,
+		ArgsUsage: "[<name> [<commit>]]",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "delete,d",
+				Usage: "Delete the branch instead of creating it",
+			},
+		},
+		Description: `Branches are named lines of development that can be worked on in parallel.
+   Every repository starts out with a branch called »master«.
+
+   Without arguments, all branches are listed and the current one is marked with »*«.
+   When giving a name, a new branch starting at »commit« (or HEAD if omitted) is created.
+   Use »brig switch« to work on it and »brig merge« to merge it into the current branch.
+
+   Branch names are case insensitive and may only contain letters, digits, »-« and »_«.
+   They can be used in all places where brig requires you to specify a commit.
+
+EXAMPLES:
+
+   $ brig branch                   # List all branches.
+   $ brig branch feature           # Create a branch "feature" at HEAD.
+   $ brig branch old HEAD^^        # Create a branch "old" starting two commits back.
+   $ brig branch -d feature        # Delete the branch "feature" (its commits stay).
+`,
+	},
+	"switch": {
+		Usage:     "Make another branch the current one",
+		Complete:  completeArgsUsage,
+		ArgsUsage: "<branch>",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "create,c",
+				Usage: "Create the branch at HEAD before switching to it",
+			},
+			cli.BoolFlag{
+				Name:  "force,f",
+				Usage: "Throw away uncommitted changes",
+			},
+		},
+		Description: `Switch to »branch«. HEAD and the staging area are set to the newest commit
+   of it. The branch you left keeps pointing to its last commit.
+
+   If there are uncommitted changes, this command will refuse to switch unless
+   »--force« is given. In this case, the changes are lost.
+
+EXAMPLES:
+
+   $ brig switch -c feature        # Create "feature" and switch to it.
+   $ brig switch master            # Go back to the master branch.
+`,
+	},
+	"merge": {
+		Usage:     "Merge another branch into the current one",
+		Complete:  completeArgsUsage,
+		ArgsUsage: "<branch>",
+		Description: `Merge the changes of »branch« into the current branch.
+   This works exactly like »brig sync«, but the other side is a branch of your
+   own repository. If something changed, a merge commit is created. Conflicts
+   are handled according to »fs.sync.conflict_strategy«.
+
+   The staging area has to be empty; commit your changes before merging.
+   The output uses the same symbols as »brig sync«.
+
+EXAMPLES:
+
+   $ brig merge feature            # Merge "feature" into the current branch.
+`,
+	},
+	"bundle": {
+		Usage: "Exchange changes with other peers via files",
+		Description: `Bundles transport metadata (and optionally content) to another
+   peer without any network connection, e.g. on a USB stick. A bundle is a
+   single file that is signed with your key. The receiving side checks the
+   signature against the fingerprint it has stored for you as remote.
+
+   See »brig bundle create --help« and »brig bundle apply --help«.
+`,
+	},
+	"bundle.create": {
+		Usage:     "Write all changes for a remote to a bundle file",
+		ArgsUsage: "<path>",
+		Complete:  completeLocalPath,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "remote,r",
+				Usage: "Name of the remote the bundle is for (required).",
+			},
+			cli.StringFlag{
+				Name:  "since,s",
+				Value: "commit[0]",
+				Usage: "Only include changes after this revision.",
+			},
+			cli.BoolFlag{
+				Name:  "content,c",
+				Usage: "Also include the (encrypted) content of changed files.",
+			},
+		},
+		Description: `Create a bundle with all changes since »--since« that »--remote« is
+   allowed to see (see »brig remote folder«). Without »--content« only
+   metadata is written; the remote can then fetch the content later once
+   it is online again. The bundle file must not exist yet.
+
+EXAMPLES:
+
+   $ brig bundle create --remote bob out.brigbundle
+   $ brig bundle create --remote bob --since my-tag --content out.brigbundle
+`,
+	},
+	"bundle.apply": {
+		Usage:     "Import a bundle created by another peer",
+		ArgsUsage: "<path>",
+		Complete:  completeLocalPath,
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "no-sync,n",
+				Usage: "Only import the changes, but do not sync them yet.",
+			},
+		},
+		Description: `Verify the signature of the bundle at »path«, add the included
+   content to the backend and apply the changes to the metadata of the
+   creator. Afterwards the creator is synced like with »brig sync«, unless
+   »--no-sync« is given. The output uses the same symbols as »brig sync«.
+
+EXAMPLES:
+
+   $ brig bundle apply out.brigbundle
+`,
+	},
+	"stash": {
+		Usage: "Set staged changes aside and bring them back later",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "message,m",
+				Usage: "Describe what the stash contains.",
+			},
+		},
+		Description: `Save all changes in the staging area as a stash and reset the
+   staging area to the last commit. This is useful to get a clean state before
+   »brig sync«, »brig switch« or »brig reset«. Without a subcommand this is the
+   same as »brig stash push«.
+
+   Stashes are numbered from 0 (the newest one) upwards. They are kept until
+   they are popped or dropped.
+
+EXAMPLES:
+
+   $ brig stash -m "half done"  # Set the current changes aside.
+   $ brig sync bob              # Sync with a clean staging area.
+   $ brig stash pop             # Bring the changes back.
+`,
+	},
+	"stash.push": {
+		Usage: "Save the staged changes as new stash",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "message,m",
+				Usage: "Describe what the stash contains.",
+			},
+		},
+	},
+	"stash.pop": {
+		Usage:     "Apply a stash to the staging area and drop it",
+		ArgsUsage: "[<index>]",
+		Description: `Apply the changes of the stash at »index« (0 if not given) to the
+   staging area. Files that were changed since the stash was made are conflicts
+   and are handled according to »fs.sync.conflict_strategy«. If there were
+   conflicts, the stash is kept; use »brig stash drop« once you resolved them.
+`,
+	},
+	"stash.list": {
+		Usage: "List all stashes, newest first",
+	},
+	"stash.drop": {
+		Usage:     "Remove a stash without applying it",
+		ArgsUsage: "[<index>]",
+	},
+	"revert": {
+		Usage:     "Undo the changes of a past commit with a new commit",
+		Complete:  completeArgsUsage,
+		ArgsUsage: "<commit>",
+		Description: `Create a new commit that undoes all changes that were made by »commit«.
+   Unlike »brig reset« this does not restore a past state, but only takes back
+   the changes of a single commit. Later commits are kept as they are.
+
+   If a file was changed again after »commit«, this is a conflict and is
+   handled according to »fs.sync.conflict_strategy«: With »marker« the reverted
+   version is stored next to it as »<file>.conflict.<n>«, with »embrace« it
+   replaces the current version and with »ignore« the file is left alone.
+
+   The staging area has to be empty; commit your changes before reverting.
+
+EXAMPLES:
+
+   $ brig revert HEAD           # Undo the last commit.
+   $ brig revert HEAD^^         # Undo the commit two before the last one.
+`,
+	},
+	"cherry-pick": {
+		Usage:     "Apply the changes of a single commit of a remote",
+		Complete:  completeArgsUsage,
+		ArgsUsage: "<remote> <commit>",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "no-fetch,n",
+				Usage: "Do not fetch the metadata of the remote before.",
+			},
+		},
+		Description: `Take the changes that »commit« of »remote« introduced and
+   apply them on top of your current state as a new commit. Nothing else of
+   the remote's history is taken over. The commit is looked up in the metadata
+   of the remote, which is fetched first (unless »--no-fetch« is given).
+
+   If your version of a file differs from the version before »commit«, this
+   is a conflict. Conflicts are handled according to the conflict strategy of
+   the remote (see »brig remote conflict-strategy --help«).
+
+   The staging area has to be empty; commit your changes before.
+
+EXAMPLES:
+
+   $ brig cherry-pick bob HEAD  # Take the last commit of bob.
 `,
 	},
 	"log": {
@@ -639,6 +865,10 @@ EXAMPLES:
 				Name:  "quiet,q",
 				Usage: "Do not print what changed.",
 			},
+			cli.StringFlag{
+				Name:  "branch,b",
+				Usage: "Sync with this branch of the remote instead of its current state.",
+			},
 		},
 		Description: `Sync and merge all metadata of another peer with our metadata.
    After this operation you might see new files in your folder.
@@ -779,6 +1009,30 @@ EXAMPLES:
    - moved & modified: The file was moved and modified.
    - add & modified: The file was removed before and now re-added with different content.
    - moved & removed: The file was moved to another location.
+`,
+	},
+	"blame": {
+		Usage:     "Show which version last changed each line of a file",
+		ArgsUsage: "<path>",
+		Complete:  completeBrigPath(true, false),
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "versions,v",
+				Usage: "Only list the versions of the file",
+			},
+		},
+		Description: `Annotate every line of a text file with the commit and author
+   that last changed it. The versions of the file are taken from its history
+   (see »brig history«), so moves are followed. If a version came in by
+   syncing with a remote, the name of this remote is shown as author.
+
+   For binary files (and very big ones) only the versions are listed,
+   i.e. every commit where the content of the file changed.
+
+EXAMPLES:
+
+   $ brig blame /notes.txt      # Show the author of each line.
+   $ brig blame -v /photo.png   # Show when the content changed.
 `,
 	},
 	"stage": {
@@ -790,6 +1044,18 @@ EXAMPLES:
 				Name:  "stdin,i",
 				Usage: "Read data from stdin.",
 			},
+			cli.BoolFlag{
+				Name:  "dry-run,n",
+				Usage: "Only print what would be staged.",
+			},
+			cli.BoolFlag{
+				Name:  "no-ignore",
+				Usage: "Also stage files that are ignored.",
+			},
+			cli.BoolFlag{
+				Name:  "sync-deletes",
+				Usage: "Remove files that were staged from this directory before, but were deleted locally.",
+			},
 		},
 		Description: `Read a local file (given by »local-path«) and try to read
    it. This is the conceptual equivalent of »git add«. The stream will be encrypted
@@ -802,11 +1068,35 @@ EXAMPLES:
    Additionally you can read the file from standard input if you pass »--stdin«.
    In this case you pass only one path: The path where the stream is stored.
 
+   The content is sent to the daemon over the network, so the daemon does not
+   need to see »local-path«. This also works with a daemon on another host
+   (see »--bind«).
+
+RE-STAGING
+
+   The daemon remembers size, modification time and inode of every staged
+   file. When staging the same file or directory again, files that did not
+   change since are skipped, so re-staging a big directory is cheap. With
+   »--sync-deletes«, files that were staged from the directory before but
+   were deleted locally since are removed - unless they were changed in
+   the repository in the meantime.
+
+IGNORING FILES
+
+   When staging a directory, files matching »fs.ignore.patterns« are skipped.
+   So is everything matched by a ».brigignore« file in the directory or one of
+   its sub directories. Those files use the same syntax as gitignore(5) and
+   only apply to the directory they are in. Use »--no-ignore« to stage
+   everything and »--dry-run« to see what would be staged.
+
 EXAMPLES:
 
    $ brig stage file.png                   # gets added as /file.png
    $ brig stage file.png /photos/me.png    # gets added as /photos/me.png
-   $ cat file.png | brig --stdin /file.png # gets added as /file.png`,
+   $ cat file.png | brig --stdin /file.png # gets added as /file.png
+   $ echo node_modules/ > project/.brigignore
+   $ brig stage --dry-run project          # shows what would be added
+   $ brig stage --sync-deletes ~/Photos /photos # only adds what changed`,
 	},
 	"touch": {
 		Usage:     "Create an empty file under the specified path",
@@ -844,6 +1134,47 @@ EXAMPLES:
    $ brig cat | tar xfv -
    # Create .tar.gz out of of the /photos directory.
    $ brig cat photos | gzip -f > photos.tar.gz
+`,
+	},
+	"checkout-to": {
+		Usage:     "Write a file or directory from the repository to a local directory",
+		ArgsUsage: "<path> <local-dir>",
+		Complete:  completeBrigPath(true, true),
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "rev,r",
+				Usage: "Write the state of this commit instead of the current one.",
+			},
+			cli.BoolFlag{
+				Name:  "only-cached,o",
+				Usage: "Skip files that are not in the local cache.",
+			},
+			cli.IntFlag{
+				Name:  "jobs,j",
+				Usage: "How many files to fetch in parallel.",
+				Value: 8,
+			},
+		},
+		Description: `Write »path« and everything below it to »local-dir«. This is the
+   inverse of »brig stage«. Directories are created as needed and files get
+   the modification time they have in the repository. If »path« is a file, it
+   is written into »local-dir« under its name.
+
+   Files that already exist in »local-dir« with the same content are skipped.
+   Other files are fetched in parallel and written to a temporary file first,
+   so an interrupted checkout can be resumed by running it again. Files in
+   »local-dir« that are not in the repository are left alone.
+
+   With »--rev« the state of an older commit is written (see »brig log«).
+   With »--only-cached« files that would need to be fetched from the network
+   are skipped.
+
+EXAMPLES:
+
+   # Write /photos to ~/photos:
+   $ brig checkout-to /photos ~/photos
+   # Write the whole repository as it was two commits ago:
+   $ brig checkout-to --rev head^^ / /tmp/old-state
 `,
 	},
 	"show": {
@@ -924,6 +1255,115 @@ EXAMPLES:
 			},
 		},
 		Description: `Show entries in a tree(1)-like fashion.
+`,
+	},
+	"find": {
+		Usage:     "Search for files by name, size, time, state or content",
+		ArgsUsage: "<query>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "root,p",
+				Usage: "Only search below this directory",
+				Value: "/",
+			},
+			cli.StringFlag{
+				Name:  "rev,r",
+				Usage: "Search in this revision instead of the current state",
+			},
+			cli.BoolFlag{
+				Name:  "history,H",
+				Usage: "Search in all commits (starting at --rev)",
+			},
+			cli.StringFlag{
+				Name:  "format,f",
+				Usage: "Format the output according to a template",
+			},
+		},
+		Description: `Find all files and directories that match »query«.
+   The query consists of terms; an entry has to match all of them. Prefix a
+   term with »-« to negate it. Without a query, everything is shown.
+
+   word            The path contains »word« (or the name matches it, if it is a glob).
+   name:GLOB       The name matches GLOB (e.g. »name:*.jpg,*.png«).
+   path:GLOB       The path is below GLOB or matches it.
+   size:RANGE      The size is in RANGE (e.g. »size:>10M«, »size:1K..2M«).
+   mtime:RANGE     The modification time is in RANGE (e.g. »mtime:>7d«,
+                   »mtime:<2020-01-01«, »mtime:2020-05-01..2020-06-01«).
+   user:NAME       The entry was last modified by NAME.
+   mime:GLOB       The mime type (guessed from the name) matches GLOB.
+   is:STATE        STATE is one of pinned, explicit, cached, dir or file.
+   text:WORDS      The content contains all WORDS.
+
+   Values of name, path, user and mime may list several alternatives, separated
+   by commas. All matching is case insensitive. If the query starts with a
+   negated term, put »--« in front of it, so it is not taken as an option.
+
+   »text:« needs the full-text index, which is disabled by default. Enable it
+   with »brig cfg set fs.search.fulltext.enabled true«. It is updated after
+   every commit and only covers text files that are available locally.
+
+   With »--history«, every commit is searched and each version of a file is
+   shown once, together with the newest commit it was found in.
+
+EXAMPLES:
+
+   $ brig find name:*.jpg size:>1M           # Big JPEG images.
+   $ brig find mtime:>7d user:bob            # What bob changed last week.
+   $ brig find -p /docs text:invoice -is:pinned
+   $ brig find --history name:notes.txt      # Every version of notes.txt.
+`,
+	},
+	"dupes": {
+		Usage:     "Find files with the same content and get rid of redundant copies",
+		ArgsUsage: "[<root>]",
+		Complete:  completeBrigPath(false, true),
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "history,H",
+				Usage: "Also consider older versions of the files",
+			},
+			cli.BoolFlag{
+				Name:  "link,l",
+				Usage: "Make duplicates share the blob of the kept file",
+			},
+			cli.BoolFlag{
+				Name:  "unpin,u",
+				Usage: "Unpin the blobs of the duplicates",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run,n",
+				Usage: "Only print what --link or --unpin would do",
+			},
+		},
+		Description: `Group all files below »root« (or »/«) that have the same content.
+
+   Each group shows the size of the content, the number of files and how many
+   different blobs the backend stores for it. Files staged at different times
+   can be encrypted differently and therefore take space more than once, even
+   though the content is the same. »wasted« is how much more the files take
+   than a single copy; »cached« is how much local storage is taken by blobs
+   other than the one of the kept file (marked with »*«).
+
+   With »--history«, older versions of the files are considered too. They are
+   only reported, never changed.
+
+   To get rid of the redundant blobs, use one of:
+
+   --link    Change the duplicates to use the blob of the kept file, just like
+             »brig cp« does. The pin state of the duplicates is kept.
+   --unpin   Unpin the duplicates, so the backend may remove their blobs.
+             The kept file is pinned if it was not.
+
+   Blobs that are also used by files outside of »root« are left alone.
+   Older versions that still use a redundant blob can only be read as long as
+   it is stored somewhere.
+
+EXAMPLES:
+
+   $ brig dupes                  # Show all duplicates.
+   $ brig dupes --history /docs  # Include older versions below /docs.
+   $ brig dupes --link -n        # Show what would be linked.
+   $ brig dupes --link           # Make duplicates share their blobs.
 `,
 	},
 	"mkdir": {
@@ -987,6 +1427,18 @@ EXAMPLES:
    (if you did not specify a password helper), it will be started for you in
    the background. Therefore it is seldom useful to use any of those commands -
    unless you know what you're doing.
+
+   One daemon can serve several repositories. If »--repo« points to a
+   repository that is not the one the daemon was started with, the daemon
+   loads it (asking for its password if needed) and the command works on it.
+   The daemon to talk to is found via »--port« or »daemon.port« of the
+   repository, so give several repositories the same port to share a daemon.
+
+EXAMPLES:
+
+   $ brig --repo ~/work --port 6666 ls   # Let the daemon on 6666 serve ~/work too.
+   $ brig daemon repos                   # List all served repositories.
+   $ brig daemon close ~/work            # Stop serving ~/work.
 `,
 	},
 	"daemon.launch": {
@@ -1016,7 +1468,35 @@ EXAMPLES:
 		Complete: completeArgsUsage,
 		Description: `Quit a running daemon process.
 
-   If no daemon process is running, it will tell you.
+   If no daemon process is running, it will tell you. If »--repo« selects
+   a repository that was loaded in addition, only this one is closed.
+`,
+	},
+	"daemon.repos": {
+		Usage:    "List the repositories served by the daemon",
+		Complete: completeArgsUsage,
+		Description: `List the path and owner of every repository the daemon serves.
+
+   The repository the daemon was started with is marked as primary.
+   It is served until the daemon quits.
+
+EXAMPLES:
+
+   $ brig daemon repos
+`,
+	},
+	"daemon.close": {
+		Usage:     "Stop serving a repository",
+		ArgsUsage: "<path>",
+		Complete:  completeArgsUsage,
+		Description: `Close a repository that the daemon loaded in addition to its primary one.
+
+   The repository is locked again and its mounts are unmounted.
+   Use »brig daemon quit« to close the primary one.
+
+EXAMPLES:
+
+   $ brig daemon close ~/work
 `,
 	},
 	"daemon.ping": {
@@ -1099,6 +1579,14 @@ if the service needs a restart when setting the value.
 				Name:  "x,root",
 				Usage: "Specify a root directory other than »/«.",
 			},
+			cli.StringFlag{
+				Name:  "rev",
+				Usage: "Mount the state of a commit or tag read-only.",
+			},
+			cli.BoolFlag{
+				Name:  "s,snapshots",
+				Usage: "Show a virtual ».snapshots« directory with all tags.",
+			},
 		},
 	},
 	"fstab.remove": {
@@ -1123,6 +1611,73 @@ if the service needs a restart when setting the value.
 			},
 		},
 	},
+	"mirror": {
+		Usage: "Keep local directories and the repository in sync without FUSE.",
+		Description: `A mirror is a normal directory on your disk that brig keeps in sync
+   with a directory in the repository, in both directions.
+
+   Local changes are staged automatically shortly after they happened.
+   Changes in the repository (e.g. after a sync) are written back to disk.
+   If a file was changed on both sides, the local version is staged and
+   the other version is kept as ».conflict.N« file, like »brig sync« does.
+
+   Files matching »fs.ignore.patterns« or a ».brigignore« file are not
+   mirrored (see »brig stage --help«). Mirrors are remembered and started
+   again with the daemon.
+
+   Without a subcommand, all mirrors are listed.
+
+EXAMPLES
+
+   $ brig mirror add ~/Documents /docs
+   $ brig mirror ls
+   $ brig mirror rm Documents
+`,
+	},
+	"mirror.add": {
+		Usage:     "Start mirroring a local directory.",
+		ArgsUsage: "<local-dir> [<repo-path>]",
+		Complete:  completeArgsUsage,
+		Description: `Mirror »local-dir« to »repo-path« (»/« by default).
+
+   The local directory is created if it does not exist. Files that exist
+   on only one side are copied to the other one.
+
+EXAMPLES
+
+   $ brig mirror add ~/Documents /docs
+   $ brig mirror add --name work ~/work /projects/work
+`,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "n,name",
+				Usage: "Name of the mirror (defaults to the name of the local directory).",
+			},
+		},
+	},
+	"mirror.remove": {
+		Usage:     "Stop mirroring a local directory.",
+		ArgsUsage: "<name>",
+		Complete:  completeArgsUsage,
+		Description: `Stop the mirror and forget about it.
+   No files are removed on either side.`,
+	},
+	"mirror.list": {
+		Usage: "List all mirrors.",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "format,f",
+				Usage: "Format the output according to a template.",
+			},
+		},
+	},
+	"mirror.sync": {
+		Usage:     "Reconcile a mirror right away.",
+		ArgsUsage: "<name>",
+		Complete:  completeArgsUsage,
+		Description: `Compare both sides of the mirror now, instead of waiting for
+   the next change or the next periodic rescan.`,
+	},
 	"mount": {
 		Usage:     "Mount the contents of brig as FUSE filesystem to »mount_path«.",
 		ArgsUsage: "<mount_path>",
@@ -1144,6 +1699,22 @@ CAVEATS
    At this time, the filesystem also not very robust to files that timeout or
    error out otherwise. Consider this feature to be experimental while this has
    not been worked upon.
+
+TIME TRAVEL
+
+   With »--rev« you can mount the state of any commit or tag (e.g. »HEAD^^«
+   or »v1.0«). Such mounts are always read-only.
+
+   With »--snapshots« the root of the mount contains a hidden, read-only
+   ».snapshots« directory that has one sub directory per tag. Each of them
+   shows the state of the filesystem at the time of the tag.
+
+EXAMPLES
+
+   $ brig mount ~/data
+   $ brig mount --rev HEAD^^ /tmp/old-data
+   $ brig mount --snapshots ~/data
+   $ ls ~/data/.snapshots/init
    `,
 		Flags: []cli.Flag{
 			cli.BoolFlag{
@@ -1162,6 +1733,14 @@ CAVEATS
 				Name:  "x,root",
 				Usage: "Create the filesystem as readonly",
 			},
+			cli.StringFlag{
+				Name:  "rev",
+				Usage: "Mount the state of a commit or tag read-only",
+			},
+			cli.BoolFlag{
+				Name:  "s,snapshots",
+				Usage: "Show a virtual ».snapshots« directory with all tags",
+			},
 		},
 	},
 	"unmount": {
@@ -1192,6 +1771,147 @@ CAVEATS
 
    Additionally the build time of the binary is shown.
    Please include this information when reporting a bug.
+`,
+	},
+	"fsck": {
+		Usage:    "Check the metadata of the repository for consistency",
+		Complete: completeArgsUsage,
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "repair,r",
+				Usage: "Repair problems that can be fixed without losing data",
+			},
+			cli.BoolFlag{
+				Name:  "check-content,c",
+				Usage: "Also fetch and decrypt the content of every file",
+			},
+		},
+		Description: `Check the integrity of the metadata store.
+
+   Starting from all refs, every commit in the history and every file and
+   directory in it is loaded. The hash of each node is compared with the
+   recomputed one, directory sizes are checked to add up and it is verified
+   that the commit index, the path index and the move mapping resolve.
+   Every problem is printed with the affected path and the commit where it
+   was first seen.
+
+   With »--repair« problems that can be fixed without losing data are fixed:
+   Dangling refs are removed (or set to the latest commit for HEAD and CURR),
+   wrong directory sizes are corrected and the commit and path index are
+   rebuilt. Corrupted hashes can not be repaired.
+
+   With »--check-content« the content of every file in the history is
+   retrieved from the backend and decrypted. This can take a long time
+   and might download content from other nodes.
+
+   The exit code is non-zero if problems remain that were not repaired.
+
+EXAMPLES:
+
+   $ brig fsck
+   No problems found.
+   $ brig fsck --repair --check-content
+`,
+	},
+	"passwd": {
+		Usage:    "Change the password of the repository",
+		Complete: completeArgsUsage,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "keyfile,k",
+				Usage: "Require the content of this file in addition to the password.",
+			},
+			cli.BoolFlag{
+				Name:  "no-keyfile,n",
+				Usage: "Do not require a keyfile anymore.",
+			},
+		},
+		Description: `Change the password that protects the repository while the daemon is
+   not running. You are asked for the current and for the new password.
+
+   The key is derived from the password with argon2id and a random salt,
+   which is renewed on every change. All files are locked with the new key
+   when the daemon quits.
+
+   With »--keyfile« the content of the given file is needed in addition to
+   the password, for example a file on an USB stick. Keep a copy of it;
+   without it the repository cannot be opened anymore. If neither
+   »--keyfile« nor »--no-keyfile« is given, the current keyfile is kept.
+
+   If »repo.password_command« is set, make sure it returns the new password.
+
+EXAMPLES:
+
+   $ brig passwd
+   $ brig passwd --keyfile /media/usb/brig.key
+`,
+	},
+	"backup": {
+		Usage: "Create or restore a backup of the whole repository",
+		Description: `A backup is a single encrypted file that contains everything needed
+   to move a repository to another machine: the metadata of all owners, the
+   remote list, the keys, the gateway users, the config and optionally the
+   content of all pinned files.
+
+   See »brig backup create --help« and »brig backup restore --help«.
+`,
+	},
+	"backup.create": {
+		Usage:     "Write a backup of the repository to a file",
+		ArgsUsage: "<path>",
+		Complete:  completeLocalPath,
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "content,c",
+				Usage: "Also include the content of all pinned files.",
+			},
+			cli.StringFlag{
+				Name:  "since,s",
+				Usage: "Path of an older backup; content that is part of it is not included again.",
+			},
+			cli.StringFlag{
+				Name:  "password,p",
+				Usage: "Password to protect the backup with (asked for if not given).",
+			},
+		},
+		Description: `Write a backup of the repository to »path«, which must not exist yet.
+   The backup is encrypted with a key derived from the password you enter.
+   It is not tied to the repository password.
+
+   With »--since« an incremental backup is created: All metadata is still
+   included, but content that is already part of the older backup (or its
+   parents) is skipped. The older backup must use the same password.
+
+EXAMPLES:
+
+   $ brig backup create --content full.brigbackup
+   $ brig backup create --content --since full.brigbackup monday.brigbackup
+`,
+	},
+	"backup.restore": {
+		Usage:     "Restore a backup into this repository",
+		ArgsUsage: "<path>",
+		Complete:  completeLocalPath,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "password,p",
+				Usage: "Password of the backup (asked for if not given).",
+			},
+		},
+		Description: `Check the integrity of the backup at »path« and restore it.
+
+   The repository has to be initialized with the same owner name as the one
+   that was backed up. Settings that describe the machine (like the daemon
+   port or the IPFS path) are kept. Incremental backups need their parents to
+   be restored first, oldest first. The daemon has to be restarted afterwards
+   to pick up the restored keys.
+
+EXAMPLES:
+
+   $ brig init alice@wonderland.lit/laptop
+   $ brig backup restore full.brigbackup
+   $ brig backup restore monday.brigbackup
+   $ brig daemon quit && brig daemon launch
 `,
 	},
 	"gc": {
@@ -1292,7 +2012,7 @@ EXAMPLES:
 		Flags: []cli.Flag{
 			cli.BoolFlag{
 				Name:  "role-admin,a",
-				Usage: "Add this user as admin (short for »-r 'fs.view,fs.edit,fs.download,remotes.view,remotes.edit'«)",
+				Usage: "Add this user as admin (short for »-r 'fs.view,fs.edit,fs.download,remotes.view,remotes.edit,audit.view'«)",
 			},
 			cli.BoolFlag{
 				Name:  "role-editor,b",
@@ -1323,6 +2043,7 @@ EXAMPLES:
    fs.download: Download file content.
    remotes.view: View the remotes tab.
    remotes.edit: Edit the remotes tab.
+   audit.view: Query the audit log (only given to admins by default).
 
    If the folder list is empty, this user can access all files.
    If it is non-empty, the user can only access the files including and below all folders.
@@ -1349,6 +2070,52 @@ EXAMPLES:
    - Salt: Salt of the password.
    - Folders: A list of folders this users may access (might be empty).
    - Rights: A list of rights this users has (might be empty).
+`,
+	},
+	"gateway.audit": {
+		Usage: "Show who did what via the gateway.",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "user,u",
+				Usage: "Only show entries of this user.",
+			},
+			cli.StringFlag{
+				Name:  "path,p",
+				Usage: "Only show entries that affect this path or anything below it.",
+			},
+			cli.StringFlag{
+				Name:  "since,s",
+				Usage: "Only show entries since this time (a duration like »24h«, a date or a RFC3339 timestamp).",
+			},
+			cli.IntFlag{
+				Name:  "limit,l",
+				Usage: "Only show the last »n« entries.",
+			},
+			cli.StringFlag{
+				Name:  "format,f",
+				Usage: "Format the output by a template.",
+			},
+		},
+		Description: `
+   Every authenticated API call and every download via »/get« is recorded
+   in the audit log. The log is rotated once it reaches »gateway.audit.max_size«
+   and at most »gateway.audit.max_files« files are kept.
+
+   The keys accepted by »--format« are:
+
+   - Time: Time when the request was finished.
+   - User: Name of the user that did the request.
+   - Action: Name of the endpoint that was called.
+   - Paths: Paths affected by the request.
+   - IP: Remote address of the client.
+   - Status: HTTP status code of the response.
+   - BytesIn: Number of bytes sent by the client.
+   - BytesOut: Number of bytes sent to the client.
+
+EXAMPLES:
+
+   $ brig gw audit --user bob --since 24h
+   $ brig gw audit --path /photos
 `,
 	},
 	"debug": {
@@ -1364,6 +2131,61 @@ EXAMPLES:
 
    # Show a graph with a cpu profile of the last 30s:
    go tool pprof -web "http://localhost:$(brig d p)/debug/pprof/profile?seconds=30"
+`,
+	},
+	"debug.cache-stats": {
+		Usage: "Print statistics of the block cache.",
+		Description: `
+   Blocks of file content that were read recently are kept in the repository,
+   so reading them again does not need the backend. This shows how often the
+   cache was used since the daemon started and how big it is right now.
+
+   The size of the cache can be changed with »fs.block_cache.size«.
+`,
+	},
+	"debug.migrate": {
+		Usage: "Migrate the metadata of the current user to the current format.",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "dry-run,n",
+				Usage: "Only check and print the steps that would be run",
+			},
+		},
+		Description: `
+   The metadata of a repository is stored in a format that might change between
+   versions of brig. Older metadata is migrated automatically when the daemon
+   starts, so this command is usually not needed. It prints the format version
+   of the metadata and runs missing migration steps, if any.
+
+   Before anything is changed, the metadata is exported to a file next to it,
+   so it can be restored if something went wrong.
+
+EXAMPLES:
+
+   $ brig debug migrate --dry-run  # Show what would be done.
+`,
+	},
+	"debug.convert-db": {
+		Usage: "Change the key/value store used for the metadata.",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "to,t",
+				Usage: "The store to convert to (»badger« or »bolt«)",
+			},
+		},
+		Description: `
+   The metadata of each user is stored in a key/value store. By default this is
+   »badger«, whose log files can grow quite large on small machines. »bolt«
+   keeps everything in a single compact file instead.
+
+   This command selects the new store in »fs.db.backend« and converts the
+   metadata right away. All keys are copied to the new store and the old
+   store is kept as backup (e.g. »metadata-convert/ali/badger-backup«).
+   The backup can be deleted once everything works.
+
+EXAMPLES:
+
+   $ brig debug convert-db --to bolt
 `,
 	},
 	"bug": {
@@ -142,7 +142,7 @@ func RunCmdline(args []string) int {
 		},
 		cli.StringFlag{
 			Name:   "repo",
-			Usage:  "Path to the repository. Only has effect for new daemons.",
+			Usage:  "Path to the repository. A running daemon loads it if needed.",
 			Value:  "",
 			EnvVar: "BRIG_PATH",
 		},
@@ -296,6 +296,46 @@ func RunCmdline(args []string) int {
 			Name:     "tag",
 			Category: vcscGroup,
 			Action:   withArgCheck(needAtLeast(1), withDaemon(handleTag, true)),
+		}, {
+			Name:     "branch",
+			Category: vcscGroup,
+			Action:   withDaemon(handleBranch, true),
+		}, {
+			Name:     "switch",
+			Category: vcscGroup,
+			Action:   withArgCheck(needAtLeast(1), withDaemon(handleSwitch, true)),
+		}, {
+			Name:     "merge",
+			Category: vcscGroup,
+			Action:   withArgCheck(needAtLeast(1), withDaemon(handleMerge, true)),
+		}, {
+			Name:     "stash",
+			Category: vcscGroup,
+			Action:   withDaemon(handleStashPush, true),
+			Subcommands: []cli.Command{
+				{
+					Name:   "push",
+					Action: withDaemon(handleStashPush, true),
+				}, {
+					Name:   "pop",
+					Action: withDaemon(handleStashPop, true),
+				}, {
+					Name:    "list",
+					Aliases: []string{"ls"},
+					Action:  withDaemon(handleStashList, true),
+				}, {
+					Name:   "drop",
+					Action: withDaemon(handleStashDrop, true),
+				},
+			},
+		}, {
+			Name:     "revert",
+			Category: vcscGroup,
+			Action:   withArgCheck(needAtLeast(1), withDaemon(handleRevert, true)),
+		}, {
+			Name:     "cherry-pick",
+			Category: vcscGroup,
+			Action:   withArgCheck(needAtLeast(2), withDaemon(handleCherryPick, true)),
 		}, {
 			Name:     "log",
 			Category: vcscGroup,
@@ -312,6 +352,18 @@ func RunCmdline(args []string) int {
 			Name:     "push",
 			Category: vcscGroup,
 			Action:   withArgCheck(needAtLeast(1), withDaemon(handlePush, true)),
+		}, {
+			Name:     "bundle",
+			Category: vcscGroup,
+			Subcommands: []cli.Command{
+				{
+					Name:   "create",
+					Action: withArgCheck(needAtLeast(1), withDaemon(handleBundleCreate, true)),
+				}, {
+					Name:   "apply",
+					Action: withArgCheck(needAtLeast(1), withDaemon(handleBundleApply, true)),
+				},
+			},
 		}, {
 			Name:     "commit",
 			Aliases:  []string{"cmt"},
@@ -332,6 +384,11 @@ func RunCmdline(args []string) int {
 			Aliases:  []string{"hst", "hist"},
 			Category: vcscGroup,
 			Action:   withArgCheck(needAtLeast(1), withDaemon(handleHistory, true)),
+		}, {
+			Name:     "blame",
+			Aliases:  []string{"annotate"},
+			Category: vcscGroup,
+			Action:   withArgCheck(needAtLeast(1), withDaemon(handleBlame, true)),
 		}, {
 			Name:     "stage",
 			Aliases:  []string{"stg", "add", "a"},
@@ -346,6 +403,11 @@ func RunCmdline(args []string) int {
 			Name:     "cat",
 			Category: wdirGroup,
 			Action:   withDaemon(handleCat, true),
+		}, {
+			Name:     "checkout-to",
+			Aliases:  []string{"export"},
+			Category: wdirGroup,
+			Action:   withArgCheck(needAtLeast(2), withDaemon(handleCheckoutTo, true)),
 		}, {
 			Name:     "show",
 			Aliases:  []string{"s", "info"},
@@ -364,6 +426,15 @@ func RunCmdline(args []string) int {
 			Name:     "tree",
 			Category: wdirGroup,
 			Action:   withDaemon(handleTree, true),
+		}, {
+			Name:     "find",
+			Aliases:  []string{"search"},
+			Category: wdirGroup,
+			Action:   withDaemon(handleFind, true),
+		}, {
+			Name:     "dupes",
+			Category: wdirGroup,
+			Action:   withDaemon(handleDupes, true),
 		}, {
 			Name:     "mkdir",
 			Category: wdirGroup,
@@ -393,6 +464,13 @@ func RunCmdline(args []string) int {
 				}, {
 					Name:   "ping",
 					Action: withDaemon(handleDaemonPing, false),
+				}, {
+					Name:    "repos",
+					Aliases: []string{"ls"},
+					Action:  withDaemon(handleDaemonRepos, false),
+				}, {
+					Name:   "close",
+					Action: withArgCheck(needAtLeast(1), withDaemon(handleDaemonClose, false)),
 				},
 			},
 		}, {
@@ -437,6 +515,27 @@ func RunCmdline(args []string) int {
 					Action:  withDaemon(handleFstabList, true),
 				},
 			},
+		}, {
+			Name:     "mirror",
+			Category: repoGroup,
+			Action:   withDaemon(handleMirrorList, true),
+			Subcommands: []cli.Command{
+				{
+					Name:   "add",
+					Action: withArgCheck(needAtLeast(1), withDaemon(handleMirrorAdd, true)),
+				}, {
+					Name:    "remove",
+					Aliases: []string{"rm"},
+					Action:  withArgCheck(needAtLeast(1), withDaemon(handleMirrorRemove, true)),
+				}, {
+					Name:    "list",
+					Aliases: []string{"ls"},
+					Action:  withDaemon(handleMirrorList, true),
+				}, {
+					Name:   "sync",
+					Action: withArgCheck(needAtLeast(1), withDaemon(handleMirrorSync, true)),
+				},
+			},
 		}, {
 			Name:     "trash",
 			Aliases:  []string{"tr"},
@@ -500,6 +599,10 @@ func RunCmdline(args []string) int {
 						},
 					},
 				},
+				{
+					Name:   "audit",
+					Action: withDaemon(handleGatewayAudit, true),
+				},
 			},
 		}, {
 			Name:     "debug",
@@ -510,6 +613,16 @@ func RunCmdline(args []string) int {
 					Name:    "pprof-port",
 					Aliases: []string{"p"},
 					Action:  withDaemon(handleDebugPprofPort, true),
+				}, {
+					Name:    "cache-stats",
+					Aliases: []string{"c"},
+					Action:  withDaemon(handleDebugCacheStats, true),
+				}, {
+					Name:   "migrate",
+					Action: withDaemon(handleDebugMigrate, true),
+				}, {
+					Name:   "convert-db",
+					Action: withDaemon(handleDebugConvertDb, true),
 				},
 			},
 		}, {
@@ -528,6 +641,26 @@ func RunCmdline(args []string) int {
 			Name:     "gc",
 			Category: repoGroup,
 			Action:   withDaemon(handleGc, true),
+		}, {
+			Name:     "fsck",
+			Category: repoGroup,
+			Action:   withDaemon(handleFsck, true),
+		}, {
+			Name:     "passwd",
+			Category: repoGroup,
+			Action:   withDaemon(handlePasswd, true),
+		}, {
+			Name:     "backup",
+			Category: repoGroup,
+			Subcommands: []cli.Command{
+				{
+					Name:   "create",
+					Action: withArgCheck(needAtLeast(1), withDaemon(handleBackupCreate, true)),
+				}, {
+					Name:   "restore",
+					Action: withArgCheck(needAtLeast(1), withDaemon(handleBackupRestore, true)),
+				},
+			},
 		}, {
 			Name:   "docs",
 			Action: handleOpenHelp,
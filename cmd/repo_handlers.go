@@ -12,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/dustin/go-humanize"
 	"github.com/fatih/color"
 	e "github.com/pkg/errors"
 	"github.com/sahib/brig/client"
@@ -349,6 +350,52 @@ func handleDaemonQuit(ctx *cli.Context, ctl *client.Client) error {
 	return nil
 }
 
+func handleDaemonRepos(ctx *cli.Context, ctl *client.Client) error {
+	infos, err := ctl.RepoList()
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("repos: %v", err)}
+	}
+
+	tabW := tabwriter.NewWriter(
+		os.Stdout, 0, 0, 2, ' ',
+		tabwriter.StripEscape,
+	)
+
+	if len(infos) != 0 {
+		fmt.Fprintln(tabW, "PATH\tOWNER\t\t")
+	}
+
+	for _, info := range infos {
+		primary := ""
+		if info.IsPrimary {
+			primary = color.GreenString("primary")
+		}
+
+		fmt.Fprintf(
+			tabW,
+			"%s\t%s\t%s\t\n",
+			info.Path,
+			color.YellowString(info.Owner),
+			primary,
+		)
+	}
+
+	return tabW.Flush()
+}
+
+func handleDaemonClose(ctx *cli.Context, ctl *client.Client) error {
+	path, err := filepath.Abs(ctx.Args().First())
+	if err != nil {
+		return ExitCode{BadArgs, fmt.Sprintf("close: %v", err)}
+	}
+
+	if err := ctl.RepoClose(path); err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("close: %v", err)}
+	}
+
+	return nil
+}
+
 func handleDaemonLaunch(ctx *cli.Context) error {
 	// Enable tracing (for profiling) if required.
 	if ctx.Bool("trace") {
@@ -470,9 +517,11 @@ func handleMount(ctx *cli.Context, ctl *client.Client) error {
 	}
 
 	options := client.MountOptions{
-		ReadOnly: ctx.Bool("readonly"),
-		Offline:  ctx.Bool("offline"),
-		RootPath: ctx.String("root"),
+		ReadOnly:  ctx.Bool("readonly"),
+		Offline:   ctx.Bool("offline"),
+		RootPath:  ctx.String("root"),
+		Rev:       ctx.String("rev"),
+		Snapshots: ctx.Bool("snapshots"),
 	}
 
 	if err := ctl.Mount(absMountPath, options); err != nil {
@@ -555,14 +604,159 @@ func handleGc(ctx *cli.Context, ctl *client.Client) error {
 	return tabW.Flush()
 }
 
+func handleFsck(ctx *cli.Context, ctl *client.Client) error {
+	problems, err := ctl.Fsck(ctx.Bool("repair"), ctx.Bool("check-content"))
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("fsck: %v", err)}
+	}
+
+	if len(problems) == 0 {
+		fmt.Println("No problems found.")
+		return nil
+	}
+
+	tabW := tabwriter.NewWriter(
+		os.Stdout, 0, 0, 2, ' ',
+		tabwriter.StripEscape,
+	)
+
+	fmt.Fprintln(tabW, "KIND	PATH	COMMIT	STATE	MESSAGE	")
+
+	unrepaired := 0
+	for _, problem := range problems {
+		state := color.RedString("broken")
+		if problem.Repaired {
+			state = color.GreenString("repaired")
+		} else {
+			unrepaired++
+		}
+
+		commit := "-"
+		if problem.Commit != nil {
+			commit = problem.Commit.ShortB58()
+		}
+
+		nodePath := problem.Path
+		if nodePath == "" {
+			nodePath = "-"
+		}
+
+		fmt.Fprintf(
+			tabW,
+			"%s\t%s\t%s\t%s\t%s\t\n",
+			color.YellowString(problem.Kind),
+			color.WhiteString(nodePath),
+			color.CyanString(commit),
+			state,
+			problem.Message,
+		)
+	}
+
+	if err := tabW.Flush(); err != nil {
+		return err
+	}
+
+	if unrepaired > 0 {
+		return ExitCode{
+			UnknownError,
+			fmt.Sprintf("fsck: %d of %d problems were not repaired", unrepaired, len(problems)),
+		}
+	}
+
+	return nil
+}
+
+func handleMirrorAdd(ctx *cli.Context, ctl *client.Client) error {
+	localPath, err := filepath.Abs(ctx.Args().Get(0))
+	if err != nil {
+		return ExitCode{BadArgs, fmt.Sprintf("mirror add: %v", err)}
+	}
+
+	repoPath := "/"
+	if ctx.NArg() > 1 {
+		repoPath = ctx.Args().Get(1)
+	}
+
+	name := ctx.String("name")
+	if name == "" {
+		name = filepath.Base(localPath)
+	}
+
+	if err := ctl.MirrorAdd(name, localPath, repoPath); err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("mirror add: %v", err)}
+	}
+
+	return nil
+}
+
+func handleMirrorRemove(ctx *cli.Context, ctl *client.Client) error {
+	if err := ctl.MirrorRemove(ctx.Args().Get(0)); err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("mirror rm: %v", err)}
+	}
+
+	return nil
+}
+
+func handleMirrorSync(ctx *cli.Context, ctl *client.Client) error {
+	if err := ctl.MirrorSync(ctx.Args().Get(0)); err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("mirror sync: %v", err)}
+	}
+
+	return nil
+}
+
+func handleMirrorList(ctx *cli.Context, ctl *client.Client) error {
+	mirrors, err := ctl.MirrorList()
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("mirror list: %v", err)}
+	}
+
+	tmpl, err := readFormatTemplate(ctx)
+	if err != nil {
+		return err
+	}
+
+	tabW := tabwriter.NewWriter(
+		os.Stdout, 0, 0, 2, ' ',
+		tabwriter.StripEscape,
+	)
+
+	if tmpl == nil && len(mirrors) != 0 {
+		fmt.Fprintln(tabW, "NAME\tLOCAL_PATH\tREPO_PATH\tACTIVE\t")
+	}
+
+	for _, entry := range mirrors {
+		if tmpl != nil {
+			if err := tmpl.Execute(os.Stdout, entry); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		fmt.Fprintf(
+			tabW,
+			"%s\t%s\t%s\t%s\t\n",
+			entry.Name,
+			entry.LocalPath,
+			entry.RepoPath,
+			checkmarkify(entry.Active),
+		)
+	}
+
+	return tabW.Flush()
+}
+
 func handleFstabAdd(ctx *cli.Context, ctl *client.Client) error {
 	mountName := ctx.Args().Get(0)
 	mountPath := ctx.Args().Get(1)
 
 	options := client.MountOptions{
-		ReadOnly: ctx.Bool("readonly"),
-		RootPath: ctx.String("root"),
-		Offline:  ctx.Bool("offline"),
+		ReadOnly:  ctx.Bool("readonly"),
+		RootPath:  ctx.String("root"),
+		Offline:   ctx.Bool("offline"),
+		Rev:       ctx.String("rev"),
+		Snapshots: ctx.Bool("snapshots"),
 	}
 
 	return ctl.FstabAdd(mountName, mountPath, options)
@@ -606,7 +800,7 @@ func handleFstabList(ctx *cli.Context, ctl *client.Client) error {
 	}
 
 	if tmpl == nil && len(mounts) != 0 {
-		fmt.Fprintln(tabW, "NAME\tPATH\tREAD_ONLY\tOFFLINE\tROOT\tACTIVE\t")
+		fmt.Fprintln(tabW, "NAME\tPATH\tREAD_ONLY\tOFFLINE\tROOT\tREV\tACTIVE\t")
 	}
 
 	for _, entry := range mounts {
@@ -620,12 +814,13 @@ func handleFstabList(ctx *cli.Context, ctl *client.Client) error {
 
 		fmt.Fprintf(
 			tabW,
-			"%s\t%s\t%s\t%s\t%s\t%s\n",
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
 			entry.Name,
 			entry.Path,
-			yesify(entry.ReadOnly),
+			yesify(entry.ReadOnly || entry.Rev != ""),
 			yesify(entry.Offline),
 			entry.Root,
+			entry.Rev,
 			checkmarkify(entry.Active),
 		)
 	}
@@ -996,7 +1191,7 @@ func handleGatewayUserAdd(ctx *cli.Context, ctl *client.Client) error {
 
 	rights := []string{}
 	if ctx.Bool("role-admin") {
-		rights = allRights
+		rights = append(allRights, "audit.view")
 	}
 
 	if ctx.Bool("role-editor") {
@@ -1077,6 +1272,71 @@ func handleGatewayUserList(ctx *cli.Context, ctl *client.Client) error {
 	return tabW.Flush()
 }
 
+func handleGatewayAudit(ctx *cli.Context, ctl *client.Client) error {
+	var since time.Time
+	if ctx.IsSet("since") {
+		var err error
+		since, err = parseSince(ctx.String("since"))
+		if err != nil {
+			return err
+		}
+	}
+
+	entries, err := ctl.GatewayAudit(
+		ctx.String("user"),
+		ctx.String("path"),
+		since,
+		ctx.Int("limit"),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	tabW := tabwriter.NewWriter(
+		os.Stdout, 0, 0, 2, ' ',
+		tabwriter.StripEscape,
+	)
+
+	tmpl, err := readFormatTemplate(ctx)
+	if err != nil {
+		return err
+	}
+
+	if tmpl == nil {
+		if len(entries) == 0 {
+			fmt.Println("No audit log entries.")
+			return nil
+		}
+
+		fmt.Fprintln(tabW, "TIME\tUSER\tACTION\tSTATUS\tIP\tBYTES\tPATHS\t")
+	}
+
+	for _, entry := range entries {
+		if tmpl != nil {
+			if err := tmpl.Execute(os.Stdout, entry); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		fmt.Fprintf(
+			tabW,
+			"%s\t%s\t%s\t%d\t%s\t%s\t%s\t\n",
+			entry.Time.Format(time.Stamp),
+			entry.User,
+			entry.Action,
+			entry.Status,
+			entry.IP,
+			humanize.Bytes(uint64(entry.BytesIn+entry.BytesOut)),
+			strings.Join(entry.Paths, ","),
+		)
+	}
+
+	return tabW.Flush()
+}
+
 func handleDebugPprofPort(ctx *cli.Context, ctl *client.Client) error {
 	port, err := ctl.DebugProfilePort()
 	if err != nil {
@@ -1092,3 +1352,195 @@ func handleDebugPprofPort(ctx *cli.Context, ctl *client.Client) error {
 
 	return nil
 }
+
+func handleDebugCacheStats(ctx *cli.Context, ctl *client.Client) error {
+	stats, err := ctl.DebugCacheStats()
+	if err != nil {
+		return err
+	}
+
+	row := func(name, value string) {
+		fmt.Printf("%10s: %s\n", name, value)
+	}
+
+	hitRate := 0.0
+	if total := stats.Hits + stats.Misses; total > 0 {
+		hitRate = 100 * float64(stats.Hits) / float64(total)
+	}
+
+	row("Hits", fmt.Sprintf("%d (%.1f%%)", stats.Hits, hitRate))
+	row("Misses", fmt.Sprintf("%d", stats.Misses))
+	row("Evictions", fmt.Sprintf("%d", stats.Evictions))
+	row("Blocks", fmt.Sprintf("%d", stats.Blocks))
+	row("Size", fmt.Sprintf(
+		"%s of %s",
+		humanize.Bytes(uint64(stats.Size)),
+		humanize.Bytes(uint64(stats.MaxSize)),
+	))
+
+	return nil
+}
+
+func handleDebugMigrate(ctx *cli.Context, ctl *client.Client) error {
+	dryRun := ctx.Bool("dry-run")
+	result, err := ctl.DebugMigrate(dryRun)
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("migrate: %v", err)}
+	}
+
+	if len(result.Steps) == 0 {
+		fmt.Printf("Metadata is at version %d; nothing to do.\n", result.Version)
+		return nil
+	}
+
+	verb := "Migrated"
+	if dryRun {
+		verb = "Would migrate"
+	}
+
+	fmt.Printf("%s metadata from version %d to %d:\n", verb, result.Version, result.Target)
+	for _, step := range result.Steps {
+		fmt.Printf("  %d -> %d: %s\n", step.From, step.To, step.Description)
+	}
+
+	if result.SnapshotPath != "" {
+		fmt.Printf("The old metadata was exported to %s\n", result.SnapshotPath)
+	}
+
+	return nil
+}
+
+func handleDebugConvertDb(ctx *cli.Context, ctl *client.Client) error {
+	to := ctx.String("to")
+	if to == "" {
+		return ExitCode{BadArgs, "please specify the store to convert to with --to"}
+	}
+
+	from, err := ctl.DebugConvertDb(to)
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("convert-db: %v", err)}
+	}
+
+	if from == to {
+		fmt.Printf("%s is already selected for the metadata.\n", to)
+		return nil
+	}
+
+	fmt.Printf("The metadata was converted from %s to %s.\n", from, to)
+	return nil
+}
+
+func handleBackupCreate(ctx *cli.Context, ctl *client.Client) error {
+	absPath, err := filepath.Abs(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+
+	since := ctx.String("since")
+	if since != "" {
+		since, err = filepath.Abs(since)
+		if err != nil {
+			return err
+		}
+	}
+
+	password := ctx.String("password")
+	if password == "" {
+		pwdBytes, err := pwd.PromptNewPassword(20)
+		if err != nil {
+			return ExitCode{UnknownError, fmt.Sprintf("failed to read password: %v", err)}
+		}
+
+		password = string(pwdBytes)
+	}
+
+	info, err := ctl.BackupCreate(absPath, password, since, ctx.Bool("content"))
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("backup create: %v", err)}
+	}
+
+	fmt.Printf("Wrote backup %s to %s (%d blobs).\n", color.CyanString(info.ID), absPath, info.NBlobs)
+	if info.Parent != "" {
+		fmt.Printf(
+			"%d blobs are part of the backup %s.\n",
+			info.NInherited,
+			color.CyanString(info.Parent),
+		)
+	}
+
+	return nil
+}
+
+func handleBackupRestore(ctx *cli.Context, ctl *client.Client) error {
+	absPath, err := filepath.Abs(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+
+	password := ctx.String("password")
+	if password == "" {
+		password, err = pwd.PromptPassword()
+		if err != nil {
+			return ExitCode{UnknownError, fmt.Sprintf("failed to read password: %v", err)}
+		}
+	}
+
+	info, err := ctl.BackupRestore(absPath, password)
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("backup restore: %v", err)}
+	}
+
+	fmt.Printf(
+		"Restored backup %s of %s created on %s (%d blobs).\n",
+		color.CyanString(info.ID),
+		color.GreenString(info.Owner),
+		info.Created.Format(time.Stamp),
+		info.NBlobs,
+	)
+
+	fmt.Println("Restart the daemon to use the restored keys: brig daemon quit && brig daemon launch")
+	return nil
+}
+
+func handlePasswd(ctx *cli.Context, ctl *client.Client) error {
+	if ctx.IsSet("keyfile") && ctx.Bool("no-keyfile") {
+		return ExitCode{BadArgs, "passwd: --keyfile and --no-keyfile exclude each other"}
+	}
+
+	keyfile := ctx.String("keyfile")
+	if keyfile != "" {
+		var err error
+		if keyfile, err = filepath.Abs(keyfile); err != nil {
+			return err
+		}
+	}
+
+	keepKeyfile := keyfile == "" && !ctx.Bool("no-keyfile")
+
+	fmt.Println("Enter the current password.")
+	oldPassword, err := pwd.PromptPassword()
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("failed to read password: %v", err)}
+	}
+
+	fmt.Println("Enter the new password.")
+	newPassword, err := pwd.PromptNewPassword(20)
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("failed to read password: %v", err)}
+	}
+
+	if err := ctl.Passwd(oldPassword, string(newPassword), keyfile, keepKeyfile); err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("passwd: %v", err)}
+	}
+
+	fmt.Println("Password changed.")
+	if pwCommand, err := ctl.ConfigGet("repo.password_command"); err == nil && pwCommand != "" {
+		fmt.Printf(
+			"%s repo.password_command is set (%s); make sure it returns the new password.\n",
+			color.YellowString("Note:"),
+			pwCommand,
+		)
+	}
+
+	return nil
+}
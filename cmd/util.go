@@ -296,7 +296,7 @@ func startDaemon(ctx *cli.Context, repoPath string, port int) (*client.Client, e
 
 	warningPrinted := false
 	for i := 0; i < 500; i++ {
-		ctl, err := client.Dial(context.Background(), port)
+		ctl, err := client.DialHost(context.Background(), bindHost, port)
 		if err != nil {
 			// Only print this warning once...
 			if !warningPrinted && i >= 100 {
@@ -352,9 +352,16 @@ func withDaemon(handler cmdHandlerWithClient, startNew bool) cli.ActionFunc {
 		}
 
 		// Check if the daemon is running already:
-		ctl, err := client.Dial(context.Background(), port)
+		ctl, err := client.DialHost(context.Background(), ctx.GlobalString("bind"), port)
 		if err == nil {
 			defer ctl.Close()
+			if err := selectRepo(ctx, ctl); err != nil {
+				return ExitCode{
+					DaemonNotResponding,
+					fmt.Sprintf("Unable to select repository: %v", err),
+				}
+			}
+
 			return handler(ctx, ctl)
 		}
 
@@ -381,6 +388,44 @@ func withDaemon(handler cmdHandlerWithClient, startNew bool) cli.ActionFunc {
 	}
 }
 
+// selectRepo makes `ctl` talk to the repository given by --repo, even if
+// the daemon was started for another one. The daemon loads it if needed.
+func selectRepo(ctx *cli.Context, ctl *client.Client) error {
+	if ctx.GlobalString("repo") == "" {
+		return nil
+	}
+
+	folder := guessRepoFolder(ctx)
+	if isInit, err := repoIsInitialized(folder); err != nil || !isInit {
+		// Probably about to be created; leave it to the command.
+		return nil
+	}
+
+	infos, err := ctl.RepoList()
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		if info.Path == folder {
+			logVerbose(ctx, "selecting already loaded repository %s", folder)
+			return ctl.RepoSelect(folder, "")
+		}
+	}
+
+	// The daemon needs to unlock it; only ask if there's no password helper.
+	password := ""
+	cfg, err := defaults.OpenMigratedConfig(filepath.Join(folder, "config.yml"))
+	if err != nil || cfg.String("repo.password_command") == "" {
+		if password, err = readPassword(ctx, folder); err != nil {
+			return err
+		}
+	}
+
+	logVerbose(ctx, "asking daemon to load repository %s", folder)
+	return ctl.RepoSelect(folder, password)
+}
+
 type checkFunc func(ctx *cli.Context) int
 
 func withArgCheck(checker checkFunc, handler cli.ActionFunc) cli.ActionFunc {
@@ -550,6 +595,26 @@ func parseDuration(s string) (float64, error) {
 	return float64(dur) / float64(time.Second), nil
 }
 
+// parseSince converts `s` to a point in time. It accepts either a duration
+// (like "24h", counted backwards from now), a RFC3339 timestamp or a plain
+// date in the form "2006-01-02".
+func parseSince(s string) (time.Time, error) {
+	if dur, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-dur), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad time: %s (use a duration, RFC3339 or YYYY-MM-DD)", s)
+	}
+
+	return t, nil
+}
+
 func readFormatTemplate(ctx *cli.Context) (*template.Template, error) {
 	if ctx.IsSet("format") {
 		source := ctx.String("format") + "\n"
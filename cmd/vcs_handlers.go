@@ -6,11 +6,13 @@ import (
 	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/sahib/brig/cmd/tabwriter"
 
+	"github.com/dustin/go-humanize"
 	"github.com/fatih/color"
 	"github.com/sahib/brig/client"
 	"github.com/urfave/cli"
@@ -136,6 +138,75 @@ func handleHistory(ctx *cli.Context, ctl *client.Client) error {
 	return tabW.Flush()
 }
 
+func blameAuthor(version client.BlameVersion) string {
+	if version.MergedWith != "" {
+		return version.MergedWith
+	}
+
+	return version.Author
+}
+
+func handleBlame(ctx *cli.Context, ctl *client.Client) error {
+	blame, err := ctl.Blame(ctx.Args().First())
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("blame: %v", err)}
+	}
+
+	if blame.IsBinary || ctx.Bool("versions") {
+		tabW := tabwriter.NewWriter(
+			os.Stdout, 0, 0, 2, ' ',
+			tabwriter.StripEscape,
+		)
+
+		if len(blame.Versions) != 0 {
+			fmt.Fprintf(tabW, "COMMIT\tAUTHOR\tWHEN\tSIZE\tPATH\tMSG\t\n")
+		}
+
+		for _, version := range blame.Versions {
+			fmt.Fprintf(
+				tabW,
+				"%s\t%s\t%s\t%s\t%s\t%s\t\n",
+				color.GreenString(commitName(version.Commit)),
+				color.YellowString(blameAuthor(version)),
+				color.MagentaString(version.Commit.Date.Format(time.Stamp)),
+				humanize.Bytes(version.Size),
+				version.Path,
+				version.Commit.Msg,
+			)
+		}
+
+		return tabW.Flush()
+	}
+
+	// The lines might contain tabs, so pad by hand instead of using a tabwriter:
+	nameWidth, authorWidth := 0, 0
+	for _, version := range blame.Versions {
+		if width := len(commitName(version.Commit)); width > nameWidth {
+			nameWidth = width
+		}
+
+		if width := len(blameAuthor(version)); width > authorWidth {
+			authorWidth = width
+		}
+	}
+
+	numWidth := len(strconv.Itoa(len(blame.Lines)))
+	for idx, line := range blame.Lines {
+		version := blame.Versions[line.Version]
+		fmt.Printf(
+			"%s  %s  %s  %*d) %s\n",
+			color.GreenString("%-*s", nameWidth, commitName(version.Commit)),
+			color.YellowString("%-*s", authorWidth, blameAuthor(version)),
+			color.MagentaString(version.Commit.Date.Format("2006-01-02 15:04")),
+			numWidth,
+			idx+1,
+			line.Text,
+		)
+	}
+
+	return nil
+}
+
 // makePathAbbrev tries to abbreviate the `dst` path if
 // both are in the same directory.
 func makePathAbbrev(srcNd, dstNd client.StatInfo) string {
@@ -527,7 +598,7 @@ func handleSyncSingle(ctx *cli.Context, ctl *client.Client, remoteName string) e
 		return nil
 	}
 
-	diff, err := ctl.Sync(remoteName, needFetch)
+	diff, err := ctl.SyncBranch(remoteName, ctx.String("branch"), needFetch)
 	if err != nil {
 		return err
 	}
@@ -648,6 +719,90 @@ func handleTag(ctx *cli.Context, ctl *client.Client) error {
 	return nil
 }
 
+func handleBranch(ctx *cli.Context, ctl *client.Client) error {
+	if ctx.Bool("delete") {
+		if len(ctx.Args()) < 1 {
+			return ExitCode{BadArgs, "branch -d needs the name of the branch"}
+		}
+
+		if err := ctl.BranchRemove(ctx.Args().First()); err != nil {
+			return ExitCode{UnknownError, fmt.Sprintf("branch: %v", err)}
+		}
+
+		return nil
+	}
+
+	if len(ctx.Args()) > 0 {
+		name := ctx.Args().Get(0)
+		rev := ctx.Args().Get(1)
+		if err := ctl.BranchMake(name, rev); err != nil {
+			return ExitCode{UnknownError, fmt.Sprintf("branch: %v", err)}
+		}
+
+		return nil
+	}
+
+	branches, err := ctl.BranchList()
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("branch: %v", err)}
+	}
+
+	tabW := tabwriter.NewWriter(
+		os.Stdout, 0, 0, 2, ' ',
+		tabwriter.StripEscape,
+	)
+
+	for _, branch := range branches {
+		marker := " "
+		name := branch.Name
+		if branch.IsCurrent {
+			marker = "*"
+			name = color.GreenString(name)
+		}
+
+		fmt.Fprintf(
+			tabW,
+			"%s %s\t%s\t%s\t\n",
+			marker,
+			name,
+			color.YellowString(branch.Tip.Hash.ShortB58()),
+			branch.Tip.Msg,
+		)
+	}
+
+	return tabW.Flush()
+}
+
+func handleSwitch(ctx *cli.Context, ctl *client.Client) error {
+	name := ctx.Args().First()
+	if ctx.Bool("create") {
+		if err := ctl.BranchMake(name, ""); err != nil {
+			return ExitCode{UnknownError, fmt.Sprintf("switch: %v", err)}
+		}
+	}
+
+	if err := ctl.BranchSwitch(name, ctx.Bool("force")); err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("switch: %v", err)}
+	}
+
+	return nil
+}
+
+func handleMerge(ctx *cli.Context, ctl *client.Client) error {
+	diff, err := ctl.Merge(ctx.Args().First())
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("merge: %v", err)}
+	}
+
+	if isEmptyDiff(diff) {
+		fmt.Println("Nothing changed.")
+		return nil
+	}
+
+	printDiff(diff, false)
+	return nil
+}
+
 func handleLog(ctx *cli.Context, ctl *client.Client) error {
 	entries, err := ctl.Log()
 	if err != nil {
@@ -704,3 +859,175 @@ func handleLog(ctx *cli.Context, ctl *client.Client) error {
 
 	return nil
 }
+
+func handleBundleCreate(ctx *cli.Context, ctl *client.Client) error {
+	remote := ctx.String("remote")
+	if remote == "" {
+		return ExitCode{BadArgs, "bundle create: --remote is required"}
+	}
+
+	absPath, err := filepath.Abs(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+
+	info, err := ctl.BundleCreate(absPath, remote, ctx.String("since"), ctx.Bool("content"))
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("bundle create: %v", err)}
+	}
+
+	fmt.Printf(
+		"Wrote changes since %s for %s to %s (%d blobs).\n",
+		color.CyanString(info.Since),
+		color.GreenString(info.Remote),
+		absPath,
+		info.NBlobs,
+	)
+
+	return nil
+}
+
+func handleBundleApply(ctx *cli.Context, ctl *client.Client) error {
+	absPath, err := filepath.Abs(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+
+	info, diff, err := ctl.BundleApply(absPath, ctx.Bool("no-sync"))
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("bundle apply: %v", err)}
+	}
+
+	fmt.Printf(
+		"Applied bundle from %s created on %s (%d blobs).\n",
+		color.GreenString(info.Owner),
+		info.Created.Format(time.Stamp),
+		info.NBlobs,
+	)
+
+	if diff == nil {
+		return nil
+	}
+
+	if isEmptyDiff(diff) {
+		fmt.Println("Nothing changed.")
+		return nil
+	}
+
+	printDiff(diff, false)
+	return nil
+}
+
+func printPickResult(conflicts []string, diff *client.Diff) {
+	for _, conflict := range conflicts {
+		fmt.Printf("%s %s\n", color.RedString("Conflict:"), conflict)
+	}
+
+	if isEmptyDiff(diff) {
+		fmt.Println("Nothing changed.")
+		return
+	}
+
+	printDiff(diff, false)
+}
+
+func handleRevert(ctx *cli.Context, ctl *client.Client) error {
+	conflicts, diff, err := ctl.Revert(ctx.Args().First())
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("revert: %v", err)}
+	}
+
+	printPickResult(conflicts, diff)
+	return nil
+}
+
+func handleCherryPick(ctx *cli.Context, ctl *client.Client) error {
+	remote, rev := ctx.Args().Get(0), ctx.Args().Get(1)
+	conflicts, diff, err := ctl.CherryPick(remote, rev, !ctx.Bool("no-fetch"))
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("cherry-pick: %v", err)}
+	}
+
+	printPickResult(conflicts, diff)
+	return nil
+}
+
+func stashIndexArg(ctx *cli.Context) (int, error) {
+	if ctx.NArg() == 0 {
+		return 0, nil
+	}
+
+	idx, err := strconv.Atoi(ctx.Args().First())
+	if err != nil {
+		return 0, ExitCode{BadArgs, fmt.Sprintf("invalid stash index: %s", ctx.Args().First())}
+	}
+
+	return idx, nil
+}
+
+func handleStashPush(ctx *cli.Context, ctl *client.Client) error {
+	if err := ctl.StashPush(ctx.String("message")); err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("stash: %v", err)}
+	}
+
+	return nil
+}
+
+func handleStashPop(ctx *cli.Context, ctl *client.Client) error {
+	idx, err := stashIndexArg(ctx)
+	if err != nil {
+		return err
+	}
+
+	conflicts, err := ctl.StashPop(idx)
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("stash pop: %v", err)}
+	}
+
+	for _, conflict := range conflicts {
+		fmt.Printf("%s %s\n", color.RedString("Conflict:"), conflict)
+	}
+
+	if len(conflicts) > 0 {
+		fmt.Println("The stash was kept; drop it once the conflicts are resolved.")
+	}
+
+	return nil
+}
+
+func handleStashList(ctx *cli.Context, ctl *client.Client) error {
+	stashes, err := ctl.StashList()
+	if err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("stash list: %v", err)}
+	}
+
+	tabW := tabwriter.NewWriter(
+		os.Stdout, 0, 0, 2, ' ',
+		tabwriter.StripEscape,
+	)
+
+	for idx, stash := range stashes {
+		fmt.Fprintf(
+			tabW,
+			"%s\t%s\t%s\t\n",
+			color.CyanString(strconv.Itoa(idx)),
+			color.YellowString(stash.Date.Format(time.Stamp)),
+			stash.Msg,
+		)
+	}
+
+	return tabW.Flush()
+}
+
+func handleStashDrop(ctx *cli.Context, ctl *client.Client) error {
+	idx, err := stashIndexArg(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := ctl.StashDrop(idx); err != nil {
+		return ExitCode{UnknownError, fmt.Sprintf("stash drop: %v", err)}
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package defaults
 
 import (
+	"io"
 	"os"
 
 	e "github.com/pkg/errors"
@@ -24,11 +25,17 @@ func OpenMigratedConfig(path string) (*config.Config, error) {
 
 	defer fd.Close()
 
+	return MigratedConfigFromReader(fd)
+}
+
+// MigratedConfigFromReader is like OpenMigratedConfig,
+// but reads the config in YAML format from `r`.
+func MigratedConfigFromReader(r io.Reader) (*config.Config, error) {
 	// NOTE: Add here any migrations with mgr.Add if needed.
 	mgr := config.NewMigrater(CurrentVersion, config.StrictnessPanic)
 	mgr.Add(0, nil, DefaultsV0)
 
-	cfg, err := mgr.Migrate(config.NewYamlDecoder(fd))
+	cfg, err := mgr.Migrate(config.NewYamlDecoder(r))
 	if err != nil {
 		return nil, e.Wrap(err, "failed to migrate or open")
 	}
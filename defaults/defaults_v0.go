@@ -23,6 +23,18 @@ var DefaultsV0 = config.DefaultMapping{
 			NeedsRestart: true,
 			Docs:         "Enable a ppropf profile server on startup (see »brig d p --help«)",
 		},
+		"metrics": config.DefaultMapping{
+			"enabled": config.DefaultEntry{
+				Default:      false,
+				NeedsRestart: true,
+				Docs:         "Export metrics in the Prometheus format on /metrics.",
+			},
+			"address": config.DefaultEntry{
+				Default:      "localhost:9101",
+				NeedsRestart: true,
+				Docs:         "Address the metrics server listens on.",
+			},
+		},
 	},
 	"events": config.DefaultMapping{
 		"enabled": config.DefaultEntry{
@@ -133,6 +145,40 @@ var DefaultsV0 = config.DefaultMapping{
 				Docs:         "Key used for CSRF protection. Generated if empty.",
 			},
 		},
+		"auto_commit": config.DefaultEntry{
+			Default:      true,
+			NeedsRestart: false,
+			Docs: `Commit every change done via the gateway immediately.
+
+  If disabled, changes stay staged until somebody commits them,
+  either via 'brig commit' or via the UI.
+`,
+		},
+		"thumbs": config.DefaultMapping{
+			"cache_size": config.DefaultEntry{
+				Default:      "64MB",
+				NeedsRestart: true,
+				Docs:         "Maximum size of the on-disk cache for thumbnails and text previews.",
+			},
+		},
+		"audit": config.DefaultMapping{
+			"enabled": config.DefaultEntry{
+				Default:      true,
+				NeedsRestart: false,
+				Docs:         "Record every API call and download done via the gateway in the audit log.",
+			},
+			"max_size": config.DefaultEntry{
+				Default:      "10MB",
+				NeedsRestart: true,
+				Docs:         "Maximum size of a single audit log file before it gets rotated.",
+			},
+			"max_files": config.DefaultEntry{
+				Default:      5,
+				NeedsRestart: true,
+				Docs:         "How many audit log files to keep. Older files are deleted on rotation.",
+				Validator:    config.IntRangeValidator(1, 1000),
+			},
+		},
 	},
 	"fs": config.DefaultMapping{
 		"sync": config.DefaultMapping{
@@ -240,6 +286,82 @@ var DefaultsV0 = config.DefaultMapping{
 				Validator:    config.DurationValidator(),
 			},
 		},
+		"mirror": config.DefaultMapping{
+			"debounce": config.DefaultEntry{
+				Default:      "1s",
+				NeedsRestart: true,
+				Docs:         "How long to wait for more local changes before staging them.",
+				Validator:    config.DurationValidator(),
+			},
+			"rescan_interval": config.DefaultEntry{
+				Default:      "1m",
+				NeedsRestart: true,
+				Docs:         "How often to compare both sides of a mirror, even without reported changes.",
+				Validator:    config.DurationValidator(),
+			},
+		},
+		"ignore": config.DefaultMapping{
+			"patterns": config.DefaultEntry{
+				Default:      []string{"*~", ".*.swp", ".*.swx", ".#*", "#*#", ".DS_Store"},
+				NeedsRestart: false,
+				Docs: `Files that should not be staged, in the syntax of gitignore(5).
+
+  They apply when staging directories, to mirrors and to gateway uploads,
+  in addition to the ».brigignore« files in the staged directories.
+`,
+			},
+		},
+		"db": config.DefaultMapping{
+			"backend": config.DefaultEntry{
+				Default:      "badger",
+				NeedsRestart: true,
+				Docs: `Which key/value store to use for the metadata.
+
+  »badger« is fast, but its log files can grow large. »bolt« stores
+  everything in a single, more compact file. Existing metadata is converted
+  when the daemon starts the next time. Use »brig debug convert-db« to
+  convert it right away.
+`,
+				Validator: config.EnumValidator(
+					"badger", "bolt",
+				),
+			},
+		},
+		"block_cache": config.DefaultMapping{
+			"size": config.DefaultEntry{
+				Default:      "64MB",
+				NeedsRestart: false,
+				Docs: `Maximum size of the cache for decrypted file content.
+
+  Blocks of files that were read recently are kept in the repository, so
+  reading them again does not need the backend. Set to »0B« to disable.
+`,
+			},
+			"read_ahead": config.DefaultEntry{
+				Default:      2,
+				NeedsRestart: false,
+				Docs:         "How many blocks (64KB each) to fetch in advance when reading a file sequentially.",
+				Validator:    config.IntRangeValidator(0, 64),
+			},
+		},
+		"search": config.DefaultMapping{
+			"fulltext": config.DefaultMapping{
+				"enabled": config.DefaultEntry{
+					Default:      false,
+					NeedsRestart: false,
+					Docs: `Index the content of text files for »brig find text:word«.
+
+  The index is updated after each commit and only covers files whose content
+  is available locally. It is stored along with the other metadata.
+`,
+				},
+				"max_size": config.DefaultEntry{
+					Default:      "1MB",
+					NeedsRestart: false,
+					Docs:         "Files bigger than this are not added to the full-text index.",
+				},
+			},
+		},
 	},
 	"repo": config.DefaultMapping{
 		"current_user": config.DefaultEntry{
@@ -289,6 +411,31 @@ var DefaultsV0 = config.DefaultMapping{
 				NeedsRestart: true,
 				Docs:         "The virtual root of the mount.",
 			},
+			"rev": config.DefaultEntry{
+				Default:      "",
+				NeedsRestart: true,
+				Docs:         "Mount the state of this commit or tag read-only (empty for the current state).",
+			},
+			"snapshots": config.DefaultEntry{
+				Default:      false,
+				NeedsRestart: true,
+				Docs:         "Offer a hidden .snapshots directory in the root with all tagged commits.",
+			},
+		},
+	},
+	"mirrors": config.DefaultMapping{
+		// This key stands for the name of the mirror:
+		"__many__": config.DefaultMapping{
+			"local_path": config.DefaultEntry{
+				Default:      "",
+				NeedsRestart: true,
+				Docs:         "The local directory that is mirrored.",
+			},
+			"repo_path": config.DefaultEntry{
+				Default:      "/",
+				NeedsRestart: true,
+				Docs:         "The directory in the repository it is mirrored to.",
+			},
 		},
 	},
 }
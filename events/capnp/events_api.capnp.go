@@ -8,18 +8,97 @@ import (
 	schemas "zombiezen.com/go/capnproto2/schemas"
 )
 
+// Change is a single path affected by an event
+type Change struct{ capnp.Struct }
+
+// Change_TypeID is the unique identifier for the type Change.
+const Change_TypeID = 0xd6a9a10db7966776
+
+func NewChange(s *capnp.Segment) (Change, error) {
+	st, err := capnp.NewStruct(s, capnp.ObjectSize{DataSize: 8, PointerCount: 1})
+	return Change{st}, err
+}
+
+func NewRootChange(s *capnp.Segment) (Change, error) {
+	st, err := capnp.NewRootStruct(s, capnp.ObjectSize{DataSize: 8, PointerCount: 1})
+	return Change{st}, err
+}
+
+func ReadRootChange(msg *capnp.Message) (Change, error) {
+	root, err := msg.RootPtr()
+	return Change{root.Struct()}, err
+}
+
+func (s Change) String() string {
+	str, _ := text.Marshal(0xd6a9a10db7966776, s.Struct)
+	return str
+}
+
+func (s Change) Path() (string, error) {
+	p, err := s.Struct.Ptr(0)
+	return p.Text(), err
+}
+
+func (s Change) HasPath() bool {
+	p, err := s.Struct.Ptr(0)
+	return p.IsValid() || err != nil
+}
+
+func (s Change) PathBytes() ([]byte, error) {
+	p, err := s.Struct.Ptr(0)
+	return p.TextBytes(), err
+}
+
+func (s Change) SetPath(v string) error {
+	return s.Struct.SetText(0, v)
+}
+
+func (s Change) Mask() uint64 {
+	return s.Struct.Uint64(0)
+}
+
+func (s Change) SetMask(v uint64) {
+	s.Struct.SetUint64(0, v)
+}
+
+// Change_List is a list of Change.
+type Change_List struct{ capnp.List }
+
+// NewChange creates a new list of Change.
+func NewChange_List(s *capnp.Segment, sz int32) (Change_List, error) {
+	l, err := capnp.NewCompositeList(s, capnp.ObjectSize{DataSize: 8, PointerCount: 1}, sz)
+	return Change_List{l}, err
+}
+
+func (s Change_List) At(i int) Change { return Change{s.List.Struct(i)} }
+
+func (s Change_List) Set(i int, v Change) error { return s.List.SetStruct(i, v.Struct) }
+
+func (s Change_List) String() string {
+	str, _ := text.MarshalList(0xd6a9a10db7966776, s.List)
+	return str
+}
+
+// Change_Promise is a wrapper for a Change promised by a client call.
+type Change_Promise struct{ *capnp.Pipeline }
+
+func (p Change_Promise) Struct() (Change, error) {
+	s, err := p.Pipeline.Struct()
+	return Change{s}, err
+}
+
 type Event struct{ capnp.Struct }
 
 // Event_TypeID is the unique identifier for the type Event.
 const Event_TypeID = 0x9c032508b61d1d09
 
 func NewEvent(s *capnp.Segment) (Event, error) {
-	st, err := capnp.NewStruct(s, capnp.ObjectSize{DataSize: 0, PointerCount: 1})
+	st, err := capnp.NewStruct(s, capnp.ObjectSize{DataSize: 0, PointerCount: 3})
 	return Event{st}, err
 }
 
 func NewRootEvent(s *capnp.Segment) (Event, error) {
-	st, err := capnp.NewRootStruct(s, capnp.ObjectSize{DataSize: 0, PointerCount: 1})
+	st, err := capnp.NewRootStruct(s, capnp.ObjectSize{DataSize: 0, PointerCount: 3})
 	return Event{st}, err
 }
 
@@ -52,12 +131,56 @@ func (s Event) SetType(v string) error {
 	return s.Struct.SetText(0, v)
 }
 
+func (s Event) Changes() (Change_List, error) {
+	p, err := s.Struct.Ptr(1)
+	return Change_List{List: p.List()}, err
+}
+
+func (s Event) HasChanges() bool {
+	p, err := s.Struct.Ptr(1)
+	return p.IsValid() || err != nil
+}
+
+func (s Event) SetChanges(v Change_List) error {
+	return s.Struct.SetPtr(1, v.List.ToPtr())
+}
+
+// NewChanges sets the changes field to a newly
+// allocated Change_List, preferring placement in s's segment.
+func (s Event) NewChanges(n int32) (Change_List, error) {
+	l, err := NewChange_List(s.Struct.Segment(), n)
+	if err != nil {
+		return Change_List{}, err
+	}
+	err = s.Struct.SetPtr(1, l.List.ToPtr())
+	return l, err
+}
+
+func (s Event) CommitHash() (string, error) {
+	p, err := s.Struct.Ptr(2)
+	return p.Text(), err
+}
+
+func (s Event) HasCommitHash() bool {
+	p, err := s.Struct.Ptr(2)
+	return p.IsValid() || err != nil
+}
+
+func (s Event) CommitHashBytes() ([]byte, error) {
+	p, err := s.Struct.Ptr(2)
+	return p.TextBytes(), err
+}
+
+func (s Event) SetCommitHash(v string) error {
+	return s.Struct.SetText(2, v)
+}
+
 // Event_List is a list of Event.
 type Event_List struct{ capnp.List }
 
 // NewEvent creates a new list of Event.
 func NewEvent_List(s *capnp.Segment, sz int32) (Event_List, error) {
-	l, err := capnp.NewCompositeList(s, capnp.ObjectSize{DataSize: 0, PointerCount: 1}, sz)
+	l, err := capnp.NewCompositeList(s, capnp.ObjectSize{DataSize: 0, PointerCount: 3}, sz)
 	return Event_List{l}, err
 }
 
@@ -78,19 +201,32 @@ func (p Event_Promise) Struct() (Event, error) {
 	return Event{s}, err
 }
 
-const schema_fc8938b535319bfe = "x\xda\x12Hu`2d\x15gb`\x08\x94`e" +
-	"\xfb\xcf)+\xbb\x8dC\x95y\x0e\x83\xa0\x1c\xe3\xff\x7f" +
-	"\xb3\x0dM\xb7Zt\xfea`edg`0\xfc\xa8" +
-	"\xc4(\xcc\xc8\xc8.\xcc\xc8(/\xac\xc8h\xcf`\xf1" +
-	"?\xb5,5\xaf\xa4X?\x999\xb1 \xaf@\x1f\xc2" +
-	"\x8bO,\xc8\xd4K\x06\x09X\xb9\x96\xb1\xa7\xe6\x95\x04" +
-	"02\x06\xb202\xfd\x8f\x9b<?p\xef\xb5\xae\xa3" +
-	"\x0c\x81,L\x8c\x8e\x02\x8c\x8c<\x0c\x0c\x82\x8c\\\x0c" +
-	"\x8c\x81,\xcc,\x0c\x0c,\x8c\x0c\x0c\x82\xbcZ\x0c\x0c" +
-	"\x81\x1c\xcc\x8c\x81\"L\x8c\xfc%\x95\x05\xa9\x8c<\x0c" +
-	"L\x8c<\x0c\x8c\x80\x00\x00\x00\xff\xff\x9f[%\x81"
+const schema_fc8938b535319bfe = "x\xda\x84\x8f\xb1\x8b\x13A\x14\xc6\xbf\xef\xcd\xc6\xbb\xc0" +
+	"\xea\xde\x92+\xcd\xdd 'x\x01\x8dA\x03\xc1*\x1a" +
+	"\x03\x0a\x16Nk\xa1\x8e\x9bIv5\xd9,\xec\x12I" +
+	"e\xad\xad`\xa3 \x96VVje\xa7\xffB\xfe\x13" +
+	";qe\x94\xa8\x85`9\xdf|\xef\xf7\xdeo\xef\xf3" +
+	"Pz\x8d\xa5\x00\xe6t\xe3D\xddl\xb7?\xec\x9eU" +
+	"\xaf\x10\x1f\xb0\xfe\xfe\xb2\xd7\x7f?x\xfa\x0d\x0d\xb5\x03" +
+	"\\j\xf3\x0c\x81\xd61\x0f[c\xbe\x03\xeb\xd5\xec\xc5" +
+	"\xc7\x93o\xden`\x0e\xf8w\x9b\xbe\xfd\x95\x1d\xdf\xa6" +
+	"\x1c\xb6\xfa\xf2\x18\x83\xda\xad\\^\x95\xddD\xd9\"/" +
+	"\xba\xbf^\xf7l\x91]H|pe\xbc\xdaqye" +
+	"\x02J}\xf7\xf9k\xf3i\xf3\xec\x0bL \xbc\x1a\x92" +
+	"!\xc4\x84*\x00\x02\x02\xf1\xb8\x03\x98\xa1\xa2\xb9%\x8c" +
+	"\xc9}\xfa\xf0\xe65\xc0\\W4\x13a,\xb2O\x01" +
+	"b{\x070\xf7\x15\xcd\\\x18U\xeb\xc2y\x12C\xf0" +
+	"I\x92\xda|\xe6J\x9e\x02o+r\xef\x8f\x0b0$" +
+	"\xe0?\xead\xb9Xd\xd5\x0d\x0bU\xa6\xdb\xc9\xffi" +
+	"\x8c\xd2\xc8\x93\xff\xe1q\xe4=\xd0\xe3C\xd6\xa3\x9f\xdb" +
+	"u\x16\x94\xda\xea2\xcbgs\xa7\x0b[\xa5\xdaN\xa7" +
+	".\xa9\xdcD?Xk\x9bk\x17y<`v\x7f\xcb" +
+	"\x1f{\xf9#EsQ\xb8u?\xef\xb3s\x8a\xe6\xb2" +
+	"0\xf2\x9c\xed\xb1\xd1\xc2\x96\x8f\xd8\x84\xb0\x09\xfe\x18\x00" +
+	"\x05zu<"
 
 func init() {
 	schemas.Register(schema_fc8938b535319bfe,
-		0x9c032508b61d1d09)
+		0x9c032508b61d1d09,
+		0xd6a9a10db7966776)
 }
@@ -2,7 +2,11 @@ package events
 
 import (
 	"fmt"
+	"path"
+	"sort"
+	"strings"
 
+	"github.com/sahib/brig/catfs/vcs"
 	capnp_model "github.com/sahib/brig/events/capnp"
 	capnp "zombiezen.com/go/capnproto2"
 )
@@ -44,10 +48,79 @@ func EventFromString(ev string) (EventType, error) {
 	}
 }
 
+// Change describes how a single path was affected by an event.
+type Change struct {
+	// Path is the absolute path of the node that changed.
+	Path string
+	// Mask describes what happened to the node at Path.
+	Mask vcs.ChangeType
+}
+
 // Event is a event that can be published or received by the event subsystem.
 type Event struct {
 	Type   EventType
 	Source string
+
+	// Changes lists the paths affected by this event, if known.
+	// An empty list means that anything might have changed.
+	Changes []Change
+
+	// CommitHash is the hash of the commit that contains the changes.
+	// It is empty for changes that were not committed yet.
+	CommitHash string
+}
+
+// ChangesFromMasks converts a mapping of paths to change masks
+// to a list of changes, sorted by path.
+func ChangesFromMasks(masks map[string]vcs.ChangeType) []Change {
+	changes := []Change{}
+	for changePath, mask := range masks {
+		changes = append(changes, Change{Path: changePath, Mask: mask})
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes
+}
+
+// hasPathPrefix checks if `nodePath` is `prefix` or lies below it.
+func hasPathPrefix(nodePath, prefix string) bool {
+	prefix = path.Clean("/" + prefix)
+	if prefix == "/" || nodePath == prefix {
+		return true
+	}
+
+	return strings.HasPrefix(nodePath, prefix+"/")
+}
+
+// FilterByPrefixes returns a copy of `ev` that only contains the changes
+// below one of `prefixes`. If none of the changes match, nil is returned.
+// Events without any changes always match, since we cannot tell what
+// changed. An empty list of prefixes also matches everything.
+func (msg *Event) FilterByPrefixes(prefixes []string) *Event {
+	if len(prefixes) == 0 || len(msg.Changes) == 0 {
+		return msg
+	}
+
+	filtered := *msg
+	filtered.Changes = nil
+
+	for _, change := range msg.Changes {
+		for _, prefix := range prefixes {
+			if hasPathPrefix(change.Path, prefix) {
+				filtered.Changes = append(filtered.Changes, change)
+				break
+			}
+		}
+	}
+
+	if len(filtered.Changes) == 0 {
+		return nil
+	}
+
+	return &filtered
 }
 
 func (msg *Event) encode() ([]byte, error) {
@@ -65,6 +138,31 @@ func (msg *Event) encode() ([]byte, error) {
 		return nil, err
 	}
 
+	if err := capEv.SetCommitHash(msg.CommitHash); err != nil {
+		return nil, err
+	}
+
+	capChanges, err := capEv.NewChanges(int32(len(msg.Changes)))
+	if err != nil {
+		return nil, err
+	}
+
+	for idx, change := range msg.Changes {
+		capChange, err := capnp_model.NewChange(seg)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := capChange.SetPath(change.Path); err != nil {
+			return nil, err
+		}
+
+		capChange.SetMask(uint64(change.Mask))
+		if err := capChanges.Set(idx, capChange); err != nil {
+			return nil, err
+		}
+	}
+
 	return capMsg.Marshal()
 }
 
@@ -93,26 +191,87 @@ func decodeMessage(data []byte) (*Event, error) {
 		return nil, err
 	}
 
-	return &Event{Type: ev}, nil
+	// Older peers do not send the fields below;
+	// they will just read as empty values then.
+	commitHash, err := capEv.CommitHash()
+	if err != nil {
+		return nil, err
+	}
+
+	capChanges, err := capEv.Changes()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []Change{}
+	for idx := 0; idx < capChanges.Len(); idx++ {
+		capChange := capChanges.At(idx)
+		changePath, err := capChange.Path()
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, Change{
+			Path: changePath,
+			Mask: vcs.ChangeType(capChange.Mask()),
+		})
+	}
+
+	return &Event{
+		Type:       ev,
+		Changes:    changes,
+		CommitHash: commitHash,
+	}, nil
+}
+
+// mergeChanges combines two lists of changes.
+// Masks of the same path are or'ed together.
+func mergeChanges(a, b []Change) []Change {
+	masks := make(map[string]vcs.ChangeType)
+	for _, change := range a {
+		masks[change.Path] |= change.Mask
+	}
+
+	for _, change := range b {
+		masks[change.Path] |= change.Mask
+	}
+
+	return ChangesFromMasks(masks)
 }
 
+// dedupeEvents merges events of the same type and source into one.
+// The changed paths of merged events are combined and the commit hash
+// of the latest event wins.
 func dedupeEvents(evs []Event) []Event {
-	seen := make(map[EventType]map[string]bool)
+	seen := make(map[EventType]map[string]int)
 	dedupEvs := []Event{}
 
 	for _, ev := range evs {
 		seenSources, ok := seen[ev.Type]
 		if ok {
-			if seenSources[ev.Source] {
+			if idx, ok := seenSources[ev.Source]; ok {
+				prev := &dedupEvs[idx]
+				if len(prev.Changes) > 0 && len(ev.Changes) > 0 {
+					prev.Changes = mergeChanges(prev.Changes, ev.Changes)
+				} else {
+					// One of both does not know what changed,
+					// so the merged event can't know either.
+					prev.Changes = nil
+				}
+
+				if ev.CommitHash != "" {
+					prev.CommitHash = ev.CommitHash
+				}
+
 				continue
 			}
 		} else {
-			seenSources = make(map[string]bool)
+			seenSources = make(map[string]int)
 			seen[ev.Type] = seenSources
 		}
 
 		dedupEvs = append(dedupEvs, ev)
-		seen[ev.Type][ev.Source] = true
+		seen[ev.Type][ev.Source] = len(dedupEvs) - 1
 	}
 
 	return dedupEvs
@@ -7,6 +7,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sahib/brig/events/backend"
 	"github.com/sahib/config"
 	log "github.com/sirupsen/logrus"
@@ -18,6 +20,18 @@ const (
 	maxBurstSize         = 100
 )
 
+var (
+	metricQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "brig_events_queue_depth",
+		Help: "Number of events waiting to be processed.",
+	}, []string{"direction"})
+
+	metricDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "brig_events_dropped_total",
+		Help: "Number of events that were dropped because the queue was full.",
+	}, []string{"direction"})
+)
+
 // Listener listens to incoming events from other remotes.
 // For every event, a registered callback can be executed.
 // It does not implement net.Listener and is only similar from a concept POV.
@@ -37,6 +51,14 @@ type Listener struct {
 type callback struct {
 	fn          func(*Event)
 	notifyOnOwn bool
+	prefixes    []string
+}
+
+// call executes the callback if `ev` matches the callback's prefixes.
+func (cb callback) call(ev Event) {
+	if filtered := ev.FilterByPrefixes(cb.prefixes); filtered != nil {
+		go cb.fn(filtered)
+	}
 }
 
 // NewListener constructs a new listener.
@@ -97,7 +119,26 @@ func (lst *Listener) RegisterEventHandler(ev EventType, notifyOnOwn bool, hdl fu
 	})
 }
 
-func eventLoop(evCh chan Event, interval time.Duration, rps float64, fn func(ev Event)) {
+// RegisterPrefixEventHandler works like RegisterEventHandler, but `hdl` is
+// only called for events that affect a path below one of `prefixes`.
+// The event passed to `hdl` only contains the matching changes. Events that
+// do not carry any path information are always passed on.
+func (lst *Listener) RegisterPrefixEventHandler(ev EventType, notifyOnOwn bool, prefixes []string, hdl func(ev *Event)) {
+	lst.mu.Lock()
+	defer lst.mu.Unlock()
+
+	if lst.isClosed {
+		return
+	}
+
+	lst.callbacks[ev] = append(lst.callbacks[ev], callback{
+		fn:          hdl,
+		notifyOnOwn: notifyOnOwn,
+		prefixes:    prefixes,
+	})
+}
+
+func eventLoop(evCh chan Event, direction string, interval time.Duration, rps float64, fn func(ev Event)) {
 	tckr := time.NewTicker(interval)
 	defer tckr.Stop()
 
@@ -106,6 +147,7 @@ func eventLoop(evCh chan Event, interval time.Duration, rps float64, fn func(ev
 	// We still rate limit while processing too many at the same time.
 	events := []Event{}
 	lim := rate.NewLimiter(rate.Limit(rps), maxBurstSize)
+	depth := metricQueueDepth.WithLabelValues(direction)
 
 	for {
 		select {
@@ -135,6 +177,7 @@ func eventLoop(evCh chan Event, interval time.Duration, rps float64, fn func(ev
 			}
 
 			events = []Event{}
+			depth.Set(float64(len(evCh)))
 		case ev, ok := <-evCh:
 			if !ok {
 				return
@@ -142,10 +185,12 @@ func eventLoop(evCh chan Event, interval time.Duration, rps float64, fn func(ev
 
 			if len(events) > maxBurstSize {
 				// drop events if the list gets too big:
+				metricDropped.WithLabelValues(direction).Inc()
 				continue
 			}
 
 			events = append(events, ev)
+			depth.Set(float64(len(events) + len(evCh)))
 		}
 	}
 }
@@ -154,12 +199,12 @@ func (lst *Listener) eventRecvLoop() {
 	recvInterval := lst.cfg.Duration("recv_interval")
 	recvMaxEvRPS := lst.cfg.Float("recv_max_events_per_second")
 
-	eventLoop(lst.evRecvCh, recvInterval, recvMaxEvRPS, func(ev Event) {
+	eventLoop(lst.evRecvCh, "recv", recvInterval, recvMaxEvRPS, func(ev Event) {
 		lst.mu.Lock()
 		if cbs, ok := lst.callbacks[ev.Type]; ok {
 			for _, cb := range cbs {
 				if !cb.notifyOnOwn {
-					go cb.fn(&ev)
+					cb.call(ev)
 				}
 			}
 		}
@@ -173,7 +218,7 @@ func (lst *Listener) eventSendLoop() {
 	sendInterval := lst.cfg.Duration("send_interval")
 	sendMaxEvRPS := lst.cfg.Float("send_max_events_per_second")
 
-	eventLoop(lst.evSendCh, sendInterval, sendMaxEvRPS, func(ev Event) {
+	eventLoop(lst.evSendCh, "send", sendInterval, sendMaxEvRPS, func(ev Event) {
 		data, err := ev.encode()
 		if err != nil {
 			log.Errorf("event: failed to encode: %v", err)
@@ -191,7 +236,7 @@ func (lst *Listener) publishToSelf(ev Event) {
 	if cbs, ok := lst.callbacks[ev.Type]; ok {
 		for _, cb := range cbs {
 			if cb.notifyOnOwn {
-				go cb.fn(&ev)
+				cb.call(ev)
 			}
 		}
 	}
@@ -223,6 +268,7 @@ func (lst *Listener) PublishEvent(ev Event) error {
 	case lst.evSendCh <- ev:
 		return nil
 	default:
+		metricDropped.WithLabelValues("send").Inc()
 		return fmt.Errorf("lost event: %v", ev)
 	}
 }
@@ -313,6 +359,7 @@ func (lst *Listener) listenSingle(ctx context.Context, topic string) error {
 		select {
 		case lst.evRecvCh <- *ev:
 		default:
+			metricDropped.WithLabelValues("recv").Inc()
 			log.Warningf("dropped incoming event: %v", ev)
 		}
 	}
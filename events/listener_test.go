@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/sahib/brig/catfs/vcs"
 	"github.com/sahib/brig/defaults"
 	"github.com/sahib/brig/events/mock"
 	"github.com/sahib/config"
@@ -63,3 +64,89 @@ func TestBasicRun(t *testing.T) {
 		time.Sleep(200 * time.Millisecond)
 	})
 }
+
+func TestEncodeDecodeChanges(t *testing.T) {
+	ev := Event{
+		Type: FsEvent,
+		Changes: []Change{
+			{Path: "/a", Mask: vcs.ChangeTypeAdd},
+			{Path: "/b/c", Mask: vcs.ChangeTypeMove | vcs.ChangeTypeModify},
+		},
+		CommitHash: "W1cmtHash",
+	}
+
+	data, err := ev.encode()
+	require.Nil(t, err)
+
+	decoded, err := decodeMessage(data)
+	require.Nil(t, err)
+	require.Equal(t, ev, *decoded)
+}
+
+func TestDedupeMergesChanges(t *testing.T) {
+	evs := dedupeEvents([]Event{
+		{Type: FsEvent, Source: "a", Changes: []Change{{Path: "/x", Mask: vcs.ChangeTypeAdd}}},
+		{Type: FsEvent, Source: "a", Changes: []Change{{Path: "/x", Mask: vcs.ChangeTypeModify}}, CommitHash: "W1"},
+		{Type: FsEvent, Source: "b", Changes: []Change{{Path: "/y", Mask: vcs.ChangeTypeRemove}}},
+		{Type: FsEvent, Source: "b"},
+	})
+
+	require.Len(t, evs, 2)
+	require.Equal(t, []Change{{Path: "/x", Mask: vcs.ChangeTypeAdd | vcs.ChangeTypeModify}}, evs[0].Changes)
+	require.Equal(t, "W1", evs[0].CommitHash)
+
+	// The second event of "b" does not know what changed:
+	require.Nil(t, evs[1].Changes)
+}
+
+func TestFilterByPrefixes(t *testing.T) {
+	ev := &Event{
+		Type: FsEvent,
+		Changes: []Change{
+			{Path: "/photos/a.png", Mask: vcs.ChangeTypeAdd},
+			{Path: "/photosphere", Mask: vcs.ChangeTypeAdd},
+			{Path: "/music/b.ogg", Mask: vcs.ChangeTypeRemove},
+		},
+	}
+
+	filtered := ev.FilterByPrefixes([]string{"/photos"})
+	require.NotNil(t, filtered)
+	require.Equal(t, []Change{{Path: "/photos/a.png", Mask: vcs.ChangeTypeAdd}}, filtered.Changes)
+	require.Len(t, ev.Changes, 3)
+
+	require.Nil(t, ev.FilterByPrefixes([]string{"/docs"}))
+	require.Equal(t, ev, ev.FilterByPrefixes(nil))
+	require.Len(t, ev.FilterByPrefixes([]string{"/"}).Changes, 3)
+
+	// Events without paths always match:
+	unknown := &Event{Type: FsEvent}
+	require.Equal(t, unknown, unknown.FilterByPrefixes([]string{"/docs"}))
+}
+
+func TestPrefixEventHandler(t *testing.T) {
+	withEventListenerPair(t, "a", "b", func(lstA, lstB *Listener) {
+		received := make(chan *Event, 10)
+		lstB.RegisterPrefixEventHandler(FsEvent, false, []string{"/photos"}, func(ev *Event) {
+			received <- ev
+		})
+
+		require.Nil(t, lstB.SetupListeners(context.Background(), []string{"a"}))
+		require.Nil(t, lstA.PublishEvent(Event{
+			Type:    FsEvent,
+			Changes: []Change{{Path: "/music/x.ogg", Mask: vcs.ChangeTypeAdd}},
+		}))
+
+		time.Sleep(100 * time.Millisecond)
+		require.Nil(t, lstA.PublishEvent(Event{
+			Type:    FsEvent,
+			Changes: []Change{{Path: "/photos/x.png", Mask: vcs.ChangeTypeAdd}},
+		}))
+
+		select {
+		case ev := <-received:
+			require.Equal(t, []Change{{Path: "/photos/x.png", Mask: vcs.ChangeTypeAdd}}, ev.Changes)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("did not receive event")
+		}
+	})
+}
@@ -5,6 +5,7 @@ package fuse
 import (
 	"os"
 	"path"
+	"syscall"
 	"time"
 
 	"context"
@@ -18,6 +19,9 @@ import (
 type Directory struct {
 	path string
 	m    *Mount
+	// rev is the commit this directory belongs to.
+	// If empty, the current state is shown and the directory is modifiable.
+	rev string
 }
 
 // Attr is called to retrieve stat-metadata about the directory.
@@ -25,7 +29,7 @@ func (dir *Directory) Attr(ctx context.Context, attr *fuse.Attr) error {
 	defer logPanic("dir: attr")
 
 	debugLog("Exec dir attr: %v", dir.path)
-	info, err := dir.m.fs.Stat(dir.path)
+	info, err := dir.m.fs.StatAt(dir.rev, dir.path)
 	if err != nil {
 		return errorize("dir-attr", err)
 	}
@@ -41,6 +45,12 @@ func (dir *Directory) Attr(ctx context.Context, attr *fuse.Attr) error {
 	return nil
 }
 
+// isSnapshotsParent returns true if the virtual .snapshots directory
+// should be reachable from this directory.
+func (dir *Directory) isSnapshotsParent() bool {
+	return dir.m.options.Snapshots && dir.rev == "" && dir.path == dir.m.filesys.root
+}
+
 // Lookup is called to lookup a direct child of the directory.
 func (dir *Directory) Lookup(ctx context.Context, name string) (fs.Node, error) {
 	defer logPanic("dir: lookup")
@@ -51,21 +61,25 @@ func (dir *Directory) Lookup(ctx context.Context, name string) (fs.Node, error)
 	}
 
 	if name == ".." && dir.path != "/" {
-		return &Directory{path: path.Dir(dir.path), m: dir.m}, nil
+		return &Directory{path: path.Dir(dir.path), m: dir.m, rev: dir.rev}, nil
+	}
+
+	if name == snapshotsDirName && dir.isSnapshotsParent() {
+		return &SnapshotsDir{m: dir.m}, nil
 	}
 
 	var result fs.Node
 	childPath := path.Join(dir.path, name)
 
-	info, err := dir.m.fs.Stat(childPath)
+	info, err := dir.m.fs.StatAt(dir.rev, childPath)
 	if err != nil {
 		return nil, errorize("dir-lookup", err)
 	}
 
 	if info.IsDir {
-		result = &Directory{path: childPath, m: dir.m}
+		result = &Directory{path: childPath, m: dir.m, rev: dir.rev}
 	} else {
-		result = &File{path: childPath, m: dir.m}
+		result = &File{path: childPath, m: dir.m, rev: dir.rev}
 	}
 
 	return result, nil
@@ -76,6 +90,9 @@ func (dir *Directory) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Nod
 	defer logPanic("dir: mkdir")
 
 	debugLog("fuse-mkdir: %v", req.Name)
+	if dir.rev != "" {
+		return nil, fuse.Errno(syscall.EROFS)
+	}
 
 	childPath := path.Join(dir.path, req.Name)
 	if err := dir.m.fs.Mkdir(childPath, false); err != nil {
@@ -97,6 +114,9 @@ func (dir *Directory) Create(ctx context.Context, req *fuse.CreateRequest, resp
 
 	var err error
 	debugLog("fuse-create: %v", req.Name)
+	if dir.rev != "" {
+		return nil, nil, fuse.Errno(syscall.EROFS)
+	}
 
 	childPath := path.Join(dir.path, req.Name)
 	switch {
@@ -128,6 +148,10 @@ func (dir *Directory) Create(ctx context.Context, req *fuse.CreateRequest, resp
 func (dir *Directory) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
 	defer logPanic("dir: remove")
 
+	if dir.rev != "" {
+		return fuse.Errno(syscall.EROFS)
+	}
+
 	path := path.Join(dir.path, req.Name)
 	if err := dir.m.fs.Remove(path); err != nil {
 		log.Errorf("fuse: dir-remove: `%s` failed: %v", path, err)
@@ -143,14 +167,14 @@ func (dir *Directory) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	defer logPanic("dir: readdirall")
 
 	debugLog("Exec read dir all")
-	selfInfo, err := dir.m.fs.Stat(dir.path)
+	selfInfo, err := dir.m.fs.StatAt(dir.rev, dir.path)
 	if err != nil {
 		log.Debugf("Failed to stat: %v", dir.path)
 		return nil, errorize("fuse-dir-ls-stat", err)
 	}
 
 	parentDir := path.Dir(dir.path)
-	parInfo, err := dir.m.fs.Stat(parentDir)
+	parInfo, err := dir.m.fs.StatAt(dir.rev, parentDir)
 	if err != nil {
 		log.Debugf("Failed to stat parent: %v", parentDir)
 		return nil, errorize("fuse-dir-ls-stat-par", err)
@@ -169,7 +193,7 @@ func (dir *Directory) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 		},
 	}
 
-	entries, err := dir.m.fs.List(dir.path, 1)
+	entries, err := dir.m.fs.ListAt(dir.rev, dir.path, 1)
 	if err != nil {
 		log.Warningf("Failed to list entries: %v", dir.path)
 		return nil, errorize("fuse-dir-readall", err)
@@ -202,7 +226,7 @@ func (dir *Directory) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, r
 	defer logPanic("dir: getxattr")
 
 	debugLog("exec dir getxattr: %v: %v", dir.path, req.Name)
-	xattrs, err := getXattr(dir.m.fs, req.Name, dir.path, req.Size)
+	xattrs, err := getXattr(dir.m.fs, req.Name, dir.rev, dir.path, req.Size)
 	if err != nil {
 		return err
 	}
@@ -239,6 +263,10 @@ func (dir *Directory) Rename(ctx context.Context, req *fuse.RenameRequest, newDi
 	if !ok {
 		return fuse.EIO
 	}
+
+	if dir.rev != "" || newParent.rev != "" {
+		return fuse.Errno(syscall.EROFS)
+	}
 	oldPath := path.Join(dir.path, req.OldName)
 	newPath := path.Join(newParent.path, req.NewName)
 	if err := dir.m.fs.Move(oldPath, newPath); err != nil {
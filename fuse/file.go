@@ -5,6 +5,7 @@ package fuse
 import (
 	"errors"
 	"os"
+	"syscall"
 
 	"context"
 
@@ -21,19 +22,26 @@ type File struct {
 	path string
 	m    *Mount
 	hd   *Handle
+	// rev is the commit this file belongs to.
+	// If empty, the current state is shown and the file is modifiable.
+	rev string
 }
 
 // Attr is called to get the stat(2) attributes of a file.
 func (fi *File) Attr(ctx context.Context, attr *fuse.Attr) error {
 	defer logPanic("file: attr")
 
-	info, err := fi.m.fs.Stat(fi.path)
+	info, err := fi.m.fs.StatAt(fi.rev, fi.path)
 	if err != nil {
 		return err
 	}
 	debugLog("exec file attr: %v", fi.path)
 
 	attr.Mode = 0755
+	if fi.rev != "" {
+		attr.Mode = 0555
+	}
+
 	if fi.hd != nil && fi.hd.writers > 0 {
 		attr.Size = uint64(len(fi.hd.data))
 	} else {
@@ -63,9 +71,13 @@ func (fi *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.Open
 	defer logPanic("file: open")
 	debugLog("fuse-open: %s", fi.path)
 
+	if fi.rev != "" && !req.Flags.IsReadOnly() {
+		return nil, fuse.Errno(syscall.EROFS)
+	}
+
 	// Check if the file is actually available locally.
 	if fi.m.options.Offline {
-		isCached, err := fi.m.fs.IsCached(fi.path)
+		isCached, err := fi.m.fs.IsCachedAt(fi.rev, fi.path)
 		if err != nil {
 			return nil, errorize("file-is-cached", err)
 		}
@@ -75,7 +87,7 @@ func (fi *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.Open
 		}
 	}
 
-	fd, err := fi.m.fs.Open(fi.path)
+	fd, err := fi.m.fs.OpenAt(fi.rev, fi.path)
 	if err != nil {
 		return nil, errorize("file-open", err)
 	}
@@ -112,6 +124,10 @@ func (fi *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fus
 	// most importantly the file size. For example it is called when truncating
 	// the file to zero bytes with a size change of `0`.
 	debugLog("exec file setattr")
+	if fi.rev != "" {
+		return fuse.Errno(syscall.EROFS)
+	}
+
 	switch {
 	case req.Valid&fuse.SetattrSize != 0:
 		if err := fi.hd.truncate(req.Size); err != nil {
@@ -140,7 +156,7 @@ func (fi *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *f
 	defer logPanic("file: getxattr")
 
 	debugLog("exec file getxattr: %v: %v", fi.path, req.Name)
-	xattrs, err := getXattr(fi.m.fs, req.Name, fi.path, req.Size)
+	xattrs, err := getXattr(fi.m.fs, req.Name, fi.rev, fi.path, req.Size)
 	if err != nil {
 		return err
 	}
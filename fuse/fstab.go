@@ -40,6 +40,14 @@ func FsTabAdd(cfg *config.Config, name, path string, opts MountOptions) error {
 		return err
 	}
 
+	if err := cfg.SetString(name+".rev", opts.Rev); err != nil {
+		return err
+	}
+
+	if err := cfg.SetBool(name+".snapshots", opts.Snapshots); err != nil {
+		return err
+	}
+
 	if opts.Root == "" {
 		opts.Root = "/"
 	}
@@ -99,6 +107,12 @@ func FsTabApply(cfg *config.Config, mounts *MountTable) error {
 			offlineKey := key[:len(key)-len(".path")] + ".offline"
 			entry.Offline = cfg.Bool(offlineKey)
 
+			revKey := key[:len(key)-len(".path")] + ".rev"
+			entry.Rev = cfg.String(revKey)
+
+			snapshotsKey := key[:len(key)-len(".path")] + ".snapshots"
+			entry.Snapshots = cfg.Bool(snapshotsKey)
+
 			rootPathKey := key[:len(key)-len(".path")] + ".root"
 			entry.Root = cfg.String(rootPathKey)
 			if entry.Root == "" {
@@ -136,12 +150,14 @@ func FsTabApply(cfg *config.Config, mounts *MountTable) error {
 
 // FsTabEntry is a representation of one entry in the filesystem tab.
 type FsTabEntry struct {
-	Name     string
-	Path     string
-	Root     string
-	Active   bool
-	ReadOnly bool
-	Offline  bool
+	Name      string
+	Path      string
+	Root      string
+	Active    bool
+	ReadOnly  bool
+	Offline   bool
+	Rev       string
+	Snapshots bool
 }
 
 // FsTabList lists all entries in the filesystem tab in a nice way.
@@ -174,6 +190,10 @@ func FsTabList(cfg *config.Config, mounts *MountTable) ([]FsTabEntry, error) {
 			mountMap[mountName].Offline = cfg.Bool(key)
 		case "root":
 			mountMap[mountName].Root = cfg.String(key)
+		case "rev":
+			mountMap[mountName].Rev = cfg.String(key)
+		case "snapshots":
+			mountMap[mountName].Snapshots = cfg.Bool(key)
 		}
 	}
 
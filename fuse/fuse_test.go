@@ -253,3 +253,60 @@ func TestWithRoot(t *testing.T) {
 		})
 	})
 }
+
+func TestMountRev(t *testing.T) {
+	withDummyFS(t, func(fs *catfs.FS) {
+		require.Nil(t, fs.Stage("/x.png", bytes.NewReader([]byte{1, 2, 3})))
+		require.Nil(t, fs.MakeCommit("first"))
+		require.Nil(t, fs.Tag("head", "v1"))
+		require.Nil(t, fs.Stage("/x.png", bytes.NewReader([]byte{4, 5, 6})))
+		require.Nil(t, fs.MakeCommit("second"))
+
+		withMountFromFs(t, MountOptions{Rev: "v1"}, fs, func(mount *Mount) {
+			xPath := filepath.Join(mount.Dir, "x.png")
+			data, err := ioutil.ReadFile(xPath)
+			require.Nil(t, err)
+			require.Equal(t, []byte{1, 2, 3}, data)
+
+			// Mounts of old revisions are always read-only:
+			require.NotNil(t, ioutil.WriteFile(xPath, []byte{7, 8, 9}, 0600))
+		})
+	})
+}
+
+func TestMountSnapshots(t *testing.T) {
+	withDummyFS(t, func(fs *catfs.FS) {
+		require.Nil(t, fs.Stage("/x.png", bytes.NewReader([]byte{1, 2, 3})))
+		require.Nil(t, fs.MakeCommit("first"))
+		require.Nil(t, fs.Tag("head", "v1"))
+		require.Nil(t, fs.Stage("/x.png", bytes.NewReader([]byte{4, 5, 6})))
+
+		withMountFromFs(t, MountOptions{Snapshots: true}, fs, func(mount *Mount) {
+			// The snapshots dir is hidden from the listing:
+			infos, err := ioutil.ReadDir(mount.Dir)
+			require.Nil(t, err)
+			require.Len(t, infos, 1)
+
+			infos, err = ioutil.ReadDir(filepath.Join(mount.Dir, ".snapshots"))
+			require.Nil(t, err)
+
+			names := []string{}
+			for _, info := range infos {
+				names = append(names, info.Name())
+			}
+
+			require.Contains(t, names, "v1")
+			require.Contains(t, names, "head")
+
+			oldPath := filepath.Join(mount.Dir, ".snapshots", "v1", "x.png")
+			data, err := ioutil.ReadFile(oldPath)
+			require.Nil(t, err)
+			require.Equal(t, []byte{1, 2, 3}, data)
+			require.NotNil(t, ioutil.WriteFile(oldPath, []byte{7, 8, 9}, 0600))
+
+			data, err = ioutil.ReadFile(filepath.Join(mount.Dir, "x.png"))
+			require.Nil(t, err)
+			require.Equal(t, []byte{4, 5, 6}, data)
+		})
+	})
+}
@@ -38,6 +38,14 @@ type MountOptions struct {
 	// Offline tells the mount to error out on files that would need
 	// to be fetched from far.
 	Offline bool
+	// Rev is a tag, commit hash or other revision spec (like "HEAD^^").
+	// If set, the mount shows the state of this commit and is read-only.
+	// The revision is resolved once when mounting, so a mount of "HEAD"
+	// will not change when new commits are made.
+	Rev string
+	// Snapshots enables a hidden .snapshots directory in the mount's root,
+	// which contains a read-only view of every tagged commit.
+	Snapshots bool
 }
 
 // This is very similar (and indeed mostly copied) code from:
@@ -59,6 +67,9 @@ type Mount struct {
 	options  MountOptions
 	notifier Notifier
 	fs       *catfs.FS
+
+	// rev is the hash of the commit the mount shows (if opts.Rev was set)
+	rev string
 }
 
 // NewMount mounts a fuse endpoint at `mountpoint` retrieving data from `store`.
@@ -69,6 +80,22 @@ func NewMount(cfs *catfs.FS, mountpoint string, notifier Notifier, opts MountOpt
 		fuse.AllowNonEmptyMount(),
 	}
 
+	rev := ""
+	if opts.Rev != "" {
+		cmt, err := cfs.CommitInfo(opts.Rev)
+		if err != nil {
+			return nil, e.Wrapf(err, "failed to resolve revision %s", opts.Rev)
+		}
+
+		if cmt == nil {
+			return nil, fmt.Errorf("no such revision: %s", opts.Rev)
+		}
+
+		// Old commits can't be modified:
+		opts.ReadOnly = true
+		rev = cmt.Hash.B58String()
+	}
+
 	if opts.ReadOnly {
 		mountOptions = append(mountOptions, fuse.ReadOnly())
 	}
@@ -83,7 +110,7 @@ func NewMount(cfs *catfs.FS, mountpoint string, notifier Notifier, opts MountOpt
 		opts.Root = "/"
 	}
 
-	info, err := cfs.Stat(opts.Root)
+	info, err := cfs.StatAt(rev, opts.Root)
 	if err != nil {
 		return nil, e.Wrapf(err, "failed to lookup root node of mount: %v", mountpoint)
 	}
@@ -101,6 +128,7 @@ func NewMount(cfs *catfs.FS, mountpoint string, notifier Notifier, opts MountOpt
 		options:  opts,
 		notifier: notifier,
 		fs:       cfs,
+		rev:      rev,
 	}
 	filesys := &Filesystem{m: mnt, root: opts.Root}
 	mnt.filesys = filesys
@@ -146,7 +174,12 @@ func lazyUnmount(dir string) error {
 // EqualOptions returns true when the options in `opts` have the same
 // option as currently set in the mount. If so, no re-mount is required.
 func (m *Mount) EqualOptions(opts MountOptions) bool {
-	if m.options.ReadOnly != opts.ReadOnly {
+	if m.options.Rev != opts.Rev || m.options.Snapshots != opts.Snapshots {
+		return false
+	}
+
+	// Mounts of a revision are always read-only:
+	if m.options.ReadOnly != (opts.ReadOnly || opts.Rev != "") {
 		return false
 	}
 
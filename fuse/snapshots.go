@@ -0,0 +1,92 @@
+// +build !windows
+
+package fuse
+
+import (
+	"context"
+	"os"
+	"sort"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+)
+
+const (
+	// snapshotsDirName is the name of the virtual directory
+	// that contains all tagged commits.
+	snapshotsDirName = ".snapshots"
+)
+
+// SnapshotsDir is a virtual, read-only directory that has one
+// sub directory for every tagged commit. It is not shown in the
+// listing of the mount's root, but can be accessed by its name.
+type SnapshotsDir struct {
+	m *Mount
+}
+
+// Attr is called to retrieve stat-metadata about the directory.
+func (sd *SnapshotsDir) Attr(ctx context.Context, attr *fuse.Attr) error {
+	defer logPanic("snapshots: attr")
+
+	attr.Uid = uint32(os.Getuid())
+	attr.Gid = uint32(os.Getgid())
+	attr.Mode = os.ModeDir | 0555
+	return nil
+}
+
+// Lookup is called to lookup a tag in the snapshots directory.
+func (sd *SnapshotsDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	defer logPanic("snapshots: lookup")
+
+	tags, err := sd.m.fs.Tags()
+	if err != nil {
+		return nil, errorize("snapshots-lookup", err)
+	}
+
+	hash, ok := tags[name]
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+
+	return &Directory{
+		path: sd.m.filesys.root,
+		m:    sd.m,
+		rev:  hash.B58String(),
+	}, nil
+}
+
+// ReadDirAll is called to list all tags.
+func (sd *SnapshotsDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	defer logPanic("snapshots: readdirall")
+
+	tags, err := sd.m.fs.Tags()
+	if err != nil {
+		return nil, errorize("snapshots-readdirall", err)
+	}
+
+	names := []string{}
+	for name := range tags {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	fuseEnts := []fuse.Dirent{
+		{Type: fuse.DT_Dir, Name: "."},
+		{Type: fuse.DT_Dir, Name: ".."},
+	}
+
+	for _, name := range names {
+		fuseEnts = append(fuseEnts, fuse.Dirent{
+			Type: fuse.DT_Dir,
+			Name: name,
+		})
+	}
+
+	return fuseEnts, nil
+}
+
+// Compile time checks to see which interfaces we implement:
+var _ = fs.Node(&SnapshotsDir{})
+var _ = fs.NodeStringLookuper(&SnapshotsDir{})
+var _ = fs.HandleReadDirAller(&SnapshotsDir{})
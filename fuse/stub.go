@@ -22,8 +22,11 @@ type Notifier interface {
 var ErrCompiledWithoutFuse = errors.New("brig was compiled without fuse support")
 
 type MountOptions struct {
-	ReadOnly bool
-	Root     string
+	ReadOnly  bool
+	Root      string
+	Offline   bool
+	Rev       string
+	Snapshots bool
 }
 
 type Mount struct {
@@ -61,11 +64,14 @@ func (t *MountTable) Close() error {
 }
 
 type FsTabEntry struct {
-	Name     string
-	Path     string
-	Root     string
-	Active   bool
-	ReadOnly bool
+	Name      string
+	Path      string
+	Root      string
+	Active    bool
+	ReadOnly  bool
+	Offline   bool
+	Rev       string
+	Snapshots bool
 }
 
 func FsTabAdd(cfg *config.Config, name, path string, opts MountOptions) error {
@@ -46,8 +46,8 @@ func listXattr(size uint32) []byte {
 	return resp
 }
 
-func getXattr(cfs *catfs.FS, name, path string, size uint32) ([]byte, error) {
-	info, err := cfs.Stat(path)
+func getXattr(cfs *catfs.FS, name, rev, path string, size uint32) ([]byte, error) {
+	info, err := cfs.StatAt(rev, path)
 	if err != nil {
 		return nil, errorize("getxattr", err)
 	}
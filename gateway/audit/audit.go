@@ -0,0 +1,265 @@
+// Package audit implements an append-only log of actions done via the gateway.
+// Every entry is stored as a single JSON line. The log is split over several
+// files that are rotated once the current one grows too big.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	logName = "audit.log"
+)
+
+// Entry is a single record in the audit log.
+type Entry struct {
+	// Time is the point in time when the request was finished.
+	Time time.Time `json:"time"`
+	// User is the name of the user that did the request.
+	// Might be empty if the user could not be authenticated.
+	User string `json:"user"`
+	// Action is the endpoint that was called ("get", "upload", "move", ...)
+	Action string `json:"action"`
+	// Paths are the paths that were affected by the action.
+	Paths []string `json:"paths,omitempty"`
+	// IP is the remote address of the client.
+	IP string `json:"ip"`
+	// Status is the HTTP status code of the response.
+	Status int `json:"status"`
+	// BytesIn is the number of bytes read from the request body.
+	BytesIn int64 `json:"bytes_in"`
+	// BytesOut is the number of bytes written to the response body.
+	BytesOut int64 `json:"bytes_out"`
+}
+
+// Query describes what entries should be returned by Log.Query.
+// Fields with their zero value are not used for filtering.
+type Query struct {
+	// User filters for entries of this user.
+	User string
+	// Path filters for entries that affect this path or anything below it.
+	Path string
+	// Since filters for entries that are newer than this.
+	Since time.Time
+	// Limit is the maximum number of (most recent) entries to return.
+	Limit int
+}
+
+// Log is a rotating, append-only log of audit entries.
+// It is safe to use from several go routines.
+type Log struct {
+	mu       sync.Mutex
+	dir      string
+	maxSize  int64
+	maxFiles int
+	fd       *os.File
+	size     int64
+}
+
+// Open opens or creates an audit log in `dir`. A single log file
+// will not grow bigger than `maxSize` bytes and at most `maxFiles`
+// files are kept; older ones are deleted on rotation.
+func Open(dir string, maxSize int64, maxFiles int) (*Log, error) {
+	if maxFiles < 1 {
+		maxFiles = 1
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	lg := &Log{
+		dir:      dir,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+
+	if err := lg.open(); err != nil {
+		return nil, err
+	}
+
+	return lg, nil
+}
+
+// filePath returns the path of the log file with the rotation index `idx`.
+// The current log file has the index 0.
+func (lg *Log) filePath(idx int) string {
+	if idx == 0 {
+		return filepath.Join(lg.dir, logName)
+	}
+
+	return filepath.Join(lg.dir, fmt.Sprintf("%s.%d", logName, idx))
+}
+
+func (lg *Log) open() error {
+	fd, err := os.OpenFile(lg.filePath(0), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return err
+	}
+
+	lg.fd = fd
+	lg.size = info.Size()
+	return nil
+}
+
+// rotate moves all log files one index up and deletes the oldest one.
+// It must be called with lg.mu held.
+func (lg *Log) rotate() error {
+	if err := lg.fd.Close(); err != nil {
+		return err
+	}
+
+	oldest := lg.filePath(lg.maxFiles - 1)
+	if err := os.Remove(oldest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for idx := lg.maxFiles - 2; idx >= 0; idx-- {
+		err := os.Rename(lg.filePath(idx), lg.filePath(idx+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return lg.open()
+}
+
+// Add appends `entry` to the log.
+func (lg *Log) Add(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	data = append(data, '\n')
+
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+
+	if lg.fd == nil {
+		return os.ErrClosed
+	}
+
+	if lg.size > 0 && lg.size+int64(len(data)) > lg.maxSize {
+		if err := lg.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := lg.fd.Write(data)
+	lg.size += int64(n)
+	return err
+}
+
+func hasPathPrefix(nodePath, prefix string) bool {
+	prefix = path.Clean(prefix)
+	if prefix == "/" || nodePath == prefix {
+		return true
+	}
+
+	return strings.HasPrefix(nodePath, prefix+"/")
+}
+
+func (q *Query) matches(entry *Entry) bool {
+	if q.User != "" && entry.User != q.User {
+		return false
+	}
+
+	if !q.Since.IsZero() && entry.Time.Before(q.Since) {
+		return false
+	}
+
+	if q.Path == "" {
+		return true
+	}
+
+	for _, entryPath := range entry.Paths {
+		if hasPathPrefix(entryPath, q.Path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (lg *Log) readFile(filePath string, q *Query, entries []Entry) ([]Entry, error) {
+	fd, err := os.Open(filePath) // #nosec
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Debugf("audit: skipping bad line in %s: %v", filePath, err)
+			continue
+		}
+
+		if q.matches(&entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+// Query returns all entries that match `q`, oldest first.
+func (lg *Log) Query(q Query) ([]Entry, error) {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+
+	var err error
+	entries := []Entry{}
+
+	// Go from the oldest file to the current one:
+	for idx := lg.maxFiles - 1; idx >= 0; idx-- {
+		entries, err = lg.readFile(lg.filePath(idx), &q, entries)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+
+	return entries, nil
+}
+
+// Close closes the currently opened log file.
+func (lg *Log) Close() error {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+
+	if lg.fd == nil {
+		return nil
+	}
+
+	err := lg.fd.Close()
+	lg.fd = nil
+	return err
+}
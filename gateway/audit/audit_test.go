@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func withLog(t *testing.T, maxSize int64, maxFiles int, fn func(lg *Log, dir string)) {
+	dir, err := ioutil.TempDir("", "brig-audit-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	lg, err := Open(dir, maxSize, maxFiles)
+	require.Nil(t, err)
+
+	fn(lg, dir)
+	require.Nil(t, lg.Close())
+}
+
+func TestAddAndQuery(t *testing.T) {
+	withLog(t, 1024*1024, 3, func(lg *Log, dir string) {
+		now := time.Now()
+		require.Nil(t, lg.Add(Entry{Time: now.Add(-time.Hour), User: "ali", Action: "get", Paths: []string{"/public/x"}}))
+		require.Nil(t, lg.Add(Entry{Time: now, User: "bob", Action: "upload", Paths: []string{"/private/y"}}))
+		require.Nil(t, lg.Add(Entry{Time: now, User: "ali", Action: "move", Paths: []string{"/public", "/publicity"}}))
+
+		entries, err := lg.Query(Query{})
+		require.Nil(t, err)
+		require.Len(t, entries, 3)
+		require.Equal(t, "get", entries[0].Action)
+		require.Equal(t, "move", entries[2].Action)
+
+		entries, err = lg.Query(Query{User: "ali"})
+		require.Nil(t, err)
+		require.Len(t, entries, 2)
+
+		entries, err = lg.Query(Query{Path: "/public"})
+		require.Nil(t, err)
+		require.Len(t, entries, 2)
+
+		// "/publicity" is not below "/public/":
+		entries, err = lg.Query(Query{Path: "/public/"})
+		require.Nil(t, err)
+		require.Len(t, entries, 2)
+
+		entries, err = lg.Query(Query{Path: "/private/y"})
+		require.Nil(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, "bob", entries[0].User)
+
+		entries, err = lg.Query(Query{Since: now.Add(-time.Minute)})
+		require.Nil(t, err)
+		require.Len(t, entries, 2)
+
+		entries, err = lg.Query(Query{Limit: 1})
+		require.Nil(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, "move", entries[0].Action)
+	})
+}
+
+func TestRotation(t *testing.T) {
+	withLog(t, 256, 3, func(lg *Log, dir string) {
+		for idx := 0; idx < 100; idx++ {
+			require.Nil(t, lg.Add(Entry{
+				Time:   time.Now(),
+				User:   "ali",
+				Action: "get",
+				Paths:  []string{fmt.Sprintf("/file_%d", idx)},
+			}))
+		}
+
+		matches, err := filepath.Glob(filepath.Join(dir, "audit.log*"))
+		require.Nil(t, err)
+		require.Len(t, matches, 3)
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			require.Nil(t, err)
+			require.True(t, info.Size() <= 256)
+		}
+
+		// Only the most recent entries survive:
+		entries, err := lg.Query(Query{})
+		require.Nil(t, err)
+		require.True(t, len(entries) > 0)
+		require.True(t, len(entries) < 100)
+		require.Equal(t, "/file_99", entries[len(entries)-1].Paths[0])
+	})
+}
+
+func TestReopen(t *testing.T) {
+	withLog(t, 1024, 2, func(lg *Log, dir string) {
+		require.Nil(t, lg.Add(Entry{User: "ali", Action: "ls"}))
+		require.Nil(t, lg.Close())
+
+		reopened, err := Open(dir, 1024, 2)
+		require.Nil(t, err)
+		defer reopened.Close()
+
+		require.Nil(t, reopened.Add(Entry{User: "bob", Action: "ls"}))
+
+		entries, err := reopened.Query(Query{})
+		require.Nil(t, err)
+		require.Len(t, entries, 2)
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
@@ -28,6 +29,9 @@ const (
 	RightRemotesView = "remotes.view"
 	// RightRemotesEdit is the right to edit the remote list.
 	RightRemotesEdit = "remotes.edit"
+	// RightAuditView is the right to query the audit log.
+	// It is not part of the default rights.
+	RightAuditView = "audit.view"
 )
 
 var (
@@ -50,6 +54,7 @@ var (
 		RightFsEdit:      true,
 		RightRemotesView: true,
 		RightRemotesEdit: true,
+		RightAuditView:   true,
 	}
 )
 
@@ -399,3 +404,21 @@ func (ub *UserDatabase) List() ([]User, error) {
 		return nil
 	})
 }
+
+// Export writes a dump of all users to `w`.
+func (ub *UserDatabase) Export(w io.Writer) error {
+	ub.mu.Lock()
+	defer ub.mu.Unlock()
+
+	_, err := ub.db.Backup(w, 0)
+	return err
+}
+
+// Import loads a dump written by Export. Existing users with the
+// same name are overwritten, other existing users are kept.
+func (ub *UserDatabase) Import(r io.Reader) error {
+	ub.mu.Lock()
+	defer ub.mu.Unlock()
+
+	return ub.db.Load(r)
+}
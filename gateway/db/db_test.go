@@ -1,6 +1,7 @@
 package db
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -33,3 +34,27 @@ func TestAddGet(t *testing.T) {
 		require.Equal(t, []string{"fs.view"}, user.Rights)
 	})
 }
+
+func TestExportImport(t *testing.T) {
+	withDummyDb(t, func(srcDb *UserDatabase) {
+		require.Nil(t, srcDb.Add("hello", "world", []string{"/"}, []string{"fs.view"}))
+
+		buf := &bytes.Buffer{}
+		require.Nil(t, srcDb.Export(buf))
+
+		withDummyDb(t, func(dstDb *UserDatabase) {
+			require.Nil(t, dstDb.Add("other", "user", []string{"/"}, nil))
+			require.Nil(t, dstDb.Import(buf))
+
+			user, err := dstDb.Get("hello")
+			require.Nil(t, err)
+			ok, err := user.CheckPassword("world")
+			require.Nil(t, err)
+			require.True(t, ok)
+
+			users, err := dstDb.List()
+			require.Nil(t, err)
+			require.Len(t, users, 2)
+		})
+	})
+}
@@ -0,0 +1,241 @@
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sahib/brig/gateway/audit"
+	"github.com/sahib/brig/gateway/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// Request bodies bigger than this are not inspected for paths.
+const maxAuditBodyPeek = 64 * 1024
+
+// auditBody is a union of all path related fields
+// that are used in the requests of the API.
+type auditBody struct {
+	Path        string   `json:"path"`
+	Root        string   `json:"root"`
+	Source      string   `json:"source"`
+	Destination string   `json:"destination"`
+	Paths       []string `json:"paths"`
+}
+
+func (ab *auditBody) paths() []string {
+	paths := []string{}
+	for _, p := range append([]string{ab.Path, ab.Root, ab.Source, ab.Destination}, ab.Paths...) {
+		if p != "" {
+			paths = append(paths, prefixRoot(path.Clean(p)))
+		}
+	}
+
+	return paths
+}
+
+// countingReader wraps the request body and counts the bytes read.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (cr *countingReader) Read(buf []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(buf)
+	cr.n += int64(n)
+	return n, err
+}
+
+type auditMiddleware struct {
+	*State
+	SubHandler http.Handler
+}
+
+// peekBody reads the JSON body of `r` (if it is small enough) and extracts
+// all paths from it. The body is restored so the handler can read it again.
+func peekBody(r *http.Request) []string {
+	if r.Body == nil || strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		return nil
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxAuditBodyPeek+1))
+	r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(data), r.Body))
+	if err != nil || len(data) > maxAuditBodyPeek {
+		return nil
+	}
+
+	body := auditBody{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil
+	}
+
+	return body.paths()
+}
+
+// userName returns the name of the user doing the request.
+// Most endpoints are behind the auth middleware, /get does its own auth.
+func (am *auditMiddleware) userName(r *http.Request) string {
+	if user, ok := r.Context().Value(dbUserKey("brig.db_user")).(db.User); ok {
+		return user.Name
+	}
+
+	if sess, err := am.store.Get(r, "sess"); err == nil {
+		if name, ok := sess.Values["name"].(string); ok && name != "" {
+			return name
+		}
+	}
+
+	if name, _, ok := r.BasicAuth(); ok {
+		return name
+	}
+
+	if am.cfg.Bool("auth.anon_allowed") {
+		return am.cfg.String("auth.anon_user")
+	}
+
+	return ""
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func (am *auditMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if am.audit == nil || !am.cfg.Bool("audit.enabled") {
+		am.SubHandler.ServeHTTP(w, r)
+		return
+	}
+
+	var action string
+	var paths []string
+
+	if strings.HasPrefix(r.URL.Path, "/api/v0/") {
+		action = strings.TrimPrefix(r.URL.Path, "/api/v0/")
+		paths = peekBody(r)
+	} else {
+		action = "get"
+		paths = []string{prefixRoot(path.Clean(strings.TrimPrefix(r.URL.Path, "/get")))}
+	}
+
+	body := &countingReader{ReadCloser: r.Body}
+	if r.Body != nil {
+		r.Body = body
+	}
+
+	rec := &statusRecorder{ResponseWriter: w}
+	am.SubHandler.ServeHTTP(rec, r)
+
+	// Uploads are multipart forms; take the paths from there.
+	if r.MultipartForm != nil {
+		root := prefixRoot(r.URL.Query().Get("root"))
+		for _, headers := range r.MultipartForm.File {
+			for _, header := range headers {
+				paths = append(paths, path.Join(root, header.Filename))
+			}
+		}
+	}
+
+	entry := audit.Entry{
+		Time:     time.Now(),
+		User:     am.userName(r),
+		Action:   action,
+		Paths:    paths,
+		IP:       remoteIP(r),
+		Status:   rec.Status(),
+		BytesIn:  body.n,
+		BytesOut: rec.written,
+	}
+
+	if err := am.audit.Add(entry); err != nil {
+		log.Warningf("failed to write audit log entry: %v", err)
+	}
+}
+
+// AuditMiddleware returns a new handler wrapper that records
+// every request to the wrapped handler in the audit log.
+func AuditMiddleware(s *State) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return &auditMiddleware{State: s, SubHandler: h}
+	}
+}
+
+///////
+
+// AuditHandler implements http.Handler.
+// It allows admins to query the audit log.
+type AuditHandler struct {
+	*State
+}
+
+// NewAuditHandler returns a new AuditHandler.
+func NewAuditHandler(s *State) *AuditHandler {
+	return &AuditHandler{State: s}
+}
+
+// AuditRequest is the request that can be sent to this endpoint.
+type AuditRequest struct {
+	// User filters for a certain user.
+	User string `json:"user"`
+	// Path filters for a certain path and everything below it.
+	Path string `json:"path"`
+	// Since is a unix timestamp in milliseconds.
+	Since int64 `json:"since"`
+	// Limit is the maximum number of (most recent) entries.
+	Limit int `json:"limit"`
+}
+
+// AuditResponse is the response sent back by this endpoint.
+type AuditResponse struct {
+	Success bool          `json:"success"`
+	Entries []audit.Entry `json:"entries"`
+}
+
+func (ah *AuditHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !checkRights(w, r, db.RightAuditView) {
+		return
+	}
+
+	if ah.audit == nil {
+		jsonifyErrf(w, http.StatusNotFound, "audit log is not available")
+		return
+	}
+
+	auditReq := AuditRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&auditReq); err != nil {
+		jsonifyErrf(w, http.StatusBadRequest, "bad json")
+		return
+	}
+
+	query := audit.Query{
+		User:  auditReq.User,
+		Path:  auditReq.Path,
+		Limit: auditReq.Limit,
+	}
+
+	if auditReq.Since > 0 {
+		query.Since = time.Unix(0, auditReq.Since*int64(time.Millisecond))
+	}
+
+	entries, err := ah.audit.Query(query)
+	if err != nil {
+		log.Warningf("failed to query audit log: %v", err)
+		jsonifyErrf(w, http.StatusInternalServerError, "failed to query audit log")
+		return
+	}
+
+	jsonify(w, http.StatusOK, &AuditResponse{
+		Success: true,
+		Entries: entries,
+	})
+}
@@ -0,0 +1,84 @@
+package endpoints
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/sahib/brig/gateway/audit"
+	"github.com/sahib/brig/gateway/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditMiddleware(t *testing.T) {
+	withState(t, func(s *testState) {
+		require.Nil(t, s.fs.Stage("/hello.txt", bytes.NewReader([]byte("world"))))
+
+		hdl := AuditMiddleware(s.State)(NewMkdirHandler(s.State))
+		resp := s.mustRun(t, hdl, "POST", "http://localhost:5000/api/v0/mkdir", MkdirRequest{
+			Path: "/sub",
+		})
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		getHdl := AuditMiddleware(s.State)(NewGetHandler(s.State))
+		resp = s.mustRun(t, getHdl, "GET", "http://localhost:5000/get/hello.txt", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		entries, err := s.audit.Query(audit.Query{})
+		require.Nil(t, err)
+		require.Len(t, entries, 2)
+
+		require.Equal(t, "mkdir", entries[0].Action)
+		require.Equal(t, "ali", entries[0].User)
+		require.Equal(t, []string{"/sub"}, entries[0].Paths)
+		require.Equal(t, http.StatusOK, entries[0].Status)
+		require.True(t, entries[0].BytesIn > 0)
+
+		require.Equal(t, "get", entries[1].Action)
+		require.Equal(t, "ali", entries[1].User)
+		require.Equal(t, []string{"/hello.txt"}, entries[1].Paths)
+		require.Equal(t, int64(len("world")), entries[1].BytesOut)
+		require.Equal(t, "192.0.2.1", entries[1].IP)
+	})
+}
+
+func TestAuditMiddlewareDisabled(t *testing.T) {
+	withState(t, func(s *testState) {
+		require.Nil(t, s.cfg.SetBool("audit.enabled", false))
+
+		hdl := AuditMiddleware(s.State)(NewMkdirHandler(s.State))
+		resp := s.mustRun(t, hdl, "POST", "http://localhost:5000/api/v0/mkdir", MkdirRequest{
+			Path: "/sub",
+		})
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		entries, err := s.audit.Query(audit.Query{})
+		require.Nil(t, err)
+		require.Len(t, entries, 0)
+	})
+}
+
+func TestAuditEndpoint(t *testing.T) {
+	withState(t, func(s *testState) {
+		require.Nil(t, s.audit.Add(audit.Entry{User: "bob", Action: "get", Paths: []string{"/a"}}))
+		require.Nil(t, s.audit.Add(audit.Entry{User: "ali", Action: "move", Paths: []string{"/b"}}))
+
+		// Default rights do not include the audit log:
+		resp := s.mustRun(t, NewAuditHandler(s.State), "POST", "http://localhost:5000/api/v0/audit", AuditRequest{})
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		require.Nil(t, s.userDb.Remove("ali"))
+		require.Nil(t, s.userDb.Add("ali", "ila", nil, []string{db.RightAuditView}))
+
+		resp = s.mustRun(t, NewAuditHandler(s.State), "POST", "http://localhost:5000/api/v0/audit", AuditRequest{
+			User: "bob",
+		})
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		data := AuditResponse{}
+		mustDecodeBody(t, resp.Body, &data)
+		require.True(t, data.Success)
+		require.Len(t, data.Entries, 1)
+		require.Equal(t, "get", data.Entries[0].Action)
+	})
+}
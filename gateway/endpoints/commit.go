@@ -0,0 +1,80 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	ie "github.com/sahib/brig/catfs/errors"
+	"github.com/sahib/brig/gateway/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// CommitHandler implements http.Handler.
+type CommitHandler struct {
+	*State
+}
+
+// NewCommitHandler returns a new CommitHandler.
+func NewCommitHandler(s *State) *CommitHandler {
+	return &CommitHandler{State: s}
+}
+
+// CommitRequest is the request sent to this endpoint.
+type CommitRequest struct {
+	Message string `json:"message"`
+}
+
+// CommitResponse is the response sent back to the client.
+type CommitResponse struct {
+	Success bool   `json:"success"`
+	Commit  Commit `json:"commit"`
+}
+
+func (ch *CommitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !checkRights(w, r, db.RightFsEdit) {
+		return
+	}
+
+	commitReq := CommitRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&commitReq); err != nil {
+		jsonifyErrf(w, http.StatusBadRequest, "bad json")
+		return
+	}
+
+	if commitReq.Message == "" {
+		jsonifyErrf(w, http.StatusBadRequest, "empty commit message")
+		return
+	}
+
+	// A commit affects the whole tree, not just the user's folders.
+	if !ch.validatePath("/", w, r) {
+		jsonifyErrf(w, http.StatusUnauthorized, "path forbidden")
+		return
+	}
+
+	name := getUserName(ch.store, w, r)
+	msg := fmt.Sprintf("gateway: »%s« %s", name, commitReq.Message)
+	if err := ch.fs.MakeCommit(msg); err != nil {
+		if err == ie.ErrNoChange {
+			jsonifyErrf(w, http.StatusBadRequest, "nothing to commit")
+			return
+		}
+
+		log.Warningf("could not commit: %v", err)
+		jsonifyErrf(w, http.StatusInternalServerError, "could not commit")
+		return
+	}
+
+	cmt, err := ch.fs.CommitInfo("head")
+	if err != nil || cmt == nil {
+		jsonifyErrf(w, http.StatusInternalServerError, "failed to get commit info")
+		return
+	}
+
+	ch.evHdl.NotifyFsEvent(r.Context(), ch.headEvent())
+	jsonify(w, http.StatusOK, &CommitResponse{
+		Success: true,
+		Commit:  toExternalCommit(cmt),
+	})
+}
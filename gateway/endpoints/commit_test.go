@@ -0,0 +1,90 @@
+package endpoints
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommitSuccess(t *testing.T) {
+	withState(t, func(s *testState) {
+		require.Nil(t, s.fs.Stage("/file", bytes.NewReader([]byte("hello"))))
+
+		resp := s.mustRun(
+			t,
+			NewCommitHandler(s.State),
+			"POST",
+			"http://localhost:5000/api/v0/commit",
+			&CommitRequest{
+				Message: "add file",
+			},
+		)
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		commitResp := &CommitResponse{}
+		mustDecodeBody(t, resp.Body, &commitResp)
+		require.True(t, commitResp.Success)
+		require.Equal(t, "gateway: »ali« add file", commitResp.Commit.Msg)
+
+		haveStaged, err := s.fs.HaveStagedChanges()
+		require.Nil(t, err)
+		require.False(t, haveStaged)
+
+		// Nothing left to commit:
+		resp = s.mustRun(
+			t,
+			NewCommitHandler(s.State),
+			"POST",
+			"http://localhost:5000/api/v0/commit",
+			&CommitRequest{
+				Message: "again",
+			},
+		)
+
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestCommitForbidden(t *testing.T) {
+	withState(t, func(s *testState) {
+		require.Nil(t, s.fs.Stage("/file", bytes.NewReader([]byte("hello"))))
+		s.mustChangeFolders(t, "/public")
+
+		resp := s.mustRun(
+			t,
+			NewCommitHandler(s.State),
+			"POST",
+			"http://localhost:5000/api/v0/commit",
+			&CommitRequest{
+				Message: "add file",
+			},
+		)
+
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
+
+func TestCommitNoAutoCommit(t *testing.T) {
+	withState(t, func(s *testState) {
+		s.cfg.SetBool("auto_commit", false)
+
+		resp := s.mustRun(
+			t,
+			NewMkdirHandler(s.State),
+			"POST",
+			"http://localhost:5000/api/v0/mkdir",
+			&MkdirRequest{
+				Path: "/dir",
+			},
+		)
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		haveStaged, err := s.fs.HaveStagedChanges()
+		require.Nil(t, err)
+		require.True(t, haveStaged)
+	})
+}
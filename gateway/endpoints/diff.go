@@ -0,0 +1,112 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sahib/brig/catfs"
+	"github.com/sahib/brig/gateway/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// DiffHandler implements http.Handler.
+// It shows the difference between two commits of our own filesystem.
+type DiffHandler struct {
+	*State
+}
+
+// NewDiffHandler returns a new DiffHandler.
+func NewDiffHandler(s *State) *DiffHandler {
+	return &DiffHandler{State: s}
+}
+
+// DiffRequest is the request sent to this endpoint.
+// Empty revisions default to HEAD and CURR respectively.
+type DiffRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// DiffResponse is the response sent back to the client.
+type DiffResponse struct {
+	Success bool  `json:"success"`
+	Diff    *Diff `json:"diff"`
+}
+
+func filterSingles(infos []*StatInfo, folders []string) []*StatInfo {
+	result := []*StatInfo{}
+	for _, info := range infos {
+		if pathIsInFolders(info.Path, folders) {
+			result = append(result, info)
+		}
+	}
+
+	return result
+}
+
+func filterPairs(pairs []DiffPair, folders []string) []DiffPair {
+	result := []DiffPair{}
+	for _, pair := range pairs {
+		if pathIsInFolders(pair.Src.Path, folders) && pathIsInFolders(pair.Dst.Path, folders) {
+			result = append(result, pair)
+		}
+	}
+
+	return result
+}
+
+// toVisibleDiff converts `rawDiff` and drops every entry that is not
+// inside of one of `folders`.
+func toVisibleDiff(rawDiff *catfs.Diff, folders []string) *Diff {
+	return &Diff{
+		Added:    filterSingles(convertSingles(rawDiff.Added), folders),
+		Removed:  filterSingles(convertSingles(rawDiff.Removed), folders),
+		Ignored:  filterSingles(convertSingles(rawDiff.Ignored), folders),
+		Missing:  filterSingles(convertSingles(rawDiff.Missing), folders),
+		Conflict: filterPairs(convertPairs(rawDiff.Conflict), folders),
+		Moved:    filterPairs(convertPairs(rawDiff.Moved), folders),
+		Merged:   filterPairs(convertPairs(rawDiff.Merged), folders),
+	}
+}
+
+// userFolders returns the folders the user of `r` may access.
+func userFolders(r *http.Request) []string {
+	user, ok := r.Context().Value(dbUserKey("brig.db_user")).(db.User)
+	if !ok {
+		return nil
+	}
+
+	return user.Folders
+}
+
+func (dh *DiffHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !checkRights(w, r, db.RightFsView) {
+		return
+	}
+
+	diffReq := DiffRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&diffReq); err != nil {
+		jsonifyErrf(w, http.StatusBadRequest, "bad json")
+		return
+	}
+
+	if diffReq.From == "" {
+		diffReq.From = "head"
+	}
+
+	if diffReq.To == "" {
+		diffReq.To = "curr"
+	}
+
+	rawDiff, err := dh.fs.MakeDiff(dh.fs, diffReq.From, diffReq.To)
+	if err != nil {
+		log.Debugf("failed to diff %s..%s: %v", diffReq.From, diffReq.To, err)
+		jsonifyErrf(w, http.StatusBadRequest, "failed to diff")
+		return
+	}
+
+	jsonify(w, http.StatusOK, &DiffResponse{
+		Success: true,
+		Diff:    toVisibleDiff(rawDiff, userFolders(r)),
+	})
+}
@@ -0,0 +1,55 @@
+package endpoints
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffSuccess(t *testing.T) {
+	withState(t, func(s *testState) {
+		require.Nil(t, s.fs.MakeCommit("init"))
+		require.Nil(t, s.fs.Stage("/file", bytes.NewReader([]byte("hello"))))
+		require.Nil(t, s.fs.MakeCommit("add"))
+		require.Nil(t, s.fs.Remove("/file"))
+		require.Nil(t, s.fs.MakeCommit("remove"))
+
+		resp := s.mustRun(
+			t,
+			NewDiffHandler(s.State),
+			"POST",
+			"http://localhost:5000/api/v0/diff",
+			&DiffRequest{
+				From: "init",
+				To:   "head^",
+			},
+		)
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		diffResp := &DiffResponse{}
+		mustDecodeBody(t, resp.Body, &diffResp)
+		require.True(t, diffResp.Success)
+		require.Len(t, diffResp.Diff.Added, 1)
+		require.Equal(t, "/file", diffResp.Diff.Added[0].Path)
+	})
+}
+
+func TestDiffBadRevision(t *testing.T) {
+	withState(t, func(s *testState) {
+		resp := s.mustRun(
+			t,
+			NewDiffHandler(s.State),
+			"POST",
+			"http://localhost:5000/api/v0/diff",
+			&DiffRequest{
+				From: "head",
+				To:   "no-such-rev",
+			},
+		)
+
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
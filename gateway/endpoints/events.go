@@ -2,6 +2,7 @@ package endpoints
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"sync"
 	"time"
@@ -18,11 +19,87 @@ var upgrader = websocket.Upgrader{
 	WriteBufferSize: 1024,
 }
 
+// EventChange is a single changed path in an EventMessage.
+type EventChange struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+}
+
+// EventMessage is what clients receive that connected with ?details=true.
+// Other clients only receive the plain type of the event.
+type EventMessage struct {
+	Type    string        `json:"type"`
+	Commit  string        `json:"commit,omitempty"`
+	Changes []EventChange `json:"changes,omitempty"`
+}
+
+// eventsClient is a single connected websocket.
+type eventsClient struct {
+	ch chan string
+
+	// detailed is true if the client wants to receive EventMessages.
+	detailed bool
+
+	// prefixes is the list of paths the client is interested in.
+	prefixes []string
+
+	// folders are the folders the user is allowed to see.
+	// If nil, all folders are allowed.
+	folders []string
+}
+
+// format returns the message that should be sent to this client,
+// or an empty string if the client should not receive it at all.
+func (cl *eventsClient) format(typ string, ev *events.Event) string {
+	if ev != nil {
+		ev = ev.FilterByPrefixes(cl.prefixes)
+		if ev == nil {
+			return ""
+		}
+	}
+
+	changes := []EventChange{}
+	if ev != nil {
+		for _, change := range ev.Changes {
+			if cl.folders != nil && !pathIsInFolders(change.Path, cl.folders) {
+				continue
+			}
+
+			changes = append(changes, EventChange{
+				Path:   change.Path,
+				Change: change.Mask.String(),
+			})
+		}
+
+		if len(ev.Changes) > 0 && len(changes) == 0 {
+			// None of the changes are visible to this user.
+			return ""
+		}
+	}
+
+	if !cl.detailed {
+		return typ
+	}
+
+	msg := EventMessage{Type: typ, Changes: changes}
+	if ev != nil {
+		msg.Commit = ev.CommitHash
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Warningf("failed to encode event message: %v", err)
+		return ""
+	}
+
+	return string(data)
+}
+
 // EventsHandler implements http.Handler
 type EventsHandler struct {
 	mu         sync.Mutex
 	id         int
-	chs        map[int]chan string
+	clients    map[int]*eventsClient
 	rapi       remotesapi.RemotesAPI
 	evListener *events.Listener
 	changeOnce sync.Once
@@ -36,8 +113,8 @@ type EventsHandler struct {
 // NewEventsHandler returns a new EventsHandler
 func NewEventsHandler(rapi remotesapi.RemotesAPI, ev *events.Listener) *EventsHandler {
 	hdl := &EventsHandler{
-		chs:  make(map[int]chan string),
-		rapi: rapi,
+		clients: make(map[int]*eventsClient),
+		rapi:    rapi,
 	}
 
 	if ev != nil {
@@ -46,7 +123,7 @@ func NewEventsHandler(rapi remotesapi.RemotesAPI, ev *events.Listener) *EventsHa
 			ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 			defer cancel()
 
-			hdl.notify(ctx, "fs", true, false)
+			hdl.notify(ctx, "fs", ev, true, false)
 		})
 
 		// Incoming events from other nodes:
@@ -54,7 +131,7 @@ func NewEventsHandler(rapi remotesapi.RemotesAPI, ev *events.Listener) *EventsHa
 			ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 			defer cancel()
 
-			hdl.notify(ctx, "fs", false, false)
+			hdl.notify(ctx, "fs", ev, false, false)
 		})
 
 		hdl.evListener = ev
@@ -65,22 +142,33 @@ func NewEventsHandler(rapi remotesapi.RemotesAPI, ev *events.Listener) *EventsHa
 // Notify sends `msg` to all connected clients, but stops in case `ctx`
 // was canceled before sending it all.
 func (eh *EventsHandler) Notify(ctx context.Context, msg string) error {
-	return eh.notify(ctx, msg, true, true)
+	return eh.notify(ctx, msg, nil, true, true)
+}
+
+// NotifyFsEvent works like Notify, but also tells clients what paths were
+// changed by `ev`. Clients only get to see paths they have access to.
+func (eh *EventsHandler) NotifyFsEvent(ctx context.Context, ev *events.Event) error {
+	return eh.notify(ctx, "fs", ev, true, true)
 }
 
-func (eh *EventsHandler) notify(ctx context.Context, msg string, isOwnEvent, triggerPublish bool) error {
+func (eh *EventsHandler) notify(ctx context.Context, msg string, ev *events.Event, isOwnEvent, triggerPublish bool) error {
 	eh.mu.Lock()
-	chs := []chan string{}
-	for _, ch := range eh.chs {
-		chs = append(chs, ch)
+	clients := []*eventsClient{}
+	for _, client := range eh.clients {
+		clients = append(clients, client)
 	}
 	eh.mu.Unlock()
 
-	for _, ch := range chs {
+	for _, client := range clients {
+		clientMsg := client.format(msg, ev)
+		if clientMsg == "" {
+			continue
+		}
+
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case ch <- msg:
+		case client.ch <- clientMsg:
 			continue
 		}
 	}
@@ -90,6 +178,11 @@ func (eh *EventsHandler) notify(ctx context.Context, msg string, isOwnEvent, tri
 		Type: events.FsEvent,
 	}
 
+	if ev != nil {
+		event.Changes = ev.Changes
+		event.CommitHash = ev.CommitHash
+	}
+
 	if !isOwnEvent && triggerPublish && eh.evListener != nil {
 		return eh.evListener.PublishEvent(event)
 	}
@@ -102,16 +195,29 @@ func (eh *EventsHandler) Shutdown() {
 	eh.mu.Lock()
 	defer eh.mu.Unlock()
 
-	for _, ch := range eh.chs {
-		close(ch)
+	for _, client := range eh.clients {
+		close(client.ch)
 	}
 }
 
 func (eh *EventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client := &eventsClient{
+		ch:       make(chan string, 20),
+		detailed: r.URL.Query().Get("details") == "true",
+		prefixes: r.URL.Query()["prefix"],
+	}
+
 	if !eh.testing {
 		if !checkRights(w, r, db.RightFsView) {
 			return
 		}
+
+		// checkRights() made sure that there is a user:
+		user, _ := r.Context().Value(dbUserKey("brig.db_user")).(db.User)
+		client.folders = user.Folders
+		if client.folders == nil {
+			client.folders = []string{}
+		}
 	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -135,13 +241,12 @@ func (eh *EventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	eh.mu.Lock()
 	id := eh.id
 	eh.id++
-	ch := make(chan string, 20)
-	eh.chs[id] = ch
+	eh.clients[id] = client
 	eh.mu.Unlock()
 
 	defer func() {
 		eh.mu.Lock()
-		delete(eh.chs, id)
+		delete(eh.clients, id)
 		eh.mu.Unlock()
 	}()
 
@@ -149,7 +254,7 @@ func (eh *EventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	for {
 		select {
-		case msg, ok := <-ch:
+		case msg, ok := <-client.ch:
 			if !ok {
 				return
 			}
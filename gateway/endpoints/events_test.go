@@ -1,11 +1,14 @@
 package endpoints
 
 import (
+	"encoding/json"
 	"net/http"
 	"testing"
 
 	"github.com/gorilla/websocket"
 	"github.com/posener/wstest"
+	"github.com/sahib/brig/catfs/vcs"
+	"github.com/sahib/brig/events"
 	"github.com/stretchr/testify/require"
 )
 
@@ -44,3 +47,36 @@ func TestEvents(t *testing.T) {
 		require.Equal(t, []byte("fs"), data)
 	})
 }
+
+func TestEventsClientFormat(t *testing.T) {
+	ev := &events.Event{
+		Type:       events.FsEvent,
+		CommitHash: "W1cmt",
+		Changes: []events.Change{
+			{Path: "/public/a", Mask: vcs.ChangeTypeAdd},
+			{Path: "/private/b", Mask: vcs.ChangeTypeRemove},
+		},
+	}
+
+	// Legacy clients only get the type:
+	legacy := &eventsClient{}
+	require.Equal(t, "fs", legacy.format("fs", ev))
+	require.Equal(t, "remotes", legacy.format("remotes", nil))
+
+	detailed := &eventsClient{detailed: true, folders: []string{"/public"}}
+	msg := EventMessage{}
+	require.Nil(t, json.Unmarshal([]byte(detailed.format("fs", ev)), &msg))
+	require.Equal(t, EventMessage{
+		Type:    "fs",
+		Commit:  "W1cmt",
+		Changes: []EventChange{{Path: "/public/a", Change: "added"}},
+	}, msg)
+
+	// Nothing that this client may see:
+	private := &eventsClient{detailed: true, folders: []string{"/other"}}
+	require.Equal(t, "", private.format("fs", ev))
+
+	// Nothing that this client is interested in:
+	prefixed := &eventsClient{prefixes: []string{"/music"}}
+	require.Equal(t, "", prefixed.format("fs", ev))
+}
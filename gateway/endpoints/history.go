@@ -22,6 +22,8 @@ func NewHistoryHandler(s *State) *HistoryHandler {
 // HistoryRequest is the request sent to this endpoint.
 type HistoryRequest struct {
 	Path string `json:"path"`
+	// Blame requests the authorship of the file's content (see catfs.FS.Blame).
+	Blame bool `json:"blame"`
 }
 
 // Commit is the same as catfs.Commit, but JSON friendly
@@ -43,10 +45,34 @@ type HistoryEntry struct {
 	IsExplicit bool   `json:"is_explicit"`
 }
 
+// BlameVersion is a version of a file that changed its content.
+type BlameVersion struct {
+	Head       Commit `json:"head"`
+	Author     string `json:"author"`
+	MergedWith string `json:"merged_with"`
+	Path       string `json:"path"`
+	Size       uint64 `json:"size"`
+}
+
+// BlameLine is a line of a text file and the
+// index of the version that last changed it.
+type BlameLine struct {
+	Text    string `json:"text"`
+	Version int    `json:"version"`
+}
+
+// Blame is the JSON friendly version of catfs.Blame.
+type Blame struct {
+	Versions []BlameVersion `json:"versions"`
+	IsBinary bool           `json:"is_binary"`
+	Lines    []BlameLine    `json:"lines"`
+}
+
 // HistoryResponse is the data that is sent back to the client.
 type HistoryResponse struct {
 	Success bool           `json:"success"`
 	Entries []HistoryEntry `json:"entries"`
+	Blame   *Blame         `json:"blame,omitempty"`
 }
 
 func toExternalCommit(cmt *catfs.Commit) Commit {
@@ -76,6 +102,33 @@ func toExternalChange(c catfs.Change) HistoryEntry {
 	return e
 }
 
+func toExternalBlame(b *catfs.Blame) *Blame {
+	ext := &Blame{
+		Versions: []BlameVersion{},
+		IsBinary: b.IsBinary,
+		Lines:    []BlameLine{},
+	}
+
+	for _, version := range b.Versions {
+		ext.Versions = append(ext.Versions, BlameVersion{
+			Head:       toExternalCommit(version.Commit),
+			Author:     version.Author,
+			MergedWith: version.MergedWith,
+			Path:       version.Path,
+			Size:       version.Size,
+		})
+	}
+
+	for _, line := range b.Lines {
+		ext.Lines = append(ext.Lines, BlameLine{
+			Text:    line.Text,
+			Version: line.Version,
+		})
+	}
+
+	return ext
+}
+
 func (hh *HistoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if !checkRights(w, r, db.RightFsView) {
 		return
@@ -110,8 +163,21 @@ func (hh *HistoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		entries = append(entries, toExternalChange(change))
 	}
 
+	var blame *Blame
+	if histReq.Blame {
+		b, err := hh.fs.Blame(path)
+		if err != nil {
+			log.Debugf("failed to blame %s: %v", path, err)
+			jsonifyErrf(w, http.StatusBadRequest, "failed to blame")
+			return
+		}
+
+		blame = toExternalBlame(b)
+	}
+
 	jsonify(w, http.StatusOK, &HistoryResponse{
 		Success: true,
 		Entries: entries,
+		Blame:   blame,
 	})
 }
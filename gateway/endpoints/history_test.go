@@ -70,3 +70,40 @@ func TestHistoryEndpointForbidden(t *testing.T) {
 		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
 	})
 }
+
+func TestHistoryEndpointBlame(t *testing.T) {
+	withState(t, func(s *testState) {
+		require.Nil(t, s.fs.Stage("/x", bytes.NewReader([]byte("a\nb\n"))))
+		require.Nil(t, s.fs.MakeCommit("first"))
+		require.Nil(t, s.fs.Stage("/x", bytes.NewReader([]byte("a\nc\n"))))
+		require.Nil(t, s.fs.MakeCommit("second"))
+
+		resp := s.mustRun(
+			t,
+			NewHistoryHandler(s.State),
+			"POST",
+			"http://localhost:5000/api/v0/history",
+			&HistoryRequest{
+				Path:  "/x",
+				Blame: true,
+			},
+		)
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		data := &HistoryResponse{}
+		mustDecodeBody(t, resp.Body, &data)
+		require.Equal(t, true, data.Success)
+		require.NotNil(t, data.Blame)
+		require.False(t, data.Blame.IsBinary)
+
+		versions := data.Blame.Versions
+		require.Len(t, versions, 2)
+		require.Equal(t, "second", versions[0].Head.Msg)
+		require.Equal(t, "first", versions[1].Head.Msg)
+		require.Equal(t, []BlameLine{
+			{Text: "a", Version: 1},
+			{Text: "c", Version: 0},
+		}, data.Blame.Lines)
+	})
+}
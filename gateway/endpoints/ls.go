@@ -8,6 +8,7 @@ import (
 
 	"github.com/sahib/brig/catfs"
 	"github.com/sahib/brig/gateway/db"
+	"github.com/sahib/brig/gateway/thumbs"
 )
 
 // LsHandler implements http.Handler.
@@ -39,6 +40,7 @@ type StatInfo struct {
 	IsDir      bool   `json:"is_dir"`
 	IsPinned   bool   `json:"is_pinned"`
 	IsExplicit bool   `json:"is_explicit"`
+	HasPreview bool   `json:"has_preview"`
 }
 
 func toExternalStatInfo(i *catfs.StatInfo) *StatInfo {
@@ -52,6 +54,7 @@ func toExternalStatInfo(i *catfs.StatInfo) *StatInfo {
 		IsDir:      i.IsDir,
 		IsPinned:   i.IsPinned,
 		IsExplicit: i.IsExplicit,
+		HasPreview: !i.IsDir && thumbs.KindFromPath(i.Path) != thumbs.KindNone,
 	}
 }
 
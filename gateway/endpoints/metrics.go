@@ -0,0 +1,55 @@
+package endpoints
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	metricRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "brig_gateway_requests_total",
+		Help: "Number of requests served by the gateway.",
+	}, []string{"route", "code"})
+
+	metricRequestSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "brig_gateway_request_duration_seconds",
+		Help: "Time it took to serve a request by the gateway.",
+	}, []string{"route"})
+)
+
+type metricsMiddleware struct {
+	SubHandler http.Handler
+}
+
+// routeName returns the matched route template, so that paths
+// like /get/some/file do not end up as separate label values.
+func routeName(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+
+	return "other"
+}
+
+func (mm *metricsMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w}
+	mm.SubHandler.ServeHTTP(rec, r)
+
+	route := routeName(r)
+	metricRequests.WithLabelValues(route, strconv.Itoa(rec.Status())).Inc()
+	metricRequestSeconds.WithLabelValues(route).Observe(time.Since(start).Seconds())
+}
+
+// MetricsMiddleware counts the requests and measures
+// the time it took to serve them for every route.
+func MetricsMiddleware(h http.Handler) http.Handler {
+	return &metricsMiddleware{SubHandler: h}
+}
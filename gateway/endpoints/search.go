@@ -0,0 +1,85 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sahib/brig/catfs"
+	"github.com/sahib/brig/gateway/db"
+)
+
+// SearchHandler implements http.Handler.
+type SearchHandler struct {
+	*State
+}
+
+// NewSearchHandler returns a new SearchHandler
+func NewSearchHandler(s *State) *SearchHandler {
+	return &SearchHandler{State: s}
+}
+
+// SearchRequest is the data that needs to be sent to this endpoint.
+type SearchRequest struct {
+	Root    string `json:"root"`
+	Query   string `json:"query"`
+	Rev     string `json:"rev,omitempty"`
+	History bool   `json:"history,omitempty"`
+}
+
+// SearchResult is a single node that matched the query.
+type SearchResult struct {
+	*StatInfo
+
+	// Commit is the commit the node was found in.
+	// It is empty if the current state was searched.
+	Commit string `json:"commit,omitempty"`
+}
+
+// SearchResponse is the response sent back to the client.
+type SearchResponse struct {
+	Success bool            `json:"success"`
+	Results []*SearchResult `json:"results"`
+}
+
+func (sh *SearchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !checkRights(w, r, db.RightFsView) {
+		return
+	}
+
+	searchReq := SearchRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&searchReq); err != nil {
+		jsonifyErrf(w, http.StatusBadRequest, "bad json")
+		return
+	}
+
+	opts := catfs.SearchOptions{
+		Rev:     searchReq.Rev,
+		History: searchReq.History,
+	}
+
+	root := prefixRoot(searchReq.Root)
+	items, err := sh.fs.Search(root, searchReq.Query, opts)
+	if err != nil {
+		jsonifyErrf(w, http.StatusBadRequest, "failed to search: %v", err)
+		return
+	}
+
+	results := []*SearchResult{}
+	for _, item := range items {
+		if !sh.pathIsVisible(item.Path, w, r) {
+			continue
+		}
+
+		result := &SearchResult{StatInfo: toExternalStatInfo(item.StatInfo)}
+		if item.Commit != nil {
+			result.Commit = item.Commit.B58String()
+		}
+
+		results = append(results, result)
+	}
+
+	jsonify(w, http.StatusOK, &SearchResponse{
+		Success: true,
+		Results: results,
+	})
+}
@@ -0,0 +1,54 @@
+package endpoints
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchEndpoint(t *testing.T) {
+	withState(t, func(s *testState) {
+		require.Nil(t, s.fs.Stage("/hello/world.png", bytes.NewReader([]byte("Hello world"))))
+		require.Nil(t, s.fs.Stage("/hello/world.txt", bytes.NewReader([]byte("Hello world"))))
+
+		resp := s.mustRun(
+			t,
+			NewSearchHandler(s.State),
+			"POST",
+			"http://localhost:5000/api/v0/search",
+			&SearchRequest{
+				Root:  "/",
+				Query: "mime:image/*",
+			},
+		)
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		searchResp := &SearchResponse{}
+		mustDecodeBody(t, resp.Body, &searchResp)
+
+		require.True(t, searchResp.Success)
+		require.Len(t, searchResp.Results, 1)
+		require.Equal(t, "/hello/world.png", searchResp.Results[0].Path)
+		require.Equal(t, "", searchResp.Results[0].Commit)
+	})
+}
+
+func TestSearchEndpointBadQuery(t *testing.T) {
+	withState(t, func(s *testState) {
+		resp := s.mustRun(
+			t,
+			NewSearchHandler(s.State),
+			"POST",
+			"http://localhost:5000/api/v0/search",
+			&SearchRequest{
+				Root:  "/",
+				Query: "size:huge",
+			},
+		)
+
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
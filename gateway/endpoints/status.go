@@ -0,0 +1,52 @@
+package endpoints
+
+import (
+	"net/http"
+
+	"github.com/sahib/brig/gateway/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// StatusHandler implements http.Handler.
+// It lists all changes that were not committed yet.
+type StatusHandler struct {
+	*State
+}
+
+// NewStatusHandler returns a new StatusHandler.
+func NewStatusHandler(s *State) *StatusHandler {
+	return &StatusHandler{State: s}
+}
+
+// StatusResponse is the response sent back to the client.
+type StatusResponse struct {
+	Success           bool  `json:"success"`
+	HaveStagedChanges bool  `json:"have_staged_changes"`
+	Diff              *Diff `json:"diff"`
+}
+
+func (sh *StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !checkRights(w, r, db.RightFsView) {
+		return
+	}
+
+	haveStagedChanges, err := sh.fs.HaveStagedChanges()
+	if err != nil {
+		jsonifyErrf(w, http.StatusInternalServerError, "failed to check staged state")
+		return
+	}
+
+	// Same as `brig status`:
+	rawDiff, err := sh.fs.MakeDiff(sh.fs, "head", "curr")
+	if err != nil {
+		log.Debugf("failed to get status: %v", err)
+		jsonifyErrf(w, http.StatusInternalServerError, "failed to get status")
+		return
+	}
+
+	jsonify(w, http.StatusOK, &StatusResponse{
+		Success:           true,
+		HaveStagedChanges: haveStagedChanges,
+		Diff:              toVisibleDiff(rawDiff, userFolders(r)),
+	})
+}
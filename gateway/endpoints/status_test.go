@@ -0,0 +1,50 @@
+package endpoints
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusSuccess(t *testing.T) {
+	withState(t, func(s *testState) {
+		require.Nil(t, s.fs.MakeCommit("init"))
+		require.Nil(t, s.fs.Stage("/public/file", bytes.NewReader([]byte("hello"))))
+		require.Nil(t, s.fs.Stage("/private/file", bytes.NewReader([]byte("world"))))
+
+		resp := s.mustRun(
+			t,
+			NewStatusHandler(s.State),
+			"POST",
+			"http://localhost:5000/api/v0/status",
+			nil,
+		)
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		statusResp := &StatusResponse{}
+		mustDecodeBody(t, resp.Body, &statusResp)
+		require.True(t, statusResp.Success)
+		require.True(t, statusResp.HaveStagedChanges)
+		require.Len(t, statusResp.Diff.Added, 2)
+
+		// Users should only see changes in their folders:
+		s.mustChangeFolders(t, "/public")
+		resp = s.mustRun(
+			t,
+			NewStatusHandler(s.State),
+			"POST",
+			"http://localhost:5000/api/v0/status",
+			nil,
+		)
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		statusResp = &StatusResponse{}
+		mustDecodeBody(t, resp.Body, &statusResp)
+		require.Len(t, statusResp.Diff.Added, 1)
+		require.Equal(t, "/public", statusResp.Diff.Added[0].Path)
+	})
+}
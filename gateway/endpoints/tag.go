@@ -0,0 +1,56 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sahib/brig/gateway/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// TagHandler implements http.Handler.
+type TagHandler struct {
+	*State
+}
+
+// NewTagHandler returns a new TagHandler.
+func NewTagHandler(s *State) *TagHandler {
+	return &TagHandler{State: s}
+}
+
+// TagRequest is the request sent to this endpoint.
+type TagRequest struct {
+	Revision string `json:"revision"`
+	Name     string `json:"name"`
+}
+
+func (th *TagHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !checkRights(w, r, db.RightFsEdit) {
+		return
+	}
+
+	tagReq := TagRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&tagReq); err != nil {
+		jsonifyErrf(w, http.StatusBadRequest, "bad json")
+		return
+	}
+
+	if tagReq.Name == "" || tagReq.Revision == "" {
+		jsonifyErrf(w, http.StatusBadRequest, "need a revision and a tag name")
+		return
+	}
+
+	if !th.validatePath("/", w, r) {
+		jsonifyErrf(w, http.StatusUnauthorized, "path forbidden")
+		return
+	}
+
+	if err := th.fs.Tag(tagReq.Revision, tagReq.Name); err != nil {
+		log.Debugf("failed to tag %s as %s: %v", tagReq.Revision, tagReq.Name, err)
+		jsonifyErrf(w, http.StatusBadRequest, "failed to tag")
+		return
+	}
+
+	th.evHdl.Notify(r.Context(), "fs")
+	jsonifySuccess(w)
+}
@@ -0,0 +1,69 @@
+package endpoints
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagSuccess(t *testing.T) {
+	withState(t, func(s *testState) {
+		require.Nil(t, s.fs.Stage("/file", bytes.NewReader([]byte("hello"))))
+		require.Nil(t, s.fs.MakeCommit("add"))
+
+		resp := s.mustRun(
+			t,
+			NewTagHandler(s.State),
+			"POST",
+			"http://localhost:5000/api/v0/tag",
+			&TagRequest{
+				Revision: "head",
+				Name:     "v1",
+			},
+		)
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		cmt, err := s.fs.CommitInfo("v1")
+		require.Nil(t, err)
+		require.NotNil(t, cmt)
+		require.Equal(t, "add", cmt.Msg)
+	})
+}
+
+func TestTagBadRevision(t *testing.T) {
+	withState(t, func(s *testState) {
+		resp := s.mustRun(
+			t,
+			NewTagHandler(s.State),
+			"POST",
+			"http://localhost:5000/api/v0/tag",
+			&TagRequest{
+				Revision: "no-such-rev",
+				Name:     "v1",
+			},
+		)
+
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestTagForbidden(t *testing.T) {
+	withState(t, func(s *testState) {
+		s.mustChangeFolders(t, "/public")
+		resp := s.mustRun(
+			t,
+			NewTagHandler(s.State),
+			"POST",
+			"http://localhost:5000/api/v0/tag",
+			&TagRequest{
+				Revision: "head",
+				Name:     "v1",
+			},
+		)
+
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
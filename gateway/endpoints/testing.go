@@ -14,8 +14,10 @@ import (
 
 	"github.com/sahib/brig/catfs"
 	"github.com/sahib/brig/defaults"
+	"github.com/sahib/brig/gateway/audit"
 	"github.com/sahib/brig/gateway/db"
 	"github.com/sahib/brig/gateway/remotesapi"
+	"github.com/sahib/brig/gateway/thumbs"
 	"github.com/sahib/config"
 	"github.com/stretchr/testify/require"
 )
@@ -54,8 +56,14 @@ func withState(t *testing.T, fn func(state *testState)) {
 	userDb, err := db.NewUserDatabase(dbPath)
 	require.Nil(t, err)
 
+	thumbCache, err := thumbs.NewCache(filepath.Join(tmpDir, "thumbs"), 1024*1024)
+	require.Nil(t, err)
+
+	auditLog, err := audit.Open(filepath.Join(tmpDir, "audit"), 1024*1024, 2)
+	require.Nil(t, err)
+
 	state, err := NewState(
-		fs, rapi, cfg.Section("gateway"), NewEventsHandler(rapi, nil), nil, userDb,
+		fs, rapi, cfg.Section("gateway"), NewEventsHandler(rapi, nil), nil, userDb, thumbCache, auditLog,
 	)
 
 	require.Nil(t, err)
@@ -65,6 +73,7 @@ func withState(t *testing.T, fn func(state *testState)) {
 
 	require.Nil(t, state.Close())
 	require.Nil(t, state.fs.Close())
+	require.Nil(t, auditLog.Close())
 }
 
 func mustEncodeBody(t *testing.T, v interface{}) io.Reader {
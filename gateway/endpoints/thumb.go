@@ -0,0 +1,120 @@
+package endpoints
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+
+	ie "github.com/sahib/brig/catfs/errors"
+	"github.com/sahib/brig/gateway/db"
+	"github.com/sahib/brig/gateway/thumbs"
+	log "github.com/sirupsen/logrus"
+)
+
+// ThumbHandler implements http.Handler.
+// It serves image thumbnails and text previews of files.
+type ThumbHandler struct {
+	*State
+}
+
+// NewThumbHandler returns a new ThumbHandler
+func NewThumbHandler(s *State) *ThumbHandler {
+	return &ThumbHandler{State: s}
+}
+
+func (th *ThumbHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !checkRights(w, r, db.RightDownload) {
+		return
+	}
+
+	// get the file nodePath including the leading slash:
+	fullURL := r.URL.EscapedPath()
+	nodePath, err := url.PathUnescape(fullURL[len("/thumb"):])
+	if err != nil {
+		log.Debugf("received malformed url: %s", fullURL)
+		http.Error(w, "malformed url", http.StatusBadRequest)
+		return
+	}
+
+	nodePath = prefixRoot(path.Clean(nodePath))
+	if !th.validatePath(nodePath, w, r) {
+		http.Error(w, "insufficient rights", http.StatusUnauthorized)
+		return
+	}
+
+	size := thumbs.DefaultSize
+	if sizeStr := r.URL.Query().Get("size"); sizeStr != "" {
+		size, err = strconv.Atoi(sizeStr)
+		if err != nil {
+			http.Error(w, "bad size", http.StatusBadRequest)
+			return
+		}
+	}
+
+	size = thumbs.ClampSize(size)
+
+	info, err := th.fs.Stat(nodePath)
+	if err != nil {
+		if ie.IsNoSuchFileError(err) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		log.Errorf("thumb: failed to stat %s: %v", nodePath, err)
+		http.Error(w, "failed to stat file", http.StatusInternalServerError)
+		return
+	}
+
+	if info.IsDir {
+		http.Error(w, "no preview for directories", http.StatusNotFound)
+		return
+	}
+
+	etag := fmt.Sprintf("\"%s-%d\"", info.ContentHash.B58String(), size)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Text previews do not depend on the size,
+	// but it's easier to cache them the same way.
+	cacheKey := fmt.Sprintf("%s-%d", info.ContentHash.B58String(), size)
+	preview, ok := th.thumbs.Get(cacheKey)
+	if !ok {
+		stream, err := th.fs.Cat(nodePath)
+		if err != nil {
+			log.Errorf("thumb: failed to stream %s: %v", nodePath, err)
+			http.Error(w, "failed to stream", http.StatusInternalServerError)
+			return
+		}
+
+		defer stream.Close()
+
+		preview, err = thumbs.Generate(stream, size)
+		if err != nil {
+			if err != thumbs.ErrNoPreview {
+				log.Debugf("thumb: failed to generate preview for %s: %v", nodePath, err)
+			}
+
+			http.Error(w, "no preview available", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		if err := th.thumbs.Put(cacheKey, preview); err != nil {
+			log.Warningf("thumb: failed to cache preview of %s: %v", nodePath, err)
+		}
+	}
+
+	hdr := w.Header()
+	hdr.Set("Content-Type", preview.MimeType)
+	hdr.Set("Content-Length", strconv.Itoa(len(preview.Data)))
+	hdr.Set("ETag", etag)
+	hdr.Set("Cache-Control", "private, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(preview.Data); err != nil {
+		log.Debugf("thumb: failed to write preview: %v", err)
+	}
+}
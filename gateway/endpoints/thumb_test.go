@@ -0,0 +1,117 @@
+package endpoints
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func mustEncodePNG(t *testing.T, w, h int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xFF})
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	require.Nil(t, png.Encode(buf, img))
+	return buf.Bytes()
+}
+
+func TestThumbEndpointImage(t *testing.T) {
+	withState(t, func(s *testState) {
+		require.Nil(t, s.fs.Stage("/image.png", bytes.NewReader(mustEncodePNG(t, 400, 200))))
+
+		resp := s.mustRun(
+			t,
+			NewThumbHandler(s.State),
+			"GET",
+			"http://localhost:5000/thumb/image.png?size=64",
+			nil,
+		)
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "image/jpeg", resp.Header.Get("Content-Type"))
+		require.NotEmpty(t, resp.Header.Get("ETag"))
+
+		img, _, err := image.Decode(resp.Body)
+		require.Nil(t, err)
+		require.Equal(t, 64, img.Bounds().Dx())
+		require.Equal(t, 32, img.Bounds().Dy())
+
+		// Second request should be served from the cache:
+		require.True(t, s.thumbs.Size() > 0)
+		resp = s.mustRun(
+			t,
+			NewThumbHandler(s.State),
+			"GET",
+			"http://localhost:5000/thumb/image.png?size=64",
+			nil,
+		)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
+
+func TestThumbEndpointText(t *testing.T) {
+	withState(t, func(s *testState) {
+		text := strings.Repeat("Hello World\n", 1000)
+		require.Nil(t, s.fs.Stage("/file.txt", strings.NewReader(text)))
+
+		resp := s.mustRun(
+			t,
+			NewThumbHandler(s.State),
+			"GET",
+			"http://localhost:5000/thumb/file.txt",
+			nil,
+		)
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain"))
+
+		data, err := ioutil.ReadAll(resp.Body)
+		require.Nil(t, err)
+		require.Equal(t, text[:4096], string(data))
+	})
+}
+
+func TestThumbEndpointNoPreview(t *testing.T) {
+	withState(t, func(s *testState) {
+		require.Nil(t, s.fs.Stage("/file.bin", bytes.NewReader([]byte{0, 1, 2, 3, 0xFF})))
+		require.Nil(t, s.fs.Mkdir("/dir", true))
+
+		resp := s.mustRun(
+			t,
+			NewThumbHandler(s.State),
+			"GET",
+			"http://localhost:5000/thumb/file.bin",
+			nil,
+		)
+		require.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+		resp = s.mustRun(
+			t,
+			NewThumbHandler(s.State),
+			"GET",
+			"http://localhost:5000/thumb/dir",
+			nil,
+		)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = s.mustRun(
+			t,
+			NewThumbHandler(s.State),
+			"GET",
+			"http://localhost:5000/thumb/does-not-exist.png",
+			nil,
+		)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
@@ -0,0 +1,55 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sahib/brig/gateway/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// UntagHandler implements http.Handler.
+type UntagHandler struct {
+	*State
+}
+
+// NewUntagHandler returns a new UntagHandler.
+func NewUntagHandler(s *State) *UntagHandler {
+	return &UntagHandler{State: s}
+}
+
+// UntagRequest is the request sent to this endpoint.
+type UntagRequest struct {
+	Name string `json:"name"`
+}
+
+func (uh *UntagHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !checkRights(w, r, db.RightFsEdit) {
+		return
+	}
+
+	untagReq := UntagRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&untagReq); err != nil {
+		jsonifyErrf(w, http.StatusBadRequest, "bad json")
+		return
+	}
+
+	if untagReq.Name == "" {
+		jsonifyErrf(w, http.StatusBadRequest, "empty tag name")
+		return
+	}
+
+	if !uh.validatePath("/", w, r) {
+		jsonifyErrf(w, http.StatusUnauthorized, "path forbidden")
+		return
+	}
+
+	if err := uh.fs.RemoveTag(untagReq.Name); err != nil {
+		log.Debugf("failed to remove tag %s: %v", untagReq.Name, err)
+		jsonifyErrf(w, http.StatusBadRequest, "failed to remove tag")
+		return
+	}
+
+	uh.evHdl.Notify(r.Context(), "fs")
+	jsonifySuccess(w)
+}
@@ -0,0 +1,31 @@
+package endpoints
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUntagSuccess(t *testing.T) {
+	withState(t, func(s *testState) {
+		require.Nil(t, s.fs.MakeCommit("init"))
+		require.Nil(t, s.fs.Tag("head", "v1"))
+
+		resp := s.mustRun(
+			t,
+			NewUntagHandler(s.State),
+			"POST",
+			"http://localhost:5000/api/v0/untag",
+			&UntagRequest{
+				Name: "v1",
+			},
+		)
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		cmt, err := s.fs.CommitInfo("v1")
+		require.Nil(t, err)
+		require.Nil(t, cmt)
+	})
+}
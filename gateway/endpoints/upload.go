@@ -58,6 +58,20 @@ func (uh *UploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
+			isIgnored, err := uh.fs.IsIgnored(path, false)
+			if err != nil {
+				log.Debugf("upload: could not check ignore rules: %v", err)
+				jsonifyErrf(w, http.StatusInternalServerError, "failed to check ignore rules: %v", path)
+				fd.Close()
+				return
+			}
+
+			if isIgnored {
+				log.Debugf("upload: skipping ignored file %s", path)
+				fd.Close()
+				continue
+			}
+
 			if err := uh.fs.Stage(path, fd); err != nil {
 				log.Debugf("upload: could not stage: %v", err)
 				jsonifyErrf(w, http.StatusBadRequest, "failed to insert file: %v", path)
@@ -67,3 +67,20 @@ func TestUploadForbidden(t *testing.T) {
 		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
 	})
 }
+
+func TestUploadIgnored(t *testing.T) {
+	withState(t, func(s *testState) {
+		require.Nil(t, s.fs.Stage("/sub/.brigignore", bytes.NewReader([]byte("*.log\n"))))
+		resp := mustDoUpload(t, s, "/sub/debug.log", []byte("hello"))
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		_, err := s.fs.Stat("/sub/debug.log")
+		require.NotNil(t, err)
+
+		resp = mustDoUpload(t, s, "/debug.log", []byte("hello"))
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		_, err = s.fs.Stat("/debug.log")
+		require.Nil(t, err)
+	})
+}
@@ -1,10 +1,12 @@
 package endpoints
 
 import (
+	"bufio"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"path"
 	"strings"
@@ -15,8 +17,10 @@ import (
 	"github.com/sahib/brig/catfs"
 	ie "github.com/sahib/brig/catfs/errors"
 	"github.com/sahib/brig/events"
+	"github.com/sahib/brig/gateway/audit"
 	"github.com/sahib/brig/gateway/db"
 	"github.com/sahib/brig/gateway/remotesapi"
+	"github.com/sahib/brig/gateway/thumbs"
 	"github.com/sahib/config"
 	log "github.com/sirupsen/logrus"
 )
@@ -31,6 +35,8 @@ type State struct {
 	evHdl  *EventsHandler
 	store  *sessions.CookieStore
 	userDb *db.UserDatabase
+	thumbs *thumbs.Cache
+	audit  *audit.Log
 }
 
 func readOrInitKeyFromConfig(cfg *config.Config, keyName string, keyLen int) ([]byte, error) {
@@ -53,6 +59,8 @@ func NewState(
 	evHdl *EventsHandler,
 	ev *events.Listener,
 	userDb *db.UserDatabase,
+	thumbCache *thumbs.Cache,
+	auditLog *audit.Log,
 ) (*State, error) {
 	authKey, err := readOrInitKeyFromConfig(cfg, "auth.session-authentication-key", 64)
 	if err != nil {
@@ -77,6 +85,8 @@ func NewState(
 		evHdl:  evHdl,
 		store:  sessions.NewCookieStore(authKey, encKey),
 		userDb: userDb,
+		thumbs: thumbCache,
+		audit:  auditLog,
 	}, nil
 }
 
@@ -91,6 +101,11 @@ func (s *State) UserDatabase() *db.UserDatabase {
 	return s.userDb
 }
 
+// AuditLog returns the currently opened audit log.
+func (s *State) AuditLog() *audit.Log {
+	return s.audit
+}
+
 func (s *State) publishFsEvent(req *http.Request) {
 	if s.evHdl != nil {
 		ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
@@ -196,8 +211,13 @@ func (s *State) validatePath(nodePath string, w http.ResponseWriter, r *http.Req
 }
 
 func (s *State) validatePathForUser(nodePath string, user db.User, w http.ResponseWriter, r *http.Request) bool {
+	return pathIsInFolders(nodePath, user.Folders)
+}
+
+// pathIsInFolders checks if `nodePath` is one of `folders` or below one of them.
+func pathIsInFolders(nodePath string, folders []string) bool {
 	curr := prefixRoot(nodePath)
-	folderCache := buildFolderCache(user.Folders)
+	folderCache := buildFolderCache(folders)
 
 	for curr != "" {
 		if folderCache[curr] {
@@ -257,6 +277,12 @@ func jsonifySuccess(w http.ResponseWriter) {
 }
 
 func (s *State) commitChange(msg string, w http.ResponseWriter, r *http.Request) bool {
+	if !s.cfg.Bool("auto_commit") {
+		// Leave the change staged; somebody else will commit it.
+		s.evHdl.Notify(r.Context(), "fs")
+		return true
+	}
+
 	name := getUserName(s.store, w, r)
 	fullMsg := fmt.Sprintf("gateway: »%s« %s", name, msg)
 
@@ -271,10 +297,83 @@ func (s *State) commitChange(msg string, w http.ResponseWriter, r *http.Request)
 		return true
 	}
 
-	s.evHdl.Notify(r.Context(), "fs")
+	s.evHdl.NotifyFsEvent(r.Context(), s.headEvent())
 	return true
 }
 
+// headEvent builds an event describing the changes in the HEAD commit.
+// If that fails, the event does not carry any path information.
+func (s *State) headEvent() *events.Event {
+	ev := &events.Event{Type: events.FsEvent}
+
+	head, err := s.fs.Head()
+	if err != nil {
+		log.Warningf("failed to get head commit: %v", err)
+		return ev
+	}
+
+	masks, err := s.fs.ChangedPaths(head)
+	if err != nil {
+		log.Warningf("failed to get changes of %s: %v", head, err)
+		return ev
+	}
+
+	ev.CommitHash = head
+	ev.Changes = events.ChangesFromMasks(masks)
+	return ev
+}
+
+///////
+
+// statusRecorder remembers the status code and the number
+// of bytes written by the handler it was passed to.
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(buf []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+
+	n, err := sr.ResponseWriter.Write(buf)
+	sr.written += int64(n)
+	return n, err
+}
+
+// Hijack is needed for the websocket in /events.
+func (sr *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := sr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+
+	if sr.status == 0 {
+		sr.status = http.StatusSwitchingProtocols
+	}
+
+	return hijacker.Hijack()
+}
+
+// Status returns the status code that was sent to the client.
+func (sr *statusRecorder) Status() int {
+	if sr.status == 0 {
+		return http.StatusOK
+	}
+
+	return sr.status
+}
+
 ///////
 
 type secureMiddleware struct {
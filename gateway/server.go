@@ -4,17 +4,21 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"path/filepath"
 	"time"
 
 	"github.com/NYTimes/gziphandler"
+	humanize "github.com/dustin/go-humanize"
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
 	"github.com/phogolabs/parcello"
 	"github.com/sahib/brig/catfs"
 	"github.com/sahib/brig/events"
+	"github.com/sahib/brig/gateway/audit"
 	"github.com/sahib/brig/gateway/db"
 	"github.com/sahib/brig/gateway/endpoints"
 	"github.com/sahib/brig/gateway/remotesapi"
+	"github.com/sahib/brig/gateway/thumbs"
 	"github.com/sahib/config"
 	log "github.com/sirupsen/logrus"
 	"github.com/ulule/limiter"
@@ -53,8 +57,32 @@ func NewGateway(fs *catfs.FS, rapi remotesapi.RemotesAPI, cfg *config.Config, ev
 		return nil, err
 	}
 
+	thumbCacheSize, err := humanize.ParseBytes(cfg.String("thumbs.cache_size"))
+	if err != nil {
+		return nil, err
+	}
+
+	// The preview cache lives next to the user database:
+	thumbCacheDir := filepath.Join(filepath.Dir(dbPath), "thumbs")
+	thumbCache, err := thumbs.NewCache(thumbCacheDir, int64(thumbCacheSize))
+	if err != nil {
+		return nil, err
+	}
+
+	auditMaxSize, err := humanize.ParseBytes(cfg.String("audit.max_size"))
+	if err != nil {
+		return nil, err
+	}
+
+	// The audit log also lives next to the user database:
+	auditDir := filepath.Join(filepath.Dir(dbPath), "audit")
+	auditLog, err := audit.Open(auditDir, int64(auditMaxSize), int(cfg.Int("audit.max_files")))
+	if err != nil {
+		return nil, err
+	}
+
 	evHdl := endpoints.NewEventsHandler(rapi, ev)
-	state, err := endpoints.NewState(fs, rapi, cfg, evHdl, ev, userDb)
+	state, err := endpoints.NewState(fs, rapi, cfg, evHdl, ev, userDb, thumbCache, auditLog)
 	if err != nil {
 		return nil, err
 	}
@@ -188,8 +216,15 @@ func (gw *Gateway) Start() {
 	// Use csrf protection for all routes by default.
 	// This does not influence GET routes, only POST ones:
 	router := mux.NewRouter()
+	router.Use(endpoints.MetricsMiddleware)
 	router.Use(endpoints.SecureMiddleware(gw.state))
 	needsAuth := endpoints.AuthMiddleware(gw.state)
+	auditLog := endpoints.AuditMiddleware(gw.state)
+
+	// Every authenticated API call is recorded in the audit log:
+	audited := func(h http.Handler) http.Handler {
+		return needsAuth(auditLog(h))
+	}
 
 	csrfOpts := []csrf.Option{
 		csrf.ErrorHandler(&csrfErrorHandler{}),
@@ -208,39 +243,49 @@ func (gw *Gateway) Start() {
 		apiRouter.Handle("/login", endpoints.NewLoginHandler(gw.state))
 		apiRouter.Handle("/whoami", endpoints.NewWhoamiHandler(gw.state))
 		apiRouter.Handle("/ping", endpoints.NewPingHandler(gw.state))
-		apiRouter.Handle("/logout", needsAuth(endpoints.NewLogoutHandler(gw.state)))
-		apiRouter.Handle("/ls", needsAuth(endpoints.NewLsHandler(gw.state)))
-		apiRouter.Handle("/upload", needsAuth(endpoints.NewUploadHandler(gw.state)))
-		apiRouter.Handle("/move", needsAuth(endpoints.NewMoveHandler(gw.state)))
-		apiRouter.Handle("/mkdir", needsAuth(endpoints.NewMkdirHandler(gw.state)))
-		apiRouter.Handle("/copy", needsAuth(endpoints.NewCopyHandler(gw.state)))
-		apiRouter.Handle("/remove", needsAuth(endpoints.NewRemoveHandler(gw.state)))
-		apiRouter.Handle("/history", needsAuth(endpoints.NewHistoryHandler(gw.state)))
-		apiRouter.Handle("/reset", needsAuth(endpoints.NewResetHandler(gw.state)))
-		apiRouter.Handle("/all-dirs", needsAuth(endpoints.NewAllDirsHandler(gw.state)))
-		apiRouter.Handle("/log", needsAuth(endpoints.NewLogHandler(gw.state)))
-		apiRouter.Handle("/deleted", needsAuth(endpoints.NewDeletedPathsHandler(gw.state)))
-		apiRouter.Handle("/undelete", needsAuth(endpoints.NewUndeleteHandler(gw.state)))
-		apiRouter.Handle("/pin", needsAuth(endpoints.NewPinHandler(gw.state)))
-		apiRouter.Handle("/unpin", needsAuth(endpoints.NewUnpinHandler(gw.state)))
+		apiRouter.Handle("/logout", audited(endpoints.NewLogoutHandler(gw.state)))
+		apiRouter.Handle("/ls", audited(endpoints.NewLsHandler(gw.state)))
+		apiRouter.Handle("/search", audited(endpoints.NewSearchHandler(gw.state)))
+		apiRouter.Handle("/upload", audited(endpoints.NewUploadHandler(gw.state)))
+		apiRouter.Handle("/move", audited(endpoints.NewMoveHandler(gw.state)))
+		apiRouter.Handle("/mkdir", audited(endpoints.NewMkdirHandler(gw.state)))
+		apiRouter.Handle("/copy", audited(endpoints.NewCopyHandler(gw.state)))
+		apiRouter.Handle("/remove", audited(endpoints.NewRemoveHandler(gw.state)))
+		apiRouter.Handle("/history", audited(endpoints.NewHistoryHandler(gw.state)))
+		apiRouter.Handle("/reset", audited(endpoints.NewResetHandler(gw.state)))
+		apiRouter.Handle("/all-dirs", audited(endpoints.NewAllDirsHandler(gw.state)))
+		apiRouter.Handle("/log", audited(endpoints.NewLogHandler(gw.state)))
+		apiRouter.Handle("/deleted", audited(endpoints.NewDeletedPathsHandler(gw.state)))
+		apiRouter.Handle("/undelete", audited(endpoints.NewUndeleteHandler(gw.state)))
+		apiRouter.Handle("/pin", audited(endpoints.NewPinHandler(gw.state)))
+		apiRouter.Handle("/unpin", audited(endpoints.NewUnpinHandler(gw.state)))
+		apiRouter.Handle("/commit", audited(endpoints.NewCommitHandler(gw.state)))
+		apiRouter.Handle("/tag", audited(endpoints.NewTagHandler(gw.state)))
+		apiRouter.Handle("/untag", audited(endpoints.NewUntagHandler(gw.state)))
+		apiRouter.Handle("/status", audited(endpoints.NewStatusHandler(gw.state)))
+		apiRouter.Handle("/diff", audited(endpoints.NewDiffHandler(gw.state)))
+		apiRouter.Handle("/audit", audited(endpoints.NewAuditHandler(gw.state)))
 
 		// Remote API:
-		apiRouter.Handle("/remotes/list", needsAuth(endpoints.NewRemotesListHandler(gw.state)))
-		apiRouter.Handle("/remotes/add", needsAuth(endpoints.NewRemotesAddHandler(gw.state)))
-		apiRouter.Handle("/remotes/modify", needsAuth(endpoints.NewRemotesModifyHandler(gw.state)))
-		apiRouter.Handle("/remotes/remove", needsAuth(endpoints.NewRemotesRemoveHandler(gw.state)))
-		apiRouter.Handle("/remotes/self", needsAuth(endpoints.NewRemotesSelfHandler(gw.state)))
-		apiRouter.Handle("/remotes/sync", needsAuth(endpoints.NewRemotesSyncHandler(gw.state)))
-		apiRouter.Handle("/remotes/diff", needsAuth(endpoints.NewRemotesDiffHandler(gw.state)))
+		apiRouter.Handle("/remotes/list", audited(endpoints.NewRemotesListHandler(gw.state)))
+		apiRouter.Handle("/remotes/add", audited(endpoints.NewRemotesAddHandler(gw.state)))
+		apiRouter.Handle("/remotes/modify", audited(endpoints.NewRemotesModifyHandler(gw.state)))
+		apiRouter.Handle("/remotes/remove", audited(endpoints.NewRemotesRemoveHandler(gw.state)))
+		apiRouter.Handle("/remotes/self", audited(endpoints.NewRemotesSelfHandler(gw.state)))
+		apiRouter.Handle("/remotes/sync", audited(endpoints.NewRemotesSyncHandler(gw.state)))
+		apiRouter.Handle("/remotes/diff", audited(endpoints.NewRemotesDiffHandler(gw.state)))
 	}
 
 	// Add the /get endpoint. Since it might contain any path, we have to
 	// Use a path prefix so the right handler is called.
 	// NOTE: /get does its own auth handling currently,
 	// since it needs to be available if somebody is not using the UI.
-	router.PathPrefix("/get").Handler(endpoints.NewGetHandler(gw.state)).Methods("GET")
+	router.PathPrefix("/get").Handler(auditLog(endpoints.NewGetHandler(gw.state))).Methods("GET")
 
 	if uiEnabled {
+		// /thumb serves small previews of images and text files.
+		router.PathPrefix("/thumb").Handler(needsAuth(endpoints.NewThumbHandler(gw.state))).Methods("GET")
+
 		// /events is a websocket that pushes events to the client.
 		// The client will probably call /ls then.
 		router.PathPrefix("/events").Handler(needsAuth(gw.evHdl)).Methods("GET")
@@ -311,7 +356,16 @@ func (gw *Gateway) UserDatabase() *db.UserDatabase {
 	return gw.state.UserDatabase()
 }
 
+// AuditLog returns the audit log of the gateway.
+func (gw *Gateway) AuditLog() *audit.Log {
+	return gw.state.AuditLog()
+}
+
 // Close the gateway and clean up all open resouces.
 func (gw *Gateway) Close() error {
+	if err := gw.state.AuditLog().Close(); err != nil {
+		log.Warningf("failed to close audit log: %v", err)
+	}
+
 	return gw.state.UserDatabase().Close()
 }
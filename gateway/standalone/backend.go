@@ -62,6 +62,16 @@ func (tb *TmpFsBackend) Add(r io.Reader) (h.Hash, error) {
 	return hash, nil
 }
 
+// HashOf implements FsBackend.HashOf by hashing the data like Add does.
+func (tb *TmpFsBackend) HashOf(r io.Reader) (h.Hash, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.SumWithBackendHash(data), nil
+}
+
 // Pin implements FsBackend.Pin by storing a marker in memory.
 func (tb *TmpFsBackend) Pin(hash h.Hash) error {
 	path := filepath.Join(tb.root, hash.B58String()+"-pin")
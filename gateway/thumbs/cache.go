@@ -0,0 +1,171 @@
+package thumbs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Cache is a size-bounded on-disk cache for generated previews.
+// Every entry is stored as a single file in the cache directory.
+// When the cache grows bigger than its maximum size, the least
+// recently used entries are deleted first.
+type Cache struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+	size    int64
+}
+
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// NewCache opens or creates a preview cache in `dir`.
+// The cache will not grow much larger than `maxSize` bytes.
+func NewCache(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	cache := &Cache{dir: dir, maxSize: maxSize}
+	entries, err := cache.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		cache.size += entry.size
+	}
+
+	return cache, nil
+}
+
+// keyToPath makes sure that the key cannot escape the cache directory.
+func (c *Cache) keyToPath(key string) string {
+	key = strings.Replace(key, string(filepath.Separator), "_", -1)
+	return filepath.Join(c.dir, key)
+}
+
+// Get returns the preview stored under `key`.
+// The second return value is false if there is no such entry.
+func (c *Cache) Get(key string) (*Preview, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entryPath := c.keyToPath(key)
+	data, err := ioutil.ReadFile(entryPath) // #nosec
+	if err != nil {
+		return nil, false
+	}
+
+	// The first line is the mime type, the rest is the actual data.
+	idx := strings.IndexByte(string(data), '\n')
+	if idx < 0 {
+		return nil, false
+	}
+
+	// Mark this entry as recently used:
+	now := time.Now()
+	if err := os.Chtimes(entryPath, now, now); err != nil {
+		log.Debugf("thumbs: failed to touch %s: %v", entryPath, err)
+	}
+
+	return &Preview{
+		MimeType: string(data[:idx]),
+		Data:     data[idx+1:],
+	}, true
+}
+
+// Put stores `preview` under `key`, possibly evicting older entries.
+func (c *Cache) Put(key string, preview *Preview) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data := make([]byte, 0, len(preview.MimeType)+1+len(preview.Data))
+	data = append(data, preview.MimeType...)
+	data = append(data, '\n')
+	data = append(data, preview.Data...)
+
+	entryPath := c.keyToPath(key)
+	if info, err := os.Stat(entryPath); err == nil {
+		c.size -= info.Size()
+	}
+
+	if err := ioutil.WriteFile(entryPath, data, 0600); err != nil {
+		return err
+	}
+
+	c.size += int64(len(data))
+	return c.evict()
+}
+
+// Size returns the number of bytes currently used by the cache.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+func (c *Cache) entries() ([]cacheEntry, error) {
+	infos, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []cacheEntry{}
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		entries = append(entries, cacheEntry{
+			path:    filepath.Join(c.dir, info.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+
+	return entries, nil
+}
+
+// evict removes the oldest entries until we're below the maximum size.
+// It must be called with c.mu held.
+func (c *Cache) evict() error {
+	if c.size <= c.maxSize {
+		return nil
+	}
+
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	for _, entry := range entries {
+		if c.size <= c.maxSize {
+			break
+		}
+
+		if err := os.Remove(entry.path); err != nil {
+			log.Warningf("thumbs: failed to evict %s: %v", entry.path, err)
+			continue
+		}
+
+		c.size -= entry.size
+	}
+
+	return nil
+}
@@ -0,0 +1,283 @@
+// Package thumbs generates small previews of files served by the gateway.
+// Images (JPEG, PNG and GIF) are scaled down to thumbnails, text files are
+// cut down to their first few kilobytes. Generated previews are kept in a
+// size-bounded on-disk cache, keyed by the content hash of the file.
+package thumbs
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	// Register the decoders for image.Decode:
+	_ "image/gif"
+)
+
+const (
+	// DefaultSize is the edge length of a thumbnail if nothing else is given.
+	DefaultSize = 128
+	// MinSize is the smallest edge length that may be requested.
+	MinSize = 16
+	// MaxSize is the biggest edge length that may be requested.
+	MaxSize = 1024
+
+	// TextPreviewSize is the maximum number of bytes of a text preview.
+	TextPreviewSize = 4 * 1024
+
+	// MaxImagePixels is the number of pixels of the biggest image that is
+	// decoded. A small, well compressed file can describe a huge image,
+	// which would need a lot of memory to decode.
+	MaxImagePixels = 50 * 1000 * 1000
+)
+
+var (
+	// ErrNoPreview is returned when no preview can be generated for a file.
+	ErrNoPreview = errors.New("no preview available for this type of file")
+
+	// ErrImageTooBig is returned for images with more than MaxImagePixels.
+	ErrImageTooBig = errors.New("image is too big for a preview")
+)
+
+// Kind describes what kind of preview can be generated for a file.
+type Kind int
+
+const (
+	// KindNone means that no preview can be generated.
+	KindNone Kind = iota
+	// KindImage means that a scaled down image can be generated.
+	KindImage
+	// KindText means that the first few KB of text can be shown.
+	KindText
+)
+
+// KindFromMimeType returns the kind of preview for `mimeType`.
+func KindFromMimeType(mimeType string) Kind {
+	mimeType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return KindNone
+	}
+
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif":
+		return KindImage
+	case "application/json", "application/xml", "application/javascript":
+		return KindText
+	}
+
+	if strings.HasPrefix(mimeType, "text/") {
+		return KindText
+	}
+
+	return KindNone
+}
+
+// KindFromPath guesses the kind of preview by looking at the file extension
+// of `nodePath`. It does not look at the content and is therefore cheap, but
+// may be wrong for badly named files.
+func KindFromPath(nodePath string) Kind {
+	ext := strings.ToLower(path.Ext(nodePath))
+	switch ext {
+	case "":
+		return KindNone
+	case ".md", ".txt", ".log", ".go", ".py", ".c", ".h", ".rs", ".sh", ".yml", ".yaml", ".toml", ".ini", ".csv":
+		// Not all systems know about those in their mime database.
+		return KindText
+	}
+
+	return KindFromMimeType(mime.TypeByExtension(ext))
+}
+
+// Preview is a generated preview of a file.
+type Preview struct {
+	// MimeType is the content type of Data.
+	MimeType string
+	// Data is the encoded thumbnail or the text snippet.
+	Data []byte
+}
+
+// ClampSize makes sure that `size` is in the allowed range.
+// Zero or negative sizes are translated to DefaultSize.
+func ClampSize(size int) int {
+	switch {
+	case size <= 0:
+		return DefaultSize
+	case size < MinSize:
+		return MinSize
+	case size > MaxSize:
+		return MaxSize
+	default:
+		return size
+	}
+}
+
+// Generate reads the file contents from `r` and produces a preview.
+// The kind of preview is determined by sniffing the content.
+// `size` is the maximum edge length of image thumbnails.
+// If no preview can be generated, ErrNoPreview is returned.
+func Generate(r io.Reader, size int) (*Preview, error) {
+	header := make([]byte, 512)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+
+	header = header[:n]
+	full := io.MultiReader(bytes.NewReader(header), r)
+
+	switch KindFromMimeType(http.DetectContentType(header)) {
+	case KindImage:
+		return GenerateImage(full, size)
+	case KindText:
+		return GenerateText(full)
+	default:
+		return nil, ErrNoPreview
+	}
+}
+
+// GenerateImage decodes the image in `r` and scales it down so that
+// it fits into a square of `size` pixels. Images that are already small
+// enough are not scaled up. Opaque images are encoded as JPEG,
+// all others as PNG to retain transparency. Images with more than
+// MaxImagePixels pixels are rejected with ErrImageTooBig.
+func GenerateImage(r io.Reader, size int) (*Preview, error) {
+	// Only read the header first to check the dimensions.
+	// Remember what was read, so the full decode can see it again.
+	header := &bytes.Buffer{}
+	cfg, _, err := image.DecodeConfig(io.TeeReader(r, header))
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > MaxImagePixels {
+		return nil, ErrImageTooBig
+	}
+
+	src, format, err := image.Decode(io.MultiReader(header, r))
+	if err != nil {
+		return nil, err
+	}
+
+	dst := scale(src, ClampSize(size))
+
+	buf := &bytes.Buffer{}
+	if format == "jpeg" || isOpaque(dst) {
+		if err := jpeg.Encode(buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, err
+		}
+
+		return &Preview{MimeType: "image/jpeg", Data: buf.Bytes()}, nil
+	}
+
+	if err := png.Encode(buf, dst); err != nil {
+		return nil, err
+	}
+
+	return &Preview{MimeType: "image/png", Data: buf.Bytes()}, nil
+}
+
+// GenerateText reads at most TextPreviewSize bytes from `r`.
+// The snippet is cut at the last complete UTF-8 character.
+// If the data does not look like UTF-8 text, ErrNoPreview is returned.
+func GenerateText(r io.Reader) (*Preview, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, TextPreviewSize))
+	if err != nil {
+		return nil, err
+	}
+
+	// Do not cut a multi-byte character in half:
+	for cut := 0; cut < utf8.UTFMax && len(data) > 0; cut++ {
+		if utf8.Valid(data) {
+			break
+		}
+
+		data = data[:len(data)-1]
+	}
+
+	if !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+		return nil, ErrNoPreview
+	}
+
+	return &Preview{MimeType: "text/plain; charset=utf-8", Data: data}, nil
+}
+
+func isOpaque(img image.Image) bool {
+	if oimg, ok := img.(interface{ Opaque() bool }); ok {
+		return oimg.Opaque()
+	}
+
+	return false
+}
+
+// scale shrinks `src` to fit in a `size`x`size` box, keeping the aspect ratio.
+// Every destination pixel is the average of the source pixels it covers,
+// which gives reasonable results for the large factors of thumbnails.
+func scale(src image.Image, size int) *image.NRGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	dstW, dstH := srcW, srcH
+	if srcW > size || srcH > size {
+		if srcW >= srcH {
+			dstW, dstH = size, srcH*size/srcW
+		} else {
+			dstW, dstH = srcW*size/srcH, size
+		}
+	}
+
+	if dstW < 1 {
+		dstW = 1
+	}
+
+	if dstH < 1 {
+		dstH = 1
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+	for dy := 0; dy < dstH; dy++ {
+		y0 := bounds.Min.Y + dy*srcH/dstH
+		y1 := bounds.Min.Y + (dy+1)*srcH/dstH
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+
+		for dx := 0; dx < dstW; dx++ {
+			x0 := bounds.Min.X + dx*srcW/dstW
+			x1 := bounds.Min.X + (dx+1)*srcW/dstW
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, cnt uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					pr, pg, pb, pa := src.At(x, y).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					b += uint64(pb)
+					a += uint64(pa)
+					cnt++
+				}
+			}
+
+			// Colors from RGBA() are alpha-premultiplied 16-bit values:
+			dst.Set(dx, dy, color.RGBA64{
+				R: uint16(r / cnt),
+				G: uint16(g / cnt),
+				B: uint16(b / cnt),
+				A: uint16(a / cnt),
+			})
+		}
+	}
+
+	return dst
+}
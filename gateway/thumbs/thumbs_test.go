@@ -0,0 +1,92 @@
+package thumbs
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKindFromPath(t *testing.T) {
+	require.Equal(t, KindImage, KindFromPath("/a/b.JPG"))
+	require.Equal(t, KindImage, KindFromPath("/a/b.png"))
+	require.Equal(t, KindText, KindFromPath("/README.md"))
+	require.Equal(t, KindText, KindFromPath("/x.txt"))
+	require.Equal(t, KindNone, KindFromPath("/x"))
+	require.Equal(t, KindNone, KindFromPath("/x.tar.gz"))
+}
+
+func TestGenerateImage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 100; x++ {
+			img.Set(x, y, color.NRGBA{R: 0xFF, A: uint8(y % 256)})
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	require.Nil(t, png.Encode(buf, img))
+
+	preview, err := Generate(buf, 30)
+	require.Nil(t, err)
+
+	// Has transparency, so it should stay a png:
+	require.Equal(t, "image/png", preview.MimeType)
+
+	thumb, err := png.Decode(bytes.NewReader(preview.Data))
+	require.Nil(t, err)
+	require.Equal(t, 10, thumb.Bounds().Dx())
+	require.Equal(t, 30, thumb.Bounds().Dy())
+}
+
+func TestGenerateImageTooBig(t *testing.T) {
+	// A GIF header that claims to be 65535x65535 pixels big:
+	data := []byte("GIF89a\xff\xff\xff\xff\x00\x00\x00")
+	_, err := Generate(bytes.NewReader(data), 30)
+	require.Equal(t, ErrImageTooBig, err)
+}
+
+func TestGenerateText(t *testing.T) {
+	// A multi-byte character right at the cut-off point:
+	text := strings.Repeat("a", TextPreviewSize-1) + "ä"
+	preview, err := Generate(strings.NewReader(text), DefaultSize)
+	require.Nil(t, err)
+	require.Equal(t, strings.Repeat("a", TextPreviewSize-1), string(preview.Data))
+
+	_, err = Generate(bytes.NewReader([]byte{0, 1, 2, 0xFF}), DefaultSize)
+	require.Equal(t, ErrNoPreview, err)
+}
+
+func TestCacheEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brig-thumbs-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cache, err := NewCache(dir, 1024)
+	require.Nil(t, err)
+
+	data := bytes.Repeat([]byte{'x'}, 300)
+	for idx := 0; idx < 10; idx++ {
+		key := fmt.Sprintf("key-%d", idx)
+		require.Nil(t, cache.Put(key, &Preview{MimeType: "text/plain", Data: data}))
+		require.True(t, cache.Size() <= 1024)
+	}
+
+	// The latest entry should always survive:
+	preview, ok := cache.Get("key-9")
+	require.True(t, ok)
+	require.Equal(t, "text/plain", preview.MimeType)
+	require.Equal(t, data, preview.Data)
+
+	// Re-opening should restore the size:
+	reopened, err := NewCache(dir, 1024)
+	require.Nil(t, err)
+	require.Equal(t, cache.Size(), reopened.Size())
+}
@@ -8,12 +8,14 @@ require (
 	github.com/NYTimes/gziphandler v1.1.0
 	github.com/VividCortex/ewma v1.1.1 // indirect
 	github.com/alokmenghrajani/gpgeez v0.0.0-20161206084504-1a06f1c582f9
+	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/bkaradzic/go-lz4 v1.0.0
 	github.com/blang/semver v3.5.1+incompatible
 	github.com/blang/vfs v1.0.0 // indirect
 	github.com/chzyer/logex v1.1.10 // indirect
 	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e
 	github.com/chzyer/test v0.0.0-20180213035817-a1ea475d72b1 // indirect
+	github.com/coreos/bbolt v1.3.3
 	github.com/daaku/go.zipexe v0.0.0-20150329023125-a5fe2436ffcb // indirect
 	github.com/dgraph-io/badger v1.5.4
 	github.com/dgryski/go-farm v0.0.0-20190104051053-3adb47b1fb0f // indirect
@@ -41,10 +43,14 @@ require (
 	github.com/nbutton23/zxcvbn-go v0.0.0-20180912185939-ae427f1e4c1d
 	github.com/onsi/ginkgo v1.8.0 // indirect
 	github.com/onsi/gomega v1.4.3 // indirect
+	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/philhofer/fwd v1.0.0 // indirect
 	github.com/phogolabs/parcello v0.8.1
 	github.com/pkg/errors v0.8.1
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/posener/wstest v0.0.0-20180217133618-28272a7ea048
+	github.com/prometheus/client_golang v0.9.2
+	github.com/prometheus/client_model v0.2.0
 	github.com/sahib/config v0.2.0
 	github.com/sahib/go-ipfs-api v1.4.1
 	github.com/sdemontfort/go-mimemagic v0.0.0-20150708072242-d026a5785116
@@ -60,11 +66,10 @@ require (
 	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
 	golang.org/x/net v0.0.0-20190301231341-16b79f2e4e95
 	golang.org/x/sync v0.0.0-20190227155943-e225da77a7e6 // indirect
-	golang.org/x/sys v0.0.0-20190309122539-980fc434d28e // indirect
+	golang.org/x/sys v0.0.0-20190309122539-980fc434d28e
 	golang.org/x/text v0.3.1-0.20180807135948-17ff2d5776d2
 	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 	gopkg.in/yaml.v2 v2.2.2
 	zombiezen.com/go/capnproto2 v2.17.0+incompatible
-	github.com/patrickmn/go-cache v2.1.0+incompatible
 )
@@ -0,0 +1,436 @@
+// Package mirror keeps a directory on the local disk and a directory
+// in the repository in sync, in both directions, without FUSE.
+//
+// Local changes are picked up via inotify (or a periodic rescan where
+// inotify is not available) and staged after a short debounce time.
+// Changes in the repository (e.g. after a sync) are written back to disk.
+// Both sides are compared to the state of the last reconciliation, so it
+// is possible to tell which side changed. If both changed, the local
+// version wins and the repository's version is kept as a conflict file,
+// like a sync does it.
+package mirror
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sahib/brig/catfs"
+	ie "github.com/sahib/brig/catfs/errors"
+	h "github.com/sahib/brig/util/hashlib"
+	"github.com/sahib/brig/util/ignore"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Files written back to disk are first written to a temporary file
+	// with this prefix. Those are never staged.
+	tmpPrefix = ".brig-mirror-"
+)
+
+// Notifier is told about local changes that were staged.
+type Notifier interface {
+	PublishEvent()
+}
+
+// Options change the behaviour of a mirror.
+type Options struct {
+	// Debounce is how long to wait for more local changes before staging.
+	Debounce time.Duration
+	// RescanInterval is how often both sides are compared, even
+	// if no change was reported.
+	RescanInterval time.Duration
+	// Ignore is a list of patterns (see the ignore package) for paths
+	// that should not be mirrored, in addition to the ignore files
+	// in the mirrored directory.
+	Ignore []string
+}
+
+// entry is the state of a path after the last reconciliation.
+type entry struct {
+	IsDir   bool      `json:"is_dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Hash    string    `json:"hash"`
+}
+
+// Mirror mirrors `LocalDir` to `RepoPath` and vice versa.
+type Mirror struct {
+	// mu is held during a reconciliation.
+	mu sync.Mutex
+
+	fs        *catfs.FS
+	notifier  Notifier
+	opts      Options
+	localDir  string
+	repoPath  string
+	statePath string
+	state     map[string]entry
+
+	// ignore has the rules from the options,
+	// currIgnore also the ones from the ignore files.
+	ignore     *ignore.Matcher
+	currIgnore *ignore.Matcher
+
+	watcher   *watcher
+	triggerCh chan struct{}
+	quitCh    chan struct{}
+	doneCh    chan struct{}
+}
+
+// New creates a new mirror between `localDir` and `repoPath`.
+// `statePath` is where the state of the last reconciliation is kept.
+// Both sides are reconciled once before New returns.
+func New(fs *catfs.FS, notifier Notifier, localDir, repoPath, statePath string, opts Options) (*Mirror, error) {
+	localDir, err := filepath.Abs(localDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(localDir, 0700); err != nil {
+		return nil, err
+	}
+
+	m := &Mirror{
+		fs:        fs,
+		notifier:  notifier,
+		opts:      opts,
+		localDir:  localDir,
+		repoPath:  prefixSlash(repoPath),
+		statePath: statePath,
+		state:     make(map[string]entry),
+		ignore:    ignore.New(opts.Ignore),
+		triggerCh: make(chan struct{}, 1),
+		quitCh:    make(chan struct{}),
+		doneCh:    make(chan struct{}),
+	}
+
+	if err := m.loadState(); err != nil {
+		return nil, err
+	}
+
+	m.watcher, err = newWatcher(func(path string) {
+		if !strings.HasPrefix(filepath.Base(path), tmpPrefix) {
+			m.trigger()
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.Sync(); err != nil {
+		m.watcher.close()
+		return nil, err
+	}
+
+	go m.loop()
+	return m, nil
+}
+
+// LocalDir returns the mirrored local directory.
+func (m *Mirror) LocalDir() string {
+	return m.localDir
+}
+
+// RepoPath returns the mirrored directory in the repository.
+func (m *Mirror) RepoPath() string {
+	return m.repoPath
+}
+
+func (m *Mirror) trigger() {
+	select {
+	case m.triggerCh <- struct{}{}:
+	default:
+		// Already triggered.
+	}
+}
+
+// Pull tells the mirror that the repository might have changed.
+// The changes are written to disk shortly after.
+func (m *Mirror) Pull() {
+	m.trigger()
+}
+
+func (m *Mirror) loop() {
+	defer close(m.doneCh)
+
+	rescanTicker := time.NewTicker(m.opts.RescanInterval)
+	defer rescanTicker.Stop()
+
+	var debounceCh <-chan time.Time
+	for {
+		select {
+		case <-m.quitCh:
+			return
+		case <-m.triggerCh:
+			// Wait a bit longer with every new change:
+			debounceCh = time.After(m.opts.Debounce)
+			continue
+		case <-debounceCh:
+			debounceCh = nil
+		case <-rescanTicker.C:
+		}
+
+		if err := m.Sync(); err != nil {
+			log.Warningf("mirror: failed to sync %s: %v", m.localDir, err)
+		}
+	}
+}
+
+// Close stops the mirror. Nothing is reconciled anymore.
+func (m *Mirror) Close() error {
+	close(m.quitCh)
+	<-m.doneCh
+	return m.watcher.close()
+}
+
+func (m *Mirror) loadState() error {
+	data, err := ioutil.ReadFile(m.statePath) // #nosec
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &m.state)
+}
+
+func (m *Mirror) saveState() error {
+	data, err := json.Marshal(m.state)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.statePath), 0700); err != nil {
+		return err
+	}
+
+	tmpPath := m.statePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, m.statePath)
+}
+
+func prefixSlash(s string) string {
+	if !strings.HasPrefix(s, "/") {
+		return "/" + s
+	}
+
+	return s
+}
+
+// SetIgnore replaces the ignore patterns from the options.
+func (m *Mirror) SetIgnore(patterns []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.opts.Ignore = patterns
+	m.ignore = ignore.New(patterns)
+}
+
+func (m *Mirror) isIgnored(relPath string, isDir bool) bool {
+	if strings.HasPrefix(path.Base(relPath), tmpPrefix) {
+		return true
+	}
+
+	return m.currIgnore.Match(relPath, isDir)
+}
+
+func (m *Mirror) localPath(relPath string) string {
+	return filepath.Join(m.localDir, filepath.FromSlash(relPath))
+}
+
+func (m *Mirror) repoPathOf(relPath string) string {
+	return path.Join(m.repoPath, relPath)
+}
+
+// scanLocal returns all local files and directories by relative path
+// and makes sure that every directory is watched. It also reads the
+// ignore files, so isIgnored() works for both sides afterwards.
+func (m *Mirror) scanLocal() (map[string]os.FileInfo, error) {
+	infos := make(map[string]os.FileInfo)
+	m.currIgnore = m.ignore.Clone()
+	err := filepath.Walk(m.localDir, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Removed while walking.
+				return nil
+			}
+
+			return err
+		}
+
+		if fullPath == m.localDir {
+			if err := m.currIgnore.AddFile("", filepath.Join(fullPath, ignore.FileName)); err != nil {
+				return err
+			}
+
+			return m.watcher.add(fullPath)
+		}
+
+		rel, err := filepath.Rel(m.localDir, fullPath)
+		if err != nil {
+			return err
+		}
+
+		relPath := "/" + filepath.ToSlash(rel)
+		if m.isIgnored(relPath, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		switch {
+		case info.IsDir():
+			if err := m.currIgnore.AddFile(relPath, filepath.Join(fullPath, ignore.FileName)); err != nil {
+				log.Warningf("mirror: cannot read ignore file in %s: %v", fullPath, err)
+			}
+
+			if err := m.watcher.add(fullPath); err != nil {
+				log.Warningf("mirror: cannot watch %s: %v", fullPath, err)
+			}
+		case !info.Mode().IsRegular():
+			// Symlinks, devices and so on are not mirrored.
+			return nil
+		}
+
+		infos[relPath] = info
+		return nil
+	})
+
+	return infos, err
+}
+
+// scanRepo returns all files and directories below the repo path.
+func (m *Mirror) scanRepo() (map[string]*catfs.StatInfo, error) {
+	infos := make(map[string]*catfs.StatInfo)
+	list, err := m.fs.List(m.repoPath, -1)
+	if ie.IsNoSuchFileError(err) {
+		if err := m.fs.Mkdir(m.repoPath, true); err != nil {
+			return nil, err
+		}
+
+		return infos, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, info := range list {
+		if info.Path == m.repoPath {
+			continue
+		}
+
+		relPath := prefixSlash(strings.TrimPrefix(info.Path, m.repoPath))
+		if m.repoPath == "/" {
+			relPath = info.Path
+		}
+
+		if m.isIgnored(relPath, info.IsDir) {
+			continue
+		}
+
+		infos[relPath] = info
+	}
+
+	return infos, nil
+}
+
+func hashLocalFile(fullPath string) (h.Hash, error) {
+	fd, err := os.Open(fullPath) // #nosec
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	hashWriter := h.NewHashWriter()
+	if _, err := io.Copy(hashWriter, fd); err != nil {
+		return nil, err
+	}
+
+	return hashWriter.Finalize(), nil
+}
+
+func localEntry(info os.FileInfo, hash h.Hash) entry {
+	return entry{
+		IsDir:   info.IsDir(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Hash:    hash.B58String(),
+	}
+}
+
+// Sync compares both sides and applies the changes from each side to
+// the other. It is called automatically, but can be used to force it.
+func (m *Mirror) Sync() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	local, err := m.scanLocal()
+	if err != nil {
+		return err
+	}
+
+	remote, err := m.scanRepo()
+	if err != nil {
+		return err
+	}
+
+	paths := make(map[string]bool)
+	for relPath := range local {
+		paths[relPath] = true
+	}
+
+	for relPath := range remote {
+		paths[relPath] = true
+	}
+
+	for relPath := range m.state {
+		paths[relPath] = true
+	}
+
+	// Parents first, so directories exist before their children.
+	// Removals are done in reverse order further below.
+	sorted := []string{}
+	for relPath := range paths {
+		sorted = append(sorted, relPath)
+	}
+
+	sort.Strings(sorted)
+
+	r := &reconciler{m: m}
+	for _, relPath := range sorted {
+		if err := r.reconcile(relPath, local[relPath], remote[relPath]); err != nil {
+			return fmt.Errorf("%s: %v", relPath, err)
+		}
+	}
+
+	for idx := len(r.removedDirs) - 1; idx >= 0; idx-- {
+		if err := r.removeDir(r.removedDirs[idx]); err != nil {
+			return err
+		}
+	}
+
+	if r.staged && m.notifier != nil {
+		m.notifier.PublishEvent()
+	}
+
+	return m.saveState()
+}
@@ -0,0 +1,241 @@
+package mirror
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sahib/brig/catfs"
+	ie "github.com/sahib/brig/catfs/errors"
+	"github.com/sahib/brig/defaults"
+	"github.com/sahib/config"
+	"github.com/stretchr/testify/require"
+)
+
+var testOpts = Options{
+	// Only reconcile when the test says so:
+	Debounce:       time.Hour,
+	RescanInterval: time.Hour,
+	Ignore:         []string{"*~"},
+}
+
+func withDummyFS(t *testing.T, fn func(fs *catfs.FS, tmpDir string)) {
+	tmpDir, err := ioutil.TempDir("", "brig-mirror-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	cfg, err := config.Open(nil, defaults.Defaults, config.StrictnessPanic)
+	require.Nil(t, err)
+
+	dbPath := filepath.Join(tmpDir, "db")
+	require.Nil(t, os.MkdirAll(dbPath, 0700))
+
+	fs, err := catfs.NewFilesystem(catfs.NewMemFsBackend(), dbPath, "alice", false, cfg.Section("fs"))
+	require.Nil(t, err)
+
+	fn(fs, tmpDir)
+	require.Nil(t, fs.Close())
+}
+
+func withMirror(t *testing.T, fn func(m *Mirror, fs *catfs.FS, localDir string)) {
+	withDummyFS(t, func(fs *catfs.FS, tmpDir string) {
+		localDir := filepath.Join(tmpDir, "local")
+		statePath := filepath.Join(tmpDir, "state.json")
+
+		m, err := New(fs, nil, localDir, "/mirror", statePath, testOpts)
+		require.Nil(t, err)
+
+		fn(m, fs, localDir)
+		require.Nil(t, m.Close())
+	})
+}
+
+func readRepoFile(t *testing.T, fs *catfs.FS, path string) string {
+	stream, err := fs.Cat(path)
+	require.Nil(t, err)
+	defer stream.Close()
+
+	data, err := ioutil.ReadAll(stream)
+	require.Nil(t, err)
+	return string(data)
+}
+
+func readLocalFile(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	return string(data)
+}
+
+func writeLocalFile(t *testing.T, path, data string) {
+	require.Nil(t, os.MkdirAll(filepath.Dir(path), 0700))
+	require.Nil(t, ioutil.WriteFile(path, []byte(data), 0600))
+
+	// Make sure the mtime differs, even on coarse filesystems:
+	future := time.Now().Add(time.Duration(len(data)+1) * time.Second)
+	require.Nil(t, os.Chtimes(path, future, future))
+}
+
+func TestMirrorBothDirections(t *testing.T) {
+	t.Parallel()
+
+	withMirror(t, func(m *Mirror, fs *catfs.FS, localDir string) {
+		// local -> repo:
+		writeLocalFile(t, filepath.Join(localDir, "sub", "x"), "hello")
+		writeLocalFile(t, filepath.Join(localDir, "ignored~"), "backup")
+		writeLocalFile(t, filepath.Join(localDir, "sub", ".brigignore"), "build/")
+		writeLocalFile(t, filepath.Join(localDir, "sub", "build", "out"), "binary")
+		require.Nil(t, m.Sync())
+		require.Equal(t, "hello", readRepoFile(t, fs, "/mirror/sub/x"))
+		require.Equal(t, "build/", readRepoFile(t, fs, "/mirror/sub/.brigignore"))
+
+		_, err := fs.Stat("/mirror/ignored~")
+		require.True(t, ie.IsNoSuchFileError(err))
+
+		_, err = fs.Stat("/mirror/sub/build")
+		require.True(t, ie.IsNoSuchFileError(err))
+
+		// repo -> local:
+		require.Nil(t, fs.Stage("/mirror/y", bytes.NewReader([]byte("world"))))
+		require.Nil(t, m.Sync())
+		require.Equal(t, "world", readLocalFile(t, filepath.Join(localDir, "y")))
+
+		// Modify on each side:
+		require.Nil(t, fs.Stage("/mirror/sub/x", bytes.NewReader([]byte("hello repo"))))
+		writeLocalFile(t, filepath.Join(localDir, "y"), "world, local")
+		require.Nil(t, m.Sync())
+		require.Equal(t, "hello repo", readLocalFile(t, filepath.Join(localDir, "sub", "x")))
+		require.Equal(t, "world, local", readRepoFile(t, fs, "/mirror/y"))
+
+		// Remove on each side:
+		require.Nil(t, os.Remove(filepath.Join(localDir, "y")))
+		require.Nil(t, fs.Remove("/mirror/sub"))
+		require.Nil(t, m.Sync())
+
+		_, err = fs.Stat("/mirror/y")
+		require.True(t, ie.IsNoSuchFileError(err))
+
+		_, err = os.Stat(filepath.Join(localDir, "sub", "x"))
+		require.True(t, os.IsNotExist(err))
+
+		// The ignored files are still there, so the directory is kept:
+		children, err := ioutil.ReadDir(filepath.Join(localDir, "sub"))
+		require.Nil(t, err)
+		require.Len(t, children, 1)
+		require.Equal(t, "build", children[0].Name())
+	})
+}
+
+func TestMirrorConflict(t *testing.T) {
+	t.Parallel()
+
+	withMirror(t, func(m *Mirror, fs *catfs.FS, localDir string) {
+		localPath := filepath.Join(localDir, "x")
+		writeLocalFile(t, localPath, "base")
+		require.Nil(t, m.Sync())
+
+		writeLocalFile(t, localPath, "local version")
+		require.Nil(t, fs.Stage("/mirror/x", bytes.NewReader([]byte("repo version"))))
+		require.Nil(t, m.Sync())
+
+		// The local version wins, the other one is kept:
+		require.Equal(t, "local version", readRepoFile(t, fs, "/mirror/x"))
+		require.Equal(t, "local version", readLocalFile(t, localPath))
+		require.Equal(t, "repo version", readRepoFile(t, fs, "/mirror/x.conflict.0"))
+		require.Equal(t, "repo version", readLocalFile(t, localPath+".conflict.0"))
+
+		// Nothing changes when syncing again:
+		require.Nil(t, m.Sync())
+		_, err := fs.Stat("/mirror/x.conflict.1")
+		require.True(t, ie.IsNoSuchFileError(err))
+	})
+}
+
+func TestMirrorState(t *testing.T) {
+	t.Parallel()
+
+	withDummyFS(t, func(fs *catfs.FS, tmpDir string) {
+		localDir := filepath.Join(tmpDir, "local")
+		statePath := filepath.Join(tmpDir, "state.json")
+
+		m, err := New(fs, nil, localDir, "/", statePath, testOpts)
+		require.Nil(t, err)
+		writeLocalFile(t, filepath.Join(localDir, "x"), "x")
+		require.Nil(t, m.Sync())
+		require.Nil(t, m.Close())
+
+		// Removed while the mirror was not running;
+		// this should be applied to the repo on restart.
+		require.Nil(t, os.Remove(filepath.Join(localDir, "x")))
+
+		m, err = New(fs, nil, localDir, "/", statePath, testOpts)
+		require.Nil(t, err)
+		require.Nil(t, m.Close())
+
+		_, err = fs.Stat("/x")
+		require.True(t, ie.IsNoSuchFileError(err))
+	})
+}
+
+func TestTable(t *testing.T) {
+	t.Parallel()
+
+	withDummyFS(t, func(fs *catfs.FS, tmpDir string) {
+		cfg, err := config.Open(nil, defaults.Defaults, config.StrictnessPanic)
+		require.Nil(t, err)
+
+		mirrorsCfg := cfg.Section("mirrors")
+		table := NewTable(fs, nil, filepath.Join(tmpDir, "state"), testOpts)
+
+		localDir := filepath.Join(tmpDir, "docs")
+		require.Nil(t, TabAdd(mirrorsCfg, "docs", localDir, "/docs"))
+		require.NotNil(t, TabAdd(mirrorsCfg, "docs", localDir, "/other"))
+		require.NotNil(t, TabAdd(mirrorsCfg, "other", localDir, "/other"))
+		require.Equal(t, []Entry{
+			{Name: "docs", LocalDir: localDir, RepoPath: "/docs"},
+		}, TabList(mirrorsCfg, table))
+
+		require.Nil(t, TabApply(mirrorsCfg, table))
+		require.True(t, TabList(mirrorsCfg, table)[0].Active)
+
+		writeLocalFile(t, filepath.Join(localDir, "x"), "x")
+		require.Nil(t, table.Sync("docs"))
+		require.Equal(t, "x", readRepoFile(t, fs, "/docs/x"))
+
+		require.Nil(t, TabRemove(mirrorsCfg, "docs"))
+		require.Nil(t, TabApply(mirrorsCfg, table))
+		require.Empty(t, TabList(mirrorsCfg, table))
+		require.NotNil(t, table.Sync("docs"))
+		require.Nil(t, table.Close())
+	})
+}
+
+func TestMirrorPull(t *testing.T) {
+	t.Parallel()
+
+	withDummyFS(t, func(fs *catfs.FS, tmpDir string) {
+		opts := testOpts
+		opts.Debounce = 10 * time.Millisecond
+
+		localDir := filepath.Join(tmpDir, "local")
+		m, err := New(fs, nil, localDir, "/", filepath.Join(tmpDir, "state.json"), opts)
+		require.Nil(t, err)
+		defer m.Close()
+
+		require.Nil(t, fs.Stage("/x", bytes.NewReader([]byte("x"))))
+		m.Pull()
+
+		localPath := filepath.Join(localDir, "x")
+		for tries := 0; tries < 100; tries++ {
+			if _, err := os.Stat(localPath); err == nil {
+				break
+			}
+
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		require.Equal(t, "x", readLocalFile(t, localPath))
+	})
+}
@@ -0,0 +1,336 @@
+package mirror
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/sahib/brig/catfs"
+	ie "github.com/sahib/brig/catfs/errors"
+	h "github.com/sahib/brig/util/hashlib"
+	log "github.com/sirupsen/logrus"
+)
+
+// removedDir is a directory that was removed on one side.
+// It is only removed on the other side if it is empty by then.
+type removedDir struct {
+	relPath        string
+	removedLocally bool
+}
+
+// reconciler holds the state of a single Mirror.Sync() run.
+type reconciler struct {
+	m           *Mirror
+	staged      bool
+	removedDirs []removedDir
+}
+
+func (r *reconciler) reconcile(relPath string, local os.FileInfo, remote *catfs.StatInfo) error {
+	state, inState := r.m.state[relPath]
+
+	localIsDir := local != nil && local.IsDir()
+	remoteIsDir := remote != nil && remote.IsDir
+	switch {
+	case local != nil && remote != nil && localIsDir != remoteIsDir:
+		log.Warningf("mirror: %s is a file on one side and a directory on the other; skipping", relPath)
+		return nil
+	case localIsDir || remoteIsDir || (local == nil && remote == nil && state.IsDir):
+		return r.reconcileDir(relPath, local, remote, inState)
+	}
+
+	switch {
+	case local == nil && remote == nil:
+		delete(r.m.state, relPath)
+		return nil
+	case remote == nil:
+		if !inState {
+			return r.stage(relPath)
+		}
+
+		localChanged, _, err := r.localChanged(relPath, local, state)
+		if err != nil {
+			return err
+		}
+
+		if localChanged {
+			// Removed in the repo, but changed here; keep it.
+			return r.stage(relPath)
+		}
+
+		return r.removeLocal(relPath)
+	case local == nil:
+		if inState && remote.ContentHash.B58String() == state.Hash {
+			return r.removeRemote(relPath)
+		}
+
+		// New in the repo or changed there after we removed it here.
+		return r.writeBack(relPath, remote)
+	}
+
+	localChanged, localHash, err := r.localChanged(relPath, local, state)
+	if err != nil {
+		return err
+	}
+
+	if !inState {
+		localChanged = true
+	}
+
+	remoteChanged := !inState || remote.ContentHash.B58String() != state.Hash
+	switch {
+	case localChanged && remoteChanged:
+		if localHash == nil {
+			if localHash, err = hashLocalFile(r.m.localPath(relPath)); err != nil {
+				return err
+			}
+		}
+
+		if localHash.Equal(remote.ContentHash) {
+			r.m.state[relPath] = localEntry(local, localHash)
+			return nil
+		}
+
+		return r.conflict(relPath)
+	case localChanged:
+		return r.stage(relPath)
+	case remoteChanged:
+		return r.writeBack(relPath, remote)
+	}
+
+	return nil
+}
+
+// localChanged checks if the local file differs from `state`.
+// The hash is only computed (and returned) if size or mtime changed.
+func (r *reconciler) localChanged(relPath string, local os.FileInfo, state entry) (bool, h.Hash, error) {
+	if local.Size() == state.Size && local.ModTime().Equal(state.ModTime) {
+		return false, nil, nil
+	}
+
+	hash, err := hashLocalFile(r.m.localPath(relPath))
+	if err != nil {
+		return false, nil, err
+	}
+
+	if hash.B58String() == state.Hash {
+		// Only touched; remember the new mtime.
+		r.m.state[relPath] = localEntry(local, hash)
+		return false, hash, nil
+	}
+
+	return true, hash, nil
+}
+
+func (r *reconciler) reconcileDir(relPath string, local os.FileInfo, remote *catfs.StatInfo, inState bool) error {
+	dirEntry := entry{IsDir: true}
+	switch {
+	case local != nil && remote != nil:
+		r.m.state[relPath] = dirEntry
+	case local == nil && remote == nil:
+		delete(r.m.state, relPath)
+	case remote == nil && inState:
+		r.removedDirs = append(r.removedDirs, removedDir{relPath: relPath})
+	case remote == nil:
+		if err := r.m.fs.Mkdir(r.m.repoPathOf(relPath), true); err != nil {
+			return err
+		}
+
+		r.staged = true
+		r.m.state[relPath] = dirEntry
+	case local == nil && inState:
+		r.removedDirs = append(r.removedDirs, removedDir{relPath: relPath, removedLocally: true})
+	default:
+		if err := os.MkdirAll(r.m.localPath(relPath), 0700); err != nil {
+			return err
+		}
+
+		r.m.state[relPath] = dirEntry
+	}
+
+	return nil
+}
+
+// removeDir removes a directory that was removed on the other side.
+// If it still has content (e.g. new files), it is kept on both sides.
+func (r *reconciler) removeDir(dir removedDir) error {
+	fullPath := r.m.localPath(dir.relPath)
+	repoPath := r.m.repoPathOf(dir.relPath)
+
+	if dir.removedLocally {
+		children, err := r.m.fs.List(repoPath, 1)
+		if err != nil && !ie.IsNoSuchFileError(err) {
+			return err
+		}
+
+		if len(children) > 0 {
+			return os.MkdirAll(fullPath, 0700)
+		}
+
+		if err := r.m.fs.Remove(repoPath); err != nil && !ie.IsNoSuchFileError(err) {
+			return err
+		}
+
+		r.staged = true
+		delete(r.m.state, dir.relPath)
+		return nil
+	}
+
+	children, err := ioutil.ReadDir(fullPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if len(children) > 0 {
+		r.staged = true
+		return r.m.fs.Mkdir(repoPath, true)
+	}
+
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	delete(r.m.state, dir.relPath)
+	return nil
+}
+
+// stage stages the local file at `relPath` into the repo.
+func (r *reconciler) stage(relPath string) error {
+	fullPath := r.m.localPath(relPath)
+	fd, err := os.Open(fullPath) // #nosec
+	if err != nil {
+		return err
+	}
+
+	defer fd.Close()
+
+	// Stat before reading; if it changes while staging,
+	// the next run will see a different mtime.
+	info, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+
+	repoPath := r.m.repoPathOf(relPath)
+	log.Debugf("mirror: staging %s to %s", fullPath, repoPath)
+	if err := r.m.fs.Stage(repoPath, fd); err != nil {
+		return err
+	}
+
+	repoInfo, err := r.m.fs.Stat(repoPath)
+	if err != nil {
+		return err
+	}
+
+	r.staged = true
+	r.m.state[relPath] = localEntry(info, repoInfo.ContentHash)
+	return nil
+}
+
+// writeBack writes the repo's version of `relPath` to disk.
+func (r *reconciler) writeBack(relPath string, remote *catfs.StatInfo) error {
+	fullPath := r.m.localPath(relPath)
+	log.Debugf("mirror: writing %s to %s", remote.Path, fullPath)
+
+	stream, err := r.m.fs.Cat(remote.Path)
+	if err != nil {
+		return err
+	}
+
+	defer stream.Close()
+
+	dir := filepath.Dir(fullPath)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	tmpFd, err := ioutil.TempFile(dir, tmpPrefix)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(tmpFd, stream); err != nil {
+		tmpFd.Close()
+		os.Remove(tmpFd.Name())
+		return err
+	}
+
+	if err := tmpFd.Close(); err != nil {
+		os.Remove(tmpFd.Name())
+		return err
+	}
+
+	if err := os.Rename(tmpFd.Name(), fullPath); err != nil {
+		os.Remove(tmpFd.Name())
+		return err
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return err
+	}
+
+	r.m.state[relPath] = localEntry(info, remote.ContentHash)
+	return nil
+}
+
+func (r *reconciler) removeLocal(relPath string) error {
+	log.Debugf("mirror: removing %s", r.m.localPath(relPath))
+	if err := os.Remove(r.m.localPath(relPath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	delete(r.m.state, relPath)
+	return nil
+}
+
+func (r *reconciler) removeRemote(relPath string) error {
+	log.Debugf("mirror: removing %s", r.m.repoPathOf(relPath))
+	if err := r.m.fs.Remove(r.m.repoPathOf(relPath)); err != nil && !ie.IsNoSuchFileError(err) {
+		return err
+	}
+
+	r.staged = true
+	delete(r.m.state, relPath)
+	return nil
+}
+
+// conflict keeps the repo's version of `relPath` as conflict file
+// (named like the ones a sync creates) and stages the local version.
+func (r *reconciler) conflict(relPath string) error {
+	conflictRelPath := ""
+	for tries := 0; ; tries++ {
+		if tries >= 100 {
+			return fmt.Errorf("no free conflict file name")
+		}
+
+		conflictRelPath = fmt.Sprintf("%s.conflict.%d", relPath, tries)
+		_, err := r.m.fs.Stat(r.m.repoPathOf(conflictRelPath))
+		if !ie.IsNoSuchFileError(err) {
+			continue
+		}
+
+		if _, err := os.Stat(r.m.localPath(conflictRelPath)); os.IsNotExist(err) {
+			break
+		}
+	}
+
+	log.Infof("mirror: conflict on %s; keeping the other version as %s", relPath, path.Base(conflictRelPath))
+	conflictRepoPath := r.m.repoPathOf(conflictRelPath)
+	if err := r.m.fs.Copy(r.m.repoPathOf(relPath), conflictRepoPath); err != nil {
+		return err
+	}
+
+	conflictInfo, err := r.m.fs.Stat(conflictRepoPath)
+	if err != nil {
+		return err
+	}
+
+	if err := r.writeBack(conflictRelPath, conflictInfo); err != nil {
+		return err
+	}
+
+	return r.stage(relPath)
+}
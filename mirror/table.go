@@ -0,0 +1,250 @@
+package mirror
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sahib/brig/catfs"
+	"github.com/sahib/brig/util"
+	"github.com/sahib/config"
+)
+
+// Table manages all mirrors of a repository.
+type Table struct {
+	mu       sync.Mutex
+	fs       *catfs.FS
+	notifier Notifier
+	stateDir string
+	opts     Options
+	m        map[string]*Mirror
+}
+
+// NewTable returns a new, empty table. The state of each mirror
+// is kept in a file named after the mirror in `stateDir`.
+func NewTable(fs *catfs.FS, notifier Notifier, stateDir string, opts Options) *Table {
+	return &Table{
+		fs:       fs,
+		notifier: notifier,
+		stateDir: stateDir,
+		opts:     opts,
+		m:        make(map[string]*Mirror),
+	}
+}
+
+func (t *Table) statePath(name string) string {
+	return filepath.Join(t.stateDir, name+".json")
+}
+
+func (t *Table) add(name, localDir, repoPath string) error {
+	if m, ok := t.m[name]; ok {
+		if m.LocalDir() == localDir && m.RepoPath() == repoPath {
+			return nil
+		}
+
+		if err := t.remove(name, true); err != nil {
+			return err
+		}
+	}
+
+	m, err := New(t.fs, t.notifier, localDir, repoPath, t.statePath(name), t.opts)
+	if err != nil {
+		return err
+	}
+
+	t.m[name] = m
+	return nil
+}
+
+// remove stops the mirror `name`. If `forget` is true, its state is
+// deleted, so a new mirror with this name starts from scratch.
+func (t *Table) remove(name string, forget bool) error {
+	m, ok := t.m[name]
+	if !ok {
+		return fmt.Errorf("no mirror named `%s`", name)
+	}
+
+	delete(t.m, name)
+	if err := m.Close(); err != nil {
+		return err
+	}
+
+	if !forget {
+		return nil
+	}
+
+	if err := os.Remove(t.statePath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// SetIgnore replaces the ignore patterns of all mirrors,
+// including the ones started later.
+func (t *Table) SetIgnore(patterns []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.opts.Ignore = patterns
+	for _, m := range t.m {
+		m.SetIgnore(patterns)
+	}
+}
+
+// Pull tells all mirrors that the repository might have changed.
+func (t *Table) Pull() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, m := range t.m {
+		m.Pull()
+	}
+}
+
+// Sync reconciles the mirror `name` right away.
+func (t *Table) Sync(name string) error {
+	t.mu.Lock()
+	m, ok := t.m[name]
+	t.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("no mirror named `%s`", name)
+	}
+
+	return m.Sync()
+}
+
+// Close stops all mirrors.
+func (t *Table) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	errs := util.Errors{}
+	for name := range t.m {
+		if err := t.remove(name, false); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs.ToErr()
+}
+
+// Entry describes a configured mirror.
+type Entry struct {
+	Name     string
+	LocalDir string
+	RepoPath string
+	Active   bool
+}
+
+// TabAdd adds the mirror `name` to `cfg` (the "mirrors" section).
+// It does not start it yet; call TabApply for this.
+func TabAdd(cfg *config.Config, name, localDir, repoPath string) error {
+	if name == "" || strings.Contains(name, ".") {
+		return fmt.Errorf("invalid mirror name `%s`", name)
+	}
+
+	if cfg.String(name+".local_path") != "" {
+		return fmt.Errorf("mirror `%s` already exists", name)
+	}
+
+	for _, key := range cfg.Keys() {
+		if strings.HasSuffix(key, ".local_path") && cfg.String(key) == localDir {
+			return fmt.Errorf("`%s` is already mirrored", localDir)
+		}
+	}
+
+	if err := cfg.SetString(name+".local_path", localDir); err != nil {
+		return err
+	}
+
+	return cfg.SetString(name+".repo_path", repoPath)
+}
+
+// TabRemove removes the mirror `name` from `cfg`.
+// It does not stop it yet; call TabApply for this.
+func TabRemove(cfg *config.Config, name string) error {
+	if cfg.String(name+".local_path") == "" {
+		return fmt.Errorf("no mirror named `%s`", name)
+	}
+
+	return cfg.Reset(name)
+}
+
+func tabEntries(cfg *config.Config) map[string]*Entry {
+	entries := make(map[string]*Entry)
+	for _, key := range cfg.Keys() {
+		split := strings.Split(key, ".")
+		if len(split) != 2 {
+			continue
+		}
+
+		name := split[0]
+		if _, ok := entries[name]; !ok {
+			entries[name] = &Entry{Name: name}
+		}
+
+		switch split[1] {
+		case "local_path":
+			entries[name].LocalDir = cfg.String(key)
+		case "repo_path":
+			entries[name].RepoPath = cfg.String(key)
+		}
+	}
+
+	for name, entry := range entries {
+		if entry.LocalDir == "" {
+			delete(entries, name)
+		}
+	}
+
+	return entries
+}
+
+// TabApply starts all mirrors in `cfg` that are not running yet
+// and stops the ones that are not configured anymore.
+func TabApply(cfg *config.Config, t *Table) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entries := tabEntries(cfg)
+
+	errs := util.Errors{}
+	for name := range t.m {
+		if _, ok := entries[name]; !ok {
+			if err := t.remove(name, true); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	for name, entry := range entries {
+		if err := t.add(name, entry.LocalDir, entry.RepoPath); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+		}
+	}
+
+	return errs.ToErr()
+}
+
+// TabList lists all configured mirrors, sorted by name.
+func TabList(cfg *config.Config, t *Table) []Entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := []Entry{}
+	for name, entry := range tabEntries(cfg) {
+		_, entry.Active = t.m[name]
+		list = append(list, *entry)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
@@ -0,0 +1,177 @@
+// +build linux
+
+package mirror
+
+import (
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	watchMask = unix.IN_CREATE |
+		unix.IN_CLOSE_WRITE |
+		unix.IN_MODIFY |
+		unix.IN_ATTRIB |
+		unix.IN_DELETE |
+		unix.IN_DELETE_SELF |
+		unix.IN_MOVED_FROM |
+		unix.IN_MOVED_TO
+
+	// How long to block in poll() before checking if we should quit.
+	watchPollTimeoutMs = 250
+)
+
+// watcher reports changes in a set of directories using inotify.
+// It does not watch recursively; every directory has to be added.
+type watcher struct {
+	mu     sync.Mutex
+	fd     int
+	wds    map[int]string
+	dirs   map[string]int
+	quitCh chan struct{}
+	doneCh chan struct{}
+}
+
+// newWatcher returns a watcher that calls `fn` with the path of
+// everything that changed in one of the watched directories.
+func newWatcher(fn func(path string)) (*watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &watcher{
+		fd:     fd,
+		wds:    make(map[int]string),
+		dirs:   make(map[string]int),
+		quitCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+
+	go w.loop(fn)
+	return w, nil
+}
+
+// add starts watching `dir`. Adding a directory twice is fine.
+func (w *watcher) add(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.dirs[dir]; ok {
+		return nil
+	}
+
+	wd, err := unix.InotifyAddWatch(w.fd, dir, watchMask)
+	if err != nil {
+		return err
+	}
+
+	w.wds[wd] = dir
+	w.dirs[dir] = wd
+	return nil
+}
+
+func (w *watcher) forget(wd int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if dir, ok := w.wds[wd]; ok {
+		delete(w.dirs, dir)
+		delete(w.wds, wd)
+	}
+}
+
+func (w *watcher) dirOf(wd int) (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	dir, ok := w.wds[wd]
+	return dir, ok
+}
+
+func (w *watcher) loop(fn func(path string)) {
+	defer close(w.doneCh)
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	fds := []unix.PollFd{{Fd: int32(w.fd), Events: unix.POLLIN}}
+	for {
+		select {
+		case <-w.quitCh:
+			return
+		default:
+		}
+
+		nfds, err := unix.Poll(fds, watchPollTimeoutMs)
+		if err == unix.EINTR || nfds == 0 {
+			continue
+		}
+
+		if err != nil {
+			log.Warningf("mirror: failed to poll for changes: %v", err)
+			return
+		}
+
+		size, err := unix.Read(w.fd, buf)
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		}
+
+		if err != nil {
+			log.Warningf("mirror: failed to read changes: %v", err)
+			return
+		}
+
+		w.handleEvents(buf[:size], fn)
+	}
+}
+
+func (w *watcher) handleEvents(buf []byte, fn func(path string)) {
+	for offset := 0; offset+unix.SizeofInotifyEvent <= len(buf); {
+		ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset])) // #nosec
+		nameStart := offset + unix.SizeofInotifyEvent
+		nameEnd := nameStart + int(ev.Len)
+		offset = nameEnd
+
+		if ev.Mask&unix.IN_Q_OVERFLOW != 0 {
+			// We lost events; just say that everything changed.
+			fn("")
+			continue
+		}
+
+		dir, ok := w.dirOf(int(ev.Wd))
+		if !ok {
+			continue
+		}
+
+		if ev.Mask&unix.IN_IGNORED != 0 {
+			// The directory was removed or unwatched.
+			w.forget(int(ev.Wd))
+			continue
+		}
+
+		name := ""
+		if ev.Len > 0 && nameEnd <= len(buf) {
+			raw := buf[nameStart:nameEnd]
+			for idx, c := range raw {
+				if c == 0 {
+					raw = raw[:idx]
+					break
+				}
+			}
+
+			name = string(raw)
+		}
+
+		fn(filepath.Join(dir, name))
+	}
+}
+
+func (w *watcher) close() error {
+	close(w.quitCh)
+	<-w.doneCh
+	return unix.Close(w.fd)
+}
@@ -0,0 +1,19 @@
+// +build !linux
+
+package mirror
+
+// watcher does nothing on this platform;
+// changes are only picked up by the periodic rescan.
+type watcher struct{}
+
+func newWatcher(fn func(path string)) (*watcher, error) {
+	return &watcher{}, nil
+}
+
+func (w *watcher) add(dir string) error {
+	return nil
+}
+
+func (w *watcher) close() error {
+	return nil
+}
@@ -7,6 +7,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sahib/brig/net/backend"
 	"github.com/sahib/brig/repo"
 	log "github.com/sirupsen/logrus"
@@ -21,6 +23,14 @@ var (
 	ErrNoSuchAddr = errors.New("No such addr known to ping map")
 )
 
+var (
+	metricPingRoundtrip = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "brig_net_ping_roundtrip_seconds",
+		Help:    "Round trip times of pings to remotes.",
+		Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"remote"})
+)
+
 // PingMap remembers the times we last accessed a remote.
 type PingMap struct {
 	mu            sync.Mutex
@@ -80,7 +90,20 @@ func (pm *PingMap) doUpdate() {
 
 		// Reaching this point means that the pinger
 		// seems to work and did not error out.
+		pm.observeRoundtrip(addr, pinger)
+	}
+}
+
+// observeRoundtrip exports the last roundtrip time of `pinger` as metric.
+func (pm *PingMap) observeRoundtrip(addr string, pinger backend.Pinger) {
+	name := addr
+	if pm.rp != nil {
+		if rmt, err := pm.rp.Remotes.RemoteByAddr(addr); err == nil {
+			name = rmt.Name
+		}
 	}
+
+	metricPingRoundtrip.WithLabelValues(name).Observe(pinger.Roundtrip().Seconds())
 }
 
 func (pm *PingMap) doUpdateSingle(addr string, checkAuthentication bool) {
@@ -0,0 +1,381 @@
+// Package backup implements a single, password protected archive that
+// contains everything needed to move a repository to another machine.
+//
+// A backup file starts with a small plain header (magic, format version,
+// the salt used to derive the key from the password and a value to check
+// the derived key against), followed by an encrypted tar stream with these
+// entries:
+//
+//	repo/<name>        - files from the repository root (keys, remotes, config).
+//	metadata/<owner>   - metadata dump of every owner (see catfs.FS.Export).
+//	gateway/users      - dump of the gateway user database.
+//	blobs/<hash>       - content as stored in the backend (still encrypted).
+//	manifest.json      - always the last entry (see Manifest).
+//
+// The manifest contains a checksum of every other entry, so a backup that
+// was truncated or modified can be detected before anything is restored.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/sahib/brig/catfs/mio/encrypt"
+	"github.com/sahib/brig/util"
+	h "github.com/sahib/brig/util/hashlib"
+)
+
+const (
+	// Version is the current version of the backup format.
+	Version = 1
+
+	// Extension is the file extension backups usually have.
+	Extension = ".brigbackup"
+
+	// RepoPrefix is the prefix of entries that are files in the repo root.
+	RepoPrefix = "repo/"
+	// MetadataPrefix is the prefix of the per-owner metadata dumps.
+	MetadataPrefix = "metadata/"
+	// BlobPrefix is the prefix of entries that contain backend content.
+	BlobPrefix = "blobs/"
+	// UsersName is the name of the gateway user database dump.
+	UsersName = "gateway/users"
+
+	manifestName = "manifest.json"
+	saltSize     = 32
+	keySize      = 32
+)
+
+var (
+	magic = []byte("BRIGBACKUP")
+
+	// ErrBadPassword is returned when the backup cannot be decrypted.
+	ErrBadPassword = errors.New("failed to decrypt backup; wrong password?")
+)
+
+// Manifest describes the contents of a backup.
+type Manifest struct {
+	// Version is the version of the backup format.
+	Version int `json:"version"`
+	// ID uniquely identifies this backup.
+	ID string `json:"id"`
+	// Owner is the owner of the repository that was backed up.
+	Owner string `json:"owner"`
+	// Created is the time when the backup was created.
+	Created time.Time `json:"created"`
+	// Parent is the ID of the backup this one is based on.
+	// It is empty for full backups.
+	Parent string `json:"parent,omitempty"`
+	// Checksums maps every entry (except the manifest) to its sha256 sum.
+	Checksums map[string]string `json:"checksums"`
+	// Blobs are the backend hashes of all blobs in this backup.
+	Blobs []string `json:"blobs"`
+	// InheritedBlobs are blobs that are needed, but were already
+	// part of the parent backup (or its parents).
+	InheritedBlobs []string `json:"inherited_blobs"`
+}
+
+// KnownBlobs returns all blobs that are available after restoring
+// this backup and all of its parents.
+func (m Manifest) KnownBlobs() map[string]bool {
+	known := make(map[string]bool)
+	for _, blob := range m.Blobs {
+		known[blob] = true
+	}
+
+	for _, blob := range m.InheritedBlobs {
+		known[blob] = true
+	}
+
+	return known
+}
+
+// keyCheck returns a value that allows to check if a password
+// is correct before decrypting anything.
+func keyCheck(key []byte) []byte {
+	sum := sha256.Sum256(append([]byte("key-check:"), key...))
+	return sum[:]
+}
+
+func newID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// Writer creates a new backup.
+type Writer struct {
+	encW     *encrypt.Writer
+	tw       *tar.Writer
+	manifest Manifest
+}
+
+// NewWriter returns a new Writer that writes a backup protected by
+// `password` to `w`. The Version, ID, Checksums, Blobs and InheritedBlobs
+// fields of `manifest` are set automatically.
+func NewWriter(w io.Writer, password string, manifest Manifest) (*Writer, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	key := util.DeriveKey([]byte(password), salt, keySize)
+
+	header := append(append([]byte{}, magic...), Version)
+	header = append(header, salt...)
+	if _, err := w.Write(append(header, keyCheck(key)...)); err != nil {
+		return nil, err
+	}
+
+	encW, err := encrypt.NewWriter(w, key)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest.Version = Version
+	manifest.ID = id
+	manifest.Checksums = make(map[string]string)
+	manifest.Blobs = []string{}
+	manifest.InheritedBlobs = []string{}
+
+	return &Writer{
+		encW:     encW,
+		tw:       tar.NewWriter(encW),
+		manifest: manifest,
+	}, nil
+}
+
+// Add adds an entry called `name` with `size` bytes read from `r`.
+func (bw *Writer) Add(name string, size int64, r io.Reader) error {
+	if name == manifestName {
+		return fmt.Errorf("`%s` is reserved", name)
+	}
+
+	if _, ok := bw.manifest.Checksums[name]; ok {
+		return fmt.Errorf("duplicate entry `%s`", name)
+	}
+
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+
+	if err := bw.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	sum := sha256.New()
+	if _, err := io.Copy(bw.tw, io.TeeReader(r, sum)); err != nil {
+		return err
+	}
+
+	bw.manifest.Checksums[name] = hex.EncodeToString(sum.Sum(nil))
+	return nil
+}
+
+// AddBytes is a shortcut for Add() with data already in memory.
+func (bw *Writer) AddBytes(name string, data []byte) error {
+	return bw.Add(name, int64(len(data)), bytes.NewReader(data))
+}
+
+// AddBlob adds `size` bytes of content from `r` under the backend hash `hash`.
+func (bw *Writer) AddBlob(hash h.Hash, size int64, r io.Reader) error {
+	name := hash.B58String()
+	if err := bw.Add(BlobPrefix+name, size, r); err != nil {
+		return err
+	}
+
+	bw.manifest.Blobs = append(bw.manifest.Blobs, name)
+	return nil
+}
+
+// SetInheritedBlobs sets the blobs that are needed, but are
+// not included because they are part of the parent backup.
+func (bw *Writer) SetInheritedBlobs(blobs []string) {
+	bw.manifest.InheritedBlobs = blobs
+}
+
+// Manifest returns the manifest as it will be written on Close().
+func (bw *Writer) Manifest() Manifest {
+	return bw.manifest
+}
+
+// Close writes the manifest and finishes the backup.
+// It does not close the underlying writer.
+func (bw *Writer) Close() error {
+	data, err := json.MarshalIndent(bw.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	hdr := &tar.Header{
+		Name:    manifestName,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+
+	if err := bw.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	if _, err := bw.tw.Write(data); err != nil {
+		return err
+	}
+
+	if err := bw.tw.Close(); err != nil {
+		return err
+	}
+
+	return bw.encW.Close()
+}
+
+// Reader gives access to an existing backup.
+type Reader struct {
+	path     string
+	key      []byte
+	manifest Manifest
+}
+
+// Open opens the backup at `path` and checks the integrity of all entries.
+// An error is returned unless the whole backup could be verified.
+func Open(path, password string) (*Reader, error) {
+	br := &Reader{path: path}
+
+	found := make(map[string]string)
+	haveManifest := false
+	err := br.walk(password, func(name string, r io.Reader) error {
+		if name == manifestName {
+			haveManifest = true
+			return json.NewDecoder(r).Decode(&br.manifest)
+		}
+
+		if haveManifest {
+			return fmt.Errorf("entry `%s` after manifest", name)
+		}
+
+		sum := sha256.New()
+		if _, err := io.Copy(sum, r); err != nil {
+			return err
+		}
+
+		found[name] = hex.EncodeToString(sum.Sum(nil))
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !haveManifest {
+		return nil, errors.New("backup has no manifest; truncated?")
+	}
+
+	for name, sum := range br.manifest.Checksums {
+		foundSum, ok := found[name]
+		if !ok {
+			return nil, fmt.Errorf("entry `%s` is missing", name)
+		}
+
+		if foundSum != sum {
+			return nil, fmt.Errorf("checksum of `%s` differs", name)
+		}
+	}
+
+	if len(found) != len(br.manifest.Checksums) {
+		return nil, errors.New("backup has entries that are not in the manifest")
+	}
+
+	return br, nil
+}
+
+// Manifest returns the (verified) manifest of the backup.
+func (br *Reader) Manifest() Manifest {
+	return br.manifest
+}
+
+// Walk calls `fn` for every entry of the backup (except the manifest)
+// in the order they were added.
+func (br *Reader) Walk(fn func(name string, r io.Reader) error) error {
+	return br.walk("", func(name string, r io.Reader) error {
+		if name == manifestName {
+			return nil
+		}
+
+		return fn(name, r)
+	})
+}
+
+// walk calls `fn` for all entries in the backup, including the manifest.
+// The key is derived from `password` unless it is already known.
+func (br *Reader) walk(password string, fn func(name string, r io.Reader) error) error {
+	fd, err := os.Open(br.path) // #nosec
+	if err != nil {
+		return err
+	}
+
+	defer util.Closer(fd)
+
+	header := make([]byte, len(magic)+1+saltSize+sha256.Size)
+	if _, err := io.ReadFull(fd, header); err != nil {
+		return fmt.Errorf("not a backup: %v", err)
+	}
+
+	if !bytes.Equal(header[:len(magic)], magic) {
+		return errors.New("not a backup: bad magic")
+	}
+
+	header = header[len(magic):]
+	if version := int(header[0]); version != Version {
+		return fmt.Errorf("unsupported backup version %d", version)
+	}
+
+	if br.key == nil {
+		key := util.DeriveKey([]byte(password), header[1:1+saltSize], keySize)
+		if !bytes.Equal(keyCheck(key), header[1+saltSize:]) {
+			return ErrBadPassword
+		}
+
+		br.key = key
+	}
+
+	encR, err := encrypt.NewReader(fd, br.key)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(encR)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := fn(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package backup
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	h "github.com/sahib/brig/util/hashlib"
+	"github.com/stretchr/testify/require"
+)
+
+func withTestBackup(t *testing.T, fn func(path string, manifest Manifest)) {
+	dir, err := ioutil.TempDir("", "brig-backup-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	buf := &bytes.Buffer{}
+	bw, err := NewWriter(buf, "password", Manifest{
+		Owner:   "alice",
+		Created: time.Now(),
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, bw.AddBytes(RepoPrefix+"remotes.yml", []byte("remotes")))
+	require.Nil(t, bw.AddBytes(MetadataPrefix+"alice", []byte("metadata")))
+	blob := []byte("blob")
+	require.Nil(t, bw.AddBlob(h.Sum(blob), int64(len(blob)), bytes.NewReader(blob)))
+	require.NotNil(t, bw.AddBytes(RepoPrefix+"remotes.yml", nil))
+	require.NotNil(t, bw.AddBytes(manifestName, nil))
+	require.Nil(t, bw.Close())
+
+	path := filepath.Join(dir, "test"+Extension)
+	require.Nil(t, ioutil.WriteFile(path, buf.Bytes(), 0600))
+	fn(path, bw.Manifest())
+}
+
+func TestBackupRoundtrip(t *testing.T) {
+	withTestBackup(t, func(path string, written Manifest) {
+		br, err := Open(path, "password")
+		require.Nil(t, err)
+
+		manifest := br.Manifest()
+		require.Equal(t, Version, manifest.Version)
+		require.Equal(t, "alice", manifest.Owner)
+		require.Equal(t, written.ID, manifest.ID)
+		require.Equal(t, []string{h.Sum([]byte("blob")).B58String()}, manifest.Blobs)
+		require.True(t, manifest.KnownBlobs()[manifest.Blobs[0]])
+
+		entries := map[string]string{}
+		err = br.Walk(func(name string, r io.Reader) error {
+			data, err := ioutil.ReadAll(r)
+			entries[name] = string(data)
+			return err
+		})
+
+		require.Nil(t, err)
+		require.Equal(t, map[string]string{
+			RepoPrefix + "remotes.yml":                     "remotes",
+			MetadataPrefix + "alice":                       "metadata",
+			BlobPrefix + h.Sum([]byte("blob")).B58String(): "blob",
+		}, entries)
+	})
+}
+
+func TestBackupBadPassword(t *testing.T) {
+	withTestBackup(t, func(path string, _ Manifest) {
+		_, err := Open(path, "wrong")
+		require.Equal(t, ErrBadPassword, err)
+	})
+}
+
+func TestBackupCorrupted(t *testing.T) {
+	withTestBackup(t, func(path string, _ Manifest) {
+		data, err := ioutil.ReadFile(path)
+		require.Nil(t, err)
+
+		// Cut off the manifest:
+		require.Nil(t, ioutil.WriteFile(path, data[:len(data)/2], 0600))
+		_, err = Open(path, "password")
+		require.NotNil(t, err)
+
+		// Flip a bit in the encrypted part:
+		data[len(data)/2] ^= 0xFF
+		require.Nil(t, ioutil.WriteFile(path, data, 0600))
+		_, err = Open(path, "password")
+		require.NotNil(t, err)
+
+		// Not a backup at all:
+		require.Nil(t, ioutil.WriteFile(path, []byte("hello world"), 0600))
+		_, err = Open(path, "password")
+		require.NotNil(t, err)
+	})
+}
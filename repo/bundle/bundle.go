@@ -0,0 +1,309 @@
+// Package bundle implements a file format to transport metadata patches
+// (and optionally the content they refer to) without a network connection.
+//
+// A bundle is a zip archive with the following entries:
+//
+//	manifest.json   - describes the bundle (see Manifest).
+//	manifest.sig    - detached signature of manifest.json by the creator.
+//	patch           - the binary metadata patch.
+//	blobs/<hash>    - content as stored in the backend (still encrypted).
+//
+// The manifest contains the hash of the patch and the hashes of all blobs,
+// so the signature over the manifest covers the whole bundle.
+package bundle
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	h "github.com/sahib/brig/util/hashlib"
+)
+
+const (
+	// Version is the current version of the bundle format.
+	Version = 1
+
+	// Extension is the file extension bundles usually have.
+	Extension = ".brigbundle"
+
+	manifestName  = "manifest.json"
+	signatureName = "manifest.sig"
+	patchName     = "patch"
+	blobPrefix    = "blobs/"
+)
+
+// Manifest describes the contents of a bundle.
+type Manifest struct {
+	// Version is the version of the bundle format.
+	Version int `json:"version"`
+	// Owner is the name of the user that created the bundle.
+	Owner string `json:"owner"`
+	// Remote is the name of the remote the bundle was created for.
+	Remote string `json:"remote"`
+	// PubKey is the public key of the owner, used to verify the signature.
+	PubKey []byte `json:"pub_key"`
+	// Created is the time when the bundle was created.
+	Created time.Time `json:"created"`
+	// Since is the revision the patch starts at.
+	Since string `json:"since"`
+	// PatchHash is the hash of the patch entry.
+	PatchHash string `json:"patch_hash"`
+	// Blobs are the backend hashes of all included blobs.
+	Blobs []string `json:"blobs"`
+}
+
+// SignFunc creates a detached signature of `data`.
+type SignFunc func(data []byte) ([]byte, error)
+
+// Writer creates a new bundle.
+type Writer struct {
+	zw       *zip.Writer
+	manifest Manifest
+	sign     SignFunc
+	patch    []byte
+}
+
+// NewWriter returns a new Writer that writes the bundle to `w`.
+// The manifest is signed with `sign` once Close() is called.
+// The Version, PatchHash and Blobs fields of `manifest` are set automatically.
+func NewWriter(w io.Writer, manifest Manifest, sign SignFunc) *Writer {
+	manifest.Version = Version
+	manifest.Blobs = []string{}
+	return &Writer{
+		zw:       zip.NewWriter(w),
+		manifest: manifest,
+		sign:     sign,
+	}
+}
+
+// SetPatch sets the metadata patch of the bundle.
+func (bw *Writer) SetPatch(patch []byte) {
+	bw.patch = patch
+	bw.manifest.PatchHash = h.Sum(patch).B58String()
+}
+
+// AddBlob adds the content of `r` under the backend hash `hash`.
+func (bw *Writer) AddBlob(hash h.Hash, r io.Reader) error {
+	name := hash.B58String()
+	w, err := bw.zw.CreateHeader(&zip.FileHeader{
+		Name: blobPrefix + name,
+		// Content is encrypted and usually compressed already:
+		Method: zip.Store,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+
+	bw.manifest.Blobs = append(bw.manifest.Blobs, name)
+	return nil
+}
+
+// Manifest returns the manifest as it will be written on Close().
+func (bw *Writer) Manifest() Manifest {
+	return bw.manifest
+}
+
+func (bw *Writer) writeEntry(name string, data []byte) error {
+	w, err := bw.zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// Close writes the patch, the manifest and its signature.
+// It does not close the underlying writer.
+func (bw *Writer) Close() error {
+	if bw.patch == nil {
+		return errors.New("bundle has no patch")
+	}
+
+	if err := bw.writeEntry(patchName, bw.patch); err != nil {
+		return err
+	}
+
+	manifestData, err := json.MarshalIndent(bw.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	sig, err := bw.sign(manifestData)
+	if err != nil {
+		return err
+	}
+
+	if err := bw.writeEntry(manifestName, manifestData); err != nil {
+		return err
+	}
+
+	if err := bw.writeEntry(signatureName, sig); err != nil {
+		return err
+	}
+
+	return bw.zw.Close()
+}
+
+// Reader gives access to the contents of an existing bundle.
+type Reader struct {
+	fd           *os.File
+	zr           *zip.Reader
+	entries      map[string]*zip.File
+	manifest     Manifest
+	manifestData []byte
+	sig          []byte
+}
+
+// Open opens the bundle at `path`. The signature is not checked;
+// use Verify() for that before trusting any of the contents.
+func Open(path string) (*Reader, error) {
+	fd, err := os.Open(path) // #nosec
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	br, err := newReader(fd, info.Size())
+	if err != nil {
+		fd.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	br.fd = fd
+	return br, nil
+}
+
+func newReader(r io.ReaderAt, size int64) (*Reader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	br := &Reader{
+		zr:      zr,
+		entries: make(map[string]*zip.File),
+	}
+
+	for _, entry := range zr.File {
+		br.entries[entry.Name] = entry
+	}
+
+	br.manifestData, err = br.readEntry(manifestName)
+	if err != nil {
+		return nil, err
+	}
+
+	br.sig, err = br.readEntry(signatureName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(br.manifestData, &br.manifest); err != nil {
+		return nil, fmt.Errorf("bad manifest: %v", err)
+	}
+
+	if br.manifest.Version != Version {
+		return nil, fmt.Errorf("unsupported bundle version %d", br.manifest.Version)
+	}
+
+	return br, nil
+}
+
+func (br *Reader) readEntry(name string) ([]byte, error) {
+	entry, ok := br.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("bundle has no entry `%s`", name)
+	}
+
+	fd, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+	return ioutil.ReadAll(fd)
+}
+
+// Manifest returns the parsed manifest of the bundle.
+func (br *Reader) Manifest() Manifest {
+	return br.manifest
+}
+
+// Verify checks the signature of the manifest with `verify`,
+// which gets the raw manifest, the signature and the public key
+// stored in the manifest. The caller has to make sure that this
+// public key actually belongs to the owner of the bundle.
+func (br *Reader) Verify(verify func(data, sig, pubKey []byte) error) error {
+	if err := verify(br.manifestData, br.sig, br.manifest.PubKey); err != nil {
+		return fmt.Errorf("bad bundle signature: %v", err)
+	}
+
+	return nil
+}
+
+// Patch returns the metadata patch and checks it against the manifest.
+func (br *Reader) Patch() ([]byte, error) {
+	patch, err := br.readEntry(patchName)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.Sum(patch).B58String() != br.manifest.PatchHash {
+		return nil, errors.New("patch does not match manifest")
+	}
+
+	return patch, nil
+}
+
+// Blobs returns the backend hashes of all blobs listed in the manifest.
+func (br *Reader) Blobs() ([]h.Hash, error) {
+	hashes := []h.Hash{}
+	for _, name := range br.manifest.Blobs {
+		hash, err := h.FromB58String(name)
+		if err != nil {
+			return nil, err
+		}
+
+		hashes = append(hashes, hash)
+	}
+
+	return hashes, nil
+}
+
+// OpenBlob returns a reader for the blob with the backend hash `hash`.
+// The caller has to check that the content matches the hash.
+func (br *Reader) OpenBlob(hash h.Hash) (io.ReadCloser, error) {
+	name := hash.B58String()
+	entry, ok := br.entries[blobPrefix+name]
+	if !ok {
+		return nil, fmt.Errorf("bundle has no blob `%s`", name)
+	}
+
+	return entry.Open()
+}
+
+// Close closes the underlying file.
+func (br *Reader) Close() error {
+	if br.fd == nil {
+		return nil
+	}
+
+	return br.fd.Close()
+}
@@ -0,0 +1,108 @@
+package bundle
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	h "github.com/sahib/brig/util/hashlib"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testPubKey = []byte("pubkey")
+	errBadSig  = errors.New("bad signature")
+)
+
+// fakeSign "signs" by hashing data and key together.
+func fakeSign(data []byte) ([]byte, error) {
+	return []byte(h.Sum(append(data, testPubKey...)).B58String()), nil
+}
+
+func fakeVerify(data, sig, pubKey []byte) error {
+	expect := h.Sum(append(data, pubKey...)).B58String()
+	if string(sig) != expect {
+		return errBadSig
+	}
+
+	return nil
+}
+
+func writeTestBundle(t *testing.T, patch []byte, blobs map[string][]byte) []byte {
+	buf := &bytes.Buffer{}
+	bw := NewWriter(buf, Manifest{
+		Owner:   "alice",
+		Remote:  "bob",
+		PubKey:  testPubKey,
+		Created: time.Now(),
+		Since:   "commit[0]",
+	}, fakeSign)
+
+	bw.SetPatch(patch)
+	for name, data := range blobs {
+		hash, err := h.FromB58String(name)
+		require.Nil(t, err)
+		require.Nil(t, bw.AddBlob(hash, bytes.NewReader(data)))
+	}
+
+	require.Nil(t, bw.Close())
+	return buf.Bytes()
+}
+
+func TestBundleRoundtrip(t *testing.T) {
+	blobHash := h.Sum([]byte("blob"))
+	data := writeTestBundle(t, []byte("patch"), map[string][]byte{
+		blobHash.B58String(): []byte("blob"),
+	})
+
+	br, err := newReader(bytes.NewReader(data), int64(len(data)))
+	require.Nil(t, err)
+	require.Nil(t, br.Verify(fakeVerify))
+
+	manifest := br.Manifest()
+	require.Equal(t, Version, manifest.Version)
+	require.Equal(t, "alice", manifest.Owner)
+	require.Equal(t, "bob", manifest.Remote)
+
+	patch, err := br.Patch()
+	require.Nil(t, err)
+	require.Equal(t, []byte("patch"), patch)
+
+	hashes, err := br.Blobs()
+	require.Nil(t, err)
+	require.Len(t, hashes, 1)
+	require.Equal(t, blobHash, hashes[0])
+
+	fd, err := br.OpenBlob(hashes[0])
+	require.Nil(t, err)
+	blob, err := ioutil.ReadAll(fd)
+	require.Nil(t, err)
+	require.Nil(t, fd.Close())
+	require.Equal(t, []byte("blob"), blob)
+
+	_, err = br.OpenBlob(h.Sum([]byte("other")))
+	require.NotNil(t, err)
+	require.Nil(t, br.Close())
+}
+
+func TestBundleTampered(t *testing.T) {
+	data := writeTestBundle(t, []byte("patch"), nil)
+	br, err := newReader(bytes.NewReader(data), int64(len(data)))
+	require.Nil(t, err)
+
+	// Pretend someone else created it:
+	br.manifest.PubKey = []byte("other")
+	require.NotNil(t, br.Verify(fakeVerify))
+
+	// Change the patch behind the manifest's back:
+	br.manifest.PatchHash = h.Sum([]byte("other")).B58String()
+	_, err = br.Patch()
+	require.NotNil(t, err)
+}
+
+func TestBundleNoPatch(t *testing.T) {
+	bw := NewWriter(&bytes.Buffer{}, Manifest{}, fakeSign)
+	require.NotNil(t, bw.Close())
+}
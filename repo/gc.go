@@ -4,10 +4,29 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	h "github.com/sahib/brig/util/hashlib"
 	log "github.com/sirupsen/logrus"
 )
 
+var (
+	metricGCRuns = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "brig_gc_runs_total",
+		Help: "Number of backend garbage collector runs.",
+	})
+
+	metricGCFreedObjects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "brig_gc_freed_objects_total",
+		Help: "Number of objects the backend garbage collector removed.",
+	})
+
+	metricGCFreedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "brig_gc_freed_bytes_total",
+		Help: "Size of the files whose content was removed by the backend garbage collector.",
+	})
+)
+
 // GC runs the garbage collector of the backend.  If `aggressive` is true, also
 // the internal data structures will be garbage collected, which might lead to
 // minimally less storage.  It returns a map of maps, where the inner map
@@ -24,6 +43,9 @@ func (rp *Repository) GC(backend Backend, aggressive bool) (map[string]map[strin
 		return nil, err
 	}
 
+	metricGCRuns.Inc()
+	metricGCFreedObjects.Add(float64(len(killed)))
+
 	result := make(map[string]map[string]h.Hash)
 	if len(killed) == 0 {
 		// Shortcut, since running the loop below
@@ -32,6 +54,14 @@ func (rp *Repository) GC(backend Backend, aggressive bool) (map[string]map[strin
 		return result, nil
 	}
 
+	// The same content might be shared by several owners:
+	freedSizes := make(map[string]uint64)
+	defer func() {
+		for _, size := range freedSizes {
+			metricGCFreedBytes.Add(float64(size))
+		}
+	}()
+
 	for owner, fs := range rp.fsMap {
 		if aggressive {
 			// Make sure we also clean every bit
@@ -48,6 +78,7 @@ func (rp *Repository) GC(backend Backend, aggressive bool) (map[string]map[strin
 		subResult := make(map[string]h.Hash)
 		for content, info := range nodeMap {
 			subResult[content] = info.ContentHash
+			freedSizes[content] = info.Size
 		}
 
 		result[owner] = subResult
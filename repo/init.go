@@ -103,17 +103,15 @@ func Init(baseFolder, owner, password, backendName string, daemonPort int64) err
 		return e.Wrap(err, "Failed to setup gpg keys")
 	}
 
-	passwdFile := filepath.Join(baseFolder, "passwd")
-	passwdData := fmt.Sprintf("%s", owner)
-	if err := ioutil.WriteFile(passwdFile, []byte(passwdData), 0644); err != nil {
+	// The header is used to derive the key from the password
+	// and to verify the password on the next start:
+	hdr, err := newKDFHeader("")
+	if err != nil {
 		return err
 	}
 
-	// passwd is used to verify the user password,
-	// so it needs to be locked only once on init and
-	// kept out otherwise from the locking machinery.
-	if err := lockFile(passwdFile, keyFromPassword(owner, password)); err != nil {
-		return e.Wrapf(err, "passwd-lock")
+	if err := writeKDFHeader(baseFolder, hdr, password); err != nil {
+		return e.Wrapf(err, "kdf-header")
 	}
 
 	return nil
@@ -0,0 +1,166 @@
+package repo
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	// kdfHeaderName is the (unencrypted) file that describes how the
+	// key that locks the repository is derived from the password.
+	kdfHeaderName = "KDF"
+
+	// kdfVersion is the current version of the header format.
+	kdfVersion = 1
+
+	kdfSaltSize = 32
+	kdfKeySize  = 32
+)
+
+var (
+	// Parameters of argon2id for newly written headers.
+	// These follow the recommendation of the argon2 RFC.
+	kdfDefaultTime    uint32 = 3
+	kdfDefaultMemory  uint32 = 64 * 1024
+	kdfDefaultThreads uint8  = 4
+)
+
+// kdfHeader is stored next to the locked files and contains everything
+// that is needed to derive the lock key, except the password itself.
+type kdfHeader struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+
+	// Keyfile is the path to a file whose content is needed
+	// in addition to the password. Empty if not used.
+	Keyfile string `json:"keyfile,omitempty"`
+
+	// Check allows to tell if a derived key is the right one.
+	Check []byte `json:"check"`
+}
+
+func newKDFHeader(keyfile string) (*kdfHeader, error) {
+	salt := make([]byte, kdfSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	return &kdfHeader{
+		Version: kdfVersion,
+		Salt:    salt,
+		Time:    kdfDefaultTime,
+		Memory:  kdfDefaultMemory,
+		Threads: kdfDefaultThreads,
+		Keyfile: keyfile,
+	}, nil
+}
+
+func keyCheck(key []byte) []byte {
+	sum := sha3.Sum256(append([]byte("brig-lock-key:"), key...))
+	return sum[:]
+}
+
+// deriveKey derives the lock key from `password` and the keyfile, if any.
+func (hdr *kdfHeader) deriveKey(password string) ([]byte, error) {
+	secret := []byte(password)
+	if hdr.Keyfile != "" {
+		data, err := ioutil.ReadFile(hdr.Keyfile) // #nosec
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keyfile: %v", err)
+		}
+
+		sum := sha3.Sum256(data)
+		secret = append(secret, sum[:]...)
+	}
+
+	return argon2.IDKey(secret, hdr.Salt, hdr.Time, hdr.Memory, hdr.Threads, kdfKeySize), nil
+}
+
+// checkedKey derives the key and makes sure it is the right one.
+func (hdr *kdfHeader) checkedKey(password string) ([]byte, error) {
+	key, err := hdr.deriveKey(password)
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare(keyCheck(key), hdr.Check) != 1 {
+		return nil, ErrBadPassword
+	}
+
+	return key, nil
+}
+
+// readKDFHeader reads the header in `root`. If there is none yet, the
+// returned error satisfies os.IsNotExist().
+func readKDFHeader(root string) (*kdfHeader, error) {
+	data, err := ioutil.ReadFile(filepath.Join(root, kdfHeaderName)) // #nosec
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := &kdfHeader{}
+	if err := json.Unmarshal(data, hdr); err != nil {
+		return nil, err
+	}
+
+	if hdr.Version != kdfVersion {
+		return nil, fmt.Errorf("unsupported key derivation header version %d", hdr.Version)
+	}
+
+	if len(hdr.Salt) == 0 {
+		return nil, errors.New("key derivation header has no salt")
+	}
+
+	return hdr, nil
+}
+
+// writeKDFHeader sets the key check for `password` in `hdr` and writes it
+// to `root`. The old header is replaced atomically.
+func writeKDFHeader(root string, hdr *kdfHeader, password string) error {
+	key, err := hdr.deriveKey(password)
+	if err != nil {
+		return err
+	}
+
+	hdr.Check = keyCheck(key)
+	data, err := json.MarshalIndent(hdr, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(root, kdfHeaderName)
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// lockKey returns the key that locks the repository at `root`.
+// Repositories without header use the key derivation of older versions.
+func lockKey(root, owner, password string) ([]byte, error) {
+	hdr, err := readKDFHeader(root)
+	if os.IsNotExist(err) {
+		return keyFromPassword(owner, password), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return hdr.checkedKey(password)
+}
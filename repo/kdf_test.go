@@ -0,0 +1,112 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func withInitRepo(t *testing.T, fn func(dir string)) {
+	withTempDir(t, func(dir string) {
+		require.Nil(t, Init(dir, "alice", "klaus", "mock", 6666))
+		fn(dir)
+	})
+}
+
+func TestKDFUpgrade(t *testing.T) {
+	withInitRepo(t, func(dir string) {
+		// Make it look like a repository of an older version:
+		require.Nil(t, os.Remove(filepath.Join(dir, kdfHeaderName)))
+		passwdFile := filepath.Join(dir, "passwd")
+		require.Nil(t, ioutil.WriteFile(passwdFile, []byte("alice"), 0600))
+		require.Nil(t, LockRepo(dir, "alice", "klaus", excludedFromLock, excludedFromUnlock))
+
+		_, err := readKDFHeader(dir)
+		require.True(t, os.IsNotExist(err))
+		require.Equal(t, ErrBadPassword, CheckPassword(dir, "wrong"))
+
+		rp, err := Open(dir, "klaus")
+		require.Nil(t, err)
+		require.Nil(t, rp.Close("klaus"))
+
+		hdr, err := readKDFHeader(dir)
+		require.Nil(t, err)
+		require.Equal(t, kdfVersion, hdr.Version)
+		require.Len(t, hdr.Salt, kdfSaltSize)
+
+		_, err = os.Stat(passwdFile + LockPathSuffix)
+		require.True(t, os.IsNotExist(err))
+
+		require.Equal(t, ErrBadPassword, CheckPassword(dir, "wrong"))
+		rp, err = Open(dir, "klaus")
+		require.Nil(t, err)
+		require.Nil(t, rp.Close("klaus"))
+	})
+}
+
+func TestChangePassword(t *testing.T) {
+	withInitRepo(t, func(dir string) {
+		rp, err := Open(dir, "klaus")
+		require.Nil(t, err)
+
+		oldHdr, err := readKDFHeader(dir)
+		require.Nil(t, err)
+
+		require.Equal(t, ErrBadPassword, rp.ChangePassword("wrong", "karl", ""))
+		require.Nil(t, rp.ChangePassword("klaus", "karl", ""))
+		require.Nil(t, rp.Close("karl"))
+
+		newHdr, err := readKDFHeader(dir)
+		require.Nil(t, err)
+		require.NotEqual(t, oldHdr.Salt, newHdr.Salt)
+
+		require.Equal(t, ErrBadPassword, CheckPassword(dir, "klaus"))
+		rp, err = Open(dir, "karl")
+		require.Nil(t, err)
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "remotes.yml"))
+		require.Nil(t, err)
+		require.NotNil(t, data)
+		require.Nil(t, rp.Close("karl"))
+	})
+}
+
+func TestKeyfile(t *testing.T) {
+	withInitRepo(t, func(dir string) {
+		keyfile := filepath.Join(dir, "..", filepath.Base(dir)+".key")
+		require.Nil(t, ioutil.WriteFile(keyfile, []byte("secret"), 0600))
+		defer os.Remove(keyfile)
+
+		rp, err := Open(dir, "klaus")
+		require.Nil(t, err)
+		require.Nil(t, rp.ChangePassword("klaus", "klaus", keyfile))
+
+		haveKeyfile, err := rp.Keyfile()
+		require.Nil(t, err)
+		require.Equal(t, keyfile, haveKeyfile)
+		require.Nil(t, rp.Close("klaus"))
+
+		// A different keyfile results in a different key:
+		require.Nil(t, ioutil.WriteFile(keyfile, []byte("other"), 0600))
+		require.Equal(t, ErrBadPassword, CheckPassword(dir, "klaus"))
+
+		// No keyfile at all:
+		require.Nil(t, os.Remove(keyfile))
+		require.NotNil(t, CheckPassword(dir, "klaus"))
+		_, err = Open(dir, "klaus")
+		require.NotNil(t, err)
+
+		require.Nil(t, ioutil.WriteFile(keyfile, []byte("secret"), 0600))
+		rp, err = Open(dir, "klaus")
+		require.Nil(t, err)
+
+		// Remove the keyfile binding again:
+		require.Nil(t, rp.ChangePassword("klaus", "klaus", ""))
+		require.Nil(t, rp.Close("klaus"))
+		require.Nil(t, os.Remove(keyfile))
+		require.Nil(t, CheckPassword(dir, "klaus"))
+	})
+}
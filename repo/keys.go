@@ -88,6 +88,46 @@ func decryptAsymetric(folder string, data []byte) ([]byte, error) {
 	return ioutil.ReadAll(md.UnverifiedBody)
 }
 
+// signDetached uses the private key from `folder` to create a
+// detached signature of `data`.
+func signDetached(folder string, data []byte) ([]byte, error) {
+	prvPath := filepath.Join(folder, "gpg.prv")
+	fd, err := os.Open(prvPath) // #nosec
+	if err != nil {
+		return nil, err
+	}
+
+	defer util.Closer(fd)
+
+	ents, err := openpgp.ReadKeyRing(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ents) == 0 {
+		return nil, fmt.Errorf("no private key found in %s", prvPath)
+	}
+
+	sigBuf := &bytes.Buffer{}
+	if err := openpgp.DetachSign(sigBuf, ents[0], bytes.NewReader(data), nil); err != nil {
+		return nil, err
+	}
+
+	return sigBuf.Bytes(), nil
+}
+
+// VerifySignature checks that `sig` is a valid detached signature
+// of `data`, made by the owner of `pubKey`.
+func VerifySignature(data, sig, pubKey []byte) error {
+	ents, err := openpgp.ReadKeyRing(bytes.NewReader(pubKey))
+	if err != nil {
+		return err
+	}
+
+	_, err = openpgp.CheckDetachedSignature(ents, bytes.NewReader(data), bytes.NewReader(sig))
+	return err
+}
+
 // Keyring manages our own keypair and stores the last known
 // pubkeys of other remotes.
 type Keyring struct {
@@ -114,6 +154,12 @@ func (kp *Keyring) Decrypt(data []byte) ([]byte, error) {
 	return decryptAsymetric(kp.folder, data)
 }
 
+// Sign creates a detached signature of `data` with our private key.
+// It can be checked by others with VerifySignature().
+func (kp *Keyring) Sign(data []byte) ([]byte, error) {
+	return signDetached(kp.folder, data)
+}
+
 // OwnPubKey returns an exported version of our own public key.
 func (kp *Keyring) OwnPubKey() ([]byte, error) {
 	pubPath := filepath.Join(kp.folder, "gpg.pub")
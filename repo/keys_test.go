@@ -29,6 +29,11 @@ func TestKeyring(t *testing.T) {
 	require.Nil(t, err)
 	require.Equal(t, testData, decTestData)
 
+	sig, err := kr.Sign(testData)
+	require.Nil(t, err)
+	require.Nil(t, VerifySignature(testData, sig, ownPubKey))
+	require.NotNil(t, VerifySignature([]byte("Hello?"), sig, ownPubKey))
+
 	require.Nil(t, kr.SavePubKey("a", []byte{1}))
 	require.Nil(t, kr.SavePubKey("a", []byte{1}))
 	remotePubKey, err := kr.PubKeyFor("a")
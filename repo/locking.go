@@ -89,12 +89,14 @@ func isExcluded(path string, excludePatterns []string) bool {
 	return false
 }
 
+// keyFromPassword is the key derivation used before the KDF header existed.
+// The owner is not the perfect salt, but was the only one available.
 func keyFromPassword(owner, password string) []byte {
 	return util.DeriveKey([]byte(password), []byte(owner), 32)
 }
 
 // LockRepo encrypts all files (except those in `lockExcludes`) in `root`,
-// depending on `user` and `password`. `unlockExcludes` is only used to
+// depending on `user`, `password` and the key derivation header, if any. `unlockExcludes` is only used to
 // prevent warnings about not locked files.
 func LockRepo(root, user, password string, lockExcludes, unlockExcludes []string) error {
 	files, err := ioutil.ReadDir(root)
@@ -102,8 +104,10 @@ func LockRepo(root, user, password string, lockExcludes, unlockExcludes []string
 		return err
 	}
 
-	// user is not the perfect salt, but pretty much the only available one here.
-	key := keyFromPassword(user, password)
+	key, err := lockKey(root, user, password)
+	if err != nil {
+		return err
+	}
 
 	for _, info := range files {
 		path := filepath.Join(root, info.Name())
@@ -226,7 +230,10 @@ func UnlockRepo(root, user, password string, lockExcludes, unlockExcludes []stri
 		}
 	}
 
-	key := keyFromPassword(user, password)
+	key, err := lockKey(root, user, password)
+	if err != nil {
+		return err
+	}
 
 	for _, info := range files {
 		path := filepath.Join(root, info.Name())
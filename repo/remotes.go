@@ -106,6 +106,18 @@ func NewRemotes(path string) (*RemoteList, error) {
 		return nil, err
 	}
 
+	remotes, err := parseRemotes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RemoteList{
+		remotes: remotes,
+		path:    path,
+	}, nil
+}
+
+func parseRemotes(data []byte) (map[string]*Remote, error) {
 	remotes := make(map[string]*Remote)
 	if err := yml.Unmarshal(data, remotes); err != nil {
 		return nil, err
@@ -119,10 +131,7 @@ func NewRemotes(path string) (*RemoteList, error) {
 		})
 	}
 
-	return &RemoteList{
-		remotes: remotes,
-		path:    path,
-	}, nil
+	return remotes, nil
 }
 
 func (rl *RemoteList) save() error {
@@ -153,6 +162,23 @@ func (rl *RemoteList) Export(w io.Writer) error {
 	return nil
 }
 
+// Import replaces the remote list with the YAML encoded contents of `r`,
+// as written by Export.
+func (rl *RemoteList) Import(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	remotes, err := parseRemotes(data)
+	if err != nil {
+		return err
+	}
+
+	rl.remotes = remotes
+	return rl.save()
+}
+
 func dedupeFolders(folders []Folder) []Folder {
 	seen := make(map[string]bool)
 	newFolders := []Folder{}
@@ -1,8 +1,10 @@
 package repo
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/sahib/brig/net/peer"
@@ -100,3 +102,31 @@ func TestRemoteOps(t *testing.T) {
 	require.Equal(t, remotes[0], bobRemote)
 	require.Equal(t, remotes[1], charlieRemote)
 }
+
+func TestRemotesExportImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brig-test-remotes")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	src, err := NewRemotes(filepath.Join(dir, "src.yml"))
+	require.Nil(t, err)
+	require.Nil(t, src.AddOrUpdateRemote(bobRemote))
+
+	dst, err := NewRemotes(filepath.Join(dir, "dst.yml"))
+	require.Nil(t, err)
+	require.Nil(t, dst.AddOrUpdateRemote(charlieRemote))
+
+	buf := &bytes.Buffer{}
+	require.Nil(t, src.Export(buf))
+	require.Nil(t, dst.Import(buf))
+
+	// Import replaces and persists the list:
+	reloaded, err := NewRemotes(filepath.Join(dir, "dst.yml"))
+	require.Nil(t, err)
+
+	remotes, err := reloaded.ListRemotes()
+	require.Nil(t, err)
+	require.Len(t, remotes, 1)
+	require.Equal(t, bobRemote.Name, remotes[0].Name)
+	require.Equal(t, bobRemote.Folders, remotes[0].Folders)
+}
@@ -7,9 +7,11 @@ import (
 	"path/filepath"
 	"sync"
 
+	humanize "github.com/dustin/go-humanize"
 	e "github.com/pkg/errors"
 	"github.com/sahib/brig/catfs"
 	fserr "github.com/sahib/brig/catfs/errors"
+	"github.com/sahib/brig/catfs/mio/blockcache"
 	"github.com/sahib/brig/defaults"
 	"github.com/sahib/config"
 	log "github.com/sirupsen/logrus"
@@ -17,7 +19,7 @@ import (
 
 var (
 	// Do not encrypt "data" (already contains encrypted streams) and
-	excludedFromLock   = []string{"data", "OWNER", "BACKEND", "REPO_ID", "config.yml"}
+	excludedFromLock   = []string{"data", "OWNER", "BACKEND", "REPO_ID", "config.yml", kdfHeaderName}
 	excludedFromUnlock = []string{"passwd.locked"}
 )
 
@@ -30,6 +32,7 @@ var (
 //
 // Informal: This file structure currently looks like this:
 // config.yml
+// KDF
 // OWNER
 // BACKEND
 // REPO_ID
@@ -42,6 +45,11 @@ var (
 //        (fs-backend specific)
 //    <name_2>
 //        (fs-backend specific)
+// metadata-convert/
+//    <name_1>
+//        (work space of metadata conversions and their backups)
+// block-cache/
+//    (cached blocks of file content)
 type Repository struct {
 	mu sync.Mutex
 
@@ -65,11 +73,31 @@ type Repository struct {
 
 	// channel to control the auto gc loop
 	autoGCControl chan bool
+
+	// Cache for file content, shared by all filesystems.
+	blockCache *blockcache.Cache
 }
 
-// CheckPassword will try to validate `password` by decrypting something
-// in `baseFolder`.
+// CheckPassword will try to validate `password` with the key derivation
+// header or (for older repositories) by decrypting something in `baseFolder`.
 func CheckPassword(baseFolder, password string) error {
+	hdr, err := readKDFHeader(baseFolder)
+	if err == nil {
+		if _, err := hdr.checkedKey(password); err != nil {
+			if err == ErrBadPassword {
+				log.Warningf("Failed to derive lock key. Wrong password entered?")
+			}
+
+			return err
+		}
+
+		return nil
+	}
+
+	if !os.IsNotExist(err) {
+		return err
+	}
+
 	passwdFile := filepath.Join(baseFolder, "passwd.locked")
 
 	// If the file does not exist yet, it probably means
@@ -125,6 +153,10 @@ func Open(baseFolder, password string) (*Repository, error) {
 		return nil, err
 	}
 
+	if err := upgradeKDF(baseFolder, password); err != nil {
+		return nil, e.Wrap(err, "failed to upgrade key derivation")
+	}
+
 	cfgPath := filepath.Join(baseFolder, "config.yml")
 	cfg, err := defaults.OpenMigratedConfig(cfgPath)
 	if err != nil {
@@ -146,6 +178,11 @@ func Open(baseFolder, password string) (*Repository, error) {
 		return nil, err
 	}
 
+	blockCache, err := openBlockCache(baseFolder, cfg)
+	if err != nil {
+		return nil, e.Wrap(err, "failed to open block cache")
+	}
+
 	rp := &Repository{
 		BaseFolder:    baseFolder,
 		backendName:   string(backendName),
@@ -154,11 +191,41 @@ func Open(baseFolder, password string) (*Repository, error) {
 		Owner:         string(owner),
 		fsMap:         make(map[string]*catfs.FS),
 		autoGCControl: make(chan bool, 1),
+		blockCache:    blockCache,
 	}
 
 	return rp, nil
 }
 
+func blockCacheSize(cfg *config.Config) int64 {
+	size, err := humanize.ParseBytes(cfg.String("fs.block_cache.size"))
+	if err != nil {
+		log.Warningf("invalid fs.block_cache.size, disabling block cache: %v", err)
+		return 0
+	}
+
+	return int64(size)
+}
+
+func openBlockCache(baseFolder string, cfg *config.Config) (*blockcache.Cache, error) {
+	cacheDir := filepath.Join(baseFolder, "block-cache")
+	blockCache, err := blockcache.Open(cacheDir, blockCacheSize(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.AddEvent("fs.block_cache.size", func(key string) {
+		blockCache.SetMaxSize(blockCacheSize(cfg))
+	})
+
+	return blockCache, nil
+}
+
+// BlockCache returns the cache for file content used by all filesystems.
+func (rp *Repository) BlockCache() *blockcache.Cache {
+	return rp.blockCache
+}
+
 // Close will lock the repository, making this instance unusable.
 func (rp *Repository) Close(password string) error {
 	rp.stopAutoGCLoop()
@@ -208,6 +275,11 @@ func (rp *Repository) FS(owner string, bk catfs.FsBackend) (*catfs.FS, error) {
 	// Create it & give it a part of the main config.
 	fsCfg := rp.Config.Section("fs")
 	fsDbPath := filepath.Join(rp.BaseFolder, "metadata", owner)
+	convertDir := rp.convertDir(owner)
+	if err := catfs.PrepareDatabase(fsDbPath, convertDir, fsCfg.String("db.backend")); err != nil {
+		return nil, err
+	}
+
 	if err := os.MkdirAll(fsDbPath, 0700); err != nil && err != os.ErrExist {
 		return nil, err
 	}
@@ -217,6 +289,8 @@ func (rp *Repository) FS(owner string, bk catfs.FsBackend) (*catfs.FS, error) {
 		return nil, err
 	}
 
+	fs.SetBlockCache(rp.blockCache)
+
 	// Create an initial commit if there was none yet:
 	if _, err := fs.Head(); fserr.IsErrNoSuchRef(err) {
 		if err := fs.MakeCommit("initial commit"); err != nil {
@@ -229,6 +303,39 @@ func (rp *Repository) FS(owner string, bk catfs.FsBackend) (*catfs.FS, error) {
 	return fs, nil
 }
 
+// convertDir returns where conversions of the metadata of `owner`
+// keep their intermediate data and backups. It is outside of the
+// metadata directory, so it is not mistaken for an owner.
+func (rp *Repository) convertDir(owner string) string {
+	return filepath.Join(rp.BaseFolder, "metadata-convert", owner)
+}
+
+// ConvertDatabases selects `to` as key/value store for the metadata and
+// converts the metadata of all opened filesystems right away. The others
+// are converted when they are opened the next time. The previously
+// selected store is returned.
+func (rp *Repository) ConvertDatabases(to string) (string, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	from := rp.Config.String("fs.db.backend")
+	if err := rp.Config.SetString("fs.db.backend", to); err != nil {
+		return "", err
+	}
+
+	if err := rp.SaveConfig(); err != nil {
+		return "", err
+	}
+
+	for owner, fs := range rp.fsMap {
+		if _, err := fs.ConvertDatabase(to, rp.convertDir(owner)); err != nil {
+			return "", e.Wrapf(err, "convert metadata of %s", owner)
+		}
+	}
+
+	return from, nil
+}
+
 // CurrentUser returns the current user of the repository.
 // (i.e. what FS is being shown)
 func (rp *Repository) CurrentUser() string {
@@ -261,3 +368,71 @@ func (rp *Repository) SaveConfig() error {
 	configPath := filepath.Join(rp.BaseFolder, "config.yml")
 	return config.ToYamlFile(configPath, rp.Config)
 }
+
+// upgradeKDF writes a key derivation header to repositories that were
+// created before it existed. It must be called while the repo is unlocked,
+// since the files are locked with the new key on the next Close().
+func upgradeKDF(baseFolder, password string) error {
+	if _, err := readKDFHeader(baseFolder); !os.IsNotExist(err) {
+		return err
+	}
+
+	hdr, err := newKDFHeader("")
+	if err != nil {
+		return err
+	}
+
+	log.Infof("upgrading repository to a salted key derivation")
+	if err := writeKDFHeader(baseFolder, hdr, password); err != nil {
+		return err
+	}
+
+	// The password is now checked with the header:
+	passwdFile := filepath.Join(baseFolder, "passwd.locked")
+	if err := os.Remove(passwdFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Keyfile returns the path of the keyfile that is needed in addition to
+// the password, or an empty string if there is none.
+func (rp *Repository) Keyfile() (string, error) {
+	hdr, err := readKDFHeader(rp.BaseFolder)
+	if err != nil {
+		return "", err
+	}
+
+	return hdr.Keyfile, nil
+}
+
+// ChangePassword replaces `oldPassword` with `newPassword`. If `keyfile`
+// is not empty, the content of this file is needed in addition to the
+// password from now on. A new salt is generated on every change.
+// Since all files are unlocked while the repository is open, they
+// are locked with the new key on the next Close().
+func (rp *Repository) ChangePassword(oldPassword, newPassword, keyfile string) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if err := CheckPassword(rp.BaseFolder, oldPassword); err != nil {
+		return err
+	}
+
+	if keyfile != "" {
+		absKeyfile, err := filepath.Abs(keyfile)
+		if err != nil {
+			return err
+		}
+
+		keyfile = absKeyfile
+	}
+
+	hdr, err := newKDFHeader(keyfile)
+	if err != nil {
+		return err
+	}
+
+	return writeKDFHeader(rp.BaseFolder, hdr, newPassword)
+}
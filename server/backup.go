@@ -0,0 +1,355 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	e "github.com/pkg/errors"
+	"github.com/sahib/brig/defaults"
+	"github.com/sahib/brig/repo/backup"
+	h "github.com/sahib/brig/util/hashlib"
+	"github.com/sahib/config"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// Files from the repo root that are copied as-is into a backup.
+	backupRepoFiles = []string{"REPO_ID", "gpg.pub", "gpg.prv"}
+
+	// Config keys that describe the machine, not the repository.
+	// They keep their value when a backup is restored.
+	backupLocalConfigKeys = []string{
+		"daemon.port",
+		"daemon.ipfs_path",
+		"repo.current_user",
+		"repo.password_command",
+	}
+)
+
+const (
+	backupConfigName  = backup.RepoPrefix + "config.yml"
+	backupRemotesName = backup.RepoPrefix + "remotes.yml"
+)
+
+// createBackup writes a backup of the whole repository, protected by
+// `password`, to `path`. If `withContent` is true, the content of all
+// pinned files is included. If `since` is the path of an older backup,
+// content that is already part of it is not included again.
+func (b *base) createBackup(path, password, since string, withContent bool) (*backup.Manifest, error) {
+	manifest := backup.Manifest{
+		Owner:   b.repo.Owner,
+		Created: time.Now(),
+	}
+
+	knownBlobs := make(map[string]bool)
+	if since != "" {
+		parent, err := backup.Open(since, password)
+		if err != nil {
+			return nil, e.Wrapf(err, "open %s", since)
+		}
+
+		manifest.Parent = parent.Manifest().ID
+		knownBlobs = parent.Manifest().KnownBlobs()
+	}
+
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600) // #nosec
+	if err != nil {
+		return nil, err
+	}
+
+	bw, err := backup.NewWriter(fd, password, manifest)
+	if err == nil {
+		err = b.writeBackup(bw, knownBlobs, withContent)
+	}
+
+	if err == nil {
+		err = bw.Close()
+	}
+
+	if err != nil {
+		fd.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	manifest = bw.Manifest()
+	return &manifest, fd.Close()
+}
+
+// backupOwners returns all owners we have metadata for.
+func (b *base) backupOwners() ([]string, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(b.repo.BaseFolder, "metadata"))
+	if err != nil {
+		return nil, err
+	}
+
+	owners := []string{}
+	for _, info := range infos {
+		if info.IsDir() {
+			owners = append(owners, info.Name())
+		}
+	}
+
+	return owners, nil
+}
+
+func (b *base) writeBackup(bw *backup.Writer, knownBlobs map[string]bool, withContent bool) error {
+	for _, name := range backupRepoFiles {
+		data, err := ioutil.ReadFile(filepath.Join(b.repo.BaseFolder, name)) // #nosec
+		if os.IsNotExist(err) {
+			// Not all repositories have all files (e.g. older ones).
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := bw.AddBytes(backup.RepoPrefix+name, data); err != nil {
+			return err
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := b.repo.Config.Save(config.NewYamlEncoder(buf)); err != nil {
+		return err
+	}
+
+	if err := bw.AddBytes(backupConfigName, buf.Bytes()); err != nil {
+		return err
+	}
+
+	buf.Reset()
+	if err := b.repo.Remotes.Export(buf); err != nil {
+		return err
+	}
+
+	if err := bw.AddBytes(backupRemotesName, buf.Bytes()); err != nil {
+		return err
+	}
+
+	buf.Reset()
+	if err := b.gateway.UserDatabase().Export(buf); err != nil {
+		return e.Wrapf(err, "export users")
+	}
+
+	if err := bw.AddBytes(backup.UsersName, buf.Bytes()); err != nil {
+		return err
+	}
+
+	owners, err := b.backupOwners()
+	if err != nil {
+		return err
+	}
+
+	pinned := []h.Hash{}
+	seen := make(map[string]bool)
+	for _, owner := range owners {
+		fs, err := b.repo.FS(owner, b.backend)
+		if err != nil {
+			return err
+		}
+
+		buf.Reset()
+		if err := fs.Export(buf); err != nil {
+			return e.Wrapf(err, "export %s", owner)
+		}
+
+		if err := bw.AddBytes(backup.MetadataPrefix+owner, buf.Bytes()); err != nil {
+			return err
+		}
+
+		if !withContent {
+			continue
+		}
+
+		infos, err := fs.List("/", -1)
+		if err != nil {
+			return err
+		}
+
+		for _, info := range infos {
+			b58Hash := info.BackendHash.B58String()
+			if info.IsDir || !info.IsPinned || seen[b58Hash] {
+				continue
+			}
+
+			seen[b58Hash] = true
+			pinned = append(pinned, info.BackendHash)
+		}
+	}
+
+	inherited := []string{}
+	for _, hash := range pinned {
+		if knownBlobs[hash.B58String()] {
+			inherited = append(inherited, hash.B58String())
+			continue
+		}
+
+		if err := b.addBackupBlob(bw, hash); err != nil {
+			return err
+		}
+	}
+
+	bw.SetInheritedBlobs(inherited)
+	return nil
+}
+
+func (b *base) addBackupBlob(bw *backup.Writer, hash h.Hash) error {
+	stream, err := b.backend.Cat(hash)
+	if err != nil {
+		return e.Wrapf(err, "cat %s", hash.B58String())
+	}
+
+	defer stream.Close()
+
+	size, err := stream.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if _, err := stream.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return bw.AddBlob(hash, size, stream)
+}
+
+// restoreBackup restores the backup at `path` into the current repository.
+// The repository has to belong to the same owner as the backup. Keys and
+// the repository ID are only picked up after a restart of the daemon.
+func (b *base) restoreBackup(path, password string) (*backup.Manifest, error) {
+	br, err := backup.Open(path, password)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := br.Manifest()
+	if manifest.Owner != b.repo.Owner {
+		return nil, fmt.Errorf(
+			"backup belongs to `%s`, but this repository to `%s`; init with the same name",
+			manifest.Owner,
+			b.repo.Owner,
+		)
+	}
+
+	// Content of incremental backups might be in an older backup:
+	for _, b58Hash := range manifest.InheritedBlobs {
+		hash, err := h.FromB58String(b58Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		isCached, err := b.backend.IsCached(hash)
+		if err != nil {
+			return nil, err
+		}
+
+		if !isCached {
+			return nil, fmt.Errorf(
+				"content `%s` is missing; restore the backup with the id %s first",
+				b58Hash,
+				manifest.Parent,
+			)
+		}
+	}
+
+	err = br.Walk(func(name string, r io.Reader) error {
+		log.Debugf("restoring %s", name)
+		return b.restoreBackupEntry(name, r)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("restored backup %s with %d blobs", manifest.ID, len(manifest.Blobs))
+	return &manifest, nil
+}
+
+func (b *base) restoreBackupEntry(name string, r io.Reader) error {
+	switch {
+	case strings.HasPrefix(name, backup.BlobPrefix):
+		hash, err := h.FromB58String(strings.TrimPrefix(name, backup.BlobPrefix))
+		if err != nil {
+			return err
+		}
+
+		return b.restoreBackupBlob(hash, r)
+	case strings.HasPrefix(name, backup.MetadataPrefix):
+		owner := strings.TrimPrefix(name, backup.MetadataPrefix)
+		fs, err := b.repo.FS(owner, b.backend)
+		if err != nil {
+			return err
+		}
+
+		return fs.Import(r)
+	case name == backup.UsersName:
+		return b.gateway.UserDatabase().Import(r)
+	case name == backupRemotesName:
+		return b.repo.Remotes.Import(r)
+	case name == backupConfigName:
+		return b.restoreBackupConfig(r)
+	case strings.HasPrefix(name, backup.RepoPrefix):
+		baseName := strings.TrimPrefix(name, backup.RepoPrefix)
+		for _, repoFile := range backupRepoFiles {
+			if repoFile != baseName {
+				continue
+			}
+
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+
+			return ioutil.WriteFile(filepath.Join(b.repo.BaseFolder, baseName), data, 0600)
+		}
+	}
+
+	log.Warningf("backup: ignoring unknown entry `%s`", name)
+	return nil
+}
+
+func (b *base) restoreBackupBlob(hash h.Hash, r io.Reader) error {
+	added, err := b.backend.Add(r)
+	if err != nil {
+		return err
+	}
+
+	if !added.Equal(hash) {
+		return fmt.Errorf("blob `%s` was stored as `%s`", hash.B58String(), added.B58String())
+	}
+
+	return b.backend.Pin(hash)
+}
+
+func (b *base) restoreBackupConfig(r io.Reader) error {
+	backupCfg, err := defaults.MigratedConfigFromReader(r)
+	if err != nil {
+		return err
+	}
+
+	cfg := b.repo.Config
+	local := make(map[string]interface{})
+	for _, key := range backupLocalConfigKeys {
+		local[key] = cfg.Get(key)
+	}
+
+	if err := cfg.Merge(backupCfg); err != nil {
+		return err
+	}
+
+	for key, val := range local {
+		if err := cfg.Set(key, val); err != nil {
+			return err
+		}
+	}
+
+	return b.repo.SaveConfig()
+}
@@ -22,9 +22,11 @@ import (
 	"github.com/sahib/brig/backend"
 	"github.com/sahib/brig/catfs"
 	fserrs "github.com/sahib/brig/catfs/errors"
+	"github.com/sahib/brig/catfs/vcs"
 	"github.com/sahib/brig/events"
 	"github.com/sahib/brig/fuse"
 	"github.com/sahib/brig/gateway"
+	"github.com/sahib/brig/mirror"
 	p2pnet "github.com/sahib/brig/net"
 	ie "github.com/sahib/brig/catfs/errors"
 	"github.com/sahib/brig/net/peer"
@@ -59,6 +61,8 @@ type base struct {
 
 	repo       *repo.Repository
 	mounts     *fuse.MountTable
+	mirrors    *mirror.Table
+	stageIdx   *stageIndex
 	peerServer *p2pnet.Server
 
 	// This the general backend, not a specific submodule one:
@@ -84,6 +88,12 @@ type base struct {
 
 	// pprofPort is the port pprof can acquire profiling from
 	pprofPort int
+
+	// metricsSrv serves /metrics if enabled in the config
+	metricsSrv *http.Server
+
+	// repos are all repositories served by this daemon (including this one)
+	repos *repoRegistry
 }
 
 func repoIsInitialized(path string) error {
@@ -102,6 +112,7 @@ func repoIsInitialized(path string) error {
 // Handle is being called by the base server implementation
 // for every local request that is being served to the brig daemon.
 func (b *base) Handle(ctx context.Context, conn net.Conn) {
+	metricRPCConns.Inc()
 	transport := rpc.StreamTransport(conn)
 	srv := capnp.API_ServerToClient(newAPIHandler(b))
 	rpcConn := rpc.NewConn(
@@ -314,6 +325,35 @@ func (b *base) loadMounts() error {
 	})
 }
 
+func (b *base) loadMirrors() error {
+	fsCfg := b.repo.Config.Section("fs")
+	opts := mirror.Options{
+		Debounce:       fsCfg.Duration("mirror.debounce"),
+		RescanInterval: fsCfg.Duration("mirror.rescan_interval"),
+		Ignore:         fsCfg.Strings("ignore.patterns"),
+	}
+
+	return b.withCurrFs(func(fs *catfs.FS) error {
+		stateDir := filepath.Join(b.repo.BaseFolder, "mirrors")
+		b.mirrors = mirror.NewTable(fs, mountNotifier{b: b}, stateDir, opts)
+		fsCfg.AddEvent("ignore.patterns", func(key string) {
+			b.mirrors.SetIgnore(fsCfg.Strings("ignore.patterns"))
+		})
+
+		return nil
+	})
+}
+
+func (b *base) loadStageIndex() error {
+	stageIdx, err := newStageIndex(filepath.Join(b.repo.BaseFolder, "stage-index"))
+	if err != nil {
+		return err
+	}
+
+	b.stageIdx = stageIdx
+	return nil
+}
+
 /////////
 
 func (b *base) loadAll() error {
@@ -329,6 +369,14 @@ func (b *base) loadAll() error {
 		return err
 	}
 
+	if err := b.loadMirrors(); err != nil {
+		return err
+	}
+
+	if err := b.loadStageIndex(); err != nil {
+		return err
+	}
+
 	if err := b.loadPeerServer(); err != nil {
 		return err
 	}
@@ -338,6 +386,7 @@ func (b *base) loadAll() error {
 	}
 
 	b.loadProfileServer()
+	b.loadMetricsServer()
 	return nil
 }
 
@@ -397,22 +446,54 @@ func (b *base) withNetClient(who string, fn func(ctl *p2pnet.Client) error) erro
 }
 
 func (b *base) Quit() (err error) {
-	log.Info("shutting down brigd due to QUIT command")
+	if b.repos.isPrimary(b) {
+		log.Info("shutting down brigd due to QUIT command")
+		b.repos.closeAdditional()
+	} else {
+		log.Infof("closing repository at %s", b.basePath)
+	}
+
+	if err := b.unload(); err != nil {
+		return err
+	}
+
+	if b.repos.isPrimary(b) {
+		log.Infof("===== brigd can be considered dead now! ====")
+	}
+
+	return nil
+}
 
-	if err := b.gateway.Stop(); err != nil {
-		log.Warningf("could not close gateway: %v", err)
+// unload releases everything that loadAll() loaded and locks the
+// repository again. It also works if loadAll() failed halfway.
+func (b *base) unload() (err error) {
+	if b.gateway != nil {
+		if err := b.gateway.Stop(); err != nil {
+			log.Warningf("could not close gateway: %v", err)
+		}
+
+		if err := b.gateway.Close(); err != nil {
+			log.Warningf("could not shut down gateway: %v", err)
+		}
 	}
 
-	if err := b.gateway.Close(); err != nil {
-		log.Warningf("could not shut down gateway: %v", err)
+	if b.metricsSrv != nil {
+		if err := b.metricsSrv.Close(); err != nil {
+			log.Warningf("could not close metrics server: %v", err)
+		}
 	}
 
-	log.Infof("closing peer server...")
-	if err = b.peerServer.Close(); err != nil {
-		log.Warningf("failed to close peer server: %v", err)
+	if b.peerServer != nil {
+		log.Infof("closing peer server...")
+		if err = b.peerServer.Close(); err != nil {
+			log.Warningf("failed to close peer server: %v", err)
+		}
+	}
+
+	if b.evListenerCancel != nil {
+		b.evListenerCancel()
 	}
 
-	b.evListenerCancel()
 	log.Infof("shutting down event listener...")
 	if b.evListener != nil {
 		if err := b.evListener.Close(); err != nil {
@@ -420,18 +501,33 @@ func (b *base) Quit() (err error) {
 		}
 	}
 
-	log.Infof("trying to lock repository...")
+	if b.mirrors != nil {
+		log.Infof("stopping mirrors...")
+		if err := b.mirrors.Close(); err != nil {
+			log.Warningf("failed to stop mirrors: %v", err)
+		}
+	}
 
-	if err = b.repo.Close(b.password); err != nil {
-		log.Warningf("failed to lock repository: %v", err)
+	if b.stageIdx != nil {
+		if err := b.stageIdx.close(); err != nil {
+			log.Warningf("failed to close stage index: %v", err)
+		}
 	}
 
-	log.Infof("trying to unmount any mounts...")
-	if err := b.mounts.Close(); err != nil {
-		return err
+	if b.repo != nil {
+		log.Infof("trying to lock repository...")
+		if err = b.repo.Close(b.password); err != nil {
+			log.Warningf("failed to lock repository: %v", err)
+		}
+	}
+
+	if b.mounts != nil {
+		log.Infof("trying to unmount any mounts...")
+		if err := b.mounts.Close(); err != nil {
+			return err
+		}
 	}
 
-	log.Infof("===== brigd can be considered dead now! ====")
 	return nil
 }
 
@@ -508,7 +604,9 @@ func (b *base) doFetch(who string) error {
 	})
 }
 
-func (b *base) doSync(withWhom string, needFetch bool, msg string) (*catfs.Diff, error) {
+// doSync merges the state of `withWhom` into ours. If `branch` is not empty,
+// the tip of this branch of `withWhom` is used instead of its current state.
+func (b *base) doSync(withWhom string, needFetch bool, msg, branch string) (*catfs.Diff, error) {
 	if needFetch {
 		if err := b.doFetch(withWhom); err != nil {
 			return nil, e.Wrapf(err, "fetch")
@@ -544,6 +642,7 @@ func (b *base) doSync(withWhom string, needFetch bool, msg string) (*catfs.Diff,
 				catfs.SyncOptConflictStrategy(rmt.ConflictStrategy),
 				catfs.SyncOptReadOnlyFolders(rmt.ReadOnlyFolders()),
 				catfs.SyncOptConflictgStrategyPerFolder(rmt.ConflictStrategyPerFolder()),
+				catfs.SyncOptBranch(branch),
 			)
 
 			if err != nil {
@@ -557,6 +656,9 @@ func (b *base) doSync(withWhom string, needFetch bool, msg string) (*catfs.Diff,
 				return err
 			}
 
+			// Write what we got to the mirrored directories:
+			b.mirrors.Pull()
+
 			diff, err = ownFs.MakeDiff(ownFs, cmtBefore, cmtAfter)
 			return err
 		})
@@ -577,12 +679,43 @@ func (b *base) handleFsEvent(ev *events.Event) {
 	log.Infof("doing sync with »%s« since we received an update notification.", rmt.Name)
 
 	msg := fmt.Sprintf("sync due to notification from »%s«", rmt.Name)
-	if _, err := b.doSync(rmt.Name, true, msg); err != nil {
+	if _, err := b.doSync(rmt.Name, true, msg, ""); err != nil {
 		log.Warningf("sync failed: %v", err)
 	}
 }
 
-func (b *base) notifyFsChangeEvent() {
+// notifyFsChangeEvent tells other peers that our filesystem changed.
+// `changes` may describe what paths were affected; if it is empty,
+// other peers have to assume that anything might have changed.
+func (b *base) notifyFsChangeEvent(changes ...events.Change) {
+	b.publishFsEvent(events.Event{
+		Type:    events.FsEvent,
+		Changes: changes,
+	})
+}
+
+// notifyCommitEvent tells other peers about the commit at `rev` in `fs`.
+func (b *base) notifyCommitEvent(fs *catfs.FS, rev string) {
+	cmt, err := fs.CommitInfo(rev)
+	if err != nil || cmt == nil {
+		log.Warningf("failed to get info about commit %s: %v", rev, err)
+		return
+	}
+
+	masks, err := fs.ChangedPaths(rev)
+	if err != nil {
+		log.Warningf("failed to get changes of commit %s: %v", rev, err)
+		return
+	}
+
+	b.publishFsEvent(events.Event{
+		Type:       events.FsEvent,
+		Changes:    events.ChangesFromMasks(masks),
+		CommitHash: cmt.Hash.B58String(),
+	})
+}
+
+func (b *base) publishFsEvent(ev events.Event) {
 	if b.evListener == nil {
 		return
 	}
@@ -592,15 +725,16 @@ func (b *base) notifyFsChangeEvent() {
 		return
 	}
 
-	ev := events.Event{
-		Type: events.FsEvent,
-	}
-
 	if err := b.evListener.PublishEvent(ev); err != nil {
 		log.Warningf("failed to publish filesystem change event: %v", err)
 	}
 }
 
+// modifiedChange is a shortcut for a change event that modified `nodePath`.
+func modifiedChange(nodePath string) events.Change {
+	return events.Change{Path: nodePath, Mask: vcs.ChangeTypeModify}
+}
+
 func (b *base) initialSyncWithAutoUpdatePeers() error {
 	rmts, err := b.repo.Remotes.ListRemotes()
 	if err != nil {
@@ -613,7 +747,7 @@ func (b *base) initialSyncWithAutoUpdatePeers() error {
 		}
 
 		msg := fmt.Sprintf("sync with »%s« due to initial auto-update", rmt.Name)
-		if _, err := b.doSync(rmt.Name, true, msg); err != nil {
+		if _, err := b.doSync(rmt.Name, true, msg, ""); err != nil {
 			log.Warningf("failed to sync initially with %s: %v", rmt.Name, err)
 		}
 	}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	e "github.com/pkg/errors"
+	"github.com/sahib/brig/catfs"
+	"github.com/sahib/brig/repo"
+	"github.com/sahib/brig/repo/bundle"
+	h "github.com/sahib/brig/util/hashlib"
+	log "github.com/sirupsen/logrus"
+)
+
+// createBundle writes all changes since `since` that are visible to
+// `remoteName` to a bundle at `path`. If `withContent` is true, the
+// (encrypted) content of all added or modified files is included.
+func (b *base) createBundle(path, remoteName, since string, withContent bool) (*bundle.Manifest, error) {
+	rmt, err := b.repo.Remotes.Remote(remoteName)
+	if err != nil {
+		return nil, err
+	}
+
+	if since == "" {
+		since = "commit[0]"
+	}
+
+	// Only include what the remote is allowed to see:
+	prefixes := []string{}
+	for _, folder := range rmt.Folders {
+		prefixes = append(prefixes, folder.Folder)
+	}
+
+	fs, err := b.repo.FS(b.repo.Owner, b.backend)
+	if err != nil {
+		return nil, err
+	}
+
+	patch, err := fs.MakePatch(since, prefixes, rmt.Name)
+	if err != nil {
+		return nil, e.Wrapf(err, "make-patch")
+	}
+
+	pubKey, err := b.repo.Keyring().OwnPubKey()
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600) // #nosec
+	if err != nil {
+		return nil, err
+	}
+
+	bw := bundle.NewWriter(fd, bundle.Manifest{
+		Owner:   b.repo.Owner,
+		Remote:  rmt.Name,
+		PubKey:  pubKey,
+		Created: time.Now(),
+		Since:   since,
+	}, b.repo.Keyring().Sign)
+
+	bw.SetPatch(patch)
+
+	if withContent {
+		if err := b.addBundleBlobs(bw, fs, patch); err != nil {
+			fd.Close()
+			os.Remove(path)
+			return nil, err
+		}
+	}
+
+	if err := bw.Close(); err != nil {
+		fd.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	manifest := bw.Manifest()
+	return &manifest, fd.Close()
+}
+
+func (b *base) addBundleBlobs(bw *bundle.Writer, fs *catfs.FS, patch []byte) error {
+	hashes, err := fs.PatchBackendHashes(patch)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		stream, err := b.backend.Cat(hash)
+		if err != nil {
+			return e.Wrapf(err, "cat %s", hash.B58String())
+		}
+
+		err = bw.AddBlob(hash, stream)
+		stream.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findBundleRemote returns the remote that created the bundle.
+// The public key in the manifest has to match the remote's fingerprint.
+func (b *base) findBundleRemote(manifest bundle.Manifest) (*repo.Remote, error) {
+	remotes, err := b.repo.Remotes.ListRemotes()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rmt := range remotes {
+		if rmt.Name != manifest.Owner {
+			continue
+		}
+
+		if !rmt.Fingerprint.PubKeyMatches(manifest.PubKey) {
+			return nil, fmt.Errorf(
+				"bundle was signed with a key that does not belong to `%s`",
+				rmt.Name,
+			)
+		}
+
+		return &rmt, nil
+	}
+
+	return nil, fmt.Errorf("bundle is from `%s`, who is not a remote", manifest.Owner)
+}
+
+// applyBundle verifies and imports the bundle at `path`. The patch is
+// applied to our copy of the creator's metadata. Unless `noSync` is true,
+// it is then synced into our own state like a regular sync would.
+func (b *base) applyBundle(path string, noSync bool) (*bundle.Manifest, *catfs.Diff, error) {
+	br, err := bundle.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer br.Close()
+
+	manifest := br.Manifest()
+	rmt, err := b.findBundleRemote(manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := br.Verify(repo.VerifySignature); err != nil {
+		return nil, nil, err
+	}
+
+	patch, err := br.Patch()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes, err := br.Blobs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, hash := range hashes {
+		if err := b.addBundleBlob(br, hash); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = b.withRemoteFs(rmt.Name, func(remoteFs *catfs.FS) error {
+		return remoteFs.ApplyPatch(patch)
+	})
+
+	if err != nil {
+		return nil, nil, e.Wrapf(err, "apply-patch")
+	}
+
+	log.Infof("applied bundle from %s with %d blobs", rmt.Name, len(hashes))
+	if noSync {
+		return &manifest, nil, nil
+	}
+
+	msg := fmt.Sprintf("sync with bundle from %s", rmt.Name)
+	diff, err := b.doSync(rmt.Name, false, msg, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &manifest, diff, nil
+}
+
+// hashBundleBlob returns the hash the backend would store the blob `hash` under.
+func (b *base) hashBundleBlob(br *bundle.Reader, hash h.Hash) (h.Hash, error) {
+	fd, err := br.OpenBlob(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	return b.backend.HashOf(fd)
+}
+
+func (b *base) addBundleBlob(br *bundle.Reader, hash h.Hash) error {
+	// The backend should come up with the same hash, otherwise
+	// the metadata would point to nothing. Check this before
+	// anything is stored, so no foreign blob is left behind.
+	expected, err := b.hashBundleBlob(br, hash)
+	if err != nil {
+		return err
+	}
+
+	if !expected.Equal(hash) {
+		return fmt.Errorf("blob `%s` would be stored as `%s`", hash.B58String(), expected.B58String())
+	}
+
+	fd, err := br.OpenBlob(hash)
+	if err != nil {
+		return err
+	}
+
+	defer fd.Close()
+
+	added, err := b.backend.Add(fd)
+	if err != nil {
+		return err
+	}
+
+	if !added.Equal(hash) {
+		return fmt.Errorf("blob `%s` was stored as `%s`", hash.B58String(), added.B58String())
+	}
+
+	return nil
+}
@@ -3,11 +3,14 @@ package server
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
 
 	"github.com/sahib/brig/catfs"
 	ie "github.com/sahib/brig/catfs/errors"
+	"github.com/sahib/brig/catfs/vcs"
+	"github.com/sahib/brig/events"
 	"github.com/sahib/brig/server/capnp"
 	log "github.com/sirupsen/logrus"
 	capnplib "zombiezen.com/go/capnproto2"
@@ -18,6 +21,16 @@ type fsHandler struct {
 	base *base
 }
 
+// addedOrModifiedChange should be called before `nodePath` is written to.
+// It returns a change that tells if the node is new or was already there.
+func addedOrModifiedChange(fs *catfs.FS, nodePath string) events.Change {
+	if _, err := fs.Stat(nodePath); ie.IsNoSuchFileError(err) {
+		return events.Change{Path: nodePath, Mask: vcs.ChangeTypeAdd}
+	}
+
+	return modifiedChange(nodePath)
+}
+
 func statToCapnp(info *catfs.StatInfo, seg *capnplib.Segment) (*capnp.StatInfo, error) {
 	capInfo, err := capnp.NewStatInfo(seg)
 	if err != nil {
@@ -76,10 +89,15 @@ func (fh *fsHandler) List(call capnp.FS_list) error {
 		return err
 	}
 
+	rev, err := call.Params.Rev()
+	if err != nil {
+		return err
+	}
+
 	maxDepth := call.Params.MaxDepth()
 
 	return fh.base.withFsFromPath(root, func(url *URL, fs *catfs.FS) error {
-		entries, err := fs.List(url.Path, int(maxDepth))
+		entries, err := fs.ListAt(rev, url.Path, int(maxDepth))
 		if err != nil {
 			return err
 		}
@@ -108,6 +126,25 @@ func (fh *fsHandler) List(call capnp.FS_list) error {
 	})
 }
 
+// stage stages the contents of `r` to `url.Path` in `fs`. If `source` is
+// not empty, the local file described by `entry` is remembered as staged
+// from `source`, so it can be skipped next time if it did not change.
+func (fh *fsHandler) stage(fs *catfs.FS, url *URL, r io.ReadSeeker, source string, entry *stageIndexEntry) error {
+	change := addedOrModifiedChange(fs, url.Path)
+	if err := fs.Stage(url.Path, r); err != nil {
+		return err
+	}
+
+	if source != "" {
+		if err := fh.base.stageIdx.remember(fs, source, entry); err != nil {
+			return err
+		}
+	}
+
+	fh.base.notifyFsChangeEvent(change)
+	return nil
+}
+
 func (fh *fsHandler) Stage(call capnp.FS_stage) error {
 	server.Ack(call.Options)
 
@@ -121,6 +158,12 @@ func (fh *fsHandler) Stage(call capnp.FS_stage) error {
 		return err
 	}
 
+	// If a source is given, unchanged files are skipped.
+	source, err := call.Params.Source()
+	if err != nil {
+		return err
+	}
+
 	return fh.base.withFsFromPath(repoPath, func(url *URL, fs *catfs.FS) error {
 		fd, err := os.Open(localPath) // #nosec
 		if err != nil {
@@ -129,12 +172,182 @@ func (fh *fsHandler) Stage(call capnp.FS_stage) error {
 
 		defer fd.Close()
 
-		if err := fs.Stage(url.Path, fd); err != nil {
+		info, err := fd.Stat()
+		if err != nil {
 			return err
 		}
 
-		fh.base.notifyFsChangeEvent()
-		return nil
+		entry := localEntryOf(info, localPath, url.Path)
+		if source != "" {
+			isUnchanged, err := fh.base.stageIdx.isUnchanged(fs, source, entry)
+			if err != nil {
+				return err
+			}
+
+			if isUnchanged {
+				call.Results.SetSkipped(true)
+				return nil
+			}
+		}
+
+		return fh.stage(fs, url, fd, source, entry)
+	})
+}
+
+// StageStream is like Stage, but the content is not read from a path the
+// daemon can see. Instead the client writes the returned token and then
+// the content to the returned port and closes its writing side. The daemon answers with an error message
+// (empty on success) and closes the connection.
+func (fh *fsHandler) StageStream(call capnp.FS_stageStream) error {
+	server.Ack(call.Options)
+
+	repoPath, err := call.Params.RepoPath()
+	if err != nil {
+		return err
+	}
+
+	source, err := call.Params.Source()
+	if err != nil {
+		return err
+	}
+
+	capLocal, err := call.Params.Local()
+	if err != nil {
+		return err
+	}
+
+	localPath, err := capLocal.Path()
+	if err != nil {
+		return err
+	}
+
+	return fh.base.withFsFromPath(repoPath, func(url *URL, fs *catfs.FS) error {
+		entry := &stageIndexEntry{
+			LocalPath: localPath,
+			RepoPath:  url.Path,
+			Size:      capLocal.Size(),
+			ModTime:   capLocal.ModTime(),
+			Inode:     capLocal.Inode(),
+		}
+
+		if source != "" {
+			isUnchanged, err := fh.base.stageIdx.isUnchanged(fs, source, entry)
+			if err != nil {
+				return err
+			}
+
+			if isUnchanged {
+				call.Results.SetSkipped(true)
+				return nil
+			}
+		}
+
+		token, err := newTransferToken()
+		if err != nil {
+			return err
+		}
+
+		port, err := bootTransferServer(fs, fh.base.bindHost, token, func(conn net.Conn) {
+			msg := ""
+			if err := fh.stageFromConn(fs, url, conn, source, entry); err != nil {
+				log.Warningf("failed to stage %s from stream: %v", url.Path, err)
+				msg = err.Error()
+			}
+
+			if _, err := conn.Write([]byte(msg)); err != nil {
+				log.Warningf("failed to report staging result of %s: %v", url.Path, err)
+			}
+		}, nil)
+
+		if err != nil {
+			return err
+		}
+
+		call.Results.SetPort(int32(port))
+		return call.Results.SetToken(token)
+	})
+}
+
+// stageFromConn reads everything from `conn` and stages it.
+// Staging needs to read the content twice, so it is buffered
+// in a temporary file first.
+func (fh *fsHandler) stageFromConn(fs *catfs.FS, url *URL, conn net.Conn, source string, entry *stageIndexEntry) error {
+	tmpFd, err := ioutil.TempFile("", "brig-stage-stream")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmpFd.Name())
+	defer tmpFd.Close()
+
+	size, err := io.Copy(tmpFd, conn)
+	if err != nil {
+		return err
+	}
+
+	// A negative size means the client does not know it in advance.
+	if entry.Size >= 0 && size != entry.Size {
+		return fmt.Errorf("expected %d bytes, but got %d", entry.Size, size)
+	}
+
+	if _, err := tmpFd.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return fh.stage(fs, url, tmpFd, source, entry)
+}
+
+func (fh *fsHandler) StageRemoveMissing(call capnp.FS_stageRemoveMissing) error {
+	server.Ack(call.Options)
+
+	source, err := call.Params.Source()
+	if err != nil {
+		return err
+	}
+
+	capExisting, err := call.Params.Existing()
+	if err != nil {
+		return err
+	}
+
+	existing := []string{}
+	for idx := 0; idx < capExisting.Len(); idx++ {
+		localPath, err := capExisting.At(idx)
+		if err != nil {
+			return err
+		}
+
+		existing = append(existing, localPath)
+	}
+
+	return fh.base.withCurrFs(func(fs *catfs.FS) error {
+		removed, err := fh.base.stageIdx.removeMissing(fs, source, existing)
+		if len(removed) > 0 {
+			changes := []events.Change{}
+			for _, path := range removed {
+				changes = append(changes, events.Change{Path: path, Mask: vcs.ChangeTypeRemove})
+			}
+
+			fh.base.notifyFsChangeEvent(changes...)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		seg := call.Results.Segment()
+		capRemoved, err := capnplib.NewTextList(seg, int32(len(removed)))
+		if err != nil {
+			return err
+		}
+
+		for idx, path := range removed {
+			if err := capRemoved.Set(idx, path); err != nil {
+				return err
+			}
+		}
+
+		return call.Results.SetRemoved(capRemoved)
 	})
 }
 
@@ -146,9 +359,14 @@ func (fh *fsHandler) Cat(call capnp.FS_cat) error {
 		return err
 	}
 
+	rev, err := call.Params.Rev()
+	if err != nil {
+		return err
+	}
+
 	return fh.base.withFsFromPath(path, func(url *URL, fs *catfs.FS) error {
 		if call.Params.Offline() {
-			isCached, err := fs.IsCached(url.Path)
+			isCached, err := fs.IsCachedAt(rev, url.Path)
 			if err != nil {
 				return err
 			}
@@ -158,12 +376,12 @@ func (fh *fsHandler) Cat(call capnp.FS_cat) error {
 			}
 		}
 
-		stream, err := fs.Cat(url.Path)
+		stream, err := fs.CatAt(rev, url.Path)
 		if err != nil {
 			return err
 		}
 
-		port, err := bootTransferServer(fs, fh.base.bindHost, func(conn net.Conn) {
+		port, err := bootTransferServer(fs, fh.base.bindHost, nil, func(conn net.Conn) {
 			defer stream.Close()
 			localAddr := conn.LocalAddr().String()
 
@@ -174,6 +392,8 @@ func (fh *fsHandler) Cat(call capnp.FS_cat) error {
 			}
 
 			log.Infof("Wrote %d bytes of `%s` over %s", n, path, localAddr)
+		}, func() {
+			stream.Close()
 		})
 
 		if err != nil {
@@ -211,12 +431,12 @@ func (fh *fsHandler) Tar(call capnp.FS_tar) error {
 			return err
 		}
 
-		port, err := bootTransferServer(fs, fh.base.bindHost, func(conn net.Conn) {
+		port, err := bootTransferServer(fs, fh.base.bindHost, nil, func(conn net.Conn) {
 			localAddr := conn.LocalAddr().String()
 			if err := fs.Tar(path, conn, nil); err != nil {
 				log.Warningf("tar failed for path %s on %s: %v", path, localAddr, err)
 			}
-		})
+		}, nil)
 
 		call.Results.SetPort(int32(port))
 		return err
@@ -237,7 +457,7 @@ func (fh *fsHandler) Mkdir(call capnp.FS_mkdir) error {
 			return err
 		}
 
-		fh.base.notifyFsChangeEvent()
+		fh.base.notifyFsChangeEvent(events.Change{Path: url.Path, Mask: vcs.ChangeTypeAdd})
 		return nil
 	})
 }
@@ -255,7 +475,7 @@ func (fh *fsHandler) Remove(call capnp.FS_remove) error {
 			return err
 		}
 
-		fh.base.notifyFsChangeEvent()
+		fh.base.notifyFsChangeEvent(events.Change{Path: url.Path, Mask: vcs.ChangeTypeRemove})
 		return nil
 	})
 }
@@ -287,7 +507,10 @@ func (fh *fsHandler) Move(call capnp.FS_move) error {
 			return err
 		}
 
-		fh.base.notifyFsChangeEvent()
+		fh.base.notifyFsChangeEvent(
+			events.Change{Path: srcUrl.Path, Mask: vcs.ChangeTypeMove | vcs.ChangeTypeRemove},
+			events.Change{Path: dstURL.Path, Mask: vcs.ChangeTypeMove},
+		)
 		return nil
 	})
 }
@@ -319,7 +542,7 @@ func (fh *fsHandler) Copy(call capnp.FS_copy) error {
 			return err
 		}
 
-		fh.base.notifyFsChangeEvent()
+		fh.base.notifyFsChangeEvent(events.Change{Path: dstURL.Path, Mask: vcs.ChangeTypeAdd})
 		return nil
 	})
 }
@@ -441,6 +664,56 @@ func (fh *fsHandler) GarbageCollect(call capnp.FS_garbageCollect) error {
 	return call.Results.SetFreed(freed)
 }
 
+func (fh *fsHandler) Fsck(call capnp.FS_fsck) error {
+	server.Ack(call.Options)
+
+	repair := call.Params.Repair()
+	checkContent := call.Params.CheckContent()
+
+	return fh.base.withCurrFs(func(fs *catfs.FS) error {
+		problems, err := fs.Fsck(repair, checkContent)
+		if err != nil {
+			return err
+		}
+
+		seg := call.Results.Segment()
+		capProblems, err := capnp.NewFsckProblem_List(seg, int32(len(problems)))
+		if err != nil {
+			return err
+		}
+
+		for idx, problem := range problems {
+			capProblem, err := capnp.NewFsckProblem(seg)
+			if err != nil {
+				return err
+			}
+
+			if err := capProblem.SetKind(problem.Kind); err != nil {
+				return err
+			}
+
+			if err := capProblem.SetPath(problem.Path); err != nil {
+				return err
+			}
+
+			if err := capProblem.SetCommit(problem.Commit); err != nil {
+				return err
+			}
+
+			if err := capProblem.SetMessage(problem.Message); err != nil {
+				return err
+			}
+
+			capProblem.SetRepaired(problem.Repaired)
+			if err := capProblems.Set(idx, capProblem); err != nil {
+				return err
+			}
+		}
+
+		return call.Results.SetProblems(capProblems)
+	})
+}
+
 func (fh *fsHandler) Touch(call capnp.FS_touch) error {
 	path, err := call.Params.Path()
 	if err != nil {
@@ -448,11 +721,12 @@ func (fh *fsHandler) Touch(call capnp.FS_touch) error {
 	}
 
 	return fh.base.withFsFromPath(path, func(url *URL, fs *catfs.FS) error {
+		change := addedOrModifiedChange(fs, url.Path)
 		if err := fs.Touch(url.Path); err != nil {
 			return err
 		}
 
-		fh.base.notifyFsChangeEvent()
+		fh.base.notifyFsChangeEvent(change)
 		return nil
 	})
 }
@@ -533,8 +807,13 @@ func (fh *fsHandler) IsCached(call capnp.FS_isCached) error {
 		return err
 	}
 
+	rev, err := call.Params.Rev()
+	if err != nil {
+		return err
+	}
+
 	return fh.base.withFsFromPath(path, func(url *URL, fs *catfs.FS) error {
-		isCached, err := fs.IsCached(url.Path)
+		isCached, err := fs.IsCachedAt(rev, url.Path)
 		if err != nil {
 			return err
 		}
@@ -543,3 +822,200 @@ func (fh *fsHandler) IsCached(call capnp.FS_isCached) error {
 		return nil
 	})
 }
+
+func (fh *fsHandler) Find(call capnp.FS_find) error {
+	server.Ack(call.Options)
+
+	root, err := call.Params.Root()
+	if err != nil {
+		return err
+	}
+
+	query, err := call.Params.Query()
+	if err != nil {
+		return err
+	}
+
+	rev, err := call.Params.Rev()
+	if err != nil {
+		return err
+	}
+
+	opts := catfs.SearchOptions{
+		Rev:     rev,
+		History: call.Params.History(),
+	}
+
+	return fh.base.withFsFromPath(root, func(url *URL, fs *catfs.FS) error {
+		results, err := fs.Search(url.Path, query, opts)
+		if err != nil {
+			return err
+		}
+
+		seg := call.Results.Segment()
+		lst, err := capnp.NewFindResult_List(seg, int32(len(results)))
+		if err != nil {
+			return err
+		}
+
+		for idx, result := range results {
+			capResult, err := findResultToCapnp(result, seg)
+			if err != nil {
+				return err
+			}
+
+			if err := lst.Set(idx, *capResult); err != nil {
+				return err
+			}
+		}
+
+		return call.Results.SetResults(lst)
+	})
+}
+
+func findResultToCapnp(result *catfs.SearchResult, seg *capnplib.Segment) (*capnp.FindResult, error) {
+	capResult, err := capnp.NewFindResult(seg)
+	if err != nil {
+		return nil, err
+	}
+
+	capInfo, err := statToCapnp(result.StatInfo, seg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := capResult.SetInfo(*capInfo); err != nil {
+		return nil, err
+	}
+
+	if err := capResult.SetCommit(result.Commit); err != nil {
+		return nil, err
+	}
+
+	return &capResult, nil
+}
+
+func (fh *fsHandler) Dupes(call capnp.FS_dupes) error {
+	server.Ack(call.Options)
+
+	root, err := call.Params.Root()
+	if err != nil {
+		return err
+	}
+
+	opts := catfs.SearchOptions{History: call.Params.History()}
+	return fh.base.withFsFromPath(root, func(url *URL, fs *catfs.FS) error {
+		groups, err := fs.Dupes(url.Path, opts)
+		if err != nil {
+			return err
+		}
+
+		seg := call.Results.Segment()
+		capGroups, err := capnp.NewDupeGroup_List(seg, int32(len(groups)))
+		if err != nil {
+			return err
+		}
+
+		for idx, group := range groups {
+			capGroup, err := capnp.NewDupeGroup(seg)
+			if err != nil {
+				return err
+			}
+
+			if err := capGroup.SetContentHash(group.ContentHash); err != nil {
+				return err
+			}
+
+			capGroup.SetSize(group.Size)
+			capGroup.SetBlobs(int32(group.Blobs))
+			capGroup.SetWastedBytes(group.WastedBytes)
+			capGroup.SetCachedBytes(group.CachedBytes)
+
+			capFiles, err := capnp.NewFindResult_List(seg, int32(len(group.Files)))
+			if err != nil {
+				return err
+			}
+
+			for fileIdx, file := range group.Files {
+				capFile, err := findResultToCapnp(file, seg)
+				if err != nil {
+					return err
+				}
+
+				if err := capFiles.Set(fileIdx, *capFile); err != nil {
+					return err
+				}
+			}
+
+			if err := capGroup.SetFiles(capFiles); err != nil {
+				return err
+			}
+
+			if err := capGroups.Set(idx, capGroup); err != nil {
+				return err
+			}
+		}
+
+		return call.Results.SetGroups(capGroups)
+	})
+}
+
+func (fh *fsHandler) Dedupe(call capnp.FS_dedupe) error {
+	server.Ack(call.Options)
+
+	root, err := call.Params.Root()
+	if err != nil {
+		return err
+	}
+
+	modeName, err := call.Params.Mode()
+	if err != nil {
+		return err
+	}
+
+	var mode catfs.DedupeMode
+	switch modeName {
+	case "link":
+		mode = catfs.DedupeLink
+	case "unpin":
+		mode = catfs.DedupeUnpin
+	default:
+		return fmt.Errorf("unknown dedupe mode: %s", modeName)
+	}
+
+	dryRun := call.Params.DryRun()
+	return fh.base.withFsFromPath(root, func(url *URL, fs *catfs.FS) error {
+		changes, err := fs.Dedupe(url.Path, mode, dryRun)
+		if err != nil {
+			return err
+		}
+
+		seg := call.Results.Segment()
+		capChanges, err := capnp.NewDedupeChange_List(seg, int32(len(changes)))
+		if err != nil {
+			return err
+		}
+
+		for idx, change := range changes {
+			capChange, err := capnp.NewDedupeChange(seg)
+			if err != nil {
+				return err
+			}
+
+			if err := capChange.SetPath(change.Path); err != nil {
+				return err
+			}
+
+			if err := capChange.SetKeptPath(change.KeptPath); err != nil {
+				return err
+			}
+
+			capChange.SetFreedBytes(change.FreedBytes)
+			if err := capChanges.Set(idx, capChange); err != nil {
+				return err
+			}
+		}
+
+		return call.Results.SetChanges(capChanges)
+	})
+}
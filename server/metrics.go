@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	metricStartTime = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "brig_daemon_start_time_seconds",
+		Help: "Unix timestamp of when the daemon was started.",
+	})
+
+	metricRPCConns = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "brig_daemon_rpc_connections_total",
+		Help: "Number of local RPC connections served by the daemon.",
+	})
+)
+
+func init() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "brig_daemon_goroutines",
+		Help: "Number of currently running go routines.",
+	}, func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+}
+
+// loadMetricsServer starts a HTTP server that exports all
+// metrics in the Prometheus format on /metrics, if enabled.
+func (b *base) loadMetricsServer() {
+	metricStartTime.Set(float64(time.Now().Unix()))
+
+	if !b.repo.Config.Bool("daemon.metrics.enabled") {
+		log.Debugf("not loading metrics server; not enabled in config")
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	addr := b.repo.Config.String("daemon.metrics.address")
+	b.metricsSrv = &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      10 * time.Second,
+	}
+
+	log.Infof("starting metrics server on %s", addr)
+	go func() {
+		if err := b.metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Warningf("failed to serve metrics: %v", err)
+		}
+	}()
+}
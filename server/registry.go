@@ -0,0 +1,197 @@
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/sahib/brig/repo"
+	log "github.com/sirupsen/logrus"
+)
+
+// repoRegistry keeps track of all repositories served by one daemon.
+// The repository the daemon was started with is the primary one; all
+// others are loaded on demand and live until they are closed or the
+// primary one quits.
+type repoRegistry struct {
+	mu      sync.Mutex
+	primary *base
+	bases   map[string]*base
+
+	// closed is closed once the respective repository was closed.
+	closed map[string]chan struct{}
+}
+
+func newRepoRegistry(primary *base) (*repoRegistry, error) {
+	absPath, err := filepath.Abs(primary.basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	primary.basePath = absPath
+	rr := &repoRegistry{
+		primary: primary,
+		bases:   map[string]*base{absPath: primary},
+		closed:  make(map[string]chan struct{}),
+	}
+
+	primary.repos = rr
+	return rr, nil
+}
+
+func (rr *repoRegistry) isPrimary(b *base) bool {
+	return rr.primary == b
+}
+
+// open returns the base for the repository at `path` and loads it if
+// necessary. `password` is only used when the repository is not loaded
+// yet and has no password command configured.
+func (rr *repoRegistry) open(path, password string) (*base, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if b, ok := rr.bases[absPath]; ok {
+		return b, nil
+	}
+
+	if err := repoIsInitialized(absPath); err != nil {
+		return nil, fmt.Errorf("no repository at %s: %v", absPath, err)
+	}
+
+	password, err = readPasswordFromHelper(absPath, func() (string, error) {
+		if password == "" {
+			return "", fmt.Errorf("need a password for %s", absPath)
+		}
+
+		return password, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := repo.CheckPassword(absPath, password); err != nil {
+		return nil, err
+	}
+
+	log.Infof("loading additional repository at %s", absPath)
+	prim := rr.primary
+	// Buffered, so that quitting an already closed repository does not block.
+	quitCh := make(chan struct{}, 1)
+	b := newBase(
+		prim.ctx,
+		prim.port,
+		absPath,
+		password,
+		prim.bindHost,
+		quitCh,
+		prim.logToStdout,
+	)
+
+	b.repos = rr
+	if err := b.loadAll(); err != nil {
+		// Release whatever was loaded, so the repository can be opened again:
+		if unloadErr := b.unload(); unloadErr != nil {
+			log.Warnf("failed to unload %s: %v", absPath, unloadErr)
+		}
+
+		return nil, err
+	}
+
+	if err := applyFstabInitially(b); err != nil {
+		log.Warnf("could not mount fstab mounts of %s: %v", absPath, err)
+	}
+
+	if err := applyMirrorsInitially(b); err != nil {
+		log.Warnf("could not start mirrors of %s: %v", absPath, err)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		// Quitting an additional repository only closes that one.
+		select {
+		case <-quitCh:
+			if err := rr.close(absPath); err != nil {
+				log.Warnf("failed to close %s: %v", absPath, err)
+			}
+		case <-closed:
+		}
+	}()
+
+	rr.bases[absPath] = b
+	rr.closed[absPath] = closed
+	return b, nil
+}
+
+// close closes the additional repository at `path`.
+func (rr *repoRegistry) close(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	b, ok := rr.bases[absPath]
+	if !ok {
+		return fmt.Errorf("repository %s is not loaded", absPath)
+	}
+
+	if rr.isPrimary(b) {
+		return fmt.Errorf("%s is the primary repository; use `brig daemon quit`", absPath)
+	}
+
+	return rr.remove(absPath, b)
+}
+
+// remove quits `b` and forgets about it. rr.mu must be held.
+func (rr *repoRegistry) remove(path string, b *base) error {
+	delete(rr.bases, path)
+	close(rr.closed[path])
+	delete(rr.closed, path)
+	return b.Quit()
+}
+
+// closeAdditional closes all repositories except the primary one.
+func (rr *repoRegistry) closeAdditional() {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	for path, b := range rr.bases {
+		if rr.isPrimary(b) {
+			continue
+		}
+
+		if err := rr.remove(path, b); err != nil {
+			log.Warnf("failed to close %s: %v", path, err)
+		}
+	}
+}
+
+// list returns all loaded repositories, the primary one first.
+func (rr *repoRegistry) list() []*base {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	bases := []*base{}
+	for _, b := range rr.bases {
+		bases = append(bases, b)
+	}
+
+	sort.Slice(bases, func(i, j int) bool {
+		if rr.isPrimary(bases[i]) != rr.isPrimary(bases[j]) {
+			return rr.isPrimary(bases[i])
+		}
+
+		return bases[i].basePath < bases[j].basePath
+	})
+
+	return bases
+}
@@ -145,7 +145,7 @@ func (a *RemotesAPI) Self() (remotesapi.Identity, error) {
 // Sync synchronizes the latest state of `name` with our latest state.
 func (a *RemotesAPI) Sync(name string) error {
 	msg := fmt.Sprintf("sync with »%s« from gateway", name)
-	_, err := a.base.doSync(name, true, msg)
+	_, err := a.base.doSync(name, true, msg, "")
 	return err
 }
 
@@ -3,11 +3,16 @@ package server
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/sahib/brig/backend"
+	"github.com/sahib/brig/catfs"
 	"github.com/sahib/brig/fuse"
+	"github.com/sahib/brig/gateway/audit"
 	gwdb "github.com/sahib/brig/gateway/db"
 	gwcapnp "github.com/sahib/brig/gateway/db/capnp"
+	"github.com/sahib/brig/mirror"
+	"github.com/sahib/brig/repo/backup"
 	"github.com/sahib/brig/server/capnp"
 	"github.com/sahib/brig/version"
 	log "github.com/sirupsen/logrus"
@@ -70,10 +75,17 @@ func capMountOptionsToMountOptions(capOpts capnp.MountOptions) (fuse.MountOption
 		return fuse.MountOptions{}, err
 	}
 
+	rev, err := capOpts.Rev()
+	if err != nil {
+		return fuse.MountOptions{}, err
+	}
+
 	return fuse.MountOptions{
-		ReadOnly: readOnly,
-		Root:     rootPath,
-		Offline:  offline,
+		ReadOnly:  readOnly,
+		Root:      rootPath,
+		Offline:   offline,
+		Rev:       rev,
+		Snapshots: capOpts.Snapshots(),
 	}, nil
 }
 
@@ -145,6 +157,7 @@ func fsTabEntryToCap(entry fuse.FsTabEntry, seg *capnplib.Segment) (*capnp.FsTab
 	capEntry.SetReadOnly(entry.ReadOnly)
 	capEntry.SetOffline(entry.Offline)
 	capEntry.SetActive(entry.Active)
+	capEntry.SetSnapshots(entry.Snapshots)
 
 	if err := capEntry.SetPath(entry.Path); err != nil {
 		return nil, err
@@ -155,6 +168,9 @@ func fsTabEntryToCap(entry fuse.FsTabEntry, seg *capnplib.Segment) (*capnp.FsTab
 	if err := capEntry.SetName(entry.Name); err != nil {
 		return nil, err
 	}
+	if err := capEntry.SetRev(entry.Rev); err != nil {
+		return nil, err
+	}
 
 	return &capEntry, nil
 }
@@ -459,3 +475,492 @@ func (rh *repoHandler) DebugProfilePort(call capnp.Repo_debugProfilePort) error
 	call.Results.SetPort(int32(rh.base.pprofPort))
 	return nil
 }
+
+func (rh *repoHandler) DebugCacheStats(call capnp.Repo_debugCacheStats) error {
+	server.Ack(call.Options)
+
+	capStats, err := capnp.NewBlockCacheStats(call.Results.Segment())
+	if err != nil {
+		return err
+	}
+
+	stats := rh.base.repo.BlockCache().Stats()
+	capStats.SetHits(stats.Hits)
+	capStats.SetMisses(stats.Misses)
+	capStats.SetEvictions(stats.Evictions)
+	capStats.SetBlocks(stats.Blocks)
+	capStats.SetSize(stats.Size)
+	capStats.SetMaxSize(stats.MaxSize)
+	return call.Results.SetStats(capStats)
+}
+
+func (rh *repoHandler) DebugMigrate(call capnp.Repo_debugMigrate) error {
+	server.Ack(call.Options)
+
+	return rh.base.withCurrFs(func(fs *catfs.FS) error {
+		result, err := fs.Migrate(call.Params.DryRun())
+		if err != nil {
+			return err
+		}
+
+		seg := call.Results.Segment()
+		capResult, err := capnp.NewMigrationResult(seg)
+		if err != nil {
+			return err
+		}
+
+		capResult.SetVersion(int32(result.Version))
+		capResult.SetTarget(int32(result.Target))
+		if err := capResult.SetSnapshotPath(result.SnapshotPath); err != nil {
+			return err
+		}
+
+		capSteps, err := capnp.NewMigrationStep_List(seg, int32(len(result.Steps)))
+		if err != nil {
+			return err
+		}
+
+		for idx, step := range result.Steps {
+			capStep, err := capnp.NewMigrationStep(seg)
+			if err != nil {
+				return err
+			}
+
+			capStep.SetFrom(int32(step.From))
+			capStep.SetTo(int32(step.To))
+			if err := capStep.SetDescription(step.Description); err != nil {
+				return err
+			}
+
+			if err := capSteps.Set(idx, capStep); err != nil {
+				return err
+			}
+		}
+
+		if err := capResult.SetSteps(capSteps); err != nil {
+			return err
+		}
+
+		return call.Results.SetResult(capResult)
+	})
+}
+
+func (rh *repoHandler) DebugConvertDb(call capnp.Repo_debugConvertDb) error {
+	server.Ack(call.Options)
+
+	to, err := call.Params.To()
+	if err != nil {
+		return err
+	}
+
+	from, err := rh.base.repo.ConvertDatabases(to)
+	if err != nil {
+		return err
+	}
+
+	return call.Results.SetFrom(from)
+}
+
+func auditEntryToCapnp(entry audit.Entry, seg *capnplib.Segment) (*capnp.AuditEntry, error) {
+	capEntry, err := capnp.NewAuditEntry(seg)
+	if err != nil {
+		return nil, err
+	}
+
+	entryTime, err := entry.Time.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := capEntry.SetTime(string(entryTime)); err != nil {
+		return nil, err
+	}
+
+	if err := capEntry.SetUser(entry.User); err != nil {
+		return nil, err
+	}
+
+	if err := capEntry.SetAction(entry.Action); err != nil {
+		return nil, err
+	}
+
+	if err := capEntry.SetIp(entry.IP); err != nil {
+		return nil, err
+	}
+
+	capPaths, err := capnplib.NewTextList(seg, int32(len(entry.Paths)))
+	if err != nil {
+		return nil, err
+	}
+
+	for idx, path := range entry.Paths {
+		if err := capPaths.Set(idx, path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := capEntry.SetPaths(capPaths); err != nil {
+		return nil, err
+	}
+
+	capEntry.SetStatus(int32(entry.Status))
+	capEntry.SetBytesIn(entry.BytesIn)
+	capEntry.SetBytesOut(entry.BytesOut)
+	return &capEntry, nil
+}
+
+func (rh *repoHandler) GatewayAudit(call capnp.Repo_gatewayAudit) error {
+	server.Ack(call.Options)
+
+	user, err := call.Params.User()
+	if err != nil {
+		return err
+	}
+
+	path, err := call.Params.Path()
+	if err != nil {
+		return err
+	}
+
+	sinceStr, err := call.Params.Since()
+	if err != nil {
+		return err
+	}
+
+	query := audit.Query{
+		User:  user,
+		Path:  path,
+		Limit: int(call.Params.Limit()),
+	}
+
+	if sinceStr != "" {
+		since := time.Time{}
+		if err := since.UnmarshalText([]byte(sinceStr)); err != nil {
+			return err
+		}
+
+		query.Since = since
+	}
+
+	entries, err := rh.base.gateway.AuditLog().Query(query)
+	if err != nil {
+		return err
+	}
+
+	seg := call.Results.Segment()
+	capEntries, err := capnp.NewAuditEntry_List(seg, int32(len(entries)))
+	if err != nil {
+		return err
+	}
+
+	for idx, entry := range entries {
+		capEntry, err := auditEntryToCapnp(entry, seg)
+		if err != nil {
+			return err
+		}
+
+		if err := capEntries.Set(idx, *capEntry); err != nil {
+			return err
+		}
+	}
+
+	return call.Results.SetEntries(capEntries)
+}
+
+func backupInfoToCap(seg *capnplib.Segment, manifest *backup.Manifest) (*capnp.BackupInfo, error) {
+	capInfo, err := capnp.NewBackupInfo(seg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := capInfo.SetId(manifest.ID); err != nil {
+		return nil, err
+	}
+
+	if err := capInfo.SetOwner(manifest.Owner); err != nil {
+		return nil, err
+	}
+
+	if err := capInfo.SetParent(manifest.Parent); err != nil {
+		return nil, err
+	}
+
+	created, err := manifest.Created.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := capInfo.SetCreated(string(created)); err != nil {
+		return nil, err
+	}
+
+	capInfo.SetNBlobs(int32(len(manifest.Blobs)))
+	capInfo.SetNInherited(int32(len(manifest.InheritedBlobs)))
+	return &capInfo, nil
+}
+
+func (rh *repoHandler) BackupCreate(call capnp.Repo_backupCreate) error {
+	server.Ack(call.Options)
+
+	path, err := call.Params.Path()
+	if err != nil {
+		return err
+	}
+
+	password, err := call.Params.Password()
+	if err != nil {
+		return err
+	}
+
+	since, err := call.Params.Since()
+	if err != nil {
+		return err
+	}
+
+	manifest, err := rh.base.createBackup(path, password, since, call.Params.WithContent())
+	if err != nil {
+		return err
+	}
+
+	capInfo, err := backupInfoToCap(call.Results.Segment(), manifest)
+	if err != nil {
+		return err
+	}
+
+	return call.Results.SetInfo(*capInfo)
+}
+
+func (rh *repoHandler) BackupRestore(call capnp.Repo_backupRestore) error {
+	server.Ack(call.Options)
+
+	path, err := call.Params.Path()
+	if err != nil {
+		return err
+	}
+
+	password, err := call.Params.Password()
+	if err != nil {
+		return err
+	}
+
+	manifest, err := rh.base.restoreBackup(path, password)
+	if err != nil {
+		return err
+	}
+
+	capInfo, err := backupInfoToCap(call.Results.Segment(), manifest)
+	if err != nil {
+		return err
+	}
+
+	return call.Results.SetInfo(*capInfo)
+}
+
+func (rh *repoHandler) Passwd(call capnp.Repo_passwd) error {
+	server.Ack(call.Options)
+
+	oldPassword, err := call.Params.OldPassword()
+	if err != nil {
+		return err
+	}
+
+	newPassword, err := call.Params.NewPassword()
+	if err != nil {
+		return err
+	}
+
+	keyfile, err := call.Params.Keyfile()
+	if err != nil {
+		return err
+	}
+
+	rp := rh.base.repo
+	if call.Params.KeepKeyfile() {
+		keyfile, err = rp.Keyfile()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := rp.ChangePassword(oldPassword, newPassword, keyfile); err != nil {
+		return err
+	}
+
+	// The repository is locked with the new password on quit:
+	rh.base.mu.Lock()
+	rh.base.password = newPassword
+	rh.base.mu.Unlock()
+
+	log.Infof("changed the repository password")
+	return nil
+}
+
+func (rh *repoHandler) RepoSelect(call capnp.Repo_repoSelect) error {
+	server.Ack(call.Options)
+
+	path, err := call.Params.Path()
+	if err != nil {
+		return err
+	}
+
+	password, err := call.Params.Password()
+	if err != nil {
+		return err
+	}
+
+	b, err := rh.base.repos.open(path, password)
+	if err != nil {
+		return err
+	}
+
+	return call.Results.SetApi(capnp.API_ServerToClient(newAPIHandler(b)))
+}
+
+func (rh *repoHandler) RepoList(call capnp.Repo_repoList) error {
+	server.Ack(call.Options)
+
+	bases := rh.base.repos.list()
+	seg := call.Results.Segment()
+	capInfos, err := capnp.NewRepoInfo_List(seg, int32(len(bases)))
+	if err != nil {
+		return err
+	}
+
+	for idx, b := range bases {
+		capInfo, err := capnp.NewRepoInfo(seg)
+		if err != nil {
+			return err
+		}
+
+		if err := capInfo.SetPath(b.basePath); err != nil {
+			return err
+		}
+
+		if err := capInfo.SetOwner(b.repo.Owner); err != nil {
+			return err
+		}
+
+		capInfo.SetIsPrimary(rh.base.repos.isPrimary(b))
+		if err := capInfos.Set(idx, capInfo); err != nil {
+			return err
+		}
+	}
+
+	return call.Results.SetRepos(capInfos)
+}
+
+func (rh *repoHandler) RepoClose(call capnp.Repo_repoClose) error {
+	server.Ack(call.Options)
+
+	path, err := call.Params.Path()
+	if err != nil {
+		return err
+	}
+
+	return rh.base.repos.close(path)
+}
+
+func (rh *repoHandler) MirrorAdd(call capnp.Repo_mirrorAdd) error {
+	server.Ack(call.Options)
+
+	name, err := call.Params.Name()
+	if err != nil {
+		return err
+	}
+
+	localPath, err := call.Params.LocalPath()
+	if err != nil {
+		return err
+	}
+
+	repoPath, err := call.Params.RepoPath()
+	if err != nil {
+		return err
+	}
+
+	mirrorsCfg := rh.base.repo.Config.Section("mirrors")
+	if err := mirror.TabAdd(mirrorsCfg, name, localPath, repoPath); err != nil {
+		return err
+	}
+
+	if err := mirror.TabApply(mirrorsCfg, rh.base.mirrors); err != nil {
+		// Do not keep a mirror that cannot be started:
+		if rmErr := mirror.TabRemove(mirrorsCfg, name); rmErr != nil {
+			log.Warningf("failed to remove mirror %s again: %v", name, rmErr)
+		}
+
+		return err
+	}
+
+	return rh.base.repo.SaveConfig()
+}
+
+func (rh *repoHandler) MirrorRemove(call capnp.Repo_mirrorRemove) error {
+	server.Ack(call.Options)
+
+	name, err := call.Params.Name()
+	if err != nil {
+		return err
+	}
+
+	mirrorsCfg := rh.base.repo.Config.Section("mirrors")
+	if err := mirror.TabRemove(mirrorsCfg, name); err != nil {
+		return err
+	}
+
+	if err := mirror.TabApply(mirrorsCfg, rh.base.mirrors); err != nil {
+		return err
+	}
+
+	return rh.base.repo.SaveConfig()
+}
+
+func (rh *repoHandler) MirrorList(call capnp.Repo_mirrorList) error {
+	server.Ack(call.Options)
+
+	mirrorsCfg := rh.base.repo.Config.Section("mirrors")
+	entries := mirror.TabList(mirrorsCfg, rh.base.mirrors)
+
+	seg := call.Results.Segment()
+	capEntries, err := capnp.NewMirrorEntry_List(seg, int32(len(entries)))
+	if err != nil {
+		return err
+	}
+
+	for idx, entry := range entries {
+		capEntry, err := capnp.NewMirrorEntry(seg)
+		if err != nil {
+			return err
+		}
+
+		if err := capEntry.SetName(entry.Name); err != nil {
+			return err
+		}
+
+		if err := capEntry.SetLocalPath(entry.LocalDir); err != nil {
+			return err
+		}
+
+		if err := capEntry.SetRepoPath(entry.RepoPath); err != nil {
+			return err
+		}
+
+		capEntry.SetActive(entry.Active)
+		if err := capEntries.Set(idx, capEntry); err != nil {
+			return err
+		}
+	}
+
+	return call.Results.SetMirrors(capEntries)
+}
+
+func (rh *repoHandler) MirrorSync(call capnp.Repo_mirrorSync) error {
+	server.Ack(call.Options)
+
+	name, err := call.Params.Name()
+	if err != nil {
+		return err
+	}
+
+	return rh.base.mirrors.Sync(name)
+}
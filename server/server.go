@@ -12,6 +12,7 @@ import (
 
 	"github.com/sahib/brig/defaults"
 	"github.com/sahib/brig/fuse"
+	"github.com/sahib/brig/mirror"
 	"github.com/sahib/brig/repo"
 	formatter "github.com/sahib/brig/util/log"
 	"github.com/sahib/brig/util/pwutil"
@@ -81,6 +82,10 @@ func applyFstabInitially(base *base) error {
 	return fuse.FsTabApply(base.repo.Config.Section("mounts"), base.mounts)
 }
 
+func applyMirrorsInitially(base *base) error {
+	return mirror.TabApply(base.repo.Config.Section("mirrors"), base.mirrors)
+}
+
 // BootServer will boot up the local server.
 // `basePath` is the path to the repository.
 // `passwordFn` is a function that will deliver a password when
@@ -143,6 +148,10 @@ func BootServer(
 		logToStdout,
 	)
 
+	if _, err := newRepoRegistry(base); err != nil {
+		return nil, err
+	}
+
 	lst, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
@@ -170,6 +179,10 @@ func BootServer(
 		log.Warnf("could not mount fstab mounts: %v", err)
 	}
 
+	if err := applyMirrorsInitially(base); err != nil {
+		log.Warnf("could not start mirrors: %v", err)
+	}
+
 	return &Server{
 		baseServer: baseServer,
 		base:       base,
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+
+	"github.com/sahib/brig/catfs"
+	"github.com/sahib/brig/catfs/db"
+	ie "github.com/sahib/brig/catfs/errors"
+	"github.com/sahib/brig/util"
+)
+
+// stageIndexEntry remembers how a local file looked when it was staged.
+type stageIndexEntry struct {
+	LocalPath string `json:"local_path"`
+	RepoPath  string `json:"repo_path"`
+	Size      int64  `json:"size"`
+	ModTime   int64  `json:"mod_time"`
+	Inode     uint64 `json:"inode"`
+	Hash      string `json:"hash"`
+}
+
+// stageIndex maps local files to the content they had when they were
+// staged, grouped by the source (the file or directory the user staged).
+// It is used to skip files that did not change since the last time.
+type stageIndex struct {
+	db db.Database
+}
+
+func newStageIndex(path string) (*stageIndex, error) {
+	database, err := db.NewBadgerDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stageIndex{db: database}, nil
+}
+
+// The database uses dots as key separator, paths may contain them.
+func encodeIndexKey(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func (si *stageIndex) get(source, localPath string) (*stageIndexEntry, error) {
+	data, err := si.db.Get("sources", encodeIndexKey(source), encodeIndexKey(localPath))
+	if err == db.ErrNoSuchKey {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &stageIndexEntry{}
+	return entry, json.Unmarshal(data, entry)
+}
+
+func (si *stageIndex) put(source string, entry *stageIndexEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	batch := si.db.Batch()
+	batch.Put(data, "sources", encodeIndexKey(source), encodeIndexKey(entry.LocalPath))
+	return batch.Flush()
+}
+
+func (si *stageIndex) entries(source string) ([]*stageIndexEntry, error) {
+	keys, err := si.db.Keys("sources", encodeIndexKey(source))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*stageIndexEntry{}
+	for _, key := range keys {
+		data, err := si.db.Get(key...)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := &stageIndexEntry{}
+		if err := json.Unmarshal(data, entry); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (si *stageIndex) forget(source, localPath string) error {
+	batch := si.db.Batch()
+	batch.Erase("sources", encodeIndexKey(source), encodeIndexKey(localPath))
+	return batch.Flush()
+}
+
+func (si *stageIndex) close() error {
+	return si.db.Close()
+}
+
+func localEntryOf(info os.FileInfo, localPath, repoPath string) *stageIndexEntry {
+	return &stageIndexEntry{
+		LocalPath: localPath,
+		RepoPath:  repoPath,
+		Size:      info.Size(),
+		ModTime:   info.ModTime().UnixNano(),
+		Inode:     util.Inode(info),
+	}
+}
+
+// isUnchanged checks if the local file still looks like it did when it was
+// staged from `source` and if the repository still has that content.
+// The hash of `curr` is ignored.
+func (si *stageIndex) isUnchanged(fs *catfs.FS, source string, curr *stageIndexEntry) (bool, error) {
+	entry, err := si.get(source, curr.LocalPath)
+	if err != nil || entry == nil {
+		return false, err
+	}
+
+	withHash := *curr
+	withHash.Hash = entry.Hash
+	if withHash != *entry {
+		return false, nil
+	}
+
+	repoInfo, err := fs.Stat(curr.RepoPath)
+	if ie.IsNoSuchFileError(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return !repoInfo.IsDir && repoInfo.ContentHash.B58String() == entry.Hash, nil
+}
+
+// remember records that the local file described by `entry`
+// was just staged from `source` to `entry.RepoPath` in `fs`.
+func (si *stageIndex) remember(fs *catfs.FS, source string, entry *stageIndexEntry) error {
+	repoInfo, err := fs.Stat(entry.RepoPath)
+	if err != nil {
+		return err
+	}
+
+	withHash := *entry
+	withHash.Hash = repoInfo.ContentHash.B58String()
+	return si.put(source, &withHash)
+}
+
+// removeMissing removes all files staged from `source` that are not in
+// `existing` (local paths) anymore, unless they were modified in the
+// repository since. It returns the removed repository paths.
+func (si *stageIndex) removeMissing(fs *catfs.FS, source string, existing []string) ([]string, error) {
+	entries, err := si.entries(source)
+	if err != nil {
+		return nil, err
+	}
+
+	isExisting := make(map[string]bool)
+	for _, localPath := range existing {
+		isExisting[localPath] = true
+	}
+
+	removed := []string{}
+	for _, entry := range entries {
+		if isExisting[entry.LocalPath] {
+			continue
+		}
+
+		repoInfo, err := fs.Stat(entry.RepoPath)
+		if err != nil && !ie.IsNoSuchFileError(err) {
+			return removed, err
+		}
+
+		if err == nil && !repoInfo.IsDir && repoInfo.ContentHash.B58String() == entry.Hash {
+			if err := fs.Remove(entry.RepoPath); err != nil {
+				return removed, err
+			}
+
+			removed = append(removed, entry.RepoPath)
+		}
+
+		if err := si.forget(source, entry.LocalPath); err != nil {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
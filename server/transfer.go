@@ -1,13 +1,31 @@
 package server
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
 	"fmt"
+	"io"
 	"net"
+	"time"
 
 	"github.com/sahib/brig/catfs"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// transferTokenSize is the size of the token that clients need to send
+	// before they are allowed to write to a transfer port.
+	transferTokenSize = 32
+)
+
+var (
+	// transferAcceptTimeout is how long a transfer port waits for the client.
+	transferAcceptTimeout = 30 * time.Second
+
+	// transferTokenTimeout is how long a client may take to send the token.
+	transferTokenTimeout = 5 * time.Second
+)
+
 func getNextFreePort() (int, error) {
 	addr, err := net.ResolveTCPAddr("tcp", "localhost:0")
 	if err != nil {
@@ -23,28 +41,85 @@ func getNextFreePort() (int, error) {
 	return l.Addr().(*net.TCPAddr).Port, nil
 }
 
-func bootTransferServer(fs *catfs.FS, bindHost string, copyFn func(conn net.Conn)) (int, error) {
+// newTransferToken returns a random token for bootTransferServer.
+func newTransferToken() ([]byte, error) {
+	token := make([]byte, transferTokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// checkTransferToken reads the token the client sent first on `conn`
+// and compares it with `token`.
+func checkTransferToken(conn net.Conn, token []byte) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(transferTokenTimeout)); err != nil {
+		return false
+	}
+
+	sent := make([]byte, len(token))
+	if _, err := io.ReadFull(conn, sent); err != nil {
+		return false
+	}
+
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(sent, token) == 1
+}
+
+// bootTransferServer opens a port for a single transfer and calls `copyFn`
+// with the first connection to it. If `token` is not nil, clients need to
+// send it first; other connections are dropped. If no client connected
+// within transferAcceptTimeout, the port is closed and `abortFn` is called
+// (if not nil) instead of `copyFn`.
+func bootTransferServer(fs *catfs.FS, bindHost string, token []byte, copyFn func(conn net.Conn), abortFn func()) (int, error) {
 	port, err := getNextFreePort()
 	if err != nil {
 		return 0, err
 	}
 
-	lst, err := net.Listen("tcp", fmt.Sprintf("%s:%d", bindHost, port))
+	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", bindHost, port))
+	if err != nil {
+		return 0, err
+	}
+
+	lst, err := net.ListenTCP("tcp", addr)
 	if err != nil {
 		return 0, err
 	}
 
+	if err := lst.SetDeadline(time.Now().Add(transferAcceptTimeout)); err != nil {
+		lst.Close()
+		return 0, err
+	}
+
 	go func() {
 		defer lst.Close()
 
-		conn, err := lst.Accept()
-		if err != nil {
-			log.Warningf("Failed to accept connection on %d: %v", port, err)
+		for {
+			conn, err := lst.Accept()
+			if err != nil {
+				log.Warningf("Failed to accept connection on %d: %v", port, err)
+				if abortFn != nil {
+					abortFn()
+				}
+
+				return
+			}
+
+			if token != nil && !checkTransferToken(conn, token) {
+				log.Warningf("Rejected connection from %s on %d: bad token", conn.RemoteAddr(), port)
+				conn.Close()
+				continue
+			}
+
+			defer conn.Close()
+			copyFn(conn)
 			return
 		}
-
-		defer conn.Close()
-		copyFn(conn)
 	}()
 
 	return port, nil
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func dialTransferPort(t *testing.T, port int) net.Conn {
+	conn, err := net.Dial("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+	require.Nil(t, err)
+	return conn
+}
+
+func TestTransferServerToken(t *testing.T) {
+	token, err := newTransferToken()
+	require.Nil(t, err)
+
+	received := make(chan []byte, 1)
+	port, err := bootTransferServer(nil, "localhost", token, func(conn net.Conn) {
+		data, err := ioutil.ReadAll(conn)
+		require.Nil(t, err)
+		received <- data
+	}, nil)
+	require.Nil(t, err)
+
+	// Connections without the right token are dropped:
+	badConn := dialTransferPort(t, port)
+	_, err = badConn.Write(make([]byte, transferTokenSize))
+	require.Nil(t, err)
+	_, err = ioutil.ReadAll(badConn)
+	require.Nil(t, err)
+	require.Nil(t, badConn.Close())
+
+	conn := dialTransferPort(t, port)
+	_, err = conn.Write(append(token, []byte("hello")...))
+	require.Nil(t, err)
+	require.Nil(t, conn.(*net.TCPConn).CloseWrite())
+
+	require.Equal(t, []byte("hello"), <-received)
+	require.Nil(t, conn.Close())
+}
+
+func TestTransferServerTimeout(t *testing.T) {
+	oldTimeout := transferAcceptTimeout
+	transferAcceptTimeout = 100 * time.Millisecond
+	defer func() { transferAcceptTimeout = oldTimeout }()
+
+	aborted := make(chan bool, 1)
+	_, err := bootTransferServer(nil, "localhost", nil, func(conn net.Conn) {
+		t.Errorf("nobody should have connected")
+	}, func() {
+		aborted <- true
+	})
+	require.Nil(t, err)
+
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("transfer port was not closed after the timeout")
+	}
+}
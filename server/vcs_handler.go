@@ -5,6 +5,7 @@ import (
 
 	e "github.com/pkg/errors"
 	"github.com/sahib/brig/catfs"
+	"github.com/sahib/brig/repo/bundle"
 	"github.com/sahib/brig/server/capnp"
 	cplib "zombiezen.com/go/capnproto2"
 	"zombiezen.com/go/capnproto2/server"
@@ -100,7 +101,12 @@ func (vcs *vcsHandler) Commit(call capnp.VCS_commit) error {
 
 	return vcs.base.withCurrFs(func(fs *catfs.FS) error {
 		msg = "user: " + msg
-		return fs.MakeCommit(msg)
+		if err := fs.MakeCommit(msg); err != nil {
+			return err
+		}
+
+		vcs.base.notifyCommitEvent(fs, "HEAD")
+		return nil
 	})
 }
 
@@ -162,7 +168,7 @@ func (vcs *vcsHandler) Reset(call capnp.VCS_reset) error {
 			return err
 		}
 
-		vcs.base.notifyFsChangeEvent()
+		vcs.base.notifyFsChangeEvent(modifiedChange(url.Path))
 		return nil
 	})
 }
@@ -450,7 +456,12 @@ func (vcs *vcsHandler) Sync(call capnp.VCS_sync) error {
 		return err
 	}
 
-	diff, err := vcs.base.doSync(withWhom, call.Params.NeedFetch(), "")
+	branch, err := call.Params.Branch()
+	if err != nil {
+		return err
+	}
+
+	diff, err := vcs.base.doSync(withWhom, call.Params.NeedFetch(), "", branch)
 	if err != nil {
 		return err
 	}
@@ -492,3 +503,545 @@ func (vcs *vcsHandler) CommitInfo(call capnp.VCS_commitInfo) error {
 		return nil
 	})
 }
+
+func (vcs *vcsHandler) BranchList(call capnp.VCS_branchList) error {
+	server.Ack(call.Options)
+	seg := call.Results.Segment()
+
+	return vcs.base.withCurrFs(func(fs *catfs.FS) error {
+		branches, err := fs.Branches()
+		if err != nil {
+			return err
+		}
+
+		lst, err := capnp.NewBranch_List(seg, int32(len(branches)))
+		if err != nil {
+			return err
+		}
+
+		for idx, branch := range branches {
+			capBranch, err := capnp.NewBranch(seg)
+			if err != nil {
+				return err
+			}
+
+			if err := capBranch.SetName(branch.Name); err != nil {
+				return err
+			}
+
+			capTip, err := commitToCap(branch.Tip, seg)
+			if err != nil {
+				return err
+			}
+
+			if err := capBranch.SetTip(*capTip); err != nil {
+				return err
+			}
+
+			capBranch.SetIsCurrent(branch.IsCurrent)
+			if err := lst.Set(idx, capBranch); err != nil {
+				return err
+			}
+		}
+
+		return call.Results.SetBranches(lst)
+	})
+}
+
+func (vcs *vcsHandler) BranchMake(call capnp.VCS_branchMake) error {
+	server.Ack(call.Options)
+
+	name, err := call.Params.Name()
+	if err != nil {
+		return err
+	}
+
+	rev, err := call.Params.Rev()
+	if err != nil {
+		return err
+	}
+
+	return vcs.base.withCurrFs(func(fs *catfs.FS) error {
+		return fs.MakeBranch(name, rev)
+	})
+}
+
+func (vcs *vcsHandler) BranchRemove(call capnp.VCS_branchRemove) error {
+	server.Ack(call.Options)
+
+	name, err := call.Params.Name()
+	if err != nil {
+		return err
+	}
+
+	return vcs.base.withCurrFs(func(fs *catfs.FS) error {
+		return fs.RemoveBranch(name)
+	})
+}
+
+func (vcs *vcsHandler) BranchSwitch(call capnp.VCS_branchSwitch) error {
+	server.Ack(call.Options)
+
+	name, err := call.Params.Name()
+	if err != nil {
+		return err
+	}
+
+	return vcs.base.withCurrFs(func(fs *catfs.FS) error {
+		if err := fs.SwitchBranch(name, call.Params.Force()); err != nil {
+			return err
+		}
+
+		vcs.base.notifyFsChangeEvent()
+		return nil
+	})
+}
+
+func (vcs *vcsHandler) Merge(call capnp.VCS_merge) error {
+	server.Ack(call.Options)
+
+	branch, err := call.Params.Branch()
+	if err != nil {
+		return err
+	}
+
+	return vcs.base.withCurrFs(func(fs *catfs.FS) error {
+		cmtBefore, err := fs.Head()
+		if err != nil {
+			return err
+		}
+
+		if err := fs.Merge(branch); err != nil {
+			return err
+		}
+
+		cmtAfter, err := fs.Head()
+		if err != nil {
+			return err
+		}
+
+		if cmtAfter != cmtBefore {
+			vcs.base.notifyCommitEvent(fs, "HEAD")
+		}
+
+		diff, err := fs.MakeDiff(fs, cmtBefore, cmtAfter)
+		if err != nil {
+			return err
+		}
+
+		capDiff, err := diffToCapnpDiff(call.Results.Segment(), diff)
+		if err != nil {
+			return err
+		}
+
+		return call.Results.SetDiff(*capDiff)
+	})
+}
+
+func bundleInfoToCap(seg *cplib.Segment, manifest *bundle.Manifest) (*capnp.BundleInfo, error) {
+	capInfo, err := capnp.NewBundleInfo(seg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := capInfo.SetOwner(manifest.Owner); err != nil {
+		return nil, err
+	}
+
+	if err := capInfo.SetRemote(manifest.Remote); err != nil {
+		return nil, err
+	}
+
+	if err := capInfo.SetSince(manifest.Since); err != nil {
+		return nil, err
+	}
+
+	created, err := manifest.Created.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := capInfo.SetCreated(string(created)); err != nil {
+		return nil, err
+	}
+
+	capInfo.SetNBlobs(int32(len(manifest.Blobs)))
+	return &capInfo, nil
+}
+
+func (vcs *vcsHandler) BundleCreate(call capnp.VCS_bundleCreate) error {
+	server.Ack(call.Options)
+
+	path, err := call.Params.Path()
+	if err != nil {
+		return err
+	}
+
+	remote, err := call.Params.Remote()
+	if err != nil {
+		return err
+	}
+
+	since, err := call.Params.Since()
+	if err != nil {
+		return err
+	}
+
+	manifest, err := vcs.base.createBundle(path, remote, since, call.Params.WithContent())
+	if err != nil {
+		return err
+	}
+
+	capInfo, err := bundleInfoToCap(call.Results.Segment(), manifest)
+	if err != nil {
+		return err
+	}
+
+	return call.Results.SetInfo(*capInfo)
+}
+
+func (vcs *vcsHandler) BundleApply(call capnp.VCS_bundleApply) error {
+	server.Ack(call.Options)
+
+	path, err := call.Params.Path()
+	if err != nil {
+		return err
+	}
+
+	manifest, diff, err := vcs.base.applyBundle(path, call.Params.NoSync())
+	if err != nil {
+		return err
+	}
+
+	seg := call.Results.Segment()
+	capInfo, err := bundleInfoToCap(seg, manifest)
+	if err != nil {
+		return err
+	}
+
+	if err := call.Results.SetInfo(*capInfo); err != nil {
+		return err
+	}
+
+	if diff == nil {
+		return nil
+	}
+
+	capDiff, err := diffToCapnpDiff(seg, diff)
+	if err != nil {
+		return err
+	}
+
+	return call.Results.SetDiff(*capDiff)
+}
+
+func stringsToTextList(seg *cplib.Segment, strs []string) (*cplib.TextList, error) {
+	lst, err := cplib.NewTextList(seg, int32(len(strs)))
+	if err != nil {
+		return nil, err
+	}
+
+	for idx, str := range strs {
+		if err := lst.Set(idx, str); err != nil {
+			return nil, err
+		}
+	}
+
+	return &lst, nil
+}
+
+// setPickResults fills the conflicts and the diff between `cmtBefore` and
+// the current HEAD into the results of revert and cherry-pick.
+func setPickResults(
+	seg *cplib.Segment,
+	fs *catfs.FS,
+	cmtBefore string,
+	conflicts []string,
+	setConflicts func(cplib.TextList) error,
+	setDiff func(capnp.Diff) error,
+) error {
+	capConflicts, err := stringsToTextList(seg, conflicts)
+	if err != nil {
+		return err
+	}
+
+	if err := setConflicts(*capConflicts); err != nil {
+		return err
+	}
+
+	cmtAfter, err := fs.Head()
+	if err != nil {
+		return err
+	}
+
+	diff, err := fs.MakeDiff(fs, cmtBefore, cmtAfter)
+	if err != nil {
+		return err
+	}
+
+	capDiff, err := diffToCapnpDiff(seg, diff)
+	if err != nil {
+		return err
+	}
+
+	return setDiff(*capDiff)
+}
+
+func (vcs *vcsHandler) Revert(call capnp.VCS_revert) error {
+	server.Ack(call.Options)
+
+	rev, err := call.Params.Rev()
+	if err != nil {
+		return err
+	}
+
+	return vcs.base.withCurrFs(func(fs *catfs.FS) error {
+		cmtBefore, err := fs.Head()
+		if err != nil {
+			return err
+		}
+
+		conflicts, err := fs.Revert(rev)
+		if err != nil {
+			return err
+		}
+
+		vcs.base.notifyCommitEvent(fs, "HEAD")
+		return setPickResults(
+			call.Results.Segment(),
+			fs,
+			cmtBefore,
+			conflicts,
+			call.Results.SetConflicts,
+			call.Results.SetDiff,
+		)
+	})
+}
+
+func (vcs *vcsHandler) CherryPick(call capnp.VCS_cherryPick) error {
+	server.Ack(call.Options)
+
+	remote, err := call.Params.Remote()
+	if err != nil {
+		return err
+	}
+
+	rev, err := call.Params.Rev()
+	if err != nil {
+		return err
+	}
+
+	rmt, err := vcs.base.repo.Remotes.Remote(remote)
+	if err != nil {
+		return err
+	}
+
+	if call.Params.NeedFetch() {
+		if err := vcs.base.doFetch(remote); err != nil {
+			return e.Wrapf(err, "fetch")
+		}
+	}
+
+	return vcs.base.withCurrFs(func(ownFs *catfs.FS) error {
+		return vcs.base.withRemoteFs(remote, func(remoteFs *catfs.FS) error {
+			cmtBefore, err := ownFs.Head()
+			if err != nil {
+				return err
+			}
+
+			conflicts, err := ownFs.CherryPick(
+				remoteFs,
+				rev,
+				catfs.SyncOptConflictStrategy(rmt.ConflictStrategy),
+			)
+
+			if err != nil {
+				return err
+			}
+
+			vcs.base.notifyCommitEvent(ownFs, "HEAD")
+			return setPickResults(
+				call.Results.Segment(),
+				ownFs,
+				cmtBefore,
+				conflicts,
+				call.Results.SetConflicts,
+				call.Results.SetDiff,
+			)
+		})
+	})
+}
+
+func (vcs *vcsHandler) StashPush(call capnp.VCS_stashPush) error {
+	server.Ack(call.Options)
+
+	msg, err := call.Params.Msg()
+	if err != nil {
+		return err
+	}
+
+	return vcs.base.withCurrFs(func(fs *catfs.FS) error {
+		return fs.StashPush(msg)
+	})
+}
+
+func (vcs *vcsHandler) StashList(call capnp.VCS_stashList) error {
+	server.Ack(call.Options)
+	seg := call.Results.Segment()
+
+	return vcs.base.withCurrFs(func(fs *catfs.FS) error {
+		stashes, err := fs.Stashes()
+		if err != nil {
+			return err
+		}
+
+		lst, err := capnp.NewCommit_List(seg, int32(len(stashes)))
+		if err != nil {
+			return err
+		}
+
+		for idx, stash := range stashes {
+			capStash, err := commitToCap(&stash, seg)
+			if err != nil {
+				return err
+			}
+
+			if err := lst.Set(idx, *capStash); err != nil {
+				return err
+			}
+		}
+
+		return call.Results.SetStashes(lst)
+	})
+}
+
+func (vcs *vcsHandler) StashPop(call capnp.VCS_stashPop) error {
+	server.Ack(call.Options)
+
+	return vcs.base.withCurrFs(func(fs *catfs.FS) error {
+		conflicts, err := fs.StashPop(int(call.Params.Index()))
+		if err != nil {
+			return err
+		}
+
+		capConflicts, err := stringsToTextList(call.Results.Segment(), conflicts)
+		if err != nil {
+			return err
+		}
+
+		return call.Results.SetConflicts(*capConflicts)
+	})
+}
+
+func (vcs *vcsHandler) StashDrop(call capnp.VCS_stashDrop) error {
+	server.Ack(call.Options)
+
+	return vcs.base.withCurrFs(func(fs *catfs.FS) error {
+		return fs.StashDrop(int(call.Params.Index()))
+	})
+}
+
+func blameToCap(blame *catfs.Blame, seg *cplib.Segment) (*capnp.Blame, error) {
+	capBlame, err := capnp.NewBlame(seg)
+	if err != nil {
+		return nil, err
+	}
+
+	capBlame.SetIsBinary(blame.IsBinary)
+	capVersions, err := capnp.NewBlameVersion_List(seg, int32(len(blame.Versions)))
+	if err != nil {
+		return nil, err
+	}
+
+	for idx, version := range blame.Versions {
+		capVersion, err := capnp.NewBlameVersion(seg)
+		if err != nil {
+			return nil, err
+		}
+
+		capCommit, err := commitToCap(version.Commit, seg)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := capVersion.SetCommit(*capCommit); err != nil {
+			return nil, err
+		}
+
+		if err := capVersion.SetAuthor(version.Author); err != nil {
+			return nil, err
+		}
+
+		if err := capVersion.SetMergedWith(version.MergedWith); err != nil {
+			return nil, err
+		}
+
+		if err := capVersion.SetPath(version.Path); err != nil {
+			return nil, err
+		}
+
+		if err := capVersion.SetContentHash(version.ContentHash); err != nil {
+			return nil, err
+		}
+
+		capVersion.SetSize(version.Size)
+		if err := capVersions.Set(idx, capVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := capBlame.SetVersions(capVersions); err != nil {
+		return nil, err
+	}
+
+	capLines, err := capnp.NewBlameLine_List(seg, int32(len(blame.Lines)))
+	if err != nil {
+		return nil, err
+	}
+
+	for idx, line := range blame.Lines {
+		capLine, err := capnp.NewBlameLine(seg)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := capLine.SetText(line.Text); err != nil {
+			return nil, err
+		}
+
+		capLine.SetVersion(int32(line.Version))
+		if err := capLines.Set(idx, capLine); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := capBlame.SetLines(capLines); err != nil {
+		return nil, err
+	}
+
+	return &capBlame, nil
+}
+
+func (vcs *vcsHandler) Blame(call capnp.VCS_blame) error {
+	server.Ack(call.Options)
+
+	path, err := call.Params.Path()
+	if err != nil {
+		return err
+	}
+
+	seg := call.Results.Segment()
+	return vcs.base.withFsFromPath(path, func(url *URL, fs *catfs.FS) error {
+		blame, err := fs.Blame(url.Path)
+		if err != nil {
+			return err
+		}
+
+		capBlame, err := blameToCap(blame, seg)
+		if err != nil {
+			return err
+		}
+
+		return call.Results.SetBlame(*capBlame)
+	})
+}
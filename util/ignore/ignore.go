@@ -0,0 +1,310 @@
+// Package ignore implements ignore rules with the syntax of gitignore(5).
+//
+// Rules come from global patterns (usually from the config) and from
+// ».brigignore« files in the directories of a tree. Rules of such a file only
+// apply to paths below its directory. Like in git, the last matching rule
+// wins, »!« re-includes a path and nothing can be re-included below an
+// ignored directory.
+package ignore
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// FileName is the name of the per-directory ignore files.
+const FileName = ".brigignore"
+
+type rule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+
+	// dir is the directory the rule applies to,
+	// relative to the root and without slashes around it.
+	dir string
+}
+
+func (r *rule) match(relPath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+
+	if r.dir != "" {
+		if !strings.HasPrefix(relPath, r.dir+"/") {
+			return false
+		}
+
+		relPath = relPath[len(r.dir)+1:]
+	}
+
+	return r.re.MatchString(relPath)
+}
+
+// Matcher decides if a path is ignored.
+// The zero value (and nil) ignores nothing.
+type Matcher struct {
+	rules []rule
+}
+
+// New returns a matcher with `patterns` as rules for the whole tree.
+// Invalid patterns are skipped.
+func New(patterns []string) *Matcher {
+	m := &Matcher{}
+	m.Add("", patterns)
+	return m
+}
+
+// Clone returns a copy of `m` that can be extended independently.
+func (m *Matcher) Clone() *Matcher {
+	if m == nil {
+		return &Matcher{}
+	}
+
+	rules := make([]rule, len(m.rules))
+	copy(rules, m.rules)
+	return &Matcher{rules: rules}
+}
+
+// Add adds `patterns` as rules for everything below `dir`.
+// `dir` is relative to the root; "" or "/" is the root itself.
+func (m *Matcher) Add(dir string, patterns []string) {
+	dir = strings.Trim(filepath.ToSlash(dir), "/")
+	for _, pattern := range patterns {
+		r, ok := parseRule(pattern)
+		if !ok {
+			continue
+		}
+
+		r.dir = dir
+		m.rules = append(m.rules, *r)
+	}
+}
+
+// AddReader reads patterns in the format of an ignore file from `r`
+// and adds them as rules for everything below `dir`.
+func (m *Matcher) AddReader(dir string, r io.Reader) error {
+	patterns := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		patterns = append(patterns, scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	m.Add(dir, patterns)
+	return nil
+}
+
+// AddFile is like AddReader, but reads from the file at `path`.
+// It is not an error if the file does not exist.
+func (m *Matcher) AddFile(dir, path string) error {
+	fd, err := os.Open(path) // #nosec
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer fd.Close()
+	return m.AddReader(dir, fd)
+}
+
+func (m *Matcher) matchOne(relPath string, isDir bool) bool {
+	ignored := false
+	for idx := range m.rules {
+		r := &m.rules[idx]
+		if r.negate != ignored {
+			// This rule could not change the result.
+			continue
+		}
+
+		if r.match(relPath, isDir) {
+			ignored = !r.negate
+		}
+	}
+
+	return ignored
+}
+
+// Match returns true if `relPath` is ignored. `relPath` is relative to the
+// root and slash separated; a leading slash is fine. A path is also ignored
+// if one of its parent directories is.
+func (m *Matcher) Match(relPath string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+
+	relPath = strings.Trim(path.Clean("/"+relPath), "/")
+	if relPath == "" {
+		return false
+	}
+
+	for idx, c := range relPath {
+		if c == '/' && m.matchOne(relPath[:idx], true) {
+			return true
+		}
+	}
+
+	return m.matchOne(relPath, isDir)
+}
+
+// Walk works like filepath.Walk, but does not call `fn` for anything
+// that is ignored by `m` or by an ignore file in `root` or below.
+// `m` is not modified and may be nil.
+func Walk(root string, m *Matcher, fn filepath.WalkFunc) error {
+	m = m.Clone()
+	return filepath.Walk(root, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fn(fullPath, info, err)
+		}
+
+		rel, err := filepath.Rel(root, fullPath)
+		if err != nil {
+			return err
+		}
+
+		relPath := filepath.ToSlash(rel)
+		if relPath == "." {
+			relPath = ""
+		} else if m.matchOne(relPath, info.IsDir()) {
+			// Parents were checked already when walking past them.
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if info.IsDir() {
+			if err := m.AddFile(relPath, filepath.Join(fullPath, FileName)); err != nil {
+				return fn(fullPath, info, err)
+			}
+		}
+
+		return fn(fullPath, info, nil)
+	})
+}
+
+func parseRule(pattern string) (*rule, bool) {
+	// Trailing spaces are ignored unless they are escaped:
+	for strings.HasSuffix(pattern, " ") && !strings.HasSuffix(pattern, "\\ ") {
+		pattern = pattern[:len(pattern)-1]
+	}
+
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return nil, false
+	}
+
+	r := &rule{}
+	if strings.HasPrefix(pattern, "!") {
+		r.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, "\\!") || strings.HasPrefix(pattern, "\\#") {
+		pattern = pattern[1:]
+	}
+
+	if strings.HasSuffix(pattern, "/") {
+		r.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+
+	if pattern == "" {
+		return nil, false
+	}
+
+	// Patterns with a slash (other than at the end) are relative to
+	// the directory they apply to. Others match at any depth.
+	prefix := "(?:.*/)?"
+	if strings.Contains(pattern, "/") {
+		prefix = ""
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+
+	re, err := regexp.Compile("^" + prefix + globToRegexp(pattern) + "$")
+	if err != nil {
+		return nil, false
+	}
+
+	r.re = re
+	return r, true
+}
+
+func globToRegexp(pattern string) string {
+	b := &strings.Builder{}
+	for idx := 0; idx < len(pattern); idx++ {
+		c := pattern[idx]
+		switch c {
+		case '*':
+			if !strings.HasPrefix(pattern[idx:], "**") {
+				b.WriteString("[^/]*")
+				continue
+			}
+
+			atStart := idx == 0 || pattern[idx-1] == '/'
+			switch {
+			case atStart && strings.HasPrefix(pattern[idx:], "**/"):
+				// »**/« matches zero or more directories.
+				b.WriteString("(?:.*/)?")
+				idx += 2
+			case atStart && idx+2 == len(pattern):
+				// A trailing »/**« matches everything inside.
+				b.WriteString(".*")
+				idx++
+			default:
+				// Otherwise it is like a normal star.
+				b.WriteString("[^/]*")
+				idx++
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[idx+1:], ']')
+			if end < 0 {
+				b.WriteString("\\[")
+				continue
+			}
+
+			class := pattern[idx+1 : idx+1+end]
+			if end == 0 {
+				// »[]...]« - the first bracket is part of the class.
+				next := strings.IndexByte(pattern[idx+2:], ']')
+				if next < 0 {
+					b.WriteString("\\[")
+					continue
+				}
+
+				end = next + 1
+				class = pattern[idx+1 : idx+1+end]
+			}
+
+			b.WriteString("[")
+			if strings.HasPrefix(class, "!") {
+				b.WriteString("^")
+				class = class[1:]
+			}
+
+			b.WriteString(strings.NewReplacer("\\", "\\\\", "[", "\\[", "]", "\\]").Replace(class))
+			b.WriteString("]")
+			idx += end + 1
+		case '\\':
+			if idx+1 < len(pattern) {
+				idx++
+				b.WriteString(regexp.QuoteMeta(string(pattern[idx])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,119 @@
+package ignore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		path     string
+		isDir    bool
+		ignored  bool
+	}{
+		{[]string{"*.swp"}, "a.swp", false, true},
+		{[]string{"*.swp"}, "/sub/dir/.a.swp", false, true},
+		{[]string{"*.swp"}, "a.swpx", false, false},
+		{[]string{"# comment"}, "# comment", false, false},
+		{[]string{"\\#x"}, "#x", false, true},
+		{[]string{"x   "}, "x", false, true},
+		{[]string{"node_modules/"}, "a/node_modules", true, true},
+		{[]string{"node_modules/"}, "a/node_modules", false, false},
+		{[]string{"node_modules/"}, "a/node_modules/b/c.js", false, true},
+		{[]string{"/build"}, "build", true, true},
+		{[]string{"/build"}, "sub/build", true, false},
+		{[]string{"doc/*.txt"}, "doc/a.txt", false, true},
+		{[]string{"doc/*.txt"}, "doc/sub/a.txt", false, false},
+		{[]string{"**/logs"}, "a/b/logs", true, true},
+		{[]string{"**/logs"}, "logs", true, true},
+		{[]string{"a/**/b"}, "a/b", false, true},
+		{[]string{"a/**/b"}, "a/x/y/b", false, true},
+		{[]string{"a/**"}, "a/x/y", false, true},
+		{[]string{"a/**"}, "a", true, false},
+		{[]string{"file[0-9]"}, "file3", false, true},
+		{[]string{"file[!0-9]"}, "file3", false, false},
+		{[]string{"file?"}, "file/", true, false},
+		{[]string{"*.log", "!keep.log"}, "keep.log", false, false},
+		{[]string{"*.log", "!keep.log"}, "other.log", false, true},
+		{[]string{"*.log", "!keep.log", "*.log"}, "keep.log", false, true},
+		// Nothing can be re-included below an ignored directory:
+		{[]string{"out/", "!out/keep"}, "out/keep", false, true},
+		{[]string{"out/*", "!out/keep"}, "out/keep", false, false},
+	}
+
+	for _, test := range tests {
+		m := New(test.patterns)
+		ignored := m.Match(test.path, test.isDir)
+		require.Equal(
+			t, test.ignored, ignored,
+			"patterns %v on %s (dir: %v)", test.patterns, test.path, test.isDir,
+		)
+	}
+
+	var nilMatcher *Matcher
+	require.False(t, nilMatcher.Match("x", false))
+}
+
+func TestMatchScoped(t *testing.T) {
+	m := New([]string{"*.tmp"})
+	require.Nil(t, m.AddReader("/sub", strings.NewReader("# comment\n/x\n!y.tmp\n")))
+
+	require.True(t, m.Match("/sub/x", false))
+	require.False(t, m.Match("/x", false))
+	require.False(t, m.Match("/other/sub/x", false))
+	require.False(t, m.Match("/sub/y.tmp", false))
+	require.True(t, m.Match("/y.tmp", false))
+}
+
+func TestWalk(t *testing.T) {
+	root, err := ioutil.TempDir("", "brig-ignore-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		".brigignore":                "node_modules/\n",
+		"a.txt":                      "",
+		"a.swp":                      "",
+		"node_modules/x/y.js":        "",
+		"sub/.brigignore":            "*.txt\n!keep.txt\n",
+		"sub/b.txt":                  "",
+		"sub/keep.txt":               "",
+		"sub/c.go":                   "",
+		"other/b.txt":                "",
+		"other/node_modules/z/zz.js": "",
+	}
+
+	for name, data := range files {
+		fullPath := filepath.Join(root, filepath.FromSlash(name))
+		require.Nil(t, os.MkdirAll(filepath.Dir(fullPath), 0700))
+		require.Nil(t, ioutil.WriteFile(fullPath, []byte(data), 0600))
+	}
+
+	seen := []string{}
+	err = Walk(root, New([]string{"*.swp"}), func(fullPath string, info os.FileInfo, err error) error {
+		require.Nil(t, err)
+		if !info.IsDir() {
+			rel, err := filepath.Rel(root, fullPath)
+			require.Nil(t, err)
+			seen = append(seen, filepath.ToSlash(rel))
+		}
+
+		return nil
+	})
+
+	require.Nil(t, err)
+	require.Equal(t, []string{
+		".brigignore",
+		"a.txt",
+		"other/b.txt",
+		"sub/.brigignore",
+		"sub/c.go",
+		"sub/keep.txt",
+	}, seen)
+}
@@ -0,0 +1,18 @@
+// +build !windows
+
+package util
+
+import (
+	"os"
+	"syscall"
+)
+
+// Inode returns the inode number of the file described by `info`
+// or 0 if it is not known.
+func Inode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+
+	return 0
+}
@@ -0,0 +1,11 @@
+// +build windows
+
+package util
+
+import "os"
+
+// Inode returns the inode number of the file described by `info`.
+// There are no inodes on windows, so it is always 0.
+func Inode(info os.FileInfo) uint64 {
+	return 0
+}